@@ -2,23 +2,173 @@ package ast
 
 type AST struct {
 	SQL []SQL
+	// Parameters holds the name of each bind parameter by index, starting
+	// from 1. Positional parameters have no name.
+	Parameters []string
 }
 
 type SQL struct {
-	SELECTStatement *SELECTStatement
+	SELECTStatement      *SELECTStatement
+	CREATETABLEStatement *CREATETABLEStatement
+	CREATEINDEXStatement *CREATEINDEXStatement
+	DROPINDEXStatement   *DROPINDEXStatement
+	PRAGMAStatement      *PRAGMAStatement
+	BEGINStatement       *BEGINStatement
+	COMMITStatement      *COMMITStatement
+	ROLLBACKStatement    *ROLLBACKStatement
+	SAVEPOINTStatement   *SAVEPOINTStatement
+	RELEASEStatement     *RELEASEStatement
+	ANALYZEStatement     *ANALYZEStatement
+	EXPLAINStatement     *EXPLAINStatement
+	SETStatement         *SETStatement
 }
 
 type SELECTStatement struct {
-	Select *SELECTClause
-	From   *FROMClause
+	Select  *SELECTClause
+	From    *FROMClause
+	Where   *Expression
+	OrderBy []OrderingTerm
+	Limit   *LIMITClause
+}
+
+// CREATETABLEStatement creates a table of the database file. The foreign
+// keys declared with REFERENCES on a column come before the table-level
+// ones, in the order of the columns.
+type CREATETABLEStatement struct {
+	Table       *Table
+	Columns     []string
+	ForeignKeys []ForeignKeyClause
+}
+
+type ReferentialAction int
+
+const (
+	NO_ACTION ReferentialAction = iota
+	RESTRICT
+	CASCADE
+	SET_NULL
+	SET_DEFAULT
+)
+
+func (a ReferentialAction) String() string {
+	switch a {
+	case NO_ACTION:
+		return "NO ACTION"
+	case RESTRICT:
+		return "RESTRICT"
+	case CASCADE:
+		return "CASCADE"
+	case SET_NULL:
+		return "SET NULL"
+	case SET_DEFAULT:
+		return "SET DEFAULT"
+	default:
+		return "Unknown Action"
+	}
+}
+
+type ForeignKeyClause struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   ReferentialAction
+	OnUpdate   ReferentialAction
+	Deferred   bool
+}
+
+type CREATEINDEXStatement struct {
+	Index   string
+	Unique  bool
+	Table   *Table
+	Columns []string
+}
+
+type DROPINDEXStatement struct {
+	Index string
+}
+
+type PRAGMAStatement struct {
+	Name  string
+	Value *Expression
+}
+
+// SETStatement sets a variable of the session.
+type SETStatement struct {
+	Name  string
+	Value *Expression
+}
+
+type BEGINStatement struct {
+	Immediate bool
+}
+
+type COMMITStatement struct {
+}
+
+type ROLLBACKStatement struct {
+	Savepoint string
+}
+
+type SAVEPOINTStatement struct {
+	Savepoint string
+}
+
+type RELEASEStatement struct {
+	Savepoint string
+}
+
+// ANALYZEStatement collects the statistics of Table, or of every table
+// when Table is nil.
+type ANALYZEStatement struct {
+	Table *Table
+}
+
+// EXPLAINStatement describes how SQL is run instead of running it: as VM
+// code, or as the plan tree when QueryPlan is set. With Analyze SQL is run
+// and the plan tree is reported with what each operator did.
+type EXPLAINStatement struct {
+	QueryPlan bool
+	Analyze   bool
+	SQL       *SQL
 }
 
 type SELECTClause struct {
 	ResultColumns []ResultColumn
 }
 
+// FROMClause is a table joined with the tables of Joins, from left to
+// right.
 type FROMClause struct {
 	Table *Table
+	Joins []JoinClause
+}
+
+type JoinOperator int
+
+const (
+	_ JoinOperator = iota
+	JOIN_INNER
+	JOIN_CROSS
+)
+
+// JoinClause joins Table to the tables before it. An inner join keeps the
+// pairs of rows for which On is true; a table after a comma is a cross
+// join.
+type JoinClause struct {
+	Operator JoinOperator
+	Table    *Table
+	On       *Expression
+}
+
+type OrderingTerm struct {
+	Expression *Expression
+	Desc       bool
+}
+
+type LIMITClause struct {
+	Limit  *Expression
+	Offset *Expression
 }
 
 type ResultColumn struct {
@@ -31,6 +181,7 @@ type Expression struct {
 	BinaryOperation *BinaryOpe
 	FunctionCall    *FunctionCall
 	Column          *Column
+	Parameter       *Parameter
 }
 
 type Literal struct {
@@ -50,6 +201,12 @@ const (
 	B_ASTERISK
 	B_SOLIDAS
 	B_PERCENT
+	B_EQUAL
+	B_LESS
+	B_LESS_EQUAL
+	B_GREATER
+	B_GREATER_EQUAL
+	B_AND
 
 	U_PLUS
 	U_MINUS
@@ -67,6 +224,18 @@ func (o OperatorType) String() string {
 		return "/"
 	case B_PERCENT:
 		return "%"
+	case B_EQUAL:
+		return "="
+	case B_LESS:
+		return "<"
+	case B_LESS_EQUAL:
+		return "<="
+	case B_GREATER:
+		return ">"
+	case B_GREATER_EQUAL:
+		return ">="
+	case B_AND:
+		return "AND"
 
 	case U_PLUS:
 		return "+"
@@ -93,6 +262,11 @@ type FunctionCall struct {
 	Args []Expression
 }
 
+type Parameter struct {
+	Index int
+	Name  string
+}
+
 type Column struct {
 	Column string
 	Table  string
@@ -10,7 +10,7 @@ func IsWhiteSpace(ch rune) bool {
 func IsSymbol(ch rune) bool {
 	if ch == '!' || ch == '"' || ch == '#' || ch == '$' || ch == '%' || ch == '&' || ch == '\'' || ch == '(' || ch == ')' || ch == '=' || ch == '-' ||
 		ch == '~' || ch == '^' || ch == '|' || ch == '`' || ch == '@' || ch == '{' || ch == '}' || ch == '[' || ch == ']' || ch == ':' || ch == '*' ||
-		ch == '+' || ch == ';' || ch == '<' || ch == '>' || ch == ',' || ch == '.' || ch == '?' || ch == '/' || ch == '_' {
+		ch == '+' || ch == ';' || ch == '<' || ch == '>' || ch == ',' || ch == '.' || ch == '?' || ch == '/' {
 		return true
 	}
 	return false
@@ -5,9 +5,12 @@ type ResultType int
 const (
 	_ ResultType = iota
 	Integral
+	String
+	Null
 )
 
 type Value struct {
 	Type     ResultType
 	Integral int
+	String   string
 }
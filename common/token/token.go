@@ -14,9 +14,56 @@ const (
 	EOS
 	IDENT
 	NUMBER
+	PARAMETER
 
 	K_SELECT
 	K_FROM
+	K_CREATE
+	K_DROP
+	K_UNIQUE
+	K_INDEX
+	K_ON
+	K_PRAGMA
+	K_BEGIN
+	K_DEFERRED
+	K_IMMEDIATE
+	K_TRANSACTION
+	K_COMMIT
+	K_END
+	K_ROLLBACK
+	K_SAVEPOINT
+	K_RELEASE
+	K_TO
+	K_ANALYZE
+	K_EXPLAIN
+	K_SET
+	K_WHERE
+	K_AND
+	K_AS
+	K_JOIN
+	K_INNER
+	K_CROSS
+	K_ORDER
+	K_BY
+	K_ASC
+	K_DESC
+	K_LIMIT
+	K_OFFSET
+	K_TABLE
+	K_REFERENCES
+	K_FOREIGN
+	K_KEY
+	K_CONSTRAINT
+	K_CASCADE
+	K_RESTRICT
+	K_NO
+	K_ACTION
+	K_NULL
+	K_DEFAULT
+	K_DEFERRABLE
+	K_INITIALLY
+	K_UPDATE
+	K_DELETE
 
 	S_PLUS
 	S_MINUS
@@ -27,6 +74,12 @@ const (
 	S_LPAREN
 	S_RPAREN
 	S_COMMA
+	S_EQUAL
+	S_LESS
+	S_LESS_EQUAL
+	S_GREATER
+	S_GREATER_EQUAL
+	S_DOT
 )
 
 func (t Type) String() string {
@@ -41,11 +94,105 @@ func (t Type) String() string {
 		return "Identifier Token"
 	case NUMBER:
 		return "Number Token"
+	case PARAMETER:
+		return "Parameter Token"
 
 	case K_SELECT:
 		return "Keyword (SELECT)"
 	case K_FROM:
 		return "Keyword (FROM)"
+	case K_CREATE:
+		return "Keyword (CREATE)"
+	case K_DROP:
+		return "Keyword (DROP)"
+	case K_UNIQUE:
+		return "Keyword (UNIQUE)"
+	case K_INDEX:
+		return "Keyword (INDEX)"
+	case K_ON:
+		return "Keyword (ON)"
+	case K_PRAGMA:
+		return "Keyword (PRAGMA)"
+	case K_BEGIN:
+		return "Keyword (BEGIN)"
+	case K_DEFERRED:
+		return "Keyword (DEFERRED)"
+	case K_IMMEDIATE:
+		return "Keyword (IMMEDIATE)"
+	case K_TRANSACTION:
+		return "Keyword (TRANSACTION)"
+	case K_COMMIT:
+		return "Keyword (COMMIT)"
+	case K_END:
+		return "Keyword (END)"
+	case K_ROLLBACK:
+		return "Keyword (ROLLBACK)"
+	case K_SAVEPOINT:
+		return "Keyword (SAVEPOINT)"
+	case K_RELEASE:
+		return "Keyword (RELEASE)"
+	case K_TO:
+		return "Keyword (TO)"
+	case K_ANALYZE:
+		return "Keyword (ANALYZE)"
+	case K_EXPLAIN:
+		return "Keyword (EXPLAIN)"
+	case K_SET:
+		return "Keyword (SET)"
+	case K_WHERE:
+		return "Keyword (WHERE)"
+	case K_AND:
+		return "Keyword (AND)"
+	case K_AS:
+		return "Keyword (AS)"
+	case K_JOIN:
+		return "Keyword (JOIN)"
+	case K_INNER:
+		return "Keyword (INNER)"
+	case K_CROSS:
+		return "Keyword (CROSS)"
+	case K_ORDER:
+		return "Keyword (ORDER)"
+	case K_BY:
+		return "Keyword (BY)"
+	case K_ASC:
+		return "Keyword (ASC)"
+	case K_DESC:
+		return "Keyword (DESC)"
+	case K_LIMIT:
+		return "Keyword (LIMIT)"
+	case K_OFFSET:
+		return "Keyword (OFFSET)"
+	case K_TABLE:
+		return "Keyword (TABLE)"
+	case K_REFERENCES:
+		return "Keyword (REFERENCES)"
+	case K_FOREIGN:
+		return "Keyword (FOREIGN)"
+	case K_KEY:
+		return "Keyword (KEY)"
+	case K_CONSTRAINT:
+		return "Keyword (CONSTRAINT)"
+	case K_CASCADE:
+		return "Keyword (CASCADE)"
+	case K_RESTRICT:
+		return "Keyword (RESTRICT)"
+	case K_NO:
+		return "Keyword (NO)"
+	case K_ACTION:
+		return "Keyword (ACTION)"
+	case K_NULL:
+		return "Keyword (NULL)"
+	case K_DEFAULT:
+		return "Keyword (DEFAULT)"
+	case K_DEFERRABLE:
+		return "Keyword (DEFERRABLE)"
+	case K_INITIALLY:
+		return "Keyword (INITIALLY)"
+	case K_UPDATE:
+		return "Keyword (UPDATE)"
+	case K_DELETE:
+		return "Keyword (DELETE)"
 
 	case S_PLUS:
 		return "Symbol (+)"
@@ -65,6 +212,18 @@ func (t Type) String() string {
 		return "Symbol ())"
 	case S_COMMA:
 		return "Symbol (,)"
+	case S_EQUAL:
+		return "Symbol (=)"
+	case S_LESS:
+		return "Symbol (<)"
+	case S_LESS_EQUAL:
+		return "Symbol (<=)"
+	case S_GREATER:
+		return "Symbol (>)"
+	case S_GREATER_EQUAL:
+		return "Symbol (>=)"
+	case S_DOT:
+		return "Symbol (.)"
 
 	default:
 		return "Unknown Type"
@@ -94,6 +253,98 @@ func CheckKeyword(s string) (bool, Type) {
 		return true, K_SELECT
 	case "FROM":
 		return true, K_FROM
+	case "CREATE":
+		return true, K_CREATE
+	case "DROP":
+		return true, K_DROP
+	case "UNIQUE":
+		return true, K_UNIQUE
+	case "INDEX":
+		return true, K_INDEX
+	case "ON":
+		return true, K_ON
+	case "PRAGMA":
+		return true, K_PRAGMA
+	case "BEGIN":
+		return true, K_BEGIN
+	case "DEFERRED":
+		return true, K_DEFERRED
+	case "IMMEDIATE":
+		return true, K_IMMEDIATE
+	case "TRANSACTION":
+		return true, K_TRANSACTION
+	case "COMMIT":
+		return true, K_COMMIT
+	case "END":
+		return true, K_END
+	case "ROLLBACK":
+		return true, K_ROLLBACK
+	case "SAVEPOINT":
+		return true, K_SAVEPOINT
+	case "RELEASE":
+		return true, K_RELEASE
+	case "TO":
+		return true, K_TO
+	case "ANALYZE":
+		return true, K_ANALYZE
+	case "EXPLAIN":
+		return true, K_EXPLAIN
+	case "SET":
+		return true, K_SET
+	case "WHERE":
+		return true, K_WHERE
+	case "AND":
+		return true, K_AND
+	case "AS":
+		return true, K_AS
+	case "JOIN":
+		return true, K_JOIN
+	case "INNER":
+		return true, K_INNER
+	case "CROSS":
+		return true, K_CROSS
+	case "ORDER":
+		return true, K_ORDER
+	case "BY":
+		return true, K_BY
+	case "ASC":
+		return true, K_ASC
+	case "DESC":
+		return true, K_DESC
+	case "LIMIT":
+		return true, K_LIMIT
+	case "OFFSET":
+		return true, K_OFFSET
+	case "TABLE":
+		return true, K_TABLE
+	case "REFERENCES":
+		return true, K_REFERENCES
+	case "FOREIGN":
+		return true, K_FOREIGN
+	case "KEY":
+		return true, K_KEY
+	case "CONSTRAINT":
+		return true, K_CONSTRAINT
+	case "CASCADE":
+		return true, K_CASCADE
+	case "RESTRICT":
+		return true, K_RESTRICT
+	case "NO":
+		return true, K_NO
+	case "ACTION":
+		return true, K_ACTION
+	case "NULL":
+		return true, K_NULL
+	case "DEFAULT":
+		return true, K_DEFAULT
+	case "DEFERRABLE":
+		return true, K_DEFERRABLE
+	case "INITIALLY":
+		return true, K_INITIALLY
+	case "UPDATE":
+		return true, K_UPDATE
+	case "DELETE":
+		return true, K_DELETE
 	}
 	return false, UNKNOWN
 }
@@ -0,0 +1,320 @@
+// Package binder checks a logical plan against the catalog before it is
+// lowered: tables and columns must exist, column references must be
+// unambiguous, and functions must be called with the right arguments.
+package binder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/value"
+	"github.com/yakawa/simpleDB/compiler/plan"
+	"github.com/yakawa/simpleDB/runtime/vm/functions"
+)
+
+// Catalog gives the binder the columns of a table.
+type Catalog interface {
+	Columns(db string, tbl string) ([]string, error)
+}
+
+// ColumnRef is the table column a column expression resolved to. Relation
+// is the name the table is known by in the statement, its alias if any.
+type ColumnRef struct {
+	DB       string
+	Table    string
+	Relation string
+	Column   string
+	Index    int
+}
+
+type Bindings struct {
+	Columns map[*ast.Column]ColumnRef
+	Types   map[*ast.Expression]value.Type
+}
+
+// Type returns the inferred type of e. Parameters are UNKNOWN until they
+// are bound.
+func (b *Bindings) Type(e *ast.Expression) value.Type {
+	return b.Types[e]
+}
+
+// Errors holds every problem found in a plan.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type relation struct {
+	name    string
+	db      string
+	table   string
+	columns []string
+}
+
+// scope is the set of relations an expression may refer to. A scope with a
+// missing table is incomplete and reports no unresolved columns, which
+// would only repeat the error of the table.
+type scope struct {
+	relations  []relation
+	incomplete bool
+}
+
+type binder struct {
+	catalog  Catalog
+	bindings *Bindings
+	errs     Errors
+}
+
+// Bind resolves the names used by nodes. All errors are collected and
+// returned together as Errors.
+func Bind(c Catalog, nodes []plan.Node) (*Bindings, error) {
+	b := &binder{
+		catalog: c,
+		bindings: &Bindings{
+			Columns: make(map[*ast.Column]ColumnRef),
+			Types:   make(map[*ast.Expression]value.Type),
+		},
+	}
+	for _, n := range nodes {
+		b.bindNode(n)
+	}
+	if len(b.errs) != 0 {
+		return b.bindings, b.errs
+	}
+	return b.bindings, nil
+}
+
+func (b *binder) errorf(format string, args ...interface{}) {
+	b.errs = append(b.errs, errors.New(fmt.Sprintf(format, args...)))
+}
+
+func dbName(db string) string {
+	if db == "" {
+		return "_"
+	}
+	return db
+}
+
+// bindNode binds the expressions of n and returns the relations visible to
+// the operator above it.
+func (b *binder) bindNode(n plan.Node) *scope {
+	switch n := n.(type) {
+	case *plan.Values:
+		return &scope{}
+	case *plan.Scan:
+		return b.bindTable(n.Table)
+	case *plan.Filter:
+		s := b.bindNode(n.Input)
+		b.bindExpression(s, n.Condition)
+		return s
+	case *plan.Project:
+		s := b.bindNode(n.Input)
+		for _, e := range n.Columns {
+			b.bindExpression(s, e)
+		}
+		return s
+	case *plan.Join:
+		left := b.bindNode(n.Left)
+		right := b.bindNode(n.Right)
+		s := &scope{
+			relations:  append(append([]relation{}, left.relations...), right.relations...),
+			incomplete: left.incomplete || right.incomplete,
+		}
+		seen := map[string]bool{}
+		for _, r := range s.relations {
+			if seen[r.name] {
+				b.errorf("Duplicate table name (%s)", r.name)
+			}
+			seen[r.name] = true
+		}
+		if n.Condition != nil {
+			b.bindExpression(s, n.Condition)
+		}
+		return s
+	case *plan.Aggregate:
+		s := b.bindNode(n.Input)
+		for _, e := range n.GroupBy {
+			b.bindExpression(s, e)
+		}
+		for _, e := range n.Aggregates {
+			b.bindExpression(s, e)
+		}
+		return s
+	case *plan.Sort:
+		s := b.bindNode(n.Input)
+		for _, k := range n.Keys {
+			b.bindExpression(s, k.Expression)
+		}
+		return s
+	case *plan.Limit:
+		s := b.bindNode(n.Input)
+		b.bindExpression(&scope{}, n.Limit)
+		if n.Offset != nil {
+			b.bindExpression(&scope{}, n.Offset)
+		}
+		return s
+	case *plan.Union:
+		b.bindNode(n.Left)
+		return b.bindNode(n.Right)
+	case *plan.Command:
+		b.bindCommand(n.SQL)
+		return &scope{}
+	case *plan.Explain:
+		b.bindNode(n.Input)
+		return &scope{}
+	default:
+		b.errorf("Unknown Operator (%s)", n)
+		return &scope{}
+	}
+}
+
+func (b *binder) bindTable(t *ast.Table) *scope {
+	cols, err := b.catalog.Columns(dbName(t.DB), t.Table)
+	if err != nil {
+		b.errs = append(b.errs, err)
+		return &scope{incomplete: true}
+	}
+	name := t.Table
+	if t.Alias != "" {
+		name = t.Alias
+	}
+	return &scope{relations: []relation{{name: name, db: dbName(t.DB), table: t.Table, columns: cols}}}
+}
+
+func (b *binder) bindCommand(sql *ast.SQL) {
+	switch {
+	case sql.CREATETABLEStatement != nil:
+		stmt := sql.CREATETABLEStatement
+		for _, fk := range stmt.ForeignKeys {
+			if fk.RefTable == stmt.Table.Table {
+				continue
+			}
+			s := b.bindTable(&ast.Table{Table: fk.RefTable})
+			for _, c := range fk.RefColumns {
+				b.resolve(s, &ast.Column{Column: c})
+			}
+		}
+	case sql.CREATEINDEXStatement != nil:
+		s := b.bindTable(sql.CREATEINDEXStatement.Table)
+		for _, c := range sql.CREATEINDEXStatement.Columns {
+			b.resolve(s, &ast.Column{Column: c})
+		}
+	case sql.ANALYZEStatement != nil:
+		if sql.ANALYZEStatement.Table != nil {
+			b.bindTable(sql.ANALYZEStatement.Table)
+		}
+	case sql.PRAGMAStatement != nil:
+		if sql.PRAGMAStatement.Value != nil {
+			if t := b.bindExpression(&scope{}, sql.PRAGMAStatement.Value); t != value.INTEGER && t != value.UNKNOWN {
+				b.errorf("PRAGMA (%s) value must be an Integer", sql.PRAGMAStatement.Name)
+			}
+		}
+	case sql.SETStatement != nil:
+		if t := b.bindExpression(&scope{}, sql.SETStatement.Value); t != value.INTEGER && t != value.UNKNOWN {
+			b.errorf("Variable (%s) value must be an Integer", sql.SETStatement.Name)
+		}
+	}
+}
+
+func (b *binder) resolve(s *scope, c *ast.Column) (ColumnRef, bool) {
+	refs := []ColumnRef{}
+	for _, r := range s.relations {
+		if c.Table != "" && c.Table != r.name {
+			continue
+		}
+		if c.DB != "" && dbName(c.DB) != r.db {
+			continue
+		}
+		for n, col := range r.columns {
+			if col == c.Column {
+				refs = append(refs, ColumnRef{DB: r.db, Table: r.table, Relation: r.name, Column: col, Index: n})
+			}
+		}
+	}
+	name := c.Column
+	if c.Table != "" {
+		name = c.Table + "." + name
+	}
+	switch len(refs) {
+	case 0:
+		if !s.incomplete {
+			b.errorf("Column (%s) Not Found", name)
+		}
+		return ColumnRef{}, false
+	case 1:
+		b.bindings.Columns[c] = refs[0]
+		return refs[0], true
+	default:
+		b.errorf("Ambiguous Column (%s)", name)
+		return ColumnRef{}, false
+	}
+}
+
+// bindExpression resolves the names in e and returns its type.
+func (b *binder) bindExpression(s *scope, e *ast.Expression) value.Type {
+	t := value.UNKNOWN
+	switch {
+	case e == nil:
+		return t
+	case e.Literal != nil:
+		t = value.INTEGER
+	case e.Parameter != nil:
+		t = value.UNKNOWN
+	case e.Column != nil:
+		if _, ok := b.resolve(s, e.Column); ok {
+			t = value.INTEGER
+		}
+	case e.UnaryOperation != nil:
+		t = b.bindOperand(s, e.UnaryOperation.Operator, e.UnaryOperation.Expr)
+	case e.BinaryOperation != nil:
+		lt := b.bindOperand(s, e.BinaryOperation.Operator, e.BinaryOperation.Left)
+		rt := b.bindOperand(s, e.BinaryOperation.Operator, e.BinaryOperation.Right)
+		if lt == value.INTEGER && rt == value.INTEGER {
+			t = value.INTEGER
+		}
+	case e.FunctionCall != nil:
+		t = b.bindFunctionCall(s, e.FunctionCall)
+	}
+	b.bindings.Types[e] = t
+	return t
+}
+
+func (b *binder) bindOperand(s *scope, ope ast.OperatorType, e *ast.Expression) value.Type {
+	t := b.bindExpression(s, e)
+	if t != value.INTEGER && t != value.UNKNOWN {
+		b.errorf("Operator (%s) does not accept %s", ope, t)
+	}
+	if t == value.UNKNOWN {
+		return t
+	}
+	return value.INTEGER
+}
+
+func (b *binder) bindFunctionCall(s *scope, f *ast.FunctionCall) value.Type {
+	types := []value.Type{}
+	for n := range f.Args {
+		types = append(types, b.bindExpression(s, &f.Args[n]))
+	}
+	sig, exists := functions.LookupSignature(f.Name)
+	if !exists {
+		b.errorf("Function (%s) Not Found", f.Name)
+		return value.UNKNOWN
+	}
+	if len(f.Args) != len(sig.Args) {
+		b.errorf("Function (%s) takes %d arguments but %d were given", f.Name, len(sig.Args), len(f.Args))
+		return sig.Result
+	}
+	for n, t := range types {
+		if t != value.UNKNOWN && t != sig.Args[n] {
+			b.errorf("Argument %d of function (%s) must be %s, not %s", n+1, f.Name, sig.Args[n], t)
+		}
+	}
+	return sig.Result
+}
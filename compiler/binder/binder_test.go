@@ -0,0 +1,141 @@
+package binder
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/value"
+	"github.com/yakawa/simpleDB/compiler/lexer"
+	"github.com/yakawa/simpleDB/compiler/parser"
+	"github.com/yakawa/simpleDB/compiler/plan"
+)
+
+type catalog map[string][]string
+
+func (c catalog) Columns(db string, tbl string) ([]string, error) {
+	cols, exists := c[db+"."+tbl]
+	if !exists {
+		return []string{}, errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
+	}
+	return cols, nil
+}
+
+var testCatalog = catalog{
+	"_.tbl1": {"colA", "colB"},
+	"_.tbl2": {"colA", "colC"},
+}
+
+func TestBind(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT 1 + 2, ABS(-1);", ""},
+		{"SELECT colA, colB + ? FROM tbl1;", ""},
+		{"SELECT colX FROM tbl1;", "Column (colX) Not Found"},
+		{"SELECT colA FROM tblX;", "Table (tblX) Not Found"},
+		{"SELECT colA;", "Column (colA) Not Found"},
+		{"SELECT UNKNOWN(1), colX, ABS(colY) FROM tbl1;", "Function (UNKNOWN) Not Found; Column (colX) Not Found; Column (colY) Not Found"},
+		{"CREATE INDEX idx ON tbl1 (colA, colC);", "Column (colC) Not Found"},
+		{"PRAGMA cache_size = ABS(colA);", "Column (colA) Not Found"},
+		{"ANALYZE; ANALYZE tbl1; ANALYZE tblX;", "Table (tblX) Not Found"},
+		{"BEGIN; PRAGMA cache_size = 10; COMMIT;", ""},
+		{"SET statement_timeout = colA;", "Column (colA) Not Found"},
+		{"SET statement_timeout = 1 + 2;", ""},
+	}
+
+	for tn, tc := range testCases {
+		a, err := parser.Parse(lexer.Lex(tc.sql))
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		_, err = Bind(testCatalog, plan.Build(a))
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		}
+		if actual != tc.expected {
+			t.Fatalf("[%d] %s expected error %q, but got %q", tn, tc.sql, tc.expected, actual)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	column := func(tbl string, c string) *ast.Expression {
+		return &ast.Expression{Column: &ast.Column{Table: tbl, Column: c}}
+	}
+	join := func(cols ...*ast.Expression) plan.Node {
+		return &plan.Project{
+			Columns: cols,
+			Input: &plan.Join{
+				Type:  plan.CrossJoin,
+				Left:  &plan.Scan{Table: &ast.Table{Table: "tbl1", Alias: "a"}},
+				Right: &plan.Scan{Table: &ast.Table{Table: "tbl2"}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		node     plan.Node
+		expected string
+	}{
+		{join(column("", "colB"), column("", "colC")), ""},
+		{join(column("a", "colA"), column("tbl2", "colA")), ""},
+		{join(column("", "colA")), "Ambiguous Column (colA)"},
+		{join(column("tbl1", "colA")), "Column (tbl1.colA) Not Found"},
+		{join(column("a", "colC")), "Column (a.colC) Not Found"},
+	}
+
+	for tn, tc := range testCases {
+		_, err := Bind(testCatalog, []plan.Node{tc.node})
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		}
+		if actual != tc.expected {
+			t.Fatalf("[%d] expected error %q, but got %q", tn, tc.expected, actual)
+		}
+	}
+
+	num := &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: 1}}}
+	call := &plan.Project{
+		Input:   &plan.Values{},
+		Columns: []*ast.Expression{{FunctionCall: &ast.FunctionCall{Name: "ABS", Args: []ast.Expression{*num, *num}}}},
+	}
+	if _, err := Bind(testCatalog, []plan.Node{call}); err == nil || err.Error() != "Function (ABS) takes 1 arguments but 2 were given" {
+		t.Fatalf("arity mismatch is not reported %v", err)
+	}
+
+	dup := &plan.Join{
+		Type:  plan.CrossJoin,
+		Left:  &plan.Scan{Table: &ast.Table{Table: "tbl1"}},
+		Right: &plan.Scan{Table: &ast.Table{Table: "tbl1"}},
+	}
+	if _, err := Bind(testCatalog, []plan.Node{dup}); err == nil {
+		t.Fatalf("duplicate table name is accepted")
+	}
+}
+
+func TestTypes(t *testing.T) {
+	a, err := parser.Parse(lexer.Lex("SELECT colA * 2, ?, ABS(?) FROM tbl1;"))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	b, err := Bind(testCatalog, plan.Build(a))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	cols := a.SQL[0].SELECTStatement.Select.ResultColumns
+	expected := []value.Type{value.INTEGER, value.UNKNOWN, value.INTEGER}
+	for n, c := range cols {
+		if b.Type(c.Expression) != expected[n] {
+			t.Fatalf("[%d] type mismatch %s", n, b.Type(c.Expression))
+		}
+	}
+	ref := b.Columns[cols[0].Expression.BinaryOperation.Left.Column]
+	if ref.Table != "tbl1" || ref.Index != 0 {
+		t.Fatalf("column reference mismatch %+v", ref)
+	}
+}
@@ -0,0 +1,142 @@
+// Package compiler turns SQL text into VM programs, reusing the programs
+// kept in the plan cache of a runtime.
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+	"github.com/yakawa/simpleDB/common/value"
+	"github.com/yakawa/simpleDB/compiler/binder"
+	"github.com/yakawa/simpleDB/compiler/lexer"
+	"github.com/yakawa/simpleDB/compiler/optimizer"
+	"github.com/yakawa/simpleDB/compiler/parser"
+	"github.com/yakawa/simpleDB/compiler/plan"
+	"github.com/yakawa/simpleDB/compiler/planner"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+// Statement is a compiled program. When the literals of the SQL text were
+// replaced by parameters, Literals holds their values in parameter order.
+type Statement struct {
+	AST      *ast.AST
+	Codes    []vm.VMCode
+	Literals []vm.VMValue
+}
+
+// NumInput returns the number of parameters to be bound by the caller.
+func (st *Statement) NumInput() int {
+	return len(st.AST.Parameters) - len(st.Literals)
+}
+
+// Compile compiles sql against the catalog of r without the plan cache.
+func Compile(r *runtime.Runtime, sql string) (*Statement, error) {
+	return compileTokens(r, lexer.Lex(sql))
+}
+
+// compileTokens parses, binds, optimizes and lowers a statement. Binding
+// errors are reported before any code is generated.
+func compileTokens(r *runtime.Runtime, tokens token.Tokens) (*Statement, error) {
+	a, err := parser.Parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	nodes := plan.Build(a)
+	bindings, err := binder.Bind(r, nodes)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := planner.LowerAll(optimizer.Optimize(nodes, bindings, r), bindings)
+	if err != nil {
+		return nil, err
+	}
+	return &Statement{AST: a, Codes: codes}, nil
+}
+
+// CompileCached compiles sql, or reuses the program cached by r for the
+// same normalized text and the current schema.
+func CompileCached(r *runtime.Runtime, sql string) (*Statement, error) {
+	c := r.PlanCache()
+	tokens := lexer.Lex(sql)
+	literals := []vm.VMValue{}
+	lift := c.Parameterize() && !hasParameters(tokens)
+	key := Normalize(tokens, lift)
+	if lift {
+		tokens, literals = liftLiterals(tokens)
+	}
+
+	schema := r.SchemaVersion()
+	if plan, ok := c.Get(key, schema); ok {
+		st := plan.(*Statement)
+		return &Statement{AST: st.AST, Codes: st.Codes, Literals: literals}, nil
+	}
+
+	st, err := compileTokens(r, tokens)
+	if err != nil {
+		return nil, err
+	}
+	c.Put(key, schema, st)
+	return &Statement{AST: st.AST, Codes: st.Codes, Literals: literals}, nil
+}
+
+// Normalize renders tokens as a cache key: keywords in upper case, one
+// space between tokens and no trailing semicolon. If literals is true
+// numbers are replaced by #.
+func Normalize(tokens token.Tokens, literals bool) string {
+	words := []string{}
+	for _, t := range tokens {
+		switch {
+		case t.Type == token.EOS:
+			continue
+		case t.Type == token.NUMBER && literals:
+			words = append(words, "#")
+		case t.Type != token.IDENT && isKeyword(t.Literal):
+			words = append(words, strings.ToUpper(t.Literal))
+		default:
+			words = append(words, t.Literal)
+		}
+	}
+	for len(words) != 0 && words[len(words)-1] == ";" {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
+
+func isKeyword(s string) bool {
+	ok, _ := token.CheckKeyword(s)
+	return ok
+}
+
+func hasParameters(tokens token.Tokens) bool {
+	for _, t := range tokens {
+		if t.Type == token.PARAMETER {
+			return true
+		}
+	}
+	return false
+}
+
+// liftLiterals replaces every number by a numbered parameter and returns
+// the numbers as the values to bind.
+func liftLiterals(tokens token.Tokens) (token.Tokens, []vm.VMValue) {
+	lifted := token.Tokens{}
+	literals := []vm.VMValue{}
+	for _, t := range tokens {
+		if t.Type == token.NUMBER {
+			literals = append(literals, vm.VMValue{Type: vm.Integer, Integral: t.Value.Integer})
+			t = token.Token{
+				Type:    token.PARAMETER,
+				Literal: fmt.Sprintf("?%d", len(literals)),
+				Value: value.Value{
+					Type:    value.INTEGER,
+					Integer: len(literals),
+				},
+			}
+		}
+		lifted = append(lifted, t)
+	}
+	return lifted, literals
+}
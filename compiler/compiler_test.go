@@ -0,0 +1,385 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yakawa/simpleDB/compiler/lexer"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		literals bool
+		expected string
+	}{
+		{"select 1+2;", false, "SELECT 1 + 2"},
+		{"SELECT   1 +\n2", false, "SELECT 1 + 2"},
+		{"select 1+2;", true, "SELECT # + #"},
+		{"SELECT colA FROM tbl1 WHERE", false, "SELECT colA FROM tbl1 WHERE"},
+		{"pragma cache_size = 10", true, "PRAGMA cache_size = #"},
+		{"SELECT ?, :a", true, "SELECT ? , :a"},
+	}
+
+	for tn, tc := range testCases {
+		actual := Normalize(lexer.Lex(tc.input), tc.literals)
+		if actual != tc.expected {
+			t.Fatalf("[%d] expected %q, but got %q", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestCompileCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compiler")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	r := runtime.New().Set(dir)
+	c := r.PlanCache()
+
+	st1, err := CompileCached(r, "SELECT 1 + 2")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	st2, err := CompileCached(r, "select 1+2;")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if &st1.Codes[0] != &st2.Codes[0] {
+		t.Fatalf("cached program is not reused")
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Fatalf("stats mismatch %+v", st)
+	}
+	if _, err := CompileCached(r, "SELECT (1"); err == nil {
+		t.Fatalf("invalid statement is compiled")
+	}
+	if c.Stats().Entries != 1 {
+		t.Fatalf("invalid statement is cached")
+	}
+
+	c.SetParameterize(true)
+	st1, _ = CompileCached(r, "SELECT 1 + 2")
+	st2, _ = CompileCached(r, "SELECT 3 + 4")
+	if &st1.Codes[0] != &st2.Codes[0] {
+		t.Fatalf("parameterized program is not reused")
+	}
+	if st2.NumInput() != 0 || len(st2.Literals) != 2 || st2.Literals[0].Integral != 3 || st2.Literals[1].Integral != 4 {
+		t.Fatalf("literals mismatch %+v", st2.Literals)
+	}
+
+	if err := r.CreateIndex("_", "tbl1", "idx", []string{"colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	st3, _ := CompileCached(r, "SELECT 5 + 6")
+	if &st3.Codes[0] == &st2.Codes[0] {
+		t.Fatalf("program is not invalidated by a schema change")
+	}
+	if c.Stats().Invalidations != 1 {
+		t.Fatalf("stats mismatch %+v", c.Stats())
+	}
+}
+
+// TestSerialize checks that compiled statements survive vm.Encode and
+// vm.Disassemble unchanged.
+func TestSerialize(t *testing.T) {
+	testCases := []string{
+		"SELECT 1 + 2 * 3, ABS(-1)",
+		"SELECT colA, colB FROM tbl1",
+		"SELECT ?1 - ?2",
+		"CREATE UNIQUE INDEX idx1 ON tbl1 (colA, colB)",
+		"DROP INDEX idx1",
+		"PRAGMA cache_size = 10",
+		"SET statement_timeout = 100",
+		"BEGIN",
+		"EXPLAIN SELECT colA FROM tbl1",
+		"EXPLAIN ANALYZE SELECT colA FROM tbl1",
+	}
+
+	r := runtime.New().Set("../testdata")
+	for tn, sql := range testCases {
+		st, err := Compile(r, sql)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+
+		var buf bytes.Buffer
+		if err := vm.Encode(&buf, st.Codes); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		decoded, err := vm.Decode(&buf)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if !reflect.DeepEqual(decoded, st.Codes) {
+			t.Fatalf("[%d] expected %v but got %v", tn, st.Codes, decoded)
+		}
+
+		var sb strings.Builder
+		if err := vm.Disassemble(&sb, st.Codes); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		assembled, err := vm.Assemble(strings.NewReader(sb.String()))
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if !reflect.DeepEqual(assembled, st.Codes) {
+			t.Fatalf("[%d] expected %v but got %v", tn, st.Codes, assembled)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"SELECT colA FROM tbl1", "[[{1 1 }] [{1 3 }] [{1 5 }] [{1 7 }] [{1 9 }]]"},
+		{"SELECT colB - colA, colA * 2 FROM tbl1", "[[{1 1 } {1 2 }] [{1 1 } {1 6 }] [{1 1 } {1 10 }] [{1 1 } {1 14 }] [{1 1 } {1 18 }]]"},
+		{"SELECT 1 FROM tbl1; SELECT colB FROM tbl1", "[[{1 1 }] [{1 1 }] [{1 1 }] [{1 1 }] [{1 1 }] [{1 2 }] [{1 4 }] [{1 6 }] [{1 8 }] [{1 10 }]]"},
+		{"SELECT colA FROM tbl1 WHERE colB = 5", "[]"},
+		{"SELECT colA FROM tbl1 WHERE 1 = 1 AND colB = 4", "[[{1 3 }]]"},
+		{"SELECT a.colA, b.colB FROM tbl1 AS a JOIN tbl1 b ON a.colA + 1 = b.colB", "[[{1 1 } {1 2 }] [{1 3 } {1 4 }] [{1 5 } {1 6 }] [{1 7 } {1 8 }] [{1 9 } {1 10 }]]"},
+		{"SELECT a.colA, b.colA FROM tbl1 a, tbl1 b WHERE a.colA = 1 AND b.colB % 4 = 0", "[[{1 1 } {1 3 }] [{1 1 } {1 7 }]]"},
+		{"SELECT colA FROM tbl1 ORDER BY colB DESC", "[[{1 9 }] [{1 7 }] [{1 5 }] [{1 3 }] [{1 1 }]]"},
+		{"SELECT colA FROM tbl1 ORDER BY colB DESC LIMIT 2 OFFSET 1", "[[{1 7 }] [{1 5 }]]"},
+		{"SELECT colA FROM tbl1 LIMIT 2 OFFSET 1; SELECT 1 LIMIT 0", "[[{1 3 }] [{1 5 }]]"},
+		{"SELECT colA FROM tbl1 WHERE 1 = 0 ORDER BY colB", "[]"},
+	}
+
+	r := runtime.New().Set("../testdata")
+	for tn, tc := range testCases {
+		st, err := Compile(r, tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		rows, err := vm.ExecuteRowsContext(context.Background(), r.Session(), st.Codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+}
+
+// TestQueryPlan checks that the optimizer rewrites the plans built from SQL
+// text: filters are pushed into scans, joins get an algorithm and sorts on
+// constants are removed.
+func TestQueryPlan(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"SELECT colA FROM tbl1 WHERE colB = 2 + 2",
+			[]string{"Project [colA]", "Scan tbl1 COLUMNS [colA, colB] FILTER [colB = 4]"},
+		},
+		{
+			"SELECT a.colA FROM tbl1 a JOIN tbl1 b ON a.colA = b.colB WHERE a.colB = 2 AND 1 = 1",
+			[]string{"Project [a.colA]", "Join INNER HASH ON a.colA = b.colB", "Scan tbl1 AS a COLUMNS [colA, colB] FILTER [a.colB = 2]", "Scan tbl1 AS b COLUMNS [colB]"},
+		},
+		{
+			"SELECT colA FROM tbl1 ORDER BY 1, ? LIMIT 1",
+			[]string{"Project [colA]", "Limit 1", "Scan tbl1 COLUMNS [colA]"},
+		},
+		{
+			"SELECT colA FROM tbl1 WHERE 1 = 0 ORDER BY colB",
+			[]string{"Project [colA]", "Sort [colB]", "Limit 0", "Scan tbl1 COLUMNS [colA, colB]"},
+		},
+	}
+
+	r := runtime.New().Set("../testdata")
+	for tn, tc := range testCases {
+		st, err := Compile(r, "EXPLAIN QUERY PLAN "+tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		rows, err := vm.ExecuteRowsContext(context.Background(), r.Session(), st.Codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		actual := []string{}
+		for _, row := range rows {
+			detail := row[len(row)-1].String
+			if n := strings.Index(detail, " (~"); n >= 0 {
+				detail = detail[:n]
+			}
+			actual = append(actual, detail)
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] expected %q but got %q", tn, tc.expected, actual)
+		}
+	}
+}
+
+// TestJoin checks that the joins the optimizer runs as hash joins look up
+// the matching rows instead of comparing every pair.
+func TestJoin(t *testing.T) {
+	testCases := []struct {
+		input    string
+		lookup   bool
+		expected string
+	}{
+		{"SELECT a.colA, b.colB FROM tbl1 a JOIN tbl1 b ON a.colA + 1 = b.colB", true, "[[{1 1 } {1 2 }] [{1 3 } {1 4 }] [{1 5 } {1 6 }] [{1 7 } {1 8 }] [{1 9 } {1 10 }]]"},
+		{"SELECT a.colA, b.colA FROM tbl1 a JOIN tbl1 b ON a.colA = b.colA + 2 WHERE b.colB = 6", true, "[[{1 7 } {1 5 }]]"},
+		{"SELECT a.colA, b.colA FROM tbl1 a, tbl1 b WHERE a.colB = 2 AND b.colB % 4 = 0", false, "[[{1 1 } {1 3 }] [{1 1 } {1 7 }]]"},
+	}
+
+	r := runtime.New().Set("../testdata")
+	for tn, tc := range testCases {
+		st, err := Compile(r, tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		lookup := false
+		for _, c := range st.Codes {
+			if c.Operator == vm.LOOKUP {
+				lookup = true
+			}
+		}
+		if lookup != tc.lookup {
+			t.Fatalf("[%d] expected lookup %t but got %v", tn, tc.lookup, st.Codes)
+		}
+		rows, err := vm.ExecuteRowsContext(context.Background(), r.Session(), st.Codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+}
+
+// TestIndexScan checks that the rows found in an index are read instead of
+// the whole table.
+func TestIndexScan(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("#colA, colB\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&sb, "%d,%d\n", i, i*2)
+	}
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "tbl.csv")
+	if err := ioutil.WriteFile(fn, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(sb.Len())
+
+	testCases := []struct {
+		input    string
+		lookups  int
+		expected string
+	}{
+		{"SELECT colB FROM tbl WHERE colA = 500", 1, "[[{1 1000 }]]"},
+		{"SELECT colB FROM tbl WHERE colA = 1000", 1, "[]"},
+		{"SELECT a.colA, b.colB FROM tbl a JOIN tbl b ON b.colA = a.colB WHERE a.colA = 3", 2, "[[{1 3 } {1 12 }]]"},
+		{"SELECT colA FROM tbl WHERE colB = 500", 0, "[[{1 250 }]]"},
+		{"SELECT colB FROM tbl WHERE colA >= 10 AND 13 > colA", 1, "[[{1 20 }] [{1 22 }] [{1 24 }]]"},
+		{"SELECT colA FROM tbl WHERE colA > 995 ORDER BY colA DESC", 1, "[[{1 999 }] [{1 998 }] [{1 997 }] [{1 996 }]]"},
+		{"SELECT colA FROM tbl ORDER BY colA DESC LIMIT 2", 1, "[[{1 999 }] [{1 998 }]]"},
+	}
+
+	r := runtime.New().Set(dir)
+	for _, sql := range []string{"CREATE INDEX idx_colA ON tbl (colA)", "ANALYZE tbl"} {
+		st, err := Compile(r, sql)
+		if err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", sql, err)
+		}
+		if _, err := vm.ExecuteRowsContext(context.Background(), r.Session(), st.Codes); err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", sql, err)
+		}
+	}
+	for tn, tc := range testCases {
+		st, err := Compile(r, tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		lookups := 0
+		for _, c := range st.Codes {
+			if (c.Operator == vm.LOOKUP || c.Operator == vm.RANGE) && c.Operand1.Index.Index == "idx_colA" {
+				lookups++
+			}
+		}
+		if lookups != tc.lookups {
+			t.Fatalf("[%d] expected %d lookups but got %v", tn, tc.lookups, st.Codes)
+		}
+		sess := r.NewSession()
+		rows, err := vm.ExecuteRowsContext(context.Background(), sess, st.Codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+		if read := sess.BytesRead(); (tc.lookups != 0) != (read < size/10) {
+			t.Fatalf("[%d] %d bytes read of %d", tn, read, size)
+		}
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	dir := t.TempDir()
+	r := runtime.New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+
+	for _, sql := range []string{
+		"CREATE TABLE parent (id INTEGER)",
+		"CREATE TABLE child (id, pid REFERENCES parent (id) ON DELETE CASCADE, CONSTRAINT fk_self FOREIGN KEY (id) REFERENCES child (pid) DEFERRABLE INITIALLY DEFERRED)",
+	} {
+		st, err := Compile(r, sql)
+		if err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", sql, err)
+		}
+		if _, err := vm.ExecuteRowsContext(context.Background(), r.Session(), st.Codes); err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", sql, err)
+		}
+	}
+
+	expected := []runtime.ForeignKey{
+		{Name: "fk_child_1", DB: "_", Table: "child", Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"id"}, OnDelete: runtime.Cascade},
+		{Name: "fk_self", DB: "_", Table: "child", Columns: []string{"id"}, RefTable: "child", RefColumns: []string{"pid"}, Deferred: true},
+	}
+	if actual := r.ForeignKeys("_", "child"); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, actual)
+	}
+
+	for _, sql := range []string{
+		"CREATE TABLE parent (id)",
+		"CREATE TABLE orphan (pid REFERENCES missing (id))",
+		"CREATE TABLE orphan (pid REFERENCES parent (name))",
+		"CREATE TABLE orphan (pid, FOREIGN KEY (x) REFERENCES parent (id))",
+	} {
+		st, err := Compile(r, sql)
+		if err == nil {
+			_, err = vm.ExecuteRowsContext(context.Background(), r.Session(), st.Codes)
+		}
+		if err == nil {
+			t.Fatalf("%s is run", sql)
+		}
+	}
+	if _, exists := r.Storage().Table("orphan"); exists {
+		t.Fatalf("Table with a broken foreign key is created")
+	}
+}
@@ -1,6 +1,9 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/yakawa/simpleDB/common/helper"
 	"github.com/yakawa/simpleDB/common/token"
 	"github.com/yakawa/simpleDB/common/value"
@@ -48,6 +51,13 @@ func (l *lexer) getCurrentChar() rune {
 	return l.src[l.currentPos]
 }
 
+func (l *lexer) getNextChar() rune {
+	if l.readPos >= len(l.src) {
+		return 0
+	}
+	return l.src[l.readPos]
+}
+
 func (l *lexer) tokenize() token.Tokens {
 	tokens := token.Tokens{}
 
@@ -74,13 +84,15 @@ func (l *lexer) tokenize() token.Tokens {
 func (l *lexer) findToken() (token.Token, error) {
 	ch := l.getCurrentChar()
 	switch ch {
-	case ';', '+', '-', '*', '/', '%', '(', ')', ',':
+	case ';', '+', '-', '*', '/', '%', '(', ')', ',', '=', '<', '>', '.':
 		v, tp := l.lookupSymbol()
 		t := token.Token{
 			Type:    tp,
 			Literal: v,
 		}
 		return t, nil
+	case '?', '$', ':':
+		return l.readParameter()
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		v := l.readNumber()
 		val, err := value.Convert(v)
@@ -99,6 +111,14 @@ func (l *lexer) findToken() (token.Token, error) {
 		return t, nil
 	default:
 		v := l.readIdent()
+		if v == "" {
+			t := token.Token{
+				Type:    token.UNKNOWN,
+				Literal: string(ch),
+			}
+			l.readChar()
+			return t, errors.New(fmt.Sprintf("Unknown Character: %c", ch))
+		}
 		isKeyword, tp := token.CheckKeyword(v)
 		t := token.Token{
 			Literal: v,
@@ -129,6 +149,40 @@ func (l *lexer) readIdent() string {
 	return string(v)
 }
 
+// readParameter reads a bind parameter: ?, ?NNN, $NNN or :name. The index
+// of a numbered parameter is kept in the token value.
+func (l *lexer) readParameter() (token.Token, error) {
+	prefix := l.getCurrentChar()
+	l.readChar()
+	t := token.Token{
+		Type: token.PARAMETER,
+	}
+	v := ""
+	switch prefix {
+	case ':':
+		v = l.readIdent()
+	default:
+		v = l.readNumber()
+	}
+	t.Literal = string(prefix) + v
+	if v == "" {
+		if prefix == '?' {
+			return t, nil
+		}
+		t.Type = token.ERROR
+		return t, errors.New(fmt.Sprintf("Parameter name missing: %c", prefix))
+	}
+	if prefix != ':' {
+		val, err := value.Convert(v)
+		if err != nil || val.Integer < 1 {
+			t.Type = token.ERROR
+			return t, errors.New(fmt.Sprintf("Invalid Parameter Index: %s", t.Literal))
+		}
+		t.Value = val
+	}
+	return t, nil
+}
+
 func (l *lexer) readNumber() string {
 	v := []rune("")
 	for {
@@ -176,6 +230,28 @@ func (l *lexer) lookupSymbol() (string, token.Type) {
 	case ',':
 		val = token.S_COMMA
 		v = ","
+	case '=':
+		val = token.S_EQUAL
+		v = "="
+	case '<':
+		val = token.S_LESS
+		v = "<"
+		if l.getNextChar() == '=' {
+			l.readChar()
+			val = token.S_LESS_EQUAL
+			v = "<="
+		}
+	case '>':
+		val = token.S_GREATER
+		v = ">"
+		if l.getNextChar() == '=' {
+			l.readChar()
+			val = token.S_GREATER_EQUAL
+			v = ">="
+		}
+	case '.':
+		val = token.S_DOT
+		v = "."
 
 	default:
 		val = token.UNKNOWN
@@ -324,6 +324,274 @@ func TestLexer(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "CREATE INDEX idx1 ON tbl1(colA);",
+			expected: token.Tokens{
+				{
+					Type:    token.K_CREATE,
+					Literal: "CREATE",
+				},
+				{
+					Type:    token.K_INDEX,
+					Literal: "INDEX",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "idx1",
+				},
+				{
+					Type:    token.K_ON,
+					Literal: "ON",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "tbl1",
+				},
+				{
+					Type:    token.S_LPAREN,
+					Literal: "(",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "colA",
+				},
+				{
+					Type:    token.S_RPAREN,
+					Literal: ")",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+		},
+		{
+			input: "PRAGMA cache_size = 100;",
+			expected: token.Tokens{
+				{
+					Type:    token.K_PRAGMA,
+					Literal: "PRAGMA",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "cache_size",
+				},
+				{
+					Type:    token.S_EQUAL,
+					Literal: "=",
+				},
+				{
+					Type:    token.NUMBER,
+					Literal: "100",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 100,
+					},
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+		},
+		{
+			input: "a<1>=b<=c>d",
+			expected: token.Tokens{
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.S_LESS, Literal: "<"},
+				{Type: token.NUMBER, Literal: "1", Value: value.Value{Type: value.INTEGER, Integer: 1}},
+				{Type: token.S_GREATER_EQUAL, Literal: ">="},
+				{Type: token.IDENT, Literal: "b"},
+				{Type: token.S_LESS_EQUAL, Literal: "<="},
+				{Type: token.IDENT, Literal: "c"},
+				{Type: token.S_GREATER, Literal: ">"},
+				{Type: token.IDENT, Literal: "d"},
+				{Type: token.EOS},
+			},
+		},
+		{
+			input: "SELECT @",
+			expected: token.Tokens{
+				{
+					Type:    token.K_SELECT,
+					Literal: "SELECT",
+				},
+				{
+					Type:    token.UNKNOWN,
+					Literal: "@",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+		},
+		{
+			input: "BEGIN IMMEDIATE; ROLLBACK TO sp1;",
+			expected: token.Tokens{
+				{
+					Type:    token.K_BEGIN,
+					Literal: "BEGIN",
+				},
+				{
+					Type:    token.K_IMMEDIATE,
+					Literal: "IMMEDIATE",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_ROLLBACK,
+					Literal: "ROLLBACK",
+				},
+				{
+					Type:    token.K_TO,
+					Literal: "TO",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "sp1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+		},
+		{
+			input: "SELECT ?, ?2 + $1, :name",
+			expected: token.Tokens{
+				{
+					Type:    token.K_SELECT,
+					Literal: "SELECT",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: "?",
+				},
+				{
+					Type:    token.S_COMMA,
+					Literal: ",",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: "?2",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 2,
+					},
+				},
+				{
+					Type:    token.S_PLUS,
+					Literal: "+",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: "$1",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 1,
+					},
+				},
+				{
+					Type:    token.S_COMMA,
+					Literal: ",",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: ":name",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+		},
+		{
+			input: "SELECT $",
+			expected: token.Tokens{
+				{
+					Type:    token.K_SELECT,
+					Literal: "SELECT",
+				},
+				{
+					Type:    token.ERROR,
+					Literal: "$",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+		},
+		{
+			input: "WHERE t.colA AND 1 ORDER BY colB DESC LIMIT 1",
+			expected: token.Tokens{
+				{Type: token.K_WHERE, Literal: "WHERE"},
+				{Type: token.IDENT, Literal: "t"},
+				{Type: token.S_DOT, Literal: "."},
+				{Type: token.IDENT, Literal: "colA"},
+				{Type: token.K_AND, Literal: "AND"},
+				{Type: token.NUMBER, Literal: "1"},
+				{Type: token.K_ORDER, Literal: "ORDER"},
+				{Type: token.K_BY, Literal: "BY"},
+				{Type: token.IDENT, Literal: "colB"},
+				{Type: token.K_DESC, Literal: "DESC"},
+				{Type: token.K_LIMIT, Literal: "LIMIT"},
+				{Type: token.NUMBER, Literal: "1"},
+				{Type: token.EOS},
+			},
+		},
+		{
+			input: "CREATE TABLE child (id, pid REFERENCES parent(id) ON DELETE SET NULL, CONSTRAINT fk FOREIGN KEY (pid) REFERENCES parent(id) ON UPDATE NO ACTION DEFERRABLE INITIALLY DEFERRED)",
+			expected: token.Tokens{
+				{Type: token.K_CREATE, Literal: "CREATE"},
+				{Type: token.K_TABLE, Literal: "TABLE"},
+				{Type: token.IDENT, Literal: "child"},
+				{Type: token.S_LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "id"},
+				{Type: token.S_COMMA, Literal: ","},
+				{Type: token.IDENT, Literal: "pid"},
+				{Type: token.K_REFERENCES, Literal: "REFERENCES"},
+				{Type: token.IDENT, Literal: "parent"},
+				{Type: token.S_LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "id"},
+				{Type: token.S_RPAREN, Literal: ")"},
+				{Type: token.K_ON, Literal: "ON"},
+				{Type: token.K_DELETE, Literal: "DELETE"},
+				{Type: token.K_SET, Literal: "SET"},
+				{Type: token.K_NULL, Literal: "NULL"},
+				{Type: token.S_COMMA, Literal: ","},
+				{Type: token.K_CONSTRAINT, Literal: "CONSTRAINT"},
+				{Type: token.IDENT, Literal: "fk"},
+				{Type: token.K_FOREIGN, Literal: "FOREIGN"},
+				{Type: token.K_KEY, Literal: "KEY"},
+				{Type: token.S_LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "pid"},
+				{Type: token.S_RPAREN, Literal: ")"},
+				{Type: token.K_REFERENCES, Literal: "REFERENCES"},
+				{Type: token.IDENT, Literal: "parent"},
+				{Type: token.S_LPAREN, Literal: "("},
+				{Type: token.IDENT, Literal: "id"},
+				{Type: token.S_RPAREN, Literal: ")"},
+				{Type: token.K_ON, Literal: "ON"},
+				{Type: token.K_UPDATE, Literal: "UPDATE"},
+				{Type: token.K_NO, Literal: "NO"},
+				{Type: token.K_ACTION, Literal: "ACTION"},
+				{Type: token.K_DEFERRABLE, Literal: "DEFERRABLE"},
+				{Type: token.K_INITIALLY, Literal: "INITIALLY"},
+				{Type: token.K_DEFERRED, Literal: "DEFERRED"},
+				{Type: token.S_RPAREN, Literal: ")"},
+				{Type: token.EOS},
+			},
+		},
 	}
 
 	for tn, tc := range testCases {
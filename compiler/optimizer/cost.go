@@ -0,0 +1,705 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/compiler/binder"
+	"github.com/yakawa/simpleDB/compiler/plan"
+	"github.com/yakawa/simpleDB/runtime/stats"
+)
+
+// Statistics gives the optimizer what the catalog knows about the data.
+type Statistics interface {
+	Statistics(db string, tbl string) (*stats.Table, bool)
+	// IndexOn returns the name of an index of a table whose first column
+	// is col.
+	IndexOn(db string, tbl string, col string) (string, bool)
+}
+
+// The estimates used for tables that were not analyzed and for predicates
+// the statistics say nothing about.
+const (
+	defaultRows             = 1000
+	defaultEqualSelectivity = 0.1
+	defaultSelectivity      = 1.0 / 3
+)
+
+// randomRead is the cost of reading a row found in an index, relative to
+// reading one in a scan, which reads the rows in the order of the table.
+const randomRead = 4
+
+// Estimate returns the estimated number of rows n produces.
+func Estimate(n plan.Node, b *binder.Bindings, s Statistics) float64 {
+	o := &optimizer{bindings: b, stats: s}
+	return o.estimate(n)
+}
+
+func (o *optimizer) estimates(n plan.Node, m map[plan.Node]float64) {
+	m[n] = o.estimate(n)
+	for _, c := range n.Children() {
+		o.estimates(c, m)
+	}
+}
+
+func dbName(db string) string {
+	if db == "" {
+		return "_"
+	}
+	return db
+}
+
+func (o *optimizer) tableRows(t *ast.Table) float64 {
+	if o.stats != nil {
+		if st, exists := o.stats.Statistics(dbName(t.DB), t.Table); exists {
+			return float64(st.Rows)
+		}
+	}
+	return defaultRows
+}
+
+func (o *optimizer) columnStats(e *ast.Expression) (*stats.Column, bool) {
+	if o.stats == nil || e == nil || e.Column == nil {
+		return nil, false
+	}
+	ref, bound := o.lookup(e.Column)
+	if !bound {
+		return nil, false
+	}
+	st, exists := o.stats.Statistics(ref.DB, ref.Table)
+	if !exists {
+		return nil, false
+	}
+	return st.Column(ref.Column)
+}
+
+func (o *optimizer) estimate(n plan.Node) float64 {
+	switch n := n.(type) {
+	case *plan.Scan:
+		rows := o.tableRows(n.Table)
+		for _, f := range n.Filters {
+			rows *= o.selectivity(f)
+		}
+		return rows
+	case *plan.IndexScan:
+		return o.estimate(n.Scan)
+	case *plan.Filter:
+		return o.estimate(n.Input) * o.selectivity(n.Condition)
+	case *plan.Project:
+		return o.estimate(n.Input)
+	case *plan.Sort:
+		return o.estimate(n.Input)
+	case *plan.Join:
+		left := o.estimate(n.Left)
+		rows := left * o.estimate(n.Right)
+		if n.Condition != nil {
+			rows *= o.selectivity(n.Condition)
+		}
+		if n.Type == plan.LeftJoin && rows < left {
+			return left
+		}
+		return rows
+	case *plan.Aggregate:
+		rows := o.estimate(n.Input)
+		if len(n.GroupBy) == 0 {
+			return 1
+		}
+		groups := 1.0
+		for _, e := range n.GroupBy {
+			c, ok := o.columnStats(e)
+			if !ok {
+				return rows
+			}
+			groups *= float64(c.Distinct)
+		}
+		return math.Min(groups, rows)
+	case *plan.Limit:
+		rows := o.estimate(n.Input)
+		if v, ok := constant(n.Limit); ok && v >= 0 && float64(v) < rows {
+			return float64(v)
+		}
+		return rows
+	case *plan.Union:
+		return o.estimate(n.Left) + o.estimate(n.Right)
+	default:
+		return 1
+	}
+}
+
+// selectivity estimates the fraction of the rows for which e is non-zero.
+// Only equalities and comparisons of a column with a constant are
+// estimated from the statistics.
+func (o *optimizer) selectivity(e *ast.Expression) float64 {
+	if v, ok := constant(e); ok {
+		if v != 0 {
+			return 1
+		}
+		return 0
+	}
+	if e.BinaryOperation != nil && isComparison(e.BinaryOperation.Operator) {
+		b := e.BinaryOperation
+		for _, sides := range []struct {
+			col, key *ast.Expression
+			ope      ast.OperatorType
+		}{{b.Left, b.Right, b.Operator}, {b.Right, b.Left, flip(b.Operator)}} {
+			c, ok := o.columnStats(sides.col)
+			v, known := constant(sides.key)
+			if !ok || !known {
+				continue
+			}
+			low, high := constantBounds(sides.ope, v)
+			return c.RangeSelectivity(low, high)
+		}
+		return defaultSelectivity
+	}
+	if e.BinaryOperation == nil || e.BinaryOperation.Operator != ast.B_EQUAL {
+		return defaultSelectivity
+	}
+	l := e.BinaryOperation.Left
+	r := e.BinaryOperation.Right
+	lc, lok := o.columnStats(l)
+	rc, rok := o.columnStats(r)
+	if v, ok := constant(r); ok && lok {
+		return lc.EqualSelectivity(v)
+	}
+	if v, ok := constant(l); ok && rok {
+		return rc.EqualSelectivity(v)
+	}
+	switch {
+	case lok && rok:
+		d := lc.Distinct
+		if rc.Distinct > d {
+			d = rc.Distinct
+		}
+		if d == 0 {
+			return 0
+		}
+		return (1 - lc.NullFraction) * (1 - rc.NullFraction) / float64(d)
+	case lok && lc.Distinct != 0:
+		return (1 - lc.NullFraction) / float64(lc.Distinct)
+	case rok && rc.Distinct != 0:
+		return (1 - rc.NullFraction) / float64(rc.Distinct)
+	default:
+		return defaultEqualSelectivity
+	}
+}
+
+func isInnerJoin(n plan.Node) bool {
+	j, ok := n.(*plan.Join)
+	return ok && (j.Type == plan.InnerJoin || j.Type == plan.CrossJoin)
+}
+
+// isRegion tells whether n is a tree of inner and cross joins, possibly
+// under filters, whose inputs can be joined in any order.
+func isRegion(n plan.Node) bool {
+	if f, ok := n.(*plan.Filter); ok {
+		return isRegion(f.Input)
+	}
+	return isInnerJoin(n)
+}
+
+// flatten collects the inputs and the conditions of a join region.
+func flatten(n plan.Node, leaves *[]plan.Node, conds *[]*ast.Expression) {
+	switch m := n.(type) {
+	case *plan.Filter:
+		if isRegion(m.Input) {
+			*conds = append(*conds, m.Condition)
+			flatten(m.Input, leaves, conds)
+			return
+		}
+	case *plan.Join:
+		if isInnerJoin(m) {
+			if m.Condition != nil {
+				*conds = append(*conds, m.Condition)
+			}
+			flatten(m.Left, leaves, conds)
+			flatten(m.Right, leaves, conds)
+			return
+		}
+	}
+	*leaves = append(*leaves, n)
+}
+
+// reorderJoins chooses the order and the algorithm of the joins of n.
+func (o *optimizer) reorderJoins(n plan.Node) plan.Node {
+	if isRegion(n) {
+		leaves := []plan.Node{}
+		conds := []*ast.Expression{}
+		flatten(n, &leaves, &conds)
+		for k := range leaves {
+			leaves[k] = o.reorderJoins(leaves[k])
+		}
+		if joined, ok := o.joinOrder(leaves, conds); ok {
+			return joined
+		}
+	}
+	switch n := n.(type) {
+	case *plan.Filter:
+		n.Input = o.reorderJoins(n.Input)
+	case *plan.Project:
+		n.Input = o.reorderJoins(n.Input)
+	case *plan.Join:
+		n.Left = o.reorderJoins(n.Left)
+		n.Right = o.reorderJoins(n.Right)
+		o.chooseAlgorithm(n)
+	case *plan.Aggregate:
+		n.Input = o.reorderJoins(n.Input)
+	case *plan.Sort:
+		n.Input = o.reorderJoins(n.Input)
+	case *plan.Limit:
+		n.Input = o.reorderJoins(n.Input)
+	case *plan.Union:
+		n.Left = o.reorderJoins(n.Left)
+		n.Right = o.reorderJoins(n.Right)
+	}
+	return n
+}
+
+type predicate struct {
+	cond *ast.Expression
+	refs map[string]bool
+	used bool
+}
+
+// joinOrder builds a left-deep tree of leaves. It starts from the smallest
+// input and then adds the input that gives the smallest result among those
+// that share a condition with the inputs already joined, so that a cross
+// product is only made when nothing else is left. It fails when a
+// condition has a column that was not bound.
+func (o *optimizer) joinOrder(leaves []plan.Node, conds []*ast.Expression) (plan.Node, bool) {
+	preds := []*predicate{}
+	for _, c := range conds {
+		refs, ok := o.relations(c)
+		if !ok {
+			return nil, false
+		}
+		preds = append(preds, &predicate{cond: c, refs: refs})
+	}
+	names := []map[string]bool{}
+	remaining := []int{}
+	for k, l := range leaves {
+		names = append(names, scanNames(l))
+		remaining = append(remaining, k)
+	}
+
+	first := 0
+	for k := range leaves {
+		if o.estimate(leaves[k]) < o.estimate(leaves[first]) {
+			first = k
+		}
+	}
+	joined := leaves[first]
+	joinedNames := map[string]bool{}
+	for name := range names[first] {
+		joinedNames[name] = true
+	}
+	remaining = append(remaining[:first], remaining[first+1:]...)
+
+	for len(remaining) != 0 {
+		candidates := []int{}
+		for k, leaf := range remaining {
+			for _, p := range preds {
+				if !p.used && connects(p.refs, joinedNames, names[leaf]) {
+					candidates = append(candidates, k)
+					break
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			for k := range remaining {
+				candidates = append(candidates, k)
+			}
+		}
+
+		best := -1
+		var bestRows float64
+		for _, k := range candidates {
+			leaf := remaining[k]
+			rows := o.estimate(joined) * o.estimate(leaves[leaf])
+			for _, p := range applicable(preds, joinedNames, names[leaf]) {
+				rows *= o.selectivity(p.cond)
+			}
+			if best < 0 || rows < bestRows {
+				best = k
+				bestRows = rows
+			}
+		}
+
+		leaf := remaining[best]
+		j := &plan.Join{Type: plan.CrossJoin, Left: joined, Right: leaves[leaf]}
+		var node plan.Node = j
+		for _, p := range applicable(preds, joinedNames, names[leaf]) {
+			p.used = true
+			if j.Condition == nil {
+				j.Type = plan.InnerJoin
+				j.Condition = p.cond
+				continue
+			}
+			node = &plan.Filter{Input: node, Condition: p.cond}
+		}
+		o.chooseAlgorithm(j)
+		joined = node
+		for name := range names[leaf] {
+			joinedNames[name] = true
+		}
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return joined, true
+}
+
+// connects tells whether refs joins the tables of right to those of left.
+func connects(refs map[string]bool, left map[string]bool, right map[string]bool) bool {
+	in := false
+	for r := range refs {
+		if !left[r] && !right[r] {
+			return false
+		}
+		if right[r] {
+			in = true
+		}
+	}
+	return in
+}
+
+// applicable returns the unused predicates whose columns are all in left
+// or right.
+func applicable(preds []*predicate, left map[string]bool, right map[string]bool) []*predicate {
+	ps := []*predicate{}
+	for _, p := range preds {
+		if p.used {
+			continue
+		}
+		ok := true
+		for r := range p.refs {
+			if !left[r] && !right[r] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// chooseAlgorithm picks the cheapest way to run j. A nested loop compares
+// every row of the left side with every row of the right one. When the
+// condition equates a column of a right table to the left side, the VM can
+// instead hash the rows of the table on the column once and only visit the
+// matching rows of each left row, or look them up in an index of the
+// table.
+func (o *optimizer) chooseAlgorithm(j *plan.Join) {
+	j.Algorithm = plan.NestedLoop
+	if j.Condition == nil {
+		return
+	}
+	_, right, ok := o.equiJoin(j)
+	if !ok || right.Column == nil {
+		return
+	}
+	s, ok := j.Right.(*plan.Scan)
+	if !ok {
+		return
+	}
+	l := o.estimate(j.Left)
+	r := o.estimate(j.Right)
+	cost := l * r
+	if hash := o.tableRows(s.Table) + l + o.estimate(j); hash < cost {
+		cost = hash
+		j.Algorithm = plan.HashJoin
+	}
+	if _, ok := o.indexed(s, right); ok {
+		if lookup := l*(math.Log2(o.tableRows(s.Table)+1)+1) + o.estimate(j); lookup < cost {
+			j.Algorithm = plan.IndexLookup
+		}
+	}
+}
+
+// equiJoin splits the condition of j, if it is an equality between an
+// expression on the left input and one on the right input.
+func (o *optimizer) equiJoin(j *plan.Join) (*ast.Expression, *ast.Expression, bool) {
+	b := j.Condition.BinaryOperation
+	if b == nil || b.Operator != ast.B_EQUAL {
+		return nil, nil, false
+	}
+	lrefs, lok := o.relations(b.Left)
+	rrefs, rok := o.relations(b.Right)
+	if !lok || !rok || len(lrefs) == 0 || len(rrefs) == 0 {
+		return nil, nil, false
+	}
+	left := scanNames(j.Left)
+	right := scanNames(j.Right)
+	switch {
+	case contains(left, lrefs) && contains(right, rrefs):
+		return b.Left, b.Right, true
+	case contains(left, rrefs) && contains(right, lrefs):
+		return b.Right, b.Left, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// indexed returns the index on e, if it is a column of s with one.
+func (o *optimizer) indexed(s *plan.Scan, e *ast.Expression) (string, bool) {
+	if o.stats == nil || e.Column == nil {
+		return "", false
+	}
+	ref, bound := o.lookup(e.Column)
+	if !bound || ref.Relation != scanName(s) {
+		return "", false
+	}
+	return o.stats.IndexOn(ref.DB, ref.Table, ref.Column)
+}
+
+// useIndexes turns the scans that read fewer rows through an index into
+// index scans: the right input of an index lookup, a scan with a filter
+// equating an indexed column to a constant or a parameter, or bounding it,
+// when the index finds few enough of its rows, and a scan sorted on an
+// indexed column when reading it in the order of the index is cheaper than
+// sorting it. The right input of the other joins is left alone, as it is
+// read once and then rewound.
+func (o *optimizer) useIndexes(n plan.Node) plan.Node {
+	switch n := n.(type) {
+	case *plan.Scan:
+		return o.indexScan(n)
+	case *plan.Filter:
+		n.Input = o.useIndexes(n.Input)
+	case *plan.Project:
+		n.Input = o.useIndexes(n.Input)
+	case *plan.Join:
+		n.Left = o.useIndexes(n.Left)
+		s, ok := n.Right.(*plan.Scan)
+		if !ok {
+			n.Right = o.useIndexes(n.Right)
+			break
+		}
+		if n.Algorithm != plan.IndexLookup {
+			break
+		}
+		left, right, _ := o.equiJoin(n)
+		name, _ := o.indexed(s, right)
+		n.Right = &plan.IndexScan{Scan: s, Index: name, Column: right.Column.Column, Key: left}
+	case *plan.Aggregate:
+		n.Input = o.useIndexes(n.Input)
+	case *plan.Sort:
+		if s, ok := n.Input.(*plan.Scan); ok && len(n.Keys) == 1 {
+			if scan, ok := o.orderedScan(s, n.Keys[0]); ok {
+				return scan
+			}
+		}
+		n.Input = o.useIndexes(n.Input)
+	case *plan.Limit:
+		n.Input = o.useIndexes(n.Input)
+	case *plan.Union:
+		n.Left = o.useIndexes(n.Left)
+		n.Right = o.useIndexes(n.Right)
+	}
+	return n
+}
+
+// indexScan returns the index scan of s on the first of its filters that
+// an index can find the rows of, if it is cheaper than the scan. An
+// equality is preferred to the bounds on a column.
+func (o *optimizer) indexScan(s *plan.Scan) plan.Node {
+	for _, f := range s.Filters {
+		b := f.BinaryOperation
+		if b == nil || b.Operator != ast.B_EQUAL {
+			continue
+		}
+		for _, sides := range [][2]*ast.Expression{{b.Left, b.Right}, {b.Right, b.Left}} {
+			col, key := sides[0], sides[1]
+			if _, ok := constant(key); !ok && key.Parameter == nil {
+				continue
+			}
+			name, ok := o.indexed(s, col)
+			if !ok || o.selectivity(f)*randomRead >= 1 {
+				continue
+			}
+			return &plan.IndexScan{Scan: s, Index: name, Column: col.Column.Column, Key: key}
+		}
+	}
+	for _, r := range o.ranges(s) {
+		if o.rangeSelectivity(r)*randomRead < 1 {
+			return r.scan
+		}
+	}
+	return s
+}
+
+// keyRange is the range of an indexed column the filters of a scan bound,
+// with the filters that bound it.
+type keyRange struct {
+	scan    *plan.IndexScan
+	col     *ast.Expression
+	filters []*ast.Expression
+}
+
+// ranges returns the ranges the filters of s comparing an indexed column
+// with a constant or a parameter bound, in the order of the columns in the
+// filters. Only the first lower and upper bound of a column are used.
+func (o *optimizer) ranges(s *plan.Scan) []*keyRange {
+	ranges := []*keyRange{}
+	found := map[string]*keyRange{}
+	for _, f := range s.Filters {
+		b := f.BinaryOperation
+		if b == nil || !isComparison(b.Operator) {
+			continue
+		}
+		for _, sides := range []struct {
+			col, key *ast.Expression
+			ope      ast.OperatorType
+		}{{b.Left, b.Right, b.Operator}, {b.Right, b.Left, flip(b.Operator)}} {
+			if _, ok := constant(sides.key); !ok && sides.key.Parameter == nil {
+				continue
+			}
+			name, ok := o.indexed(s, sides.col)
+			if !ok {
+				continue
+			}
+			r, exists := found[name]
+			if !exists {
+				r = &keyRange{scan: &plan.IndexScan{Scan: s, Index: name, Column: sides.col.Column.Column}, col: sides.col}
+				found[name] = r
+				ranges = append(ranges, r)
+			}
+			low, high := o.bounds(sides.ope, sides.key)
+			switch {
+			case low != nil && r.scan.Low == nil:
+				r.scan.Low = low
+			case high != nil && r.scan.High == nil:
+				r.scan.High = high
+			default:
+				continue
+			}
+			r.filters = append(r.filters, f)
+			break
+		}
+	}
+	return ranges
+}
+
+// rangeSelectivity estimates the fraction of the rows of the table in r.
+// The statistics give it when both bounds are constants, otherwise the
+// selectivities of the filters are multiplied.
+func (o *optimizer) rangeSelectivity(r *keyRange) float64 {
+	if c, ok := o.columnStats(r.col); ok {
+		low, lok := constant(r.scan.Low)
+		high, hok := constant(r.scan.High)
+		if (lok || r.scan.Low == nil) && (hok || r.scan.High == nil) {
+			var lp, hp *int
+			if lok {
+				lp = &low
+			}
+			if hok {
+				hp = &high
+			}
+			return c.RangeSelectivity(lp, hp)
+		}
+	}
+	sel := 1.0
+	for _, f := range r.filters {
+		sel *= o.selectivity(f)
+	}
+	return sel
+}
+
+// orderedScan returns the scan of s reading its rows in the order of k
+// through an index, if that is cheaper than sorting them. The rows are read
+// in the range the filters of s bound, if any. Reading in the order of the
+// index keeps the table order of the rows with the same key, as the sort
+// does.
+func (o *optimizer) orderedScan(s *plan.Scan, k plan.SortKey) (plan.Node, bool) {
+	name, ok := o.indexed(s, k.Expression)
+	if !ok {
+		return nil, false
+	}
+	switch scan := o.indexScan(s).(type) {
+	case *plan.IndexScan:
+		if scan.Index != name {
+			return nil, false
+		}
+		if scan.Key == nil {
+			scan.Ordered = true
+			scan.Desc = k.Desc
+		}
+		return scan, true
+	}
+	rows := o.estimate(s)
+	table := o.tableRows(s.Table)
+	if table*randomRead >= table+rows*math.Log2(rows+1) {
+		return nil, false
+	}
+	return &plan.IndexScan{Scan: s, Index: name, Column: k.Expression.Column.Column, Ordered: true, Desc: k.Desc}, true
+}
+
+// bounds returns the inclusive bound that col ope key puts on col, below or
+// above it. The columns hold integers, so col > key is col >= key + 1.
+func (o *optimizer) bounds(ope ast.OperatorType, key *ast.Expression) (*ast.Expression, *ast.Expression) {
+	step := func(d int) *ast.Expression {
+		if v, ok := constant(key); ok {
+			return o.typed(literal(v + d))
+		}
+		ope := ast.B_PLUS
+		if d < 0 {
+			ope, d = ast.B_MINUS, -d
+		}
+		return o.typed(&ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ope, Left: key, Right: o.typed(literal(d))}})
+	}
+	switch ope {
+	case ast.B_LESS:
+		return nil, step(-1)
+	case ast.B_LESS_EQUAL:
+		return nil, key
+	case ast.B_GREATER:
+		return step(1), nil
+	case ast.B_GREATER_EQUAL:
+		return key, nil
+	default:
+		return nil, nil
+	}
+}
+
+// constantBounds is bounds for a constant key.
+func constantBounds(ope ast.OperatorType, v int) (*int, *int) {
+	switch ope {
+	case ast.B_LESS:
+		v--
+		return nil, &v
+	case ast.B_LESS_EQUAL:
+		return nil, &v
+	case ast.B_GREATER:
+		v++
+		return &v, nil
+	case ast.B_GREATER_EQUAL:
+		return &v, nil
+	default:
+		return nil, nil
+	}
+}
+
+func isComparison(ope ast.OperatorType) bool {
+	switch ope {
+	case ast.B_LESS, ast.B_LESS_EQUAL, ast.B_GREATER, ast.B_GREATER_EQUAL:
+		return true
+	default:
+		return false
+	}
+}
+
+// flip returns the operator that compares the operands of ope swapped, as
+// > for <.
+func flip(ope ast.OperatorType) ast.OperatorType {
+	switch ope {
+	case ast.B_LESS:
+		return ast.B_GREATER
+	case ast.B_LESS_EQUAL:
+		return ast.B_GREATER_EQUAL
+	case ast.B_GREATER:
+		return ast.B_LESS
+	case ast.B_GREATER_EQUAL:
+		return ast.B_LESS_EQUAL
+	default:
+		return ope
+	}
+}
@@ -0,0 +1,237 @@
+package optimizer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/compiler/binder"
+	"github.com/yakawa/simpleDB/compiler/lexer"
+	"github.com/yakawa/simpleDB/compiler/parser"
+	"github.com/yakawa/simpleDB/compiler/plan"
+	"github.com/yakawa/simpleDB/runtime/stats"
+)
+
+type statistics struct {
+	tables  map[string]*stats.Table
+	indexes map[string]string
+}
+
+func (s *statistics) Statistics(db string, tbl string) (*stats.Table, bool) {
+	st, exists := s.tables[db+"."+tbl]
+	return st, exists
+}
+
+func (s *statistics) IndexOn(db string, tbl string, col string) (string, bool) {
+	name, exists := s.indexes[db+"."+tbl+"."+col]
+	return name, exists
+}
+
+func uniform(rows int, cols ...string) *stats.Table {
+	t := &stats.Table{Rows: rows, Columns: map[string]*stats.Column{}}
+	for _, c := range cols {
+		t.Columns[c] = &stats.Column{Name: c, Values: rows, Distinct: rows, Min: 0, Max: rows - 1}
+	}
+	return t
+}
+
+var testStatistics = &statistics{
+	tables: map[string]*stats.Table{
+		"_.tbl1": uniform(10000, "colA", "colB", "colC"),
+		"_.tbl2": uniform(100, "colA", "colC"),
+		"_.tbl3": uniform(10, "colA", "colD"),
+	},
+	indexes: map[string]string{"_.tbl1.colA": "idx_colA"},
+}
+
+func TestJoinOrder(t *testing.T) {
+	col := func(tbl string, c string) *ast.Expression {
+		return &ast.Expression{Column: &ast.Column{Table: tbl, Column: c}}
+	}
+	eq := func(l *ast.Expression, r *ast.Expression) *ast.Expression {
+		return &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ast.B_EQUAL, Left: l, Right: r}}
+	}
+	scan := func(tbl string) *plan.Scan {
+		return &plan.Scan{Table: &ast.Table{Table: tbl}}
+	}
+	build := func() plan.Node {
+		return &plan.Filter{
+			Condition: eq(col("tbl1", "colA"), col("tbl3", "colA")),
+			Input: &plan.Filter{
+				Condition: eq(col("tbl2", "colC"), col("tbl3", "colD")),
+				Input: &plan.Join{
+					Type:  plan.CrossJoin,
+					Left:  &plan.Join{Type: plan.CrossJoin, Left: scan("tbl1"), Right: scan("tbl2")},
+					Right: scan("tbl3"),
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		stats    Statistics
+		expected string
+	}{
+		{
+			testStatistics,
+			`Join INNER HASH ON tbl2.colC = tbl3.colD
+  Join INNER INDEX LOOKUP ON tbl1.colA = tbl3.colA
+    Scan tbl3 COLUMNS [colA, colD]
+    IndexScan tbl1 USING idx_colA (colA = tbl3.colA) COLUMNS [colA]
+  Scan tbl2 COLUMNS [colC]`,
+		},
+		{
+			nil,
+			`Join INNER HASH ON tbl2.colC = tbl3.colD
+  Join INNER HASH ON tbl1.colA = tbl3.colA
+    Scan tbl1 COLUMNS [colA]
+    Scan tbl3 COLUMNS [colA, colD]
+  Scan tbl2 COLUMNS [colC]`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		n := build()
+		b, err := binder.Bind(testCatalog, []plan.Node{n})
+		if err != nil {
+			t.Fatalf("[%d] error: %s", tn, err)
+		}
+		if actual := plan.Format(Optimize([]plan.Node{n}, b, tc.stats)[0]); actual != tc.expected {
+			t.Fatalf("[%d] expected\n%s\nbut got\n%s", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	col := func(c string) *ast.Expression {
+		return &ast.Expression{Column: &ast.Column{Column: c}}
+	}
+	num := func(n int) *ast.Expression {
+		return &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: n}}}
+	}
+	eq := func(l *ast.Expression, r *ast.Expression) *ast.Expression {
+		return &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ast.B_EQUAL, Left: l, Right: r}}
+	}
+
+	testCases := []struct {
+		node     plan.Node
+		stats    Statistics
+		expected float64
+	}{
+		{&plan.Scan{Table: &ast.Table{Table: "tbl1"}}, testStatistics, 10000},
+		{&plan.Scan{Table: &ast.Table{Table: "tbl1"}}, nil, defaultRows},
+		{&plan.Filter{Condition: eq(col("colA"), num(5)), Input: &plan.Scan{Table: &ast.Table{Table: "tbl1"}}}, testStatistics, 1},
+		{&plan.Filter{Condition: eq(col("colA"), num(-5)), Input: &plan.Scan{Table: &ast.Table{Table: "tbl1"}}}, testStatistics, 0},
+		{&plan.Filter{Condition: eq(col("colA"), num(5)), Input: &plan.Scan{Table: &ast.Table{Table: "tbl1"}}}, nil, defaultRows * defaultEqualSelectivity},
+		{&plan.Filter{Condition: col("colA"), Input: &plan.Scan{Table: &ast.Table{Table: "tbl2"}}}, testStatistics, 100 * defaultSelectivity},
+		{&plan.Aggregate{GroupBy: []*ast.Expression{col("colC")}, Input: &plan.Scan{Table: &ast.Table{Table: "tbl2"}}}, testStatistics, 100},
+		{&plan.Aggregate{Input: &plan.Scan{Table: &ast.Table{Table: "tbl2"}}}, testStatistics, 1},
+		{&plan.Limit{Limit: num(7), Input: &plan.Scan{Table: &ast.Table{Table: "tbl2"}}}, testStatistics, 7},
+		{&plan.Join{Type: plan.LeftJoin, Condition: num(0), Left: &plan.Scan{Table: &ast.Table{Table: "tbl3"}}, Right: &plan.Scan{Table: &ast.Table{Table: "tbl2"}}}, testStatistics, 10},
+	}
+
+	for tn, tc := range testCases {
+		b, err := binder.Bind(testCatalog, []plan.Node{tc.node})
+		if err != nil {
+			t.Fatalf("[%d] error: %s", tn, err)
+		}
+		if actual := Estimate(tc.node, b, tc.stats); math.Abs(actual-tc.expected) > 1e-9 {
+			t.Fatalf("[%d] %s expected %f but got %f", tn, plan.Format(tc.node), tc.expected, actual)
+		}
+	}
+}
+
+func TestIndexScan(t *testing.T) {
+	few := &statistics{
+		tables:  map[string]*stats.Table{"_.tbl1": uniform(10000, "colA")},
+		indexes: testStatistics.indexes,
+	}
+	few.tables["_.tbl1"].Columns["colA"] = &stats.Column{Name: "colA", Values: 10000, Distinct: 2, Min: 0, Max: 1}
+
+	testCases := []struct {
+		sql      string
+		stats    Statistics
+		expected string
+	}{
+		{
+			"SELECT colB FROM tbl1 WHERE colA = 5;",
+			testStatistics,
+			"Project [colB]\n  IndexScan tbl1 USING idx_colA (colA = 5) COLUMNS [colA, colB] FILTER [colA = 5]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colB = 5 AND ? = colA;",
+			testStatistics,
+			"Project [colB]\n  IndexScan tbl1 USING idx_colA (colA = ?1) COLUMNS [colA, colB] FILTER [colB = 5, ?1 = colA]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colB = 5;",
+			testStatistics,
+			"Project [colB]\n  Scan tbl1 COLUMNS [colB] FILTER [colB = 5]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colA = 1;",
+			few,
+			"Project [colB]\n  Scan tbl1 COLUMNS [colA, colB] FILTER [colA = 1]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colA = 5;",
+			nil,
+			"Project [colB]\n  Scan tbl1 COLUMNS [colA, colB] FILTER [colA = 5]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colA > 10 AND 20 >= colA;",
+			testStatistics,
+			"Project [colB]\n  IndexScan tbl1 USING idx_colA (colA >= 11 AND colA <= 20) COLUMNS [colA, colB] FILTER [colA > 10, 20 >= colA]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colA > 10;",
+			testStatistics,
+			"Project [colB]\n  Scan tbl1 COLUMNS [colA, colB] FILTER [colA > 10]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE colA < ? AND colA >= ?;",
+			testStatistics,
+			"Project [colB]\n  IndexScan tbl1 USING idx_colA (colA >= ?2 AND colA <= ?1 - 1) COLUMNS [colA, colB] FILTER [colA < ?1, colA >= ?2]",
+		},
+		{
+			"SELECT colB FROM tbl1 WHERE ? < colA;",
+			testStatistics,
+			"Project [colB]\n  Scan tbl1 COLUMNS [colA, colB] FILTER [?1 < colA]",
+		},
+		{
+			"SELECT colA FROM tbl1 ORDER BY colA DESC;",
+			testStatistics,
+			"Project [colA]\n  IndexScan tbl1 USING idx_colA ORDER BY colA DESC COLUMNS [colA]",
+		},
+		{
+			"SELECT colA FROM tbl1 WHERE colA < 100 ORDER BY colA LIMIT 5;",
+			testStatistics,
+			"Project [colA]\n  Limit 5\n    IndexScan tbl1 USING idx_colA (colA <= 99) ORDER BY colA COLUMNS [colA] FILTER [colA < 100]",
+		},
+		{
+			"SELECT colA FROM tbl1 WHERE colA = 5 ORDER BY colA;",
+			testStatistics,
+			"Project [colA]\n  IndexScan tbl1 USING idx_colA (colA = 5) COLUMNS [colA] FILTER [colA = 5]",
+		},
+		{
+			"SELECT colB FROM tbl1 ORDER BY colB;",
+			testStatistics,
+			"Project [colB]\n  Sort [colB]\n    Scan tbl1 COLUMNS [colB]",
+		},
+	}
+
+	for tn, tc := range testCases {
+		a, err := parser.Parse(lexer.Lex(tc.sql))
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		nodes := plan.Build(a)
+		b, err := binder.Bind(testCatalog, nodes)
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		if actual := plan.Format(Optimize(nodes, b, tc.stats)[0]); actual != tc.expected {
+			t.Fatalf("[%d] %s expected\n%s\nbut got\n%s", tn, tc.sql, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+// Package optimizer rewrites a bound logical plan into an equivalent plan
+// that is cheaper to run. Every rule keeps the result of the statement, so
+// an expression that fails at run time, like a division by zero, is left
+// as it is.
+package optimizer
+
+import (
+	"sort"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/value"
+	"github.com/yakawa/simpleDB/compiler/binder"
+	"github.com/yakawa/simpleDB/compiler/plan"
+)
+
+type optimizer struct {
+	bindings *binder.Bindings
+	stats    Statistics
+}
+
+// Optimize applies the rules to each plan in turn: constant folding,
+// predicate pushdown, join ordering, sort elimination, column pruning and
+// index selection.
+// b are the bindings of nodes; without them the rules that need to know
+// which table a column belongs to are skipped. s may be nil, in which case
+// joins are ordered on default estimates.
+func Optimize(nodes []plan.Node, b *binder.Bindings, s Statistics) []plan.Node {
+	o := &optimizer{bindings: b, stats: s}
+	optimized := []plan.Node{}
+	for _, n := range nodes {
+		optimized = append(optimized, o.optimize(n))
+	}
+	return optimized
+}
+
+// optimize optimizes the statement explained by an EXPLAIN, and records the
+// estimates of its operators for EXPLAIN QUERY PLAN and EXPLAIN ANALYZE.
+func (o *optimizer) optimize(n plan.Node) plan.Node {
+	if e, ok := n.(*plan.Explain); ok {
+		e.Input = o.optimize(e.Input)
+		if e.QueryPlan || e.Analyze {
+			e.Estimates = map[plan.Node]float64{}
+			o.estimates(e.Input, e.Estimates)
+		}
+		return e
+	}
+	n = o.fold(n)
+	n = o.pushFilters(n)
+	n = o.reorderJoins(n)
+	n = o.removeSorts(n, false)
+	o.pruneColumns(n)
+	return o.useIndexes(n)
+}
+
+func literal(n int) *ast.Expression {
+	return &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: n}}}
+}
+
+func constant(e *ast.Expression) (int, bool) {
+	if e != nil && e.Literal != nil && e.Literal.Numeric != nil {
+		return e.Literal.Numeric.Integral, true
+	}
+	return 0, false
+}
+
+// fold folds the expressions of n and drops the filters whose condition is
+// known. A filter that is never true becomes LIMIT 0.
+func (o *optimizer) fold(n plan.Node) plan.Node {
+	switch n := n.(type) {
+	case *plan.Filter:
+		n.Input = o.fold(n.Input)
+		n.Condition = o.foldExpression(n.Condition)
+		if v, ok := constant(n.Condition); ok {
+			if v != 0 {
+				return n.Input
+			}
+			return &plan.Limit{Input: n.Input, Limit: o.typed(literal(0))}
+		}
+	case *plan.Project:
+		n.Input = o.fold(n.Input)
+		n.Columns = o.foldExpressions(n.Columns)
+	case *plan.Join:
+		n.Left = o.fold(n.Left)
+		n.Right = o.fold(n.Right)
+		if n.Condition != nil {
+			n.Condition = o.foldExpression(n.Condition)
+			if v, ok := constant(n.Condition); ok && v != 0 && n.Type == plan.InnerJoin {
+				n.Type = plan.CrossJoin
+				n.Condition = nil
+			}
+		}
+	case *plan.Aggregate:
+		n.Input = o.fold(n.Input)
+		n.GroupBy = o.foldExpressions(n.GroupBy)
+		n.Aggregates = o.foldExpressions(n.Aggregates)
+	case *plan.Sort:
+		n.Input = o.fold(n.Input)
+		for k := range n.Keys {
+			n.Keys[k].Expression = o.foldExpression(n.Keys[k].Expression)
+		}
+	case *plan.Limit:
+		n.Input = o.fold(n.Input)
+		n.Limit = o.foldExpression(n.Limit)
+		if n.Offset != nil {
+			n.Offset = o.foldExpression(n.Offset)
+		}
+	case *plan.Union:
+		n.Left = o.fold(n.Left)
+		n.Right = o.fold(n.Right)
+	}
+	return n
+}
+
+func (o *optimizer) foldExpressions(exprs []*ast.Expression) []*ast.Expression {
+	folded := []*ast.Expression{}
+	for _, e := range exprs {
+		folded = append(folded, o.foldExpression(e))
+	}
+	return folded
+}
+
+// typed records the type of an expression made by the optimizer.
+func (o *optimizer) typed(e *ast.Expression) *ast.Expression {
+	if o.bindings != nil {
+		o.bindings.Types[e] = value.INTEGER
+	}
+	return e
+}
+
+// derived records that e replaces orig and has its type. The AST is shared
+// with the plan cache, so expressions are never changed in place.
+func (o *optimizer) derived(orig *ast.Expression, e *ast.Expression) *ast.Expression {
+	if o.bindings != nil {
+		o.bindings.Types[e] = o.bindings.Type(orig)
+	}
+	return e
+}
+
+// foldExpression evaluates the operations on constants of e and removes
+// the operations that do nothing, like x + 0 or - - x.
+func (o *optimizer) foldExpression(e *ast.Expression) *ast.Expression {
+	switch {
+	case e == nil:
+		return e
+	case e.UnaryOperation != nil:
+		u := e.UnaryOperation
+		x := o.foldExpression(u.Expr)
+		switch u.Operator {
+		case ast.U_PLUS:
+			return x
+		case ast.U_MINUS:
+			if v, ok := constant(x); ok {
+				return o.typed(literal(-v))
+			}
+			if x.UnaryOperation != nil && x.UnaryOperation.Operator == ast.U_MINUS {
+				return x.UnaryOperation.Expr
+			}
+		}
+		if x == u.Expr {
+			return e
+		}
+		return o.derived(e, &ast.Expression{UnaryOperation: &ast.UnaryOpe{Operator: u.Operator, Expr: x}})
+	case e.BinaryOperation != nil:
+		b := e.BinaryOperation
+		l := o.foldExpression(b.Left)
+		r := o.foldExpression(b.Right)
+		lv, lok := constant(l)
+		rv, rok := constant(r)
+		if lok && rok {
+			if v, ok := evaluate(b.Operator, lv, rv); ok {
+				return o.typed(literal(v))
+			}
+		}
+		if rok && identity(b.Operator, rv, false) {
+			return l
+		}
+		if lok && identity(b.Operator, lv, true) {
+			return r
+		}
+		if l == b.Left && r == b.Right {
+			return e
+		}
+		return o.derived(e, &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: b.Operator, Left: l, Right: r}})
+	case e.FunctionCall != nil:
+		f := e.FunctionCall
+		args := []ast.Expression{}
+		changed := false
+		for n := range f.Args {
+			a := o.foldExpression(&f.Args[n])
+			if a != &f.Args[n] {
+				changed = true
+			}
+			args = append(args, *a)
+		}
+		if !changed {
+			return e
+		}
+		return o.derived(e, &ast.Expression{FunctionCall: &ast.FunctionCall{Name: f.Name, Args: args}})
+	default:
+		return e
+	}
+}
+
+// evaluate computes a binary operation the way the VM does. Division by
+// zero is not folded so that it still fails when the statement runs.
+func evaluate(ope ast.OperatorType, l int, r int) (int, bool) {
+	switch ope {
+	case ast.B_PLUS:
+		return l + r, true
+	case ast.B_MINUS:
+		return l - r, true
+	case ast.B_ASTERISK:
+		return l * r, true
+	case ast.B_SOLIDAS:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	case ast.B_PERCENT:
+		if r == 0 {
+			return 0, false
+		}
+		return l % r, true
+	case ast.B_EQUAL:
+		return truth(l == r), true
+	case ast.B_LESS:
+		return truth(l < r), true
+	case ast.B_LESS_EQUAL:
+		return truth(l <= r), true
+	case ast.B_GREATER:
+		return truth(l > r), true
+	case ast.B_GREATER_EQUAL:
+		return truth(l >= r), true
+	case ast.B_AND:
+		if l != 0 && r != 0 {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// identity tells whether v is the identity element of ope on the given
+// side: 0 for + and -, 1 for * and /. Only x + 0, 0 + x, x - 0, x * 1,
+// 1 * x and x / 1 are removed.
+func identity(ope ast.OperatorType, v int, left bool) bool {
+	switch ope {
+	case ast.B_PLUS:
+		return v == 0
+	case ast.B_MINUS:
+		return v == 0 && !left
+	case ast.B_ASTERISK:
+		return v == 1
+	case ast.B_SOLIDAS:
+		return v == 1 && !left
+	default:
+		return false
+	}
+}
+
+// pushFilters moves every filter as close to the scans as it can go, into
+// the scan itself when it reaches one.
+func (o *optimizer) pushFilters(n plan.Node) plan.Node {
+	switch n := n.(type) {
+	case *plan.Filter:
+		input := o.pushFilters(n.Input)
+		return o.push(n.Condition, input)
+	case *plan.Project:
+		n.Input = o.pushFilters(n.Input)
+	case *plan.Join:
+		n.Left = o.pushFilters(n.Left)
+		n.Right = o.pushFilters(n.Right)
+	case *plan.Aggregate:
+		n.Input = o.pushFilters(n.Input)
+	case *plan.Sort:
+		n.Input = o.pushFilters(n.Input)
+	case *plan.Limit:
+		n.Input = o.pushFilters(n.Input)
+	case *plan.Union:
+		n.Left = o.pushFilters(n.Left)
+		n.Right = o.pushFilters(n.Right)
+	}
+	return n
+}
+
+// push places cond on top of n or below it. Projections, sorts and other
+// filters do not change which rows pass cond. Below a join cond goes to
+// the side that has all of its columns; the inner side of a LEFT JOIN is
+// skipped because the rows it does not match are still returned.
+func (o *optimizer) push(cond *ast.Expression, n plan.Node) plan.Node {
+	switch n := n.(type) {
+	case *plan.Scan:
+		n.Filters = append(n.Filters, cond)
+		return n
+	case *plan.Filter:
+		n.Input = o.push(cond, n.Input)
+		return n
+	case *plan.Project:
+		n.Input = o.push(cond, n.Input)
+		return n
+	case *plan.Sort:
+		n.Input = o.push(cond, n.Input)
+		return n
+	case *plan.Join:
+		refs, ok := o.relations(cond)
+		if !ok {
+			break
+		}
+		if contains(scanNames(n.Left), refs) {
+			n.Left = o.push(cond, n.Left)
+			return n
+		}
+		if n.Type == plan.LeftJoin {
+			break
+		}
+		if contains(scanNames(n.Right), refs) {
+			n.Right = o.push(cond, n.Right)
+			return n
+		}
+		if n.Type == plan.CrossJoin {
+			n.Type = plan.InnerJoin
+			n.Condition = cond
+			return n
+		}
+	}
+	return &plan.Filter{Input: n, Condition: cond}
+}
+
+// relations returns the names of the tables the columns of e belong to. It
+// fails when a column was not bound.
+func (o *optimizer) relations(e *ast.Expression) (map[string]bool, bool) {
+	refs := map[string]bool{}
+	ok := true
+	walkColumns(e, func(c *ast.Column) {
+		ref, bound := o.lookup(c)
+		if !bound {
+			ok = false
+			return
+		}
+		refs[ref.Relation] = true
+	})
+	return refs, ok
+}
+
+func (o *optimizer) lookup(c *ast.Column) (binder.ColumnRef, bool) {
+	if o.bindings == nil {
+		return binder.ColumnRef{}, false
+	}
+	ref, exists := o.bindings.Columns[c]
+	return ref, exists
+}
+
+func contains(names map[string]bool, refs map[string]bool) bool {
+	for r := range refs {
+		if !names[r] {
+			return false
+		}
+	}
+	return true
+}
+
+func scanName(s *plan.Scan) string {
+	if s.Table.Alias != "" {
+		return s.Table.Alias
+	}
+	return s.Table.Table
+}
+
+// scanNames returns the names of the tables visible above n. As in the
+// binder, only the right side of a union is visible.
+func scanNames(n plan.Node) map[string]bool {
+	names := map[string]bool{}
+	switch n := n.(type) {
+	case *plan.Scan:
+		names[scanName(n)] = true
+	case *plan.IndexScan:
+		names[scanName(n.Scan)] = true
+	case *plan.Union:
+		return scanNames(n.Right)
+	default:
+		for _, c := range n.Children() {
+			for name := range scanNames(c) {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// removeSorts drops the sorts whose order is not used. unordered tells
+// that the operator above n does not depend on the order of its input, as
+// for an aggregate, a union or another sort.
+func (o *optimizer) removeSorts(n plan.Node, unordered bool) plan.Node {
+	switch n := n.(type) {
+	case *plan.Sort:
+		keys := []plan.SortKey{}
+		for _, k := range n.Keys {
+			if _, ok := constant(k.Expression); ok || k.Expression.Parameter != nil {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		n.Keys = keys
+		if _, single := n.Input.(*plan.Values); unordered || single || len(keys) == 0 {
+			return o.removeSorts(n.Input, unordered)
+		}
+		n.Input = o.removeSorts(n.Input, true)
+	case *plan.Filter:
+		n.Input = o.removeSorts(n.Input, unordered)
+	case *plan.Project:
+		n.Input = o.removeSorts(n.Input, unordered)
+	case *plan.Join:
+		n.Left = o.removeSorts(n.Left, false)
+		n.Right = o.removeSorts(n.Right, false)
+	case *plan.Aggregate:
+		n.Input = o.removeSorts(n.Input, true)
+	case *plan.Limit:
+		n.Input = o.removeSorts(n.Input, false)
+	case *plan.Union:
+		n.Left = o.removeSorts(n.Left, true)
+		n.Right = o.removeSorts(n.Right, true)
+	}
+	return n
+}
+
+// pruneColumns sets the columns each scan of n has to read to those used
+// by the plan. Nothing is pruned if a column is not bound.
+func (o *optimizer) pruneColumns(n plan.Node) {
+	used := map[string][]binder.ColumnRef{}
+	ok := true
+	walkNode(n, func(e *ast.Expression) {
+		walkColumns(e, func(c *ast.Column) {
+			ref, bound := o.lookup(c)
+			if !bound {
+				ok = false
+				return
+			}
+			used[ref.Relation] = append(used[ref.Relation], ref)
+		})
+	})
+	if !ok {
+		return
+	}
+	walkScans(n, func(s *plan.Scan) {
+		refs := used[scanName(s)]
+		sort.Slice(refs, func(i, j int) bool { return refs[i].Index < refs[j].Index })
+		cols := []string{}
+		for k, ref := range refs {
+			if k == 0 || refs[k-1].Index != ref.Index {
+				cols = append(cols, ref.Column)
+			}
+		}
+		s.Columns = cols
+	})
+}
+
+func walkScans(n plan.Node, fn func(*plan.Scan)) {
+	switch n := n.(type) {
+	case *plan.Scan:
+		fn(n)
+	case *plan.IndexScan:
+		fn(n.Scan)
+	}
+	for _, c := range n.Children() {
+		walkScans(c, fn)
+	}
+}
+
+// walkNode calls fn with every expression of n and of its inputs.
+func walkNode(n plan.Node, fn func(*ast.Expression)) {
+	switch n := n.(type) {
+	case *plan.Scan:
+		for _, e := range n.Filters {
+			fn(e)
+		}
+	case *plan.IndexScan:
+		for _, e := range []*ast.Expression{n.Key, n.Low, n.High} {
+			if e != nil {
+				fn(e)
+			}
+		}
+		for _, e := range n.Scan.Filters {
+			fn(e)
+		}
+	case *plan.Filter:
+		fn(n.Condition)
+	case *plan.Project:
+		for _, e := range n.Columns {
+			fn(e)
+		}
+	case *plan.Join:
+		if n.Condition != nil {
+			fn(n.Condition)
+		}
+	case *plan.Aggregate:
+		for _, e := range n.GroupBy {
+			fn(e)
+		}
+		for _, e := range n.Aggregates {
+			fn(e)
+		}
+	case *plan.Sort:
+		for _, k := range n.Keys {
+			fn(k.Expression)
+		}
+	}
+	for _, c := range n.Children() {
+		walkNode(c, fn)
+	}
+}
+
+func walkColumns(e *ast.Expression, fn func(*ast.Column)) {
+	switch {
+	case e == nil:
+	case e.Column != nil:
+		fn(e.Column)
+	case e.UnaryOperation != nil:
+		walkColumns(e.UnaryOperation.Expr, fn)
+	case e.BinaryOperation != nil:
+		walkColumns(e.BinaryOperation.Left, fn)
+		walkColumns(e.BinaryOperation.Right, fn)
+	case e.FunctionCall != nil:
+		for n := range e.FunctionCall.Args {
+			walkColumns(&e.FunctionCall.Args[n], fn)
+		}
+	}
+}
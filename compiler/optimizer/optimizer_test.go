@@ -0,0 +1,179 @@
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/compiler/binder"
+	"github.com/yakawa/simpleDB/compiler/lexer"
+	"github.com/yakawa/simpleDB/compiler/parser"
+	"github.com/yakawa/simpleDB/compiler/plan"
+)
+
+type catalog map[string][]string
+
+func (c catalog) Columns(db string, tbl string) ([]string, error) {
+	cols, exists := c[db+"."+tbl]
+	if !exists {
+		return []string{}, errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
+	}
+	return cols, nil
+}
+
+var testCatalog = catalog{
+	"_.tbl1": {"colA", "colB", "colC"},
+	"_.tbl2": {"colA", "colC"},
+	"_.tbl3": {"colA", "colD"},
+}
+
+func TestOptimizeSQL(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT 1 + 2 * 3;", "Project [7]\n  Values"},
+		{"SELECT -(-colA), colC * 1 + 0, 0 - colB, 10 / 0, ABS(2 - 3) FROM tbl1;", "Project [colA, colC, 0 - colB, 10 / 0, ABS(-1)]\n  Scan tbl1 COLUMNS [colA, colB, colC]"},
+		{"SELECT colC, colA + colC FROM tbl1;", "Project [colC, colA + colC]\n  Scan tbl1 COLUMNS [colA, colC]"},
+		{"SELECT 1 FROM tbl1;", "Project [1]\n  Scan tbl1 COLUMNS []"},
+		{"SELECT ? * 1;", "Project [?1]\n  Values"},
+	}
+
+	for tn, tc := range testCases {
+		a, err := parser.Parse(lexer.Lex(tc.sql))
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		nodes := plan.Build(a)
+		b, err := binder.Bind(testCatalog, nodes)
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		original := plan.Format(plan.Build(a)[0])
+		if actual := plan.Format(Optimize(nodes, b, nil)[0]); actual != tc.expected {
+			t.Fatalf("[%d] %s expected\n%s\nbut got\n%s", tn, tc.sql, tc.expected, actual)
+		}
+		if actual := plan.Format(plan.Build(a)[0]); actual != original {
+			t.Fatalf("[%d] %s AST is changed\n%s", tn, tc.sql, actual)
+		}
+	}
+}
+
+func TestOptimizePlan(t *testing.T) {
+	col := func(tbl string, c string) *ast.Expression {
+		return &ast.Expression{Column: &ast.Column{Table: tbl, Column: c}}
+	}
+	num := func(n int) *ast.Expression {
+		return &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: n}}}
+	}
+	add := func(l *ast.Expression, r *ast.Expression) *ast.Expression {
+		return &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ast.B_PLUS, Left: l, Right: r}}
+	}
+	scan := func(tbl string, alias string) *plan.Scan {
+		return &plan.Scan{Table: &ast.Table{Table: tbl, Alias: alias}}
+	}
+
+	testCases := []struct {
+		node     plan.Node
+		expected string
+	}{
+		{
+			&plan.Project{
+				Columns: []*ast.Expression{col("a", "colB")},
+				Input: &plan.Filter{
+					Condition: add(num(1), num(1)),
+					Input: &plan.Filter{
+						Condition: add(col("a", "colA"), col("b", "colC")),
+						Input: &plan.Filter{
+							Condition: col("b", "colC"),
+							Input: &plan.Sort{
+								Keys: []plan.SortKey{{Expression: col("b", "colC")}},
+								Input: &plan.Filter{
+									Condition: col("a", "colA"),
+									Input:     &plan.Join{Type: plan.CrossJoin, Left: scan("tbl1", "a"), Right: scan("tbl2", "b")},
+								},
+							},
+						},
+					},
+				},
+			},
+			`Project [a.colB]
+  Sort [b.colC]
+    Join INNER NESTED LOOP ON a.colA + b.colC
+      Scan tbl1 AS a COLUMNS [colA, colB] FILTER [a.colA]
+      Scan tbl2 AS b COLUMNS [colC] FILTER [b.colC]`,
+		},
+		{
+			&plan.Filter{
+				Condition: add(num(1), num(-1)),
+				Input: &plan.Aggregate{
+					GroupBy:    []*ast.Expression{col("a", "colA")},
+					Aggregates: []*ast.Expression{{FunctionCall: &ast.FunctionCall{Name: "ABS", Args: []ast.Expression{*col("a", "colA")}}}},
+					Input: &plan.Sort{
+						Keys: []plan.SortKey{{Expression: col("a", "colA")}},
+						Input: &plan.Filter{
+							Condition: col("b", "colC"),
+							Input:     &plan.Join{Type: plan.LeftJoin, Left: scan("tbl1", "a"), Right: scan("tbl2", "b"), Condition: col("a", "colA")},
+						},
+					},
+				},
+			},
+			`Limit 0
+  Aggregate [ABS(a.colA)] GROUP BY [a.colA]
+    Filter b.colC
+      Join LEFT NESTED LOOP ON a.colA
+        Scan tbl1 AS a COLUMNS [colA]
+        Scan tbl2 AS b COLUMNS [colC]`,
+		},
+		{
+			&plan.Sort{
+				Keys: []plan.SortKey{{Expression: num(1)}, {Expression: &ast.Expression{Parameter: &ast.Parameter{Index: 1}}}},
+				Input: &plan.Limit{
+					Limit: add(num(2), num(3)),
+					Input: &plan.Sort{
+						Keys:  []plan.SortKey{{Expression: col("", "colA"), Desc: true}},
+						Input: &plan.Sort{Keys: []plan.SortKey{{Expression: col("", "colB")}}, Input: scan("tbl1", "")},
+					},
+				},
+			},
+			`Limit 5
+  Sort [colA DESC]
+    Scan tbl1 COLUMNS [colA]`,
+		},
+		{
+			&plan.Join{Type: plan.InnerJoin, Left: scan("tbl1", ""), Right: scan("tbl2", ""), Condition: num(3)},
+			`Join CROSS NESTED LOOP
+  Scan tbl1 COLUMNS []
+  Scan tbl2 COLUMNS []`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		b, err := binder.Bind(testCatalog, []plan.Node{tc.node})
+		if err != nil {
+			t.Fatalf("[%d] error: %s", tn, err)
+		}
+		if actual := plan.Format(Optimize([]plan.Node{tc.node}, b, nil)[0]); actual != tc.expected {
+			t.Fatalf("[%d] expected\n%s\nbut got\n%s", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestOptimizeWithoutBindings(t *testing.T) {
+	n := &plan.Filter{
+		Condition: &ast.Expression{Column: &ast.Column{Column: "colA"}},
+		Input: &plan.Join{
+			Type:  plan.CrossJoin,
+			Left:  &plan.Scan{Table: &ast.Table{Table: "tbl1"}},
+			Right: &plan.Scan{Table: &ast.Table{Table: "tbl2"}},
+		},
+	}
+	expected := `Filter colA
+  Join CROSS NESTED LOOP
+    Scan tbl1
+    Scan tbl2`
+	if actual := plan.Format(Optimize([]plan.Node{n}, nil, nil)[0]); actual != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, actual)
+	}
+}
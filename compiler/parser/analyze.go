@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+func (p *parser) parseANALYZEStatement() (*ast.ANALYZEStatement, error) {
+	statement := &ast.ANALYZEStatement{}
+
+	if p.currentToken.Type != token.K_ANALYZE {
+		return statement, errors.New("ANALYZE missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, nil
+	}
+	tbl, err := p.parseTable()
+	if err != nil {
+		return statement, err
+	}
+	statement.Table = tbl
+	p.readToken()
+
+	return statement, nil
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+// parseEXPLAINStatement parses EXPLAIN [QUERY PLAN | ANALYZE] stmt. QUERY
+// and PLAN are not reserved, so they are matched as identifiers. ANALYZE
+// followed by a table name or by nothing is the statement being explained.
+func (p *parser) parseEXPLAINStatement() (*ast.EXPLAINStatement, error) {
+	statement := &ast.EXPLAINStatement{}
+
+	if p.currentToken.Type != token.K_EXPLAIN {
+		return statement, errors.New("EXPLAIN missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type == token.IDENT && strings.ToUpper(p.currentToken.Literal) == "QUERY" {
+		p.readToken()
+		if p.currentToken.Type != token.IDENT || strings.ToUpper(p.currentToken.Literal) != "PLAN" {
+			return statement, errors.New("PLAN missing")
+		}
+		statement.QueryPlan = true
+		p.readToken()
+	} else if p.currentToken.Type == token.K_ANALYZE {
+		switch p.getNextToken().Type {
+		case token.IDENT, token.S_SEMICOLON, token.EOS:
+		default:
+			statement.Analyze = true
+			p.readToken()
+		}
+	}
+	if p.currentToken.Type == token.K_EXPLAIN {
+		return statement, errors.New("EXPLAIN cannot be nested")
+	}
+
+	sql, err := p.parseSQL()
+	if err != nil {
+		return statement, err
+	}
+	statement.SQL = &sql
+
+	return statement, nil
+}
@@ -47,6 +47,44 @@ func (p *parser) parseNumber() (*ast.Expression, error) {
 	return expr, nil
 }
 
+const maxParameterIndex = 999
+
+// parseParameter numbers a bind parameter. A bare ? takes the index after
+// the largest one seen so far and every occurrence of a :name shares one
+// index.
+func (p *parser) parseParameter() (*ast.Expression, error) {
+	lit := p.currentToken.Literal
+	param := &ast.Parameter{}
+	switch {
+	case lit[0] == ':':
+		param.Name = lit[1:]
+		for n, name := range p.parameters {
+			if name == param.Name {
+				param.Index = n + 1
+			}
+		}
+		if param.Index == 0 {
+			p.parameters = append(p.parameters, param.Name)
+			param.Index = len(p.parameters)
+		}
+	case lit == "?":
+		p.parameters = append(p.parameters, "")
+		param.Index = len(p.parameters)
+	default:
+		param.Index = p.currentToken.Value.Integer
+		if param.Index > maxParameterIndex {
+			return &ast.Expression{}, errors.New(fmt.Sprintf("Parameter Index out of range: %s", lit))
+		}
+		for len(p.parameters) < param.Index {
+			p.parameters = append(p.parameters, "")
+		}
+	}
+	if param.Index > maxParameterIndex {
+		return &ast.Expression{}, errors.New(fmt.Sprintf("Too many Parameters: %s", lit))
+	}
+	return &ast.Expression{Parameter: param}, nil
+}
+
 func (p *parser) parseIdent() (*ast.Expression, error) {
 	if p.getNextToken().Type == token.S_LPAREN {
 		expr, err := p.parseFunctionCallExpr()
@@ -81,6 +119,18 @@ func (p *parser) parseBinaryExpr(left *ast.Expression) (*ast.Expression, error)
 		expr.BinaryOperation.Operator = ast.B_SOLIDAS
 	case token.S_PERCENT:
 		expr.BinaryOperation.Operator = ast.B_PERCENT
+	case token.S_EQUAL:
+		expr.BinaryOperation.Operator = ast.B_EQUAL
+	case token.S_LESS:
+		expr.BinaryOperation.Operator = ast.B_LESS
+	case token.S_LESS_EQUAL:
+		expr.BinaryOperation.Operator = ast.B_LESS_EQUAL
+	case token.S_GREATER:
+		expr.BinaryOperation.Operator = ast.B_GREATER
+	case token.S_GREATER_EQUAL:
+		expr.BinaryOperation.Operator = ast.B_GREATER_EQUAL
+	case token.K_AND:
+		expr.BinaryOperation.Operator = ast.B_AND
 	}
 	precedence := p.getCurrentTokenPrecedence()
 
@@ -155,11 +205,23 @@ func (p *parser) parseFunctionCallExpr() (*ast.Expression, error) {
 	return expr, nil
 }
 
+// parseColumnExpr parses a column, which may be qualified by the name of
+// its table as in tbl.col.
 func (p *parser) parseColumnExpr() (*ast.Expression, error) {
 	expr := &ast.Expression{
 		Column: &ast.Column{},
 	}
 	expr.Column.Column = p.currentToken.Literal
+	if p.getNextToken().Type != token.S_DOT {
+		return expr, nil
+	}
+	p.readToken()
+	p.readToken()
+	if p.currentToken.Type != token.IDENT {
+		return expr, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	expr.Column.Table = expr.Column.Column
+	expr.Column.Column = p.currentToken.Literal
 
 	return expr, nil
 }
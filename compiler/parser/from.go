@@ -1,20 +1,89 @@
 package parser
 
-import "github.com/yakawa/simpleDB/common/ast"
+import (
+	"errors"
+	"fmt"
 
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+// parseFROMClause parses a table followed by the tables joined to it with
+// a comma, CROSS JOIN or [INNER] JOIN ... ON.
 func (p *parser) parseFROMClause() (*ast.FROMClause, error) {
 	from := &ast.FROMClause{}
 
-	tbl, err := p.parseTable()
+	tbl, err := p.parseTableReference()
 	if err != nil {
 		return from, err
 	}
-
 	from.Table = tbl
 
+	for {
+		join := ast.JoinClause{}
+		switch p.currentToken.Type {
+		case token.S_COMMA:
+			join.Operator = ast.JOIN_CROSS
+		case token.K_CROSS, token.K_INNER:
+			join.Operator = ast.JOIN_INNER
+			if p.currentToken.Type == token.K_CROSS {
+				join.Operator = ast.JOIN_CROSS
+			}
+			p.readToken()
+			if p.currentToken.Type != token.K_JOIN {
+				return from, errors.New("JOIN missing")
+			}
+		case token.K_JOIN:
+			join.Operator = ast.JOIN_INNER
+		default:
+			return from, nil
+		}
+		p.readToken()
+
+		tbl, err := p.parseTableReference()
+		if err != nil {
+			return from, err
+		}
+		join.Table = tbl
+		if join.Operator == ast.JOIN_INNER {
+			if p.currentToken.Type != token.K_ON {
+				return from, errors.New("ON missing")
+			}
+			p.readToken()
+			on, err := p.parseExpression(LOWEST)
+			if err != nil {
+				return from, err
+			}
+			join.On = on
+			p.readToken()
+		}
+		from.Joins = append(from.Joins, join)
+	}
+}
+
+// parseTableReference parses a table with an optional alias, given with or
+// without AS, and moves past them.
+func (p *parser) parseTableReference() (*ast.Table, error) {
+	if p.currentToken.Type != token.IDENT {
+		return &ast.Table{}, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	tbl, err := p.parseTable()
+	if err != nil {
+		return tbl, err
+	}
 	p.readToken()
 
-	return from, nil
+	if p.currentToken.Type == token.K_AS {
+		p.readToken()
+		if p.currentToken.Type != token.IDENT {
+			return tbl, errors.New("Alias missing")
+		}
+	}
+	if p.currentToken.Type == token.IDENT {
+		tbl.Alias = p.currentToken.Literal
+		p.readToken()
+	}
+	return tbl, nil
 }
 
 func (p *parser) parseTable() (*ast.Table, error) {
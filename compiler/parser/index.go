@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+func (p *parser) parseCREATEINDEXStatement() (*ast.CREATEINDEXStatement, error) {
+	statement := &ast.CREATEINDEXStatement{}
+
+	if p.currentToken.Type != token.K_CREATE {
+		return statement, errors.New("CREATE missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type == token.K_UNIQUE {
+		statement.Unique = true
+		p.readToken()
+	}
+	if p.currentToken.Type != token.K_INDEX {
+		return statement, errors.New("INDEX missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	statement.Index = p.currentToken.Literal
+	p.readToken()
+
+	if p.currentToken.Type != token.K_ON {
+		return statement, errors.New("ON missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	tbl, err := p.parseTable()
+	if err != nil {
+		return statement, err
+	}
+	statement.Table = tbl
+	p.readToken()
+
+	cols, err := p.parseIndexedColumns()
+	if err != nil {
+		return statement, err
+	}
+	statement.Columns = cols
+
+	return statement, nil
+}
+
+func (p *parser) parseIndexedColumns() ([]string, error) {
+	cols := []string{}
+
+	if p.currentToken.Type != token.S_LPAREN {
+		return cols, errors.New("( missing")
+	}
+	p.readToken()
+
+	for {
+		if p.currentToken.Type != token.IDENT {
+			return cols, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+		}
+		cols = append(cols, p.currentToken.Literal)
+		p.readToken()
+
+		if p.currentToken.Type == token.S_COMMA {
+			p.readToken()
+			continue
+		}
+		if p.currentToken.Type == token.S_RPAREN {
+			p.readToken()
+			break
+		}
+		return cols, errors.New(") missing")
+	}
+	return cols, nil
+}
+
+func (p *parser) parseDROPINDEXStatement() (*ast.DROPINDEXStatement, error) {
+	statement := &ast.DROPINDEXStatement{}
+
+	if p.currentToken.Type != token.K_DROP {
+		return statement, errors.New("DROP missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.K_INDEX {
+		return statement, errors.New("INDEX missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	statement.Index = p.currentToken.Literal
+	p.readToken()
+
+	return statement, nil
+}
@@ -13,6 +13,7 @@ type parser struct {
 	tokens       token.Tokens
 	currentToken token.Token
 	pos          int
+	parameters   []string
 
 	unaryParseFunc  map[token.Type]unaryOpeFunction
 	binaryParseFunc map[token.Type]binaryOpeFunction
@@ -26,6 +27,7 @@ func Parse(tokens token.Tokens) (*ast.AST, error) {
 		return a, err
 	}
 	a.SQL = sql
+	a.Parameters = p.parameters
 	return a, nil
 }
 
@@ -37,17 +39,25 @@ type (
 const (
 	_ int = iota
 	LOWEST
-	SUM     // + -
-	PRODUCT // * /
+	CONJUNCTION // AND
+	EQUALS      // = < <= > >=
+	SUM         // + -
+	PRODUCT     // * /
 	HIGHEST
 )
 
 var precedences = map[token.Type]int{
-	token.S_PLUS:     SUM,
-	token.S_MINUS:    SUM,
-	token.S_ASTERISK: PRODUCT,
-	token.S_SOLIDAS:  PRODUCT,
-	token.S_PERCENT:  PRODUCT,
+	token.S_PLUS:          SUM,
+	token.S_MINUS:         SUM,
+	token.S_ASTERISK:      PRODUCT,
+	token.S_SOLIDAS:       PRODUCT,
+	token.S_PERCENT:       PRODUCT,
+	token.S_EQUAL:         EQUALS,
+	token.S_LESS:          EQUALS,
+	token.S_LESS_EQUAL:    EQUALS,
+	token.S_GREATER:       EQUALS,
+	token.S_GREATER_EQUAL: EQUALS,
+	token.K_AND:           CONJUNCTION,
 }
 
 func new(tokens token.Tokens) *parser {
@@ -64,12 +74,19 @@ func new(tokens token.Tokens) *parser {
 	p.unaryParseFunc[token.S_PLUS] = p.parsePrefixExpr
 	p.unaryParseFunc[token.S_MINUS] = p.parsePrefixExpr
 	p.unaryParseFunc[token.IDENT] = p.parseIdent
+	p.unaryParseFunc[token.PARAMETER] = p.parseParameter
 
 	p.binaryParseFunc[token.S_PLUS] = p.parseBinaryExpr
 	p.binaryParseFunc[token.S_MINUS] = p.parseBinaryExpr
 	p.binaryParseFunc[token.S_ASTERISK] = p.parseBinaryExpr
 	p.binaryParseFunc[token.S_SOLIDAS] = p.parseBinaryExpr
 	p.binaryParseFunc[token.S_PERCENT] = p.parseBinaryExpr
+	p.binaryParseFunc[token.S_EQUAL] = p.parseBinaryExpr
+	p.binaryParseFunc[token.S_LESS] = p.parseBinaryExpr
+	p.binaryParseFunc[token.S_LESS_EQUAL] = p.parseBinaryExpr
+	p.binaryParseFunc[token.S_GREATER] = p.parseBinaryExpr
+	p.binaryParseFunc[token.S_GREATER_EQUAL] = p.parseBinaryExpr
+	p.binaryParseFunc[token.K_AND] = p.parseBinaryExpr
 
 	return p
 }
@@ -121,18 +138,11 @@ func (p *parser) getNextTokenPrecedence() int {
 func (p *parser) parse() ([]ast.SQL, error) {
 	SQLs := []ast.SQL{}
 	for {
-		if p.currentToken.Type == token.K_SELECT {
-			ss, err := p.parseSELECTStatement()
-			if err != nil {
-				return SQLs, err
-			}
-			sql := ast.SQL{
-				SELECTStatement: ss,
-			}
-			SQLs = append(SQLs, sql)
-		} else {
-			return SQLs, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+		sql, err := p.parseSQL()
+		if err != nil {
+			return SQLs, err
 		}
+		SQLs = append(SQLs, sql)
 		if p.currentToken.Type == token.S_SEMICOLON {
 			p.readToken()
 		}
@@ -142,3 +152,41 @@ func (p *parser) parse() ([]ast.SQL, error) {
 	}
 	return SQLs, nil
 }
+
+func (p *parser) parseSQL() (ast.SQL, error) {
+	sql := ast.SQL{}
+	var err error
+	switch p.currentToken.Type {
+	case token.K_SELECT:
+		sql.SELECTStatement, err = p.parseSELECTStatement()
+	case token.K_CREATE:
+		if p.getNextToken().Type == token.K_TABLE {
+			sql.CREATETABLEStatement, err = p.parseCREATETABLEStatement()
+		} else {
+			sql.CREATEINDEXStatement, err = p.parseCREATEINDEXStatement()
+		}
+	case token.K_DROP:
+		sql.DROPINDEXStatement, err = p.parseDROPINDEXStatement()
+	case token.K_PRAGMA:
+		sql.PRAGMAStatement, err = p.parsePRAGMAStatement()
+	case token.K_BEGIN:
+		sql.BEGINStatement, err = p.parseBEGINStatement()
+	case token.K_COMMIT, token.K_END:
+		sql.COMMITStatement, err = p.parseCOMMITStatement()
+	case token.K_ROLLBACK:
+		sql.ROLLBACKStatement, err = p.parseROLLBACKStatement()
+	case token.K_SAVEPOINT:
+		sql.SAVEPOINTStatement, err = p.parseSAVEPOINTStatement()
+	case token.K_RELEASE:
+		sql.RELEASEStatement, err = p.parseRELEASEStatement()
+	case token.K_ANALYZE:
+		sql.ANALYZEStatement, err = p.parseANALYZEStatement()
+	case token.K_EXPLAIN:
+		sql.EXPLAINStatement, err = p.parseEXPLAINStatement()
+	case token.K_SET:
+		sql.SETStatement, err = p.parseSETStatement()
+	default:
+		return sql, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	return sql, err
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/yakawa/simpleDB/common/ast"
 	"github.com/yakawa/simpleDB/common/token"
 	"github.com/yakawa/simpleDB/common/value"
+	"github.com/yakawa/simpleDB/compiler/lexer"
 )
 
 func TestParser(t *testing.T) {
@@ -529,6 +530,684 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			sql: "CREATE UNIQUE INDEX idx1 ON tbl1(colA, colB);",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_CREATE,
+					Literal: "CREATE",
+				},
+				{
+					Type:    token.K_UNIQUE,
+					Literal: "UNIQUE",
+				},
+				{
+					Type:    token.K_INDEX,
+					Literal: "INDEX",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "idx1",
+				},
+				{
+					Type:    token.K_ON,
+					Literal: "ON",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "tbl1",
+				},
+				{
+					Type:    token.S_LPAREN,
+					Literal: "(",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "colA",
+				},
+				{
+					Type:    token.S_COMMA,
+					Literal: ",",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "colB",
+				},
+				{
+					Type:    token.S_RPAREN,
+					Literal: ")",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						CREATEINDEXStatement: &ast.CREATEINDEXStatement{
+							Index:  "idx1",
+							Unique: true,
+							Table: &ast.Table{
+								Table: "tbl1",
+							},
+							Columns: []string{"colA", "colB"},
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "DROP INDEX idx1;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_DROP,
+					Literal: "DROP",
+				},
+				{
+					Type:    token.K_INDEX,
+					Literal: "INDEX",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "idx1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						DROPINDEXStatement: &ast.DROPINDEXStatement{
+							Index: "idx1",
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "PRAGMA cache_size = 100;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_PRAGMA,
+					Literal: "PRAGMA",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "cache_size",
+				},
+				{
+					Type:    token.S_EQUAL,
+					Literal: "=",
+				},
+				{
+					Type:    token.NUMBER,
+					Literal: "100",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 100,
+					},
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						PRAGMAStatement: &ast.PRAGMAStatement{
+							Name: "cache_size",
+							Value: &ast.Expression{
+								Literal: &ast.Literal{
+									Numeric: &ast.Numeric{
+										Integral: 100,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "PRAGMA cache_stats;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_PRAGMA,
+					Literal: "PRAGMA",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "cache_stats",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						PRAGMAStatement: &ast.PRAGMAStatement{
+							Name: "cache_stats",
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "BEGIN IMMEDIATE TRANSACTION; SAVEPOINT sp1; RELEASE SAVEPOINT sp1; ROLLBACK TO sp1; ROLLBACK; COMMIT;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_BEGIN,
+					Literal: "BEGIN",
+				},
+				{
+					Type:    token.K_IMMEDIATE,
+					Literal: "IMMEDIATE",
+				},
+				{
+					Type:    token.K_TRANSACTION,
+					Literal: "TRANSACTION",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_SAVEPOINT,
+					Literal: "SAVEPOINT",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "sp1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_RELEASE,
+					Literal: "RELEASE",
+				},
+				{
+					Type:    token.K_SAVEPOINT,
+					Literal: "SAVEPOINT",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "sp1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_ROLLBACK,
+					Literal: "ROLLBACK",
+				},
+				{
+					Type:    token.K_TO,
+					Literal: "TO",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "sp1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_ROLLBACK,
+					Literal: "ROLLBACK",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_COMMIT,
+					Literal: "COMMIT",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						BEGINStatement: &ast.BEGINStatement{
+							Immediate: true,
+						},
+					},
+					{
+						SAVEPOINTStatement: &ast.SAVEPOINTStatement{
+							Savepoint: "sp1",
+						},
+					},
+					{
+						RELEASEStatement: &ast.RELEASEStatement{
+							Savepoint: "sp1",
+						},
+					},
+					{
+						ROLLBACKStatement: &ast.ROLLBACKStatement{
+							Savepoint: "sp1",
+						},
+					},
+					{
+						ROLLBACKStatement: &ast.ROLLBACKStatement{},
+					},
+					{
+						COMMITStatement: &ast.COMMITStatement{},
+					},
+				},
+			},
+		},
+		{
+			sql: "SELECT ?, :a + ?5, :a;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_SELECT,
+					Literal: "SELECT",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: "?",
+				},
+				{
+					Type:    token.S_COMMA,
+					Literal: ",",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: ":a",
+				},
+				{
+					Type:    token.S_PLUS,
+					Literal: "+",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: "?5",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 5,
+					},
+				},
+				{
+					Type:    token.S_COMMA,
+					Literal: ",",
+				},
+				{
+					Type:    token.PARAMETER,
+					Literal: ":a",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						SELECTStatement: &ast.SELECTStatement{
+							Select: &ast.SELECTClause{
+								ResultColumns: []ast.ResultColumn{
+									{
+										Expression: &ast.Expression{
+											Parameter: &ast.Parameter{
+												Index: 1,
+											},
+										},
+									},
+									{
+										Expression: &ast.Expression{
+											BinaryOperation: &ast.BinaryOpe{
+												Operator: ast.B_PLUS,
+												Left: &ast.Expression{
+													Parameter: &ast.Parameter{
+														Index: 2,
+														Name:  "a",
+													},
+												},
+												Right: &ast.Expression{
+													Parameter: &ast.Parameter{
+														Index: 5,
+													},
+												},
+											},
+										},
+									},
+									{
+										Expression: &ast.Expression{
+											Parameter: &ast.Parameter{
+												Index: 2,
+												Name:  "a",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				Parameters: []string{"", "a", "", "", ""},
+			},
+		},
+		{
+			sql: "SELECT colA = 1 + 2; ANALYZE; ANALYZE tbl1;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_SELECT,
+					Literal: "SELECT",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "colA",
+				},
+				{
+					Type:    token.S_EQUAL,
+					Literal: "=",
+				},
+				{
+					Type:    token.NUMBER,
+					Literal: "1",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 1,
+					},
+				},
+				{
+					Type:    token.S_PLUS,
+					Literal: "+",
+				},
+				{
+					Type:    token.NUMBER,
+					Literal: "2",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 2,
+					},
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_ANALYZE,
+					Literal: "ANALYZE",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_ANALYZE,
+					Literal: "ANALYZE",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "tbl1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						SELECTStatement: &ast.SELECTStatement{
+							Select: &ast.SELECTClause{
+								ResultColumns: []ast.ResultColumn{
+									{
+										Expression: &ast.Expression{
+											BinaryOperation: &ast.BinaryOpe{
+												Operator: ast.B_EQUAL,
+												Left: &ast.Expression{
+													Column: &ast.Column{
+														Column: "colA",
+													},
+												},
+												Right: &ast.Expression{
+													BinaryOperation: &ast.BinaryOpe{
+														Operator: ast.B_PLUS,
+														Left: &ast.Expression{
+															Literal: &ast.Literal{
+																Numeric: &ast.Numeric{
+																	Integral: 1,
+																},
+															},
+														},
+														Right: &ast.Expression{
+															Literal: &ast.Literal{
+																Numeric: &ast.Numeric{
+																	Integral: 2,
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						ANALYZEStatement: &ast.ANALYZEStatement{},
+					},
+					{
+						ANALYZEStatement: &ast.ANALYZEStatement{
+							Table: &ast.Table{
+								Table: "tbl1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "EXPLAIN QUERY PLAN SELECT 1;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_EXPLAIN,
+					Literal: "EXPLAIN",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "QUERY",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "PLAN",
+				},
+				{
+					Type:    token.K_SELECT,
+					Literal: "SELECT",
+				},
+				{
+					Type:    token.NUMBER,
+					Literal: "1",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 1,
+					},
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						EXPLAINStatement: &ast.EXPLAINStatement{
+							QueryPlan: true,
+							SQL: &ast.SQL{
+								SELECTStatement: &ast.SELECTStatement{
+									Select: &ast.SELECTClause{
+										ResultColumns: []ast.ResultColumn{
+											{
+												Expression: &ast.Expression{
+													Literal: &ast.Literal{
+														Numeric: &ast.Numeric{
+															Integral: 1,
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "EXPLAIN ANALYZE ANALYZE tbl1; EXPLAIN ANALYZE;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_EXPLAIN,
+					Literal: "EXPLAIN",
+				},
+				{
+					Type:    token.K_ANALYZE,
+					Literal: "ANALYZE",
+				},
+				{
+					Type:    token.K_ANALYZE,
+					Literal: "ANALYZE",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "tbl1",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type:    token.K_EXPLAIN,
+					Literal: "EXPLAIN",
+				},
+				{
+					Type:    token.K_ANALYZE,
+					Literal: "ANALYZE",
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						EXPLAINStatement: &ast.EXPLAINStatement{
+							Analyze: true,
+							SQL: &ast.SQL{
+								ANALYZEStatement: &ast.ANALYZEStatement{
+									Table: &ast.Table{
+										Table: "tbl1",
+									},
+								},
+							},
+						},
+					},
+					{
+						EXPLAINStatement: &ast.EXPLAINStatement{
+							SQL: &ast.SQL{
+								ANALYZEStatement: &ast.ANALYZEStatement{},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "SET statement_timeout TO 100;",
+			tokens: token.Tokens{
+				{
+					Type:    token.K_SET,
+					Literal: "SET",
+				},
+				{
+					Type:    token.IDENT,
+					Literal: "statement_timeout",
+				},
+				{
+					Type:    token.K_TO,
+					Literal: "TO",
+				},
+				{
+					Type:    token.NUMBER,
+					Literal: "100",
+					Value: value.Value{
+						Type:    value.INTEGER,
+						Integer: 100,
+					},
+				},
+				{
+					Type:    token.S_SEMICOLON,
+					Literal: ";",
+				},
+				{
+					Type: token.EOS,
+				},
+			},
+			expected: &ast.AST{
+				SQL: []ast.SQL{
+					{
+						SETStatement: &ast.SETStatement{
+							Name: "statement_timeout",
+							Value: &ast.Expression{
+								Literal: &ast.Literal{
+									Numeric: &ast.Numeric{
+										Integral: 100,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for tn, tc := range testCases {
@@ -542,3 +1221,143 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSELECTClauses(t *testing.T) {
+	num := func(n int) *ast.Expression {
+		return &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: n}}}
+	}
+	col := func(tbl string, c string) *ast.Expression {
+		return &ast.Expression{Column: &ast.Column{Table: tbl, Column: c}}
+	}
+	binary := func(ope ast.OperatorType, l *ast.Expression, r *ast.Expression) *ast.Expression {
+		return &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ope, Left: l, Right: r}}
+	}
+	testCases := []struct {
+		sql      string
+		expected *ast.SELECTStatement
+	}{
+		{
+			sql: "SELECT t.colA FROM tbl1 AS t JOIN tbl2 u ON t.colA = u.colA, tbl3 WHERE colB = 1 AND 2 ORDER BY colB DESC, colA ASC LIMIT 2 OFFSET 1",
+			expected: &ast.SELECTStatement{
+				Select: &ast.SELECTClause{ResultColumns: []ast.ResultColumn{{Expression: col("t", "colA")}}},
+				From: &ast.FROMClause{
+					Table: &ast.Table{Table: "tbl1", Alias: "t"},
+					Joins: []ast.JoinClause{
+						{Operator: ast.JOIN_INNER, Table: &ast.Table{Table: "tbl2", Alias: "u"}, On: binary(ast.B_EQUAL, col("t", "colA"), col("u", "colA"))},
+						{Operator: ast.JOIN_CROSS, Table: &ast.Table{Table: "tbl3"}},
+					},
+				},
+				Where:   binary(ast.B_AND, binary(ast.B_EQUAL, col("", "colB"), num(1)), num(2)),
+				OrderBy: []ast.OrderingTerm{{Expression: col("", "colB"), Desc: true}, {Expression: col("", "colA")}},
+				Limit:   &ast.LIMITClause{Limit: num(2), Offset: num(1)},
+			},
+		},
+		{
+			sql: "SELECT colA FROM tbl1 WHERE colA >= 1 + 1 AND colA < 5 AND 0 <= colB AND colB > colA",
+			expected: &ast.SELECTStatement{
+				Select: &ast.SELECTClause{ResultColumns: []ast.ResultColumn{{Expression: col("", "colA")}}},
+				From:   &ast.FROMClause{Table: &ast.Table{Table: "tbl1"}},
+				Where: binary(ast.B_AND,
+					binary(ast.B_AND,
+						binary(ast.B_AND, binary(ast.B_GREATER_EQUAL, col("", "colA"), binary(ast.B_PLUS, num(1), num(1))), binary(ast.B_LESS, col("", "colA"), num(5))),
+						binary(ast.B_LESS_EQUAL, num(0), col("", "colB"))),
+					binary(ast.B_GREATER, col("", "colB"), col("", "colA"))),
+			},
+		},
+		{
+			sql: "SELECT colA FROM tbl1 CROSS JOIN tbl2 INNER JOIN tbl3 ON 1 LIMIT 3",
+			expected: &ast.SELECTStatement{
+				Select: &ast.SELECTClause{ResultColumns: []ast.ResultColumn{{Expression: col("", "colA")}}},
+				From: &ast.FROMClause{
+					Table: &ast.Table{Table: "tbl1"},
+					Joins: []ast.JoinClause{
+						{Operator: ast.JOIN_CROSS, Table: &ast.Table{Table: "tbl2"}},
+						{Operator: ast.JOIN_INNER, Table: &ast.Table{Table: "tbl3"}, On: num(1)},
+					},
+				},
+				Limit: &ast.LIMITClause{Limit: num(3)},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		p, err := Parse(lexer.Lex(tc.sql))
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		if !reflect.DeepEqual(p.SQL[0].SELECTStatement, tc.expected) {
+			t.Fatalf("[%d] %s Parse Error", tn, tc.sql)
+		}
+	}
+
+	for tn, sql := range []string{
+		"SELECT colA FROM tbl1 CROSS tbl2",
+		"SELECT colA FROM tbl1 JOIN tbl2",
+		"SELECT colA FROM tbl1 AS",
+		"SELECT t. FROM tbl1 t",
+		"SELECT colA FROM tbl1 ORDER colA",
+		"SELECT colA FROM tbl1 LIMIT",
+	} {
+		if _, err := Parse(lexer.Lex(sql)); err == nil {
+			t.Fatalf("[%d] %s is parsed", tn, sql)
+		}
+	}
+}
+
+func TestParseCREATETABLE(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected *ast.CREATETABLEStatement
+	}{
+		{
+			sql: "CREATE TABLE parent (id INTEGER, name)",
+			expected: &ast.CREATETABLEStatement{
+				Table:   &ast.Table{Table: "parent"},
+				Columns: []string{"id", "name"},
+			},
+		},
+		{
+			sql: "CREATE TABLE child (id, pid INTEGER CONSTRAINT fk_pid REFERENCES parent (id) ON DELETE CASCADE ON UPDATE SET NULL, a, b, FOREIGN KEY (a, b) REFERENCES pair (x, y) ON DELETE SET NULL ON UPDATE RESTRICT DEFERRABLE INITIALLY DEFERRED, CONSTRAINT fk_self FOREIGN KEY (a) REFERENCES child (id) ON DELETE NO ACTION DEFERRABLE INITIALLY IMMEDIATE)",
+			expected: &ast.CREATETABLEStatement{
+				Table:   &ast.Table{Table: "child"},
+				Columns: []string{"id", "pid", "a", "b"},
+				ForeignKeys: []ast.ForeignKeyClause{
+					{Name: "fk_pid", Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"id"}, OnDelete: ast.CASCADE, OnUpdate: ast.SET_NULL},
+					{Columns: []string{"a", "b"}, RefTable: "pair", RefColumns: []string{"x", "y"}, OnDelete: ast.SET_NULL, OnUpdate: ast.RESTRICT, Deferred: true},
+					{Name: "fk_self", Columns: []string{"a"}, RefTable: "child", RefColumns: []string{"id"}},
+				},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		p, err := Parse(lexer.Lex(tc.sql))
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		if !reflect.DeepEqual(p.SQL[0].CREATETABLEStatement, tc.expected) {
+			t.Fatalf("[%d] %s Parse Error %+v", tn, tc.sql, p.SQL[0].CREATETABLEStatement)
+		}
+	}
+
+	for tn, sql := range []string{
+		"CREATE TABLE",
+		"CREATE TABLE t",
+		"CREATE TABLE t (a",
+		"CREATE TABLE t (a REFERENCES p)",
+		"CREATE TABLE t (a REFERENCES p (x, y))",
+		"CREATE TABLE t (a REFERENCES p (x) ON INSERT CASCADE)",
+		"CREATE TABLE t (a REFERENCES p (x) ON DELETE SET)",
+		"CREATE TABLE t (a REFERENCES p (x) ON DELETE SET DEFAULT)",
+		"CREATE TABLE t (a, FOREIGN KEY (a) REFERENCES p (x) ON UPDATE SET DEFAULT)",
+		"CREATE TABLE t (a REFERENCES p (x) ON DELETE NO)",
+		"CREATE TABLE t (a REFERENCES p (x) DEFERRABLE)",
+		"CREATE TABLE t (a CONSTRAINT fk)",
+		"CREATE TABLE t (a, FOREIGN (a) REFERENCES p (x))",
+		"CREATE TABLE t (a, FOREIGN KEY (a) REFERENCES p (x), b)",
+	} {
+		if _, err := Parse(lexer.Lex(sql)); err == nil {
+			t.Fatalf("[%d] %s is parsed", tn, sql)
+		}
+	}
+}
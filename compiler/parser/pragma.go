@@ -0,0 +1,42 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+func (p *parser) parsePRAGMAStatement() (*ast.PRAGMAStatement, error) {
+	statement := &ast.PRAGMAStatement{}
+
+	if p.currentToken.Type != token.K_PRAGMA {
+		return statement, errors.New("PRAGMA missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	statement.Name = strings.ToLower(p.currentToken.Literal)
+	p.readToken()
+
+	switch p.currentToken.Type {
+	case token.S_EQUAL:
+		p.readToken()
+	case token.S_LPAREN:
+	default:
+		return statement, nil
+	}
+
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return statement, err
+	}
+	statement.Value = expr
+	p.readToken()
+
+	return statement, nil
+}
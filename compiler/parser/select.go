@@ -24,6 +24,29 @@ func (p *parser) parseSELECTStatement() (*ast.SELECTStatement, error) {
 			}
 			statement.From = fromClause
 		}
+		if p.currentToken.Type == token.K_WHERE {
+			p.readToken()
+			where, err := p.parseExpression(LOWEST)
+			if err != nil {
+				return statement, err
+			}
+			statement.Where = where
+			p.readToken()
+		}
+		if p.currentToken.Type == token.K_ORDER {
+			terms, err := p.parseORDERBYClause()
+			if err != nil {
+				return statement, err
+			}
+			statement.OrderBy = terms
+		}
+		if p.currentToken.Type == token.K_LIMIT {
+			limit, err := p.parseLIMITClause()
+			if err != nil {
+				return statement, err
+			}
+			statement.Limit = limit
+		}
 	} else {
 		return statement, errors.New("SELECT missing")
 	}
@@ -47,7 +70,7 @@ func (p *parser) parseResultColumns() ([]ast.ResultColumn, error) {
 	loop := true
 	for {
 		switch p.currentToken.Type {
-		case token.EOS, token.S_SEMICOLON, token.K_FROM:
+		case token.EOS, token.S_SEMICOLON, token.K_FROM, token.K_WHERE, token.K_ORDER, token.K_LIMIT:
 			loop = false
 		case token.S_COMMA:
 			p.readToken()
@@ -65,3 +88,54 @@ func (p *parser) parseResultColumns() ([]ast.ResultColumn, error) {
 	}
 	return cols, nil
 }
+
+// parseORDERBYClause parses ORDER BY expr [ASC | DESC], ...
+func (p *parser) parseORDERBYClause() ([]ast.OrderingTerm, error) {
+	terms := []ast.OrderingTerm{}
+	p.readToken()
+	if p.currentToken.Type != token.K_BY {
+		return terms, errors.New("BY missing")
+	}
+	for {
+		p.readToken()
+		expr, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return terms, err
+		}
+		term := ast.OrderingTerm{Expression: expr}
+		p.readToken()
+		switch p.currentToken.Type {
+		case token.K_ASC:
+			p.readToken()
+		case token.K_DESC:
+			term.Desc = true
+			p.readToken()
+		}
+		terms = append(terms, term)
+		if p.currentToken.Type != token.S_COMMA {
+			return terms, nil
+		}
+	}
+}
+
+// parseLIMITClause parses LIMIT expr [OFFSET expr].
+func (p *parser) parseLIMITClause() (*ast.LIMITClause, error) {
+	clause := &ast.LIMITClause{}
+	p.readToken()
+	limit, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return clause, err
+	}
+	clause.Limit = limit
+	p.readToken()
+	if p.currentToken.Type == token.K_OFFSET {
+		p.readToken()
+		offset, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return clause, err
+		}
+		clause.Offset = offset
+		p.readToken()
+	}
+	return clause, nil
+}
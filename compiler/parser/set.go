@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+func (p *parser) parseSETStatement() (*ast.SETStatement, error) {
+	statement := &ast.SETStatement{}
+
+	if p.currentToken.Type != token.K_SET {
+		return statement, errors.New("SET missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	statement.Name = strings.ToLower(p.currentToken.Literal)
+	p.readToken()
+
+	if p.currentToken.Type != token.S_EQUAL && p.currentToken.Type != token.K_TO {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	p.readToken()
+
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return statement, err
+	}
+	statement.Value = expr
+	p.readToken()
+
+	return statement, nil
+}
@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+func (p *parser) parseCREATETABLEStatement() (*ast.CREATETABLEStatement, error) {
+	statement := &ast.CREATETABLEStatement{}
+
+	if p.currentToken.Type != token.K_CREATE {
+		return statement, errors.New("CREATE missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.K_TABLE {
+		return statement, errors.New("TABLE missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	tbl, err := p.parseTable()
+	if err != nil {
+		return statement, err
+	}
+	statement.Table = tbl
+	p.readToken()
+
+	if p.currentToken.Type != token.S_LPAREN {
+		return statement, errors.New("( missing")
+	}
+	p.readToken()
+
+	constraints := []ast.ForeignKeyClause{}
+	for {
+		switch p.currentToken.Type {
+		case token.K_CONSTRAINT, token.K_FOREIGN:
+			fk, err := p.parseTableConstraint()
+			if err != nil {
+				return statement, err
+			}
+			constraints = append(constraints, fk)
+		case token.IDENT:
+			if len(constraints) != 0 {
+				return statement, errors.New(fmt.Sprintf("Column (%s) after a table constraint", p.currentToken.Literal))
+			}
+			col := p.currentToken.Literal
+			statement.Columns = append(statement.Columns, col)
+			p.readToken()
+
+			if p.currentToken.Type == token.IDENT {
+				p.readToken()
+			}
+			name, err := p.parseConstraintName()
+			if err != nil {
+				return statement, err
+			}
+			if p.currentToken.Type == token.K_REFERENCES {
+				fk, err := p.parseReferences([]string{col})
+				if err != nil {
+					return statement, err
+				}
+				fk.Name = name
+				statement.ForeignKeys = append(statement.ForeignKeys, fk)
+			} else if name != "" {
+				return statement, errors.New("REFERENCES missing")
+			}
+		default:
+			return statement, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+		}
+
+		if p.currentToken.Type == token.S_COMMA {
+			p.readToken()
+			continue
+		}
+		if p.currentToken.Type == token.S_RPAREN {
+			p.readToken()
+			break
+		}
+		return statement, errors.New(") missing")
+	}
+	statement.ForeignKeys = append(statement.ForeignKeys, constraints...)
+
+	return statement, nil
+}
+
+func (p *parser) parseConstraintName() (string, error) {
+	if p.currentToken.Type != token.K_CONSTRAINT {
+		return "", nil
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return "", errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	name := p.currentToken.Literal
+	p.readToken()
+	return name, nil
+}
+
+func (p *parser) parseTableConstraint() (ast.ForeignKeyClause, error) {
+	name, err := p.parseConstraintName()
+	if err != nil {
+		return ast.ForeignKeyClause{}, err
+	}
+
+	if p.currentToken.Type != token.K_FOREIGN {
+		return ast.ForeignKeyClause{}, errors.New("FOREIGN missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.K_KEY {
+		return ast.ForeignKeyClause{}, errors.New("KEY missing")
+	}
+	p.readToken()
+
+	cols, err := p.parseIndexedColumns()
+	if err != nil {
+		return ast.ForeignKeyClause{}, err
+	}
+	fk, err := p.parseReferences(cols)
+	fk.Name = name
+	return fk, err
+}
+
+func (p *parser) parseReferences(cols []string) (ast.ForeignKeyClause, error) {
+	fk := ast.ForeignKeyClause{Columns: cols}
+
+	if p.currentToken.Type != token.K_REFERENCES {
+		return fk, errors.New("REFERENCES missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type != token.IDENT {
+		return fk, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	fk.RefTable = p.currentToken.Literal
+	p.readToken()
+
+	refCols, err := p.parseIndexedColumns()
+	if err != nil {
+		return fk, err
+	}
+	if len(refCols) != len(cols) {
+		return fk, errors.New(fmt.Sprintf("Foreign Key references %d columns of %s but has %d", len(refCols), fk.RefTable, len(cols)))
+	}
+	fk.RefColumns = refCols
+
+	for {
+		switch p.currentToken.Type {
+		case token.K_ON:
+			p.readToken()
+			var action *ast.ReferentialAction
+			switch p.currentToken.Type {
+			case token.K_DELETE:
+				action = &fk.OnDelete
+			case token.K_UPDATE:
+				action = &fk.OnUpdate
+			default:
+				return fk, errors.New("DELETE or UPDATE missing")
+			}
+			p.readToken()
+			if *action, err = p.parseReferentialAction(); err != nil {
+				return fk, err
+			}
+		case token.K_DEFERRABLE:
+			p.readToken()
+			if p.currentToken.Type != token.K_INITIALLY {
+				return fk, errors.New("INITIALLY missing")
+			}
+			p.readToken()
+			switch p.currentToken.Type {
+			case token.K_DEFERRED:
+				fk.Deferred = true
+			case token.K_IMMEDIATE:
+				fk.Deferred = false
+			default:
+				return fk, errors.New("DEFERRED or IMMEDIATE missing")
+			}
+			p.readToken()
+		default:
+			return fk, nil
+		}
+	}
+}
+
+func (p *parser) parseReferentialAction() (ast.ReferentialAction, error) {
+	switch p.currentToken.Type {
+	case token.K_CASCADE:
+		p.readToken()
+		return ast.CASCADE, nil
+	case token.K_RESTRICT:
+		p.readToken()
+		return ast.RESTRICT, nil
+	case token.K_SET:
+		p.readToken()
+		switch p.currentToken.Type {
+		case token.K_NULL:
+			p.readToken()
+			return ast.SET_NULL, nil
+		case token.K_DEFAULT:
+			return ast.NO_ACTION, errors.New("SET DEFAULT is not supported: columns have no default")
+		}
+		return ast.NO_ACTION, errors.New("NULL or DEFAULT missing")
+	case token.K_NO:
+		p.readToken()
+		if p.currentToken.Type != token.K_ACTION {
+			return ast.NO_ACTION, errors.New("ACTION missing")
+		}
+		p.readToken()
+		return ast.NO_ACTION, nil
+	}
+	return ast.NO_ACTION, errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+}
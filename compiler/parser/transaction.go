@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/token"
+)
+
+func (p *parser) parseBEGINStatement() (*ast.BEGINStatement, error) {
+	statement := &ast.BEGINStatement{}
+
+	if p.currentToken.Type != token.K_BEGIN {
+		return statement, errors.New("BEGIN missing")
+	}
+	p.readToken()
+
+	switch p.currentToken.Type {
+	case token.K_DEFERRED:
+		p.readToken()
+	case token.K_IMMEDIATE:
+		statement.Immediate = true
+		p.readToken()
+	}
+	if p.currentToken.Type == token.K_TRANSACTION {
+		p.readToken()
+	}
+
+	return statement, nil
+}
+
+func (p *parser) parseCOMMITStatement() (*ast.COMMITStatement, error) {
+	statement := &ast.COMMITStatement{}
+
+	if p.currentToken.Type != token.K_COMMIT && p.currentToken.Type != token.K_END {
+		return statement, errors.New("COMMIT missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type == token.K_TRANSACTION {
+		p.readToken()
+	}
+
+	return statement, nil
+}
+
+func (p *parser) parseROLLBACKStatement() (*ast.ROLLBACKStatement, error) {
+	statement := &ast.ROLLBACKStatement{}
+
+	if p.currentToken.Type != token.K_ROLLBACK {
+		return statement, errors.New("ROLLBACK missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type == token.K_TRANSACTION {
+		p.readToken()
+	}
+	if p.currentToken.Type != token.K_TO {
+		return statement, nil
+	}
+	p.readToken()
+
+	if p.currentToken.Type == token.K_SAVEPOINT {
+		p.readToken()
+	}
+	name, err := p.parseSavepointName()
+	if err != nil {
+		return statement, err
+	}
+	statement.Savepoint = name
+
+	return statement, nil
+}
+
+func (p *parser) parseSAVEPOINTStatement() (*ast.SAVEPOINTStatement, error) {
+	statement := &ast.SAVEPOINTStatement{}
+
+	if p.currentToken.Type != token.K_SAVEPOINT {
+		return statement, errors.New("SAVEPOINT missing")
+	}
+	p.readToken()
+
+	name, err := p.parseSavepointName()
+	if err != nil {
+		return statement, err
+	}
+	statement.Savepoint = name
+
+	return statement, nil
+}
+
+func (p *parser) parseRELEASEStatement() (*ast.RELEASEStatement, error) {
+	statement := &ast.RELEASEStatement{}
+
+	if p.currentToken.Type != token.K_RELEASE {
+		return statement, errors.New("RELEASE missing")
+	}
+	p.readToken()
+
+	if p.currentToken.Type == token.K_SAVEPOINT {
+		p.readToken()
+	}
+	name, err := p.parseSavepointName()
+	if err != nil {
+		return statement, err
+	}
+	statement.Savepoint = name
+
+	return statement, nil
+}
+
+func (p *parser) parseSavepointName() (string, error) {
+	if p.currentToken.Type != token.IDENT {
+		return "", errors.New(fmt.Sprintf("Unexpected Token %s", p.currentToken.Literal))
+	}
+	name := p.currentToken.Literal
+	p.readToken()
+	return name, nil
+}
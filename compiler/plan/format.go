@@ -0,0 +1,267 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+)
+
+// Format renders a plan as an indented tree, one operator per line.
+func Format(n Node) string {
+	lines := []string{}
+	format(n, 0, &lines)
+	return strings.Join(lines, "\n")
+}
+
+func format(n Node, depth int, lines *[]string) {
+	*lines = append(*lines, strings.Repeat("  ", depth)+n.String())
+	for _, c := range n.Children() {
+		format(c, depth+1, lines)
+	}
+}
+
+func (n *Values) String() string {
+	return "Values"
+}
+
+func (n *Scan) String() string {
+	s := fmt.Sprintf("Scan %s", FormatTable(n.Table))
+	if n.Columns != nil {
+		s += fmt.Sprintf(" COLUMNS [%s]", strings.Join(n.Columns, ", "))
+	}
+	if len(n.Filters) != 0 {
+		s += fmt.Sprintf(" FILTER [%s]", formatExpressions(n.Filters))
+	}
+	return s
+}
+
+func (n *IndexScan) String() string {
+	s := fmt.Sprintf("IndexScan %s USING %s", FormatTable(n.Scan.Table), n.Index)
+	bounds := []string{}
+	switch {
+	case n.Key != nil:
+		bounds = append(bounds, fmt.Sprintf("%s = %s", n.Column, FormatExpression(n.Key)))
+	default:
+		if n.Low != nil {
+			bounds = append(bounds, fmt.Sprintf("%s >= %s", n.Column, FormatExpression(n.Low)))
+		}
+		if n.High != nil {
+			bounds = append(bounds, fmt.Sprintf("%s <= %s", n.Column, FormatExpression(n.High)))
+		}
+	}
+	if len(bounds) != 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(bounds, " AND "))
+	}
+	if n.Ordered {
+		s += fmt.Sprintf(" ORDER BY %s", n.Column)
+		if n.Desc {
+			s += " DESC"
+		}
+	}
+	if n.Scan.Columns != nil {
+		s += fmt.Sprintf(" COLUMNS [%s]", strings.Join(n.Scan.Columns, ", "))
+	}
+	if len(n.Scan.Filters) != 0 {
+		s += fmt.Sprintf(" FILTER [%s]", formatExpressions(n.Scan.Filters))
+	}
+	return s
+}
+
+func (n *Filter) String() string {
+	return fmt.Sprintf("Filter %s", FormatExpression(n.Condition))
+}
+
+func (n *Project) String() string {
+	return fmt.Sprintf("Project [%s]", formatExpressions(n.Columns))
+}
+
+func (n *Join) String() string {
+	s := fmt.Sprintf("Join %s", n.Type)
+	if n.Algorithm != 0 {
+		s += " " + n.Algorithm.String()
+	}
+	if n.Condition == nil {
+		return s
+	}
+	return fmt.Sprintf("%s ON %s", s, FormatExpression(n.Condition))
+}
+
+func (n *Aggregate) String() string {
+	return fmt.Sprintf("Aggregate [%s] GROUP BY [%s]", formatExpressions(n.Aggregates), formatExpressions(n.GroupBy))
+}
+
+func (n *Sort) String() string {
+	keys := []string{}
+	for _, k := range n.Keys {
+		if k.Desc {
+			keys = append(keys, FormatExpression(k.Expression)+" DESC")
+		} else {
+			keys = append(keys, FormatExpression(k.Expression))
+		}
+	}
+	return fmt.Sprintf("Sort [%s]", strings.Join(keys, ", "))
+}
+
+func (n *Limit) String() string {
+	if n.Offset == nil {
+		return fmt.Sprintf("Limit %s", FormatExpression(n.Limit))
+	}
+	return fmt.Sprintf("Limit %s OFFSET %s", FormatExpression(n.Limit), FormatExpression(n.Offset))
+}
+
+func (n *Union) String() string {
+	if n.All {
+		return "Union ALL"
+	}
+	return "Union"
+}
+
+func (n *Explain) String() string {
+	switch {
+	case n.QueryPlan:
+		return "Explain QUERY PLAN"
+	case n.Analyze:
+		return "Explain ANALYZE"
+	}
+	return "Explain"
+}
+
+func (n *Command) String() string {
+	sql := n.SQL
+	switch {
+	case sql.CREATETABLEStatement != nil:
+		s := sql.CREATETABLEStatement
+		defs := append([]string{}, s.Columns...)
+		for _, fk := range s.ForeignKeys {
+			defs = append(defs, FormatForeignKey(fk))
+		}
+		return fmt.Sprintf("CREATE TABLE %s (%s)", FormatTable(s.Table), strings.Join(defs, ", "))
+	case sql.CREATEINDEXStatement != nil:
+		s := sql.CREATEINDEXStatement
+		unique := ""
+		if s.Unique {
+			unique = "UNIQUE "
+		}
+		return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, s.Index, FormatTable(s.Table), strings.Join(s.Columns, ", "))
+	case sql.DROPINDEXStatement != nil:
+		return fmt.Sprintf("DROP INDEX %s", sql.DROPINDEXStatement.Index)
+	case sql.SETStatement != nil:
+		return fmt.Sprintf("SET %s = %s", sql.SETStatement.Name, FormatExpression(sql.SETStatement.Value))
+	case sql.PRAGMAStatement != nil:
+		if sql.PRAGMAStatement.Value == nil {
+			return fmt.Sprintf("PRAGMA %s", sql.PRAGMAStatement.Name)
+		}
+		return fmt.Sprintf("PRAGMA %s = %s", sql.PRAGMAStatement.Name, FormatExpression(sql.PRAGMAStatement.Value))
+	case sql.BEGINStatement != nil:
+		if sql.BEGINStatement.Immediate {
+			return "BEGIN IMMEDIATE"
+		}
+		return "BEGIN"
+	case sql.COMMITStatement != nil:
+		return "COMMIT"
+	case sql.ROLLBACKStatement != nil:
+		if sql.ROLLBACKStatement.Savepoint != "" {
+			return fmt.Sprintf("ROLLBACK TO %s", sql.ROLLBACKStatement.Savepoint)
+		}
+		return "ROLLBACK"
+	case sql.SAVEPOINTStatement != nil:
+		return fmt.Sprintf("SAVEPOINT %s", sql.SAVEPOINTStatement.Savepoint)
+	case sql.RELEASEStatement != nil:
+		return fmt.Sprintf("RELEASE %s", sql.RELEASEStatement.Savepoint)
+	case sql.ANALYZEStatement != nil:
+		if sql.ANALYZEStatement.Table == nil {
+			return "ANALYZE"
+		}
+		return fmt.Sprintf("ANALYZE %s", FormatTable(sql.ANALYZEStatement.Table))
+	default:
+		return "Unknown Command"
+	}
+}
+
+func FormatTable(t *ast.Table) string {
+	if t == nil {
+		return ""
+	}
+	s := t.Table
+	if t.DB != "" {
+		s = t.DB + "." + s
+	}
+	if t.Alias != "" {
+		s = s + " AS " + t.Alias
+	}
+	return s
+}
+
+func formatExpressions(exprs []*ast.Expression) string {
+	s := []string{}
+	for _, e := range exprs {
+		s = append(s, FormatExpression(e))
+	}
+	return strings.Join(s, ", ")
+}
+
+// FormatExpression renders an expression as SQL text. Operands that are
+// themselves binary operations are parenthesized.
+func FormatExpression(e *ast.Expression) string {
+	if e == nil {
+		return ""
+	}
+	switch {
+	case e.Literal != nil:
+		if e.Literal.Numeric != nil {
+			return fmt.Sprintf("%d", e.Literal.Numeric.Integral)
+		}
+		return ""
+	case e.Parameter != nil:
+		if e.Parameter.Name != "" {
+			return ":" + e.Parameter.Name
+		}
+		return fmt.Sprintf("?%d", e.Parameter.Index)
+	case e.Column != nil:
+		s := e.Column.Column
+		if e.Column.Table != "" {
+			s = e.Column.Table + "." + s
+		}
+		return s
+	case e.UnaryOperation != nil:
+		return e.UnaryOperation.Operator.String() + formatOperand(e.UnaryOperation.Expr)
+	case e.BinaryOperation != nil:
+		b := e.BinaryOperation
+		return fmt.Sprintf("%s %s %s", formatOperand(b.Left), b.Operator, formatOperand(b.Right))
+	case e.FunctionCall != nil:
+		args := []string{}
+		for n := range e.FunctionCall.Args {
+			args = append(args, FormatExpression(&e.FunctionCall.Args[n]))
+		}
+		return fmt.Sprintf("%s(%s)", e.FunctionCall.Name, strings.Join(args, ", "))
+	default:
+		return ""
+	}
+}
+
+func formatOperand(e *ast.Expression) string {
+	if e != nil && e.BinaryOperation != nil {
+		return "(" + FormatExpression(e) + ")"
+	}
+	return FormatExpression(e)
+}
+
+// FormatForeignKey renders fk as a table constraint, leaving out the
+// default actions.
+func FormatForeignKey(fk ast.ForeignKeyClause) string {
+	s := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", "))
+	if fk.Name != "" {
+		s = fmt.Sprintf("CONSTRAINT %s %s", fk.Name, s)
+	}
+	if fk.OnDelete != ast.NO_ACTION {
+		s += fmt.Sprintf(" ON DELETE %s", fk.OnDelete)
+	}
+	if fk.OnUpdate != ast.NO_ACTION {
+		s += fmt.Sprintf(" ON UPDATE %s", fk.OnUpdate)
+	}
+	if fk.Deferred {
+		s += " DEFERRABLE INITIALLY DEFERRED"
+	}
+	return s
+}
@@ -0,0 +1,246 @@
+// Package plan holds the logical plan of a statement: a tree of relational
+// operators built from the AST, independent of how the VM runs it.
+package plan
+
+import (
+	"github.com/yakawa/simpleDB/common/ast"
+)
+
+type Node interface {
+	Children() []Node
+	String() string
+}
+
+// Values produces a single row without columns. It is the input of a
+// SELECT without FROM.
+type Values struct{}
+
+type Scan struct {
+	Table *ast.Table
+	// Columns are the columns the scan has to read. A nil slice reads all
+	// of them.
+	Columns []string
+	// Filters are evaluated by the scan itself. A row is returned only
+	// when every filter is non-zero.
+	Filters []*ast.Expression
+}
+
+// IndexScan is Scan reading only the rows whose Column equals Key, which
+// it finds in the index Index. Key may use the columns of the tables
+// joined before it. The filters of Scan are still evaluated, so a row the
+// index should not have found is dropped.
+// Without Key the rows read are those from Low to High, both included, and
+// a nil bound leaves the range open on its side. Ordered reads them in the
+// order of Column, descending if Desc, instead of the order of the table.
+type IndexScan struct {
+	Scan    *Scan
+	Index   string
+	Column  string
+	Key     *ast.Expression
+	Low     *ast.Expression
+	High    *ast.Expression
+	Ordered bool
+	Desc    bool
+}
+
+type Filter struct {
+	Input     Node
+	Condition *ast.Expression
+}
+
+type Project struct {
+	Input   Node
+	Columns []*ast.Expression
+}
+
+type JoinType int
+
+const (
+	_ JoinType = iota
+	InnerJoin
+	LeftJoin
+	CrossJoin
+)
+
+func (j JoinType) String() string {
+	switch j {
+	case InnerJoin:
+		return "INNER"
+	case LeftJoin:
+		return "LEFT"
+	case CrossJoin:
+		return "CROSS"
+	default:
+		return "Unknown"
+	}
+}
+
+// JoinAlgorithm is how a join is run. It is chosen by the optimizer and
+// left unset by Build.
+type JoinAlgorithm int
+
+const (
+	_ JoinAlgorithm = iota
+	NestedLoop
+	HashJoin
+	IndexLookup
+)
+
+func (a JoinAlgorithm) String() string {
+	switch a {
+	case NestedLoop:
+		return "NESTED LOOP"
+	case HashJoin:
+		return "HASH"
+	case IndexLookup:
+		return "INDEX LOOKUP"
+	default:
+		return "Unknown"
+	}
+}
+
+type Join struct {
+	Type      JoinType
+	Algorithm JoinAlgorithm
+	Left      Node
+	Right     Node
+	Condition *ast.Expression
+}
+
+type Aggregate struct {
+	Input      Node
+	GroupBy    []*ast.Expression
+	Aggregates []*ast.Expression
+}
+
+type SortKey struct {
+	Expression *ast.Expression
+	Desc       bool
+}
+
+type Sort struct {
+	Input Node
+	Keys  []SortKey
+}
+
+type Limit struct {
+	Input  Node
+	Limit  *ast.Expression
+	Offset *ast.Expression
+}
+
+type Union struct {
+	Left  Node
+	Right Node
+	All   bool
+}
+
+// Command is a statement that does not produce rows from tables, such as
+// CREATE INDEX, PRAGMA, SET or a transaction statement.
+type Command struct {
+	SQL *ast.SQL
+}
+
+// Explain describes how Input is run instead of running it, or runs it and
+// reports what each operator did when Analyze is set. Estimates are the row
+// counts of the operators of Input, filled in by the optimizer.
+type Explain struct {
+	QueryPlan bool
+	Analyze   bool
+	Input     Node
+	Estimates map[Node]float64
+}
+
+func (n *Values) Children() []Node    { return []Node{} }
+func (n *Scan) Children() []Node      { return []Node{} }
+func (n *IndexScan) Children() []Node { return []Node{} }
+func (n *Filter) Children() []Node    { return []Node{n.Input} }
+func (n *Project) Children() []Node   { return []Node{n.Input} }
+func (n *Join) Children() []Node      { return []Node{n.Left, n.Right} }
+func (n *Aggregate) Children() []Node { return []Node{n.Input} }
+func (n *Sort) Children() []Node      { return []Node{n.Input} }
+func (n *Limit) Children() []Node     { return []Node{n.Input} }
+func (n *Union) Children() []Node     { return []Node{n.Left, n.Right} }
+func (n *Command) Children() []Node   { return []Node{} }
+func (n *Explain) Children() []Node   { return []Node{n.Input} }
+
+// Build returns the logical plan of each statement of a.
+func Build(a *ast.AST) []Node {
+	nodes := []Node{}
+	for n := range a.SQL {
+		nodes = append(nodes, buildSQL(&a.SQL[n]))
+	}
+	return nodes
+}
+
+func buildSQL(sql *ast.SQL) Node {
+	if sql.EXPLAINStatement != nil {
+		stmt := sql.EXPLAINStatement
+		return &Explain{QueryPlan: stmt.QueryPlan, Analyze: stmt.Analyze, Input: buildSQL(stmt.SQL)}
+	}
+	if sql.SELECTStatement == nil {
+		return &Command{SQL: sql}
+	}
+	return buildSELECT(sql.SELECTStatement)
+}
+
+// buildSELECT builds the operators of a SELECT from the bottom up: the
+// tables, the conditions of WHERE, ORDER BY, LIMIT and the result columns.
+func buildSELECT(stmt *ast.SELECTStatement) Node {
+	var input Node = &Values{}
+	if stmt.From != nil {
+		input = buildFROM(stmt.From)
+	}
+	for _, cond := range conjuncts(stmt.Where) {
+		input = &Filter{Input: input, Condition: cond}
+	}
+	if len(stmt.OrderBy) != 0 {
+		s := &Sort{Input: input, Keys: []SortKey{}}
+		for _, t := range stmt.OrderBy {
+			s.Keys = append(s.Keys, SortKey{Expression: t.Expression, Desc: t.Desc})
+		}
+		input = s
+	}
+	if stmt.Limit != nil {
+		input = &Limit{Input: input, Limit: stmt.Limit.Limit, Offset: stmt.Limit.Offset}
+	}
+	p := &Project{Input: input, Columns: []*ast.Expression{}}
+	if stmt.Select != nil {
+		for _, col := range stmt.Select.ResultColumns {
+			p.Columns = append(p.Columns, col.Expression)
+		}
+	}
+	return p
+}
+
+// buildFROM joins the tables of from from left to right. The first
+// condition of the ON of an inner join is the condition of the join, and
+// the others are filters on top of it.
+func buildFROM(from *ast.FROMClause) Node {
+	var n Node = &Scan{Table: from.Table}
+	for _, jc := range from.Joins {
+		j := &Join{Type: CrossJoin, Left: n, Right: &Scan{Table: jc.Table}}
+		n = j
+		for k, cond := range conjuncts(jc.On) {
+			if k == 0 {
+				j.Type = InnerJoin
+				j.Condition = cond
+				continue
+			}
+			n = &Filter{Input: n, Condition: cond}
+		}
+	}
+	return n
+}
+
+// conjuncts splits e into the conditions joined by AND, so that each of
+// them can be pushed down on its own.
+func conjuncts(e *ast.Expression) []*ast.Expression {
+	if e == nil {
+		return nil
+	}
+	if b := e.BinaryOperation; b != nil && b.Operator == ast.B_AND {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	return []*ast.Expression{e}
+}
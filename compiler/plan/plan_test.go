@@ -0,0 +1,97 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/compiler/lexer"
+	"github.com/yakawa/simpleDB/compiler/parser"
+)
+
+func TestBuild(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected []string
+	}{
+		{"SELECT 1 + 2 * 3;", []string{"Project [1 + (2 * 3)]\n  Values"}},
+		{"SELECT (1 + 2) * -3, ABS(?);", []string{"Project [(1 + 2) * -3, ABS(?1)]\n  Values"}},
+		{"SELECT colA, :a FROM tbl1;", []string{"Project [colA, :a]\n  Scan tbl1"}},
+		{"CREATE UNIQUE INDEX idx ON tbl1 (colA, colB);", []string{"CREATE UNIQUE INDEX idx ON tbl1 (colA, colB)"}},
+		{"BEGIN; PRAGMA cache_size = 10; ROLLBACK TO sp1; COMMIT;", []string{"BEGIN", "PRAGMA cache_size = 10", "ROLLBACK TO sp1", "COMMIT"}},
+		{"SELECT colA = 1 FROM tbl1; ANALYZE; ANALYZE tbl1;", []string{"Project [colA = 1]\n  Scan tbl1", "ANALYZE", "ANALYZE tbl1"}},
+		{"EXPLAIN SELECT 1; EXPLAIN QUERY PLAN BEGIN;", []string{"Explain\n  Project [1]\n    Values", "Explain QUERY PLAN\n  BEGIN"}},
+		{"EXPLAIN ANALYZE SELECT 1;", []string{"Explain ANALYZE\n  Project [1]\n    Values"}},
+		{
+			"SELECT t1.colA FROM tbl1 AS t1 JOIN tbl2 t2 ON t1.colA = t2.colA AND t2.colB = 1, tbl3 WHERE colA = 2 AND 1 ORDER BY colB DESC, colA LIMIT 2 OFFSET 1;",
+			[]string{`Project [t1.colA]
+  Limit 2 OFFSET 1
+    Sort [colB DESC, colA]
+      Filter 1
+        Filter colA = 2
+          Join CROSS
+            Filter t2.colB = 1
+              Join INNER ON t1.colA = t2.colA
+                Scan tbl1 AS t1
+                Scan tbl2 AS t2
+            Scan tbl3`},
+		},
+	}
+
+	for tn, tc := range testCases {
+		a, err := parser.Parse(lexer.Lex(tc.sql))
+		if err != nil {
+			t.Fatalf("[%d] %s : error: %s", tn, tc.sql, err)
+		}
+		nodes := Build(a)
+		if len(nodes) != len(tc.expected) {
+			t.Fatalf("[%d] %s length mismatch %d", tn, tc.sql, len(nodes))
+		}
+		for n, node := range nodes {
+			if actual := Format(node); actual != tc.expected[n] {
+				t.Fatalf("[%d] %s expected\n%s\nbut got\n%s", tn, tc.sql, tc.expected[n], actual)
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	col := func(tbl string, c string) *ast.Expression {
+		return &ast.Expression{Column: &ast.Column{Table: tbl, Column: c}}
+	}
+	num := func(n int) *ast.Expression {
+		return &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: n}}}
+	}
+	n := &Limit{
+		Limit:  num(10),
+		Offset: num(5),
+		Input: &Sort{
+			Keys: []SortKey{{Expression: col("", "colA"), Desc: true}},
+			Input: &Aggregate{
+				GroupBy:    []*ast.Expression{col("", "colA")},
+				Aggregates: []*ast.Expression{{FunctionCall: &ast.FunctionCall{Name: "COUNT", Args: []ast.Expression{*num(1)}}}},
+				Input: &Filter{
+					Condition: col("a", "colB"),
+					Input: &Join{
+						Type:      InnerJoin,
+						Algorithm: HashJoin,
+						Left:      &Scan{Table: &ast.Table{Table: "tbl1", Alias: "a"}, Columns: []string{"colA", "colB"}, Filters: []*ast.Expression{col("a", "colB")}},
+						Right:     &Union{All: true, Left: &IndexScan{Scan: &Scan{Table: &ast.Table{Table: "tbl2"}, Columns: []string{"colA"}}, Index: "idx", Column: "colA", Key: num(3)}, Right: &Scan{Table: &ast.Table{Table: "tbl3", DB: "db"}}},
+						Condition: col("a", "colA"),
+					},
+				},
+			},
+		},
+	}
+	expected := `Limit 10 OFFSET 5
+  Sort [colA DESC]
+    Aggregate [COUNT(1)] GROUP BY [colA]
+      Filter a.colB
+        Join INNER HASH ON a.colA
+          Scan tbl1 AS a COLUMNS [colA, colB] FILTER [a.colB]
+          Union ALL
+            IndexScan tbl2 USING idx (colA = 3) COLUMNS [colA]
+            Scan db.tbl3`
+	if actual := Format(n); actual != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,84 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/yakawa/simpleDB/compiler/plan"
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+// lowerExplain returns the code of the explained statement, or its plan
+// for EXPLAIN QUERY PLAN, as rows of strings instead of running it. For
+// EXPLAIN ANALYZE the statement is run under a profile, and each row of the
+// plan ends with the profile of the code of the operator.
+func (l *lowering) lowerExplain(n *plan.Explain) ([]vm.VMCode, error) {
+	codes := []vm.VMCode{{Operator: vm.ROW}}
+	switch {
+	case n.QueryPlan:
+		return append(codes, explainPlan(n, nil)...), nil
+	case n.Analyze:
+		spans := map[plan.Node]span{}
+		l.spans = spans
+		profiled, err := l.statement(n.Input)
+		l.spans = nil
+		if err != nil {
+			return []vm.VMCode{}, err
+		}
+		codes = append(codes, vm.VMCode{Operator: vm.PROFILE, Operand1: integer(len(profiled))})
+		codes = append(codes, profiled...)
+		return append(codes, explainPlan(n, spans)...), nil
+	}
+
+	explained, err := Lower(n.Input)
+	if err != nil {
+		return []vm.VMCode{}, err
+	}
+	for addr, c := range explained {
+		codes = append(codes, storeValues(integer(addr), str(c.Operator.String()), str(c.Operands()), str(c.Comment()))...)
+		codes = append(codes, vm.VMCode{Operator: vm.ROW})
+	}
+	return codes, nil
+}
+
+// explainPlan outputs a row for each operator of the plan, in pre-order.
+// With spans, the row is followed by the profile of the operator.
+func explainPlan(n *plan.Explain, spans map[plan.Node]span) []vm.VMCode {
+	codes := []vm.VMCode{}
+	id := 0
+	var walk func(plan.Node, int)
+	walk = func(node plan.Node, parent int) {
+		id++
+		self := id
+		detail := node.String()
+		if rows, exists := n.Estimates[node]; exists {
+			detail += fmt.Sprintf(" (~%.0f rows)", rows)
+		}
+		codes = append(codes, storeValues(integer(self), integer(parent), str(detail))...)
+		if spans != nil {
+			s := spans[node]
+			codes = append(codes, vm.VMCode{Operator: vm.REPORT, Operand1: integer(s.start), Operand2: integer(s.end)})
+		}
+		codes = append(codes, vm.VMCode{Operator: vm.ROW})
+		for _, c := range node.Children() {
+			walk(c, self)
+		}
+	}
+	walk(n.Input, 0)
+	return codes
+}
+
+func integer(n int) vm.VMValue {
+	return vm.VMValue{Type: vm.Integer, Integral: n}
+}
+
+func str(s string) vm.VMValue {
+	return vm.VMValue{Type: vm.String, String: s}
+}
+
+func storeValues(values ...vm.VMValue) []vm.VMCode {
+	codes := []vm.VMCode{}
+	for _, v := range values {
+		codes = append(codes, vm.VMCode{Operator: vm.PUSH, Operand1: v}, vm.VMCode{Operator: vm.STORE, Operand1: vm.VMValue{Type: vm.Nothing}})
+	}
+	return codes
+}
@@ -0,0 +1,50 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+// resolve removes the labels of codes and makes the jumps to them jump to
+// the address of the instruction that follows them. The ranges of PROFILE
+// and REPORT are moved with the instructions they cover.
+func resolve(codes []vm.VMCode) ([]vm.VMCode, error) {
+	addrs := make([]int, len(codes)+1)
+	labels := map[int]int{}
+	n := 0
+	for pc, c := range codes {
+		addrs[pc] = n
+		if c.Operator == vm.LABEL {
+			labels[c.Operand1.Integral] = n
+			continue
+		}
+		n++
+	}
+	addrs[len(codes)] = n
+
+	resolved := []vm.VMCode{}
+	profiled := 0
+	for pc, c := range codes {
+		switch c.Operator {
+		case vm.LABEL:
+			continue
+		case vm.PROFILE:
+			profiled = pc + 1
+			c.Operand1.Integral = addrs[profiled+c.Operand1.Integral] - addrs[profiled]
+		case vm.REPORT:
+			c.Operand1.Integral = addrs[profiled+c.Operand1.Integral] - addrs[profiled]
+			c.Operand2.Integral = addrs[profiled+c.Operand2.Integral] - addrs[profiled]
+		}
+		if c.Operand1.Type == vm.Label {
+			addr, exists := labels[c.Operand1.Integral]
+			if !exists {
+				return resolved, errors.New(fmt.Sprintf("Label (L%d) is not defined", c.Operand1.Integral))
+			}
+			c.Operand1 = vm.VMValue{Type: vm.Integer, Integral: addr}
+		}
+		resolved = append(resolved, c)
+	}
+	return resolved, nil
+}
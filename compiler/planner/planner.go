@@ -1,21 +1,276 @@
 package planner
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/compiler/binder"
+	"github.com/yakawa/simpleDB/compiler/plan"
+	"github.com/yakawa/simpleDB/runtime"
 	"github.com/yakawa/simpleDB/runtime/vm"
 )
 
+// Translate lowers the logical plan of each statement of a into VM code.
 func Translate(a *ast.AST) []vm.VMCode {
+	codes, _ := Compile(a)
+	return codes
+}
+
+// Compile is Translate reporting plans the VM cannot run. The code of the
+// statements before the failing one is returned.
+func Compile(a *ast.AST) ([]vm.VMCode, error) {
+	return LowerAll(plan.Build(a), nil)
+}
+
+// LowerAll lowers the plans of a batch of statements. b tells which table
+// each column is read from; without it a column that is not qualified is
+// read from the first table of its statement.
+func LowerAll(nodes []plan.Node, b *binder.Bindings) ([]vm.VMCode, error) {
+	l := &lowering{bindings: b}
 	codes := []vm.VMCode{}
-	for _, sql := range a.SQL {
-		if sql.SELECTStatement.From != nil {
-			c := translateFROM(sql.SELECTStatement.From)
-			codes = append(codes, c...)
+	for _, n := range nodes {
+		c, err := l.statement(n)
+		if err != nil {
+			resolved, _ := resolve(codes)
+			return resolved, err
 		}
-		for _, col := range sql.SELECTStatement.Select.ResultColumns {
-			c := translateResultColumn(col)
-			codes = append(codes, c...)
+		codes = append(codes, c...)
+	}
+	return resolve(codes)
+}
+
+// Lower translates a logical plan into VM code.
+func Lower(n plan.Node) ([]vm.VMCode, error) {
+	codes, err := (&lowering{}).statement(n)
+	if err != nil {
+		return []vm.VMCode{}, err
+	}
+	return resolve(codes)
+}
+
+// span is the range of code an operator and its inputs were lowered to.
+type span struct {
+	start int
+	end   int
+}
+
+// loop is the labels of the loop over the rows of a scan: next moves to
+// the next row, cont ends an iteration and end follows the loop.
+type loop struct {
+	next int
+	cont int
+	end  int
+}
+
+// lowering holds the state of the translation of a batch. Jumps are made
+// to labels, which resolve turns into addresses once the batch is lowered.
+// skip is the label a filter jumps to to drop the current row and done the
+// one that ends the statement. loops are the scans of the statement being
+// lowered, which it closes once its row is made, and cursors the cursor of
+// each of its tables by the name it is known by. counters is the number of
+// LIMIT counters of the statement and sort the ordering of its rows, if
+// any. If spans is not nil, the range of code of each operator is recorded
+// in it.
+type lowering struct {
+	labels   int
+	skip     int
+	done     int
+	loops    []loop
+	cursors  map[string]int
+	counters int
+	sort     *sorting
+	bindings *binder.Bindings
+	spans    map[plan.Node]span
+}
+
+// sorting is the ordering of the rows of a statement. Its keys are stored
+// after the columns of each row, which are held until SORT orders them and
+// applies the LIMIT counter, if limited.
+type sorting struct {
+	keys    []plan.SortKey
+	counter int
+	limited bool
+}
+
+func (l *lowering) label() vm.VMValue {
+	l.labels++
+	return vm.VMValue{Type: vm.Label, Integral: l.labels}
+}
+
+func (l *lowering) statement(n plan.Node) ([]vm.VMCode, error) {
+	saved := *l
+	skip := l.label()
+	l.skip, l.done = skip.Integral, skip.Integral
+	l.loops, l.cursors, l.counters, l.sort = nil, map[string]int{}, 0, nil
+	defer func() {
+		l.skip, l.done, l.loops, l.cursors, l.counters, l.sort = saved.skip, saved.done, saved.loops, saved.cursors, saved.counters, saved.sort
+	}()
 
+	codes, err := l.lower(n, 0)
+	if err != nil {
+		return codes, err
+	}
+	if len(l.loops) != 0 || query(n) {
+		// Each iteration of the innermost loop makes a row, and a query
+		// without a loop makes one, so that the rows of the statements of
+		// a batch are not merged.
+		if l.sort != nil {
+			for _, k := range l.sort.keys {
+				codes = append(codes, l.expression(k.Expression)...)
+				codes = append(codes, vm.VMCode{Operator: vm.STORE, Operand1: vm.VMValue{Type: vm.Nothing}})
+			}
+		}
+		codes = append(codes, vm.VMCode{Operator: vm.ROW})
+	}
+	for n := len(l.loops) - 1; n >= 0; n-- {
+		lp := l.loops[n]
+		codes = append(codes,
+			vm.VMCode{Operator: vm.LABEL, Operand1: label(lp.cont)},
+			vm.VMCode{Operator: vm.JUMP, Operand1: label(lp.next)},
+			vm.VMCode{Operator: vm.LABEL, Operand1: label(lp.end)},
+		)
+	}
+	codes = append(codes, vm.VMCode{Operator: vm.LABEL, Operand1: skip})
+	if l.sort != nil {
+		codes = append(codes, l.sort.code())
+	}
+	return codes, nil
+}
+
+// code is the SORT releasing the rows ordered by s.
+func (s *sorting) code() vm.VMCode {
+	order := ""
+	for _, k := range s.keys {
+		if k.Desc {
+			order += "D"
+		} else {
+			order += "A"
+		}
+	}
+	c := vm.VMCode{Operator: vm.SORT, Operand1: vm.VMValue{Type: vm.String, String: order}}
+	if s.limited {
+		c.Operand2 = integer(s.counter)
+	}
+	return c
+}
+
+// query reports whether n is a statement that makes rows.
+func query(n plan.Node) bool {
+	switch n.(type) {
+	case *plan.Command, *plan.Explain:
+		return false
+	}
+	return true
+}
+
+func label(n int) vm.VMValue {
+	return vm.VMValue{Type: vm.Label, Integral: n}
+}
+
+// lower translates n, base being the address of its first instruction.
+func (l *lowering) lower(n plan.Node, base int) ([]vm.VMCode, error) {
+	codes, err := l.lowerNode(n, base)
+	if err == nil && l.spans != nil {
+		l.spans[n] = span{start: base, end: base + len(codes)}
+	}
+	return codes, err
+}
+
+func (l *lowering) lowerNode(n plan.Node, base int) ([]vm.VMCode, error) {
+	switch n := n.(type) {
+	case *plan.Values:
+		return []vm.VMCode{}, nil
+	case *plan.Scan:
+		codes := l.lowerScan(n)
+		for _, f := range n.Filters {
+			codes = append(codes, l.filter(f)...)
+		}
+		return codes, nil
+	case *plan.IndexScan:
+		codes := l.lowerIndexScan(n)
+		for _, f := range n.Scan.Filters {
+			codes = append(codes, l.filter(f)...)
+		}
+		return codes, nil
+	case *plan.Filter:
+		codes, err := l.lower(n.Input, base)
+		if err != nil {
+			return codes, err
+		}
+		return append(codes, l.filter(n.Condition)...), nil
+	case *plan.Join:
+		// The right input is scanned again for each row of the left one.
+		// A hash join only moves over the rows of the right table whose
+		// column equals the key of the left row.
+		if n.Type != plan.InnerJoin && n.Type != plan.CrossJoin {
+			return []vm.VMCode{}, errors.New(fmt.Sprintf("Operator (%s) is not supported by the VM", n))
+		}
+		codes, err := l.lower(n.Left, base)
+		if err != nil {
+			return codes, err
+		}
+		if scan, ok := n.Right.(*plan.Scan); ok && n.Algorithm != plan.NestedLoop {
+			if probe, key, ok := l.hashKeys(n.Condition, scan); ok {
+				right := l.lowerLookup(scan, probe, key)
+				if l.spans != nil {
+					l.spans[scan] = span{start: base + len(codes), end: base + len(codes) + len(right)}
+				}
+				return append(codes, right...), nil
+			}
+		}
+		right, err := l.lower(n.Right, base+len(codes))
+		codes = append(codes, right...)
+		if err != nil {
+			return codes, err
+		}
+		if n.Condition != nil {
+			codes = append(codes, l.filter(n.Condition)...)
+		}
+		return codes, nil
+	case *plan.Sort:
+		// The rows are held until the statement has made all of them.
+		if l.sort != nil || len(l.loops) != 0 {
+			return []vm.VMCode{}, errors.New(fmt.Sprintf("Operator (%s) is not supported by the VM", n))
+		}
+		l.sort = &sorting{keys: n.Keys}
+		codes := []vm.VMCode{{Operator: vm.HOLD}}
+		input, err := l.lower(n.Input, base+len(codes))
+		return append(codes, input...), err
+	case *plan.Limit:
+		// A counter drops the rows of the offset and ends the statement
+		// after the last row of the limit. The rows of a sort are counted
+		// once sorted.
+		counter := integer(l.counters)
+		l.counters++
+		codes := l.expression(n.Limit)
+		if n.Offset != nil {
+			codes = append(codes, l.expression(n.Offset)...)
+		} else {
+			codes = append(codes, vm.VMCode{Operator: vm.PUSH, Operand1: integer(0)})
+		}
+		codes = append(codes, vm.VMCode{Operator: vm.LIMIT, Operand1: counter})
+		sorted := l.sort != nil
+		input, err := l.lower(n.Input, base+len(codes))
+		codes = append(codes, input...)
+		if err != nil {
+			return codes, err
+		}
+		if l.sort != nil && !sorted {
+			l.sort.counter, l.sort.limited = counter.Integral, true
+			return codes, nil
+		}
+		return append(codes,
+			vm.VMCode{Operator: vm.TAKE, Operand1: label(l.done), Operand2: counter},
+			vm.VMCode{Operator: vm.JUMP_IF_FALSE, Operand1: label(l.skip)},
+		), nil
+	case *plan.Project:
+		codes, err := l.lower(n.Input, base)
+		if err != nil {
+			return codes, err
+		}
+		for _, col := range n.Columns {
+			codes = append(codes, l.expression(col)...)
 			s := vm.VMCode{
 				Operator: vm.STORE,
 				Operand1: vm.VMValue{
@@ -24,15 +279,281 @@ func Translate(a *ast.AST) []vm.VMCode {
 			}
 			codes = append(codes, s)
 		}
+		return codes, nil
+	case *plan.Command:
+		return lowerCommand(n.SQL), nil
+	case *plan.Explain:
+		return l.lowerExplain(n)
+	default:
+		return []vm.VMCode{}, errors.New(fmt.Sprintf("Operator (%s) is not supported by the VM", n))
+	}
+}
+
+func lowerCommand(sql *ast.SQL) []vm.VMCode {
+	switch {
+	case sql.CREATETABLEStatement != nil:
+		return translateCREATETABLE(sql.CREATETABLEStatement)
+	case sql.CREATEINDEXStatement != nil:
+		return translateCREATEINDEX(sql.CREATEINDEXStatement)
+	case sql.DROPINDEXStatement != nil:
+		return translateDROPINDEX(sql.DROPINDEXStatement)
+	case sql.PRAGMAStatement != nil:
+		return translatePRAGMA(sql.PRAGMAStatement)
+	case sql.SETStatement != nil:
+		return translateSET(sql.SETStatement)
+	case sql.BEGINStatement != nil:
+		return translateBEGIN(sql.BEGINStatement)
+	case sql.COMMITStatement != nil:
+		return []vm.VMCode{{Operator: vm.COMMIT}}
+	case sql.ROLLBACKStatement != nil:
+		return translateROLLBACK(sql.ROLLBACKStatement)
+	case sql.SAVEPOINTStatement != nil:
+		return translateSavepoint(vm.SAVEPOINT, sql.SAVEPOINTStatement.Savepoint)
+	case sql.RELEASEStatement != nil:
+		return translateSavepoint(vm.RELEASE, sql.RELEASEStatement.Savepoint)
+	case sql.ANALYZEStatement != nil:
+		return translateANALYZE(sql.ANALYZEStatement)
+	default:
+		return []vm.VMCode{}
+	}
+}
+
+// translateCREATETABLE adds each foreign key with its ON DELETE action, ON
+// UPDATE action and deferral pushed, then creates the table with them.
+func translateCREATETABLE(stmt *ast.CREATETABLEStatement) []vm.VMCode {
+	codes := []vm.VMCode{}
+	push := func(n int) {
+		codes = append(codes, vm.VMCode{Operator: vm.PUSH, Operand1: vm.VMValue{Type: vm.Integer, Integral: n}})
+	}
+	for _, fk := range stmt.ForeignKeys {
+		push(int(fk.OnDelete))
+		push(int(fk.OnUpdate))
+		deferred := 0
+		if fk.Deferred {
+			deferred = 1
+		}
+		push(deferred)
+		codes = append(codes, vm.VMCode{
+			Operator: vm.FOREIGN_KEY,
+			Operand1: vm.VMValue{
+				Type: vm.Index,
+				Index: vm.VMIndex{
+					Index:   fk.Name,
+					Table:   stmt.Table.Table,
+					DB:      "_",
+					Schema:  "LOCAL",
+					Columns: fk.Columns,
+				},
+			},
+			Operand2: vm.VMValue{
+				Type: vm.Index,
+				Index: vm.VMIndex{
+					Table:   fk.RefTable,
+					DB:      "_",
+					Schema:  "LOCAL",
+					Columns: fk.RefColumns,
+				},
+			},
+		})
+	}
+	codes = append(codes, vm.VMCode{
+		Operator: vm.CREATE_TABLE,
+		Operand1: vm.VMValue{
+			Type: vm.Table,
+			Table: vm.VMTable{
+				Table:   stmt.Table.Table,
+				DB:      "_",
+				Schema:  "LOCAL",
+				Columns: stmt.Columns,
+			},
+		},
+	})
+	return codes
+}
+
+func translateCREATEINDEX(stmt *ast.CREATEINDEXStatement) []vm.VMCode {
+	c := vm.VMCode{
+		Operator: vm.CREATE_INDEX,
+		Operand1: vm.VMValue{
+			Type: vm.Index,
+			Index: vm.VMIndex{
+				Index:   stmt.Index,
+				Table:   stmt.Table.Table,
+				DB:      "_",
+				Schema:  "LOCAL",
+				Columns: stmt.Columns,
+				Unique:  stmt.Unique,
+			},
+		},
+	}
+	return []vm.VMCode{c}
+}
+
+func translateDROPINDEX(stmt *ast.DROPINDEXStatement) []vm.VMCode {
+	c := vm.VMCode{
+		Operator: vm.DROP_INDEX,
+		Operand1: vm.VMValue{
+			Type: vm.Index,
+			Index: vm.VMIndex{
+				Index:  stmt.Index,
+				DB:     "_",
+				Schema: "LOCAL",
+			},
+		},
+	}
+	return []vm.VMCode{c}
+}
+
+func translatePRAGMA(stmt *ast.PRAGMAStatement) []vm.VMCode {
+	codes := []vm.VMCode{}
+	c := vm.VMCode{
+		Operator: vm.PRAGMA,
+		Operand1: vm.VMValue{
+			Type:   vm.String,
+			String: stmt.Name,
+		},
+		Operand2: vm.VMValue{
+			Type: vm.Nothing,
+		},
+	}
+	if stmt.Value != nil {
+		codes = append(codes, translateExpression(stmt.Value)...)
+		c.Operand2 = vm.VMValue{
+			Type:     vm.Integer,
+			Integral: 1,
+		}
 	}
+	codes = append(codes, c)
 	return codes
 }
 
-func translateResultColumn(c ast.ResultColumn) []vm.VMCode {
-	codes := translateExpression(c.Expression)
+func translateSET(stmt *ast.SETStatement) []vm.VMCode {
+	codes := translateExpression(stmt.Value)
+	return append(codes, vm.VMCode{
+		Operator: vm.SET,
+		Operand1: vm.VMValue{
+			Type:   vm.String,
+			String: stmt.Name,
+		},
+	})
+}
+
+func translateANALYZE(stmt *ast.ANALYZEStatement) []vm.VMCode {
+	tbl := ""
+	if stmt.Table != nil {
+		tbl = stmt.Table.Table
+	}
+	c := vm.VMCode{
+		Operator: vm.ANALYZE,
+		Operand1: vm.VMValue{
+			Type: vm.Table,
+			Table: vm.VMTable{
+				Table:  tbl,
+				DB:     "_",
+				Schema: "LOCAL",
+			},
+		},
+	}
+	return []vm.VMCode{c}
+}
+
+func translateBEGIN(stmt *ast.BEGINStatement) []vm.VMCode {
+	mode := "DEFERRED"
+	if stmt.Immediate {
+		mode = "IMMEDIATE"
+	}
+	c := vm.VMCode{
+		Operator: vm.BEGIN,
+		Operand1: vm.VMValue{
+			Type:   vm.String,
+			String: mode,
+		},
+	}
+	return []vm.VMCode{c}
+}
+
+func translateROLLBACK(stmt *ast.ROLLBACKStatement) []vm.VMCode {
+	if stmt.Savepoint == "" {
+		return []vm.VMCode{{Operator: vm.ROLLBACK}}
+	}
+	return translateSavepoint(vm.ROLLBACK_TO, stmt.Savepoint)
+}
+
+func translateSavepoint(ope vm.OpeType, name string) []vm.VMCode {
+	c := vm.VMCode{
+		Operator: ope,
+		Operand1: vm.VMValue{
+			Type:   vm.String,
+			String: name,
+		},
+	}
+	return []vm.VMCode{c}
+}
+
+// filter drops the row when cond is false or NULL.
+func (l *lowering) filter(cond *ast.Expression) []vm.VMCode {
+	pass := l.label()
+	codes := l.expression(cond)
+	return append(codes,
+		vm.VMCode{Operator: vm.JUMP_IF_TRUE, Operand1: pass},
+		vm.VMCode{Operator: vm.JUMP, Operand1: label(l.skip)},
+		vm.VMCode{Operator: vm.LABEL, Operand1: pass},
+	)
+}
+
+// expression translates expr, reading each column from the cursor of its
+// table. Cursor 0 is left out of FETCH.
+func (l *lowering) expression(expr *ast.Expression) []vm.VMCode {
+	codes := translateExpression(expr)
+	cols := columns(expr)
+	for n, c := range codes {
+		if c.Operator != vm.FETCH {
+			continue
+		}
+		if id := l.cursor(cols[0]); id != 0 {
+			codes[n].Operand2 = integer(id)
+		}
+		cols = cols[1:]
+	}
 	return codes
 }
 
+// cursor returns the cursor col is read from: that of the table the binder
+// resolved it to or its qualifier names, else the first one.
+func (l *lowering) cursor(col *ast.Column) int {
+	return l.cursors[l.relation(col)]
+}
+
+// relation returns the name of the table col is read from.
+func (l *lowering) relation(col *ast.Column) string {
+	if l.bindings != nil {
+		if ref, bound := l.bindings.Columns[col]; bound {
+			return ref.Relation
+		}
+	}
+	return col.Table
+}
+
+// columns returns the columns of expr in the order translateExpression
+// fetches them.
+func columns(expr *ast.Expression) []*ast.Column {
+	switch {
+	case expr.BinaryOperation != nil:
+		return append(columns(expr.BinaryOperation.Left), columns(expr.BinaryOperation.Right)...)
+	case expr.UnaryOperation != nil:
+		return columns(expr.UnaryOperation.Expr)
+	case expr.FunctionCall != nil:
+		cols := []*ast.Column{}
+		for n := range expr.FunctionCall.Args {
+			cols = append(cols, columns(&expr.FunctionCall.Args[n])...)
+		}
+		return cols
+	case expr.Column != nil:
+		return []*ast.Column{expr.Column}
+	}
+	return []*ast.Column{}
+}
+
 func translateExpression(expr *ast.Expression) []vm.VMCode {
 	codes := []vm.VMCode{}
 	v := vm.VMValue{}
@@ -75,6 +596,30 @@ func translateExpression(expr *ast.Expression) []vm.VMCode {
 			c = vm.VMCode{
 				Operator: vm.MOD,
 			}
+		case ast.B_EQUAL:
+			c = vm.VMCode{
+				Operator: vm.EQ,
+			}
+		case ast.B_LESS:
+			c = vm.VMCode{
+				Operator: vm.LT,
+			}
+		case ast.B_LESS_EQUAL:
+			c = vm.VMCode{
+				Operator: vm.LE,
+			}
+		case ast.B_GREATER:
+			c = vm.VMCode{
+				Operator: vm.GT,
+			}
+		case ast.B_GREATER_EQUAL:
+			c = vm.VMCode{
+				Operator: vm.GE,
+			}
+		case ast.B_AND:
+			c = vm.VMCode{
+				Operator: vm.AND,
+			}
 		default:
 			return codes
 		}
@@ -101,6 +646,7 @@ func translateExpression(expr *ast.Expression) []vm.VMCode {
 		c := vm.VMCode{
 			Operator: vm.FETCH,
 			Operand1: vm.VMValue{
+				Type: vm.Column,
 				Column: vm.VMColumn{
 					Column: expr.Column.Column,
 					DB:     "_",
@@ -109,22 +655,147 @@ func translateExpression(expr *ast.Expression) []vm.VMCode {
 			},
 		}
 		codes = append(codes, c)
+	} else if expr.Parameter != nil {
+		codes = append(codes, vm.VMCode{Operator: vm.PARAM, Operand1: vm.VMValue{Type: vm.Integer, Integral: expr.Parameter.Index}})
 	}
 	return codes
 }
 
-func translateFROM(from *ast.FROMClause) []vm.VMCode {
+// hashKeys splits cond, if it is an equality between a column of scan and
+// an expression on the tables before it, into that expression and the
+// column.
+func (l *lowering) hashKeys(cond *ast.Expression, scan *plan.Scan) (*ast.Expression, *ast.Column, bool) {
+	if cond == nil || cond.BinaryOperation == nil || cond.BinaryOperation.Operator != ast.B_EQUAL {
+		return nil, nil, false
+	}
+	b := cond.BinaryOperation
+	for _, sides := range [][2]*ast.Expression{{b.Left, b.Right}, {b.Right, b.Left}} {
+		probe, key := sides[0], sides[1]
+		if key.Column == nil || l.relation(key.Column) != scanName(scan) {
+			continue
+		}
+		before := true
+		for _, c := range columns(probe) {
+			if _, exists := l.cursors[l.relation(c)]; !exists || l.relation(c) == scanName(scan) {
+				before = false
+			}
+		}
+		if before {
+			return probe, key.Column, true
+		}
+	}
+	return nil, nil, false
+}
+
+// lowerLookup opens the table of scan and loops over its rows whose key
+// column equals probe.
+func (l *lowering) lowerLookup(scan *plan.Scan, probe *ast.Expression, key *ast.Column) []vm.VMCode {
+	codes := l.lowerScan(scan)
+	lookup := l.expression(probe)
+	lookup = append(lookup, vm.VMCode{
+		Operator: vm.LOOKUP,
+		Operand1: vm.VMValue{
+			Type: vm.Index,
+			Index: vm.VMIndex{
+				Table:   scan.Table.Table,
+				DB:      "_",
+				Schema:  "LOCAL",
+				Columns: []string{key.Column},
+			},
+		},
+		Operand2: codes[0].Operand2,
+	})
+	codes = append(codes[:1], append(lookup, codes[1:]...)...)
+	for _, f := range scan.Filters {
+		codes = append(codes, l.filter(f)...)
+	}
+	return codes
+}
+
+// lowerIndexScan opens the table of n on the rows its index finds for the
+// key and starts the loop over them. The key is computed again, and the
+// rows looked up, for each row of the tables before it. A range, or a scan
+// in the order of the index, pushes its bounds, Nothing for an open one,
+// and the order before the RANGE.
+func (l *lowering) lowerIndexScan(n *plan.IndexScan) []vm.VMCode {
 	codes := []vm.VMCode{}
+	op := vm.LOOKUP
+	if n.Key != nil && !n.Ordered {
+		codes = l.expression(n.Key)
+	} else {
+		op = vm.RANGE
+		low, high := n.Low, n.High
+		if n.Key != nil {
+			low, high = n.Key, n.Key
+		}
+		for _, b := range []*ast.Expression{low, high} {
+			if b == nil {
+				codes = append(codes, vm.VMCode{Operator: vm.PUSH, Operand1: vm.VMValue{Type: vm.Nothing}})
+				continue
+			}
+			codes = append(codes, l.expression(b)...)
+		}
+		order := runtime.TableOrder
+		switch {
+		case n.Ordered && n.Desc:
+			order = runtime.Descending
+		case n.Ordered:
+			order = runtime.Ascending
+		}
+		codes = append(codes, vm.VMCode{Operator: vm.PUSH, Operand1: vm.VMValue{Type: vm.Integer, Integral: int(order)}})
+	}
+	scan := l.lowerScan(n.Scan)
+	codes = append(codes, vm.VMCode{
+		Operator: op,
+		Operand1: vm.VMValue{
+			Type: vm.Index,
+			Index: vm.VMIndex{
+				Index:   n.Index,
+				Table:   n.Scan.Table.Table,
+				DB:      "_",
+				Schema:  "LOCAL",
+				Columns: []string{n.Column},
+			},
+		},
+		Operand2: scan[0].Operand2,
+	})
+	return append(codes, scan[1:]...)
+}
+
+func scanName(scan *plan.Scan) string {
+	if scan.Table.Alias != "" {
+		return scan.Table.Alias
+	}
+	return scan.Table.Table
+}
+
+// lowerScan opens the table of scan and starts the loop over its rows.
+// The rows dropped by the operators above it skip to the next one.
+func (l *lowering) lowerScan(scan *plan.Scan) []vm.VMCode {
+	cursor := integer(len(l.loops))
+	name := scanName(scan)
+	if _, exists := l.cursors[name]; !exists {
+		l.cursors[name] = cursor.Integral
+	}
+	lp := loop{next: l.label().Integral, cont: l.label().Integral, end: l.label().Integral}
+	l.loops = append(l.loops, lp)
+	l.skip = lp.cont
 	c := vm.VMCode{
 		Operator: vm.READ,
 		Operand1: vm.VMValue{
+			Type: vm.Table,
 			Table: vm.VMTable{
-				Table:  from.Table.Table,
-				DB:     "_",
-				Schema: "LOCAL",
+				Table:   scan.Table.Table,
+				DB:      "_",
+				Schema:  "LOCAL",
+				Columns: scan.Columns,
 			},
 		},
+		Operand2: cursor,
+	}
+	return []vm.VMCode{
+		c,
+		{Operator: vm.LABEL, Operand1: label(lp.next)},
+		{Operator: vm.NEXT, Operand1: label(lp.end), Operand2: cursor},
 	}
-	codes = append(codes, c)
-	return codes
 }
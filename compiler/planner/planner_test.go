@@ -1,9 +1,11 @@
 package planner
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/compiler/plan"
 	"github.com/yakawa/simpleDB/runtime/vm"
 )
 
@@ -47,6 +49,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -105,6 +110,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -185,6 +193,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -236,6 +247,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -277,6 +291,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -349,6 +366,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -406,6 +426,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -461,6 +484,9 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
 			},
 		},
 		{
@@ -500,6 +526,19 @@ func TestTranslate(t *testing.T) {
 							Schema: "LOCAL",
 						},
 					},
+					Operand2: vm.VMValue{
+						Type: vm.Integer,
+					},
+				},
+				{
+					Operator: vm.NEXT,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 6,
+					},
+					Operand2: vm.VMValue{
+						Type: vm.Integer,
+					},
 				},
 				{
 					Operator: vm.FETCH,
@@ -516,6 +555,357 @@ func TestTranslate(t *testing.T) {
 				{
 					Operator: vm.STORE,
 				},
+				{
+					Operator: vm.ROW,
+				},
+				{
+					Operator: vm.JUMP,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 1,
+					},
+				},
+			},
+		},
+		{
+			sql: "CREATE TABLE child (id, pid REFERENCES parent (id) ON DELETE CASCADE);",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						CREATETABLEStatement: &ast.CREATETABLEStatement{
+							Table:   &ast.Table{Table: "child"},
+							Columns: []string{"id", "pid"},
+							ForeignKeys: []ast.ForeignKeyClause{
+								{Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"id"}, OnDelete: ast.CASCADE},
+							},
+						},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{Operator: vm.PUSH, Operand1: vm.VMValue{Type: vm.Integer, Integral: int(ast.CASCADE)}},
+				{Operator: vm.PUSH, Operand1: vm.VMValue{Type: vm.Integer, Integral: int(ast.NO_ACTION)}},
+				{Operator: vm.PUSH, Operand1: vm.VMValue{Type: vm.Integer, Integral: 0}},
+				{
+					Operator: vm.FOREIGN_KEY,
+					Operand1: vm.VMValue{Type: vm.Index, Index: vm.VMIndex{Table: "child", DB: "_", Schema: "LOCAL", Columns: []string{"pid"}}},
+					Operand2: vm.VMValue{Type: vm.Index, Index: vm.VMIndex{Table: "parent", DB: "_", Schema: "LOCAL", Columns: []string{"id"}}},
+				},
+				{
+					Operator: vm.CREATE_TABLE,
+					Operand1: vm.VMValue{Type: vm.Table, Table: vm.VMTable{Table: "child", DB: "_", Schema: "LOCAL", Columns: []string{"id", "pid"}}},
+				},
+			},
+		},
+		{
+			sql: "CREATE INDEX idx1 ON tbl1(colA);",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						CREATEINDEXStatement: &ast.CREATEINDEXStatement{
+							Index: "idx1",
+							Table: &ast.Table{
+								Table: "tbl1",
+							},
+							Columns: []string{"colA"},
+						},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.CREATE_INDEX,
+					Operand1: vm.VMValue{
+						Type: vm.Index,
+						Index: vm.VMIndex{
+							Index:   "idx1",
+							Table:   "tbl1",
+							DB:      "_",
+							Schema:  "LOCAL",
+							Columns: []string{"colA"},
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "DROP INDEX idx1;",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						DROPINDEXStatement: &ast.DROPINDEXStatement{
+							Index: "idx1",
+						},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.DROP_INDEX,
+					Operand1: vm.VMValue{
+						Type: vm.Index,
+						Index: vm.VMIndex{
+							Index:  "idx1",
+							DB:     "_",
+							Schema: "LOCAL",
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "BEGIN; SAVEPOINT sp1; ROLLBACK TO sp1; RELEASE sp1; COMMIT;",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						BEGINStatement: &ast.BEGINStatement{},
+					},
+					{
+						SAVEPOINTStatement: &ast.SAVEPOINTStatement{
+							Savepoint: "sp1",
+						},
+					},
+					{
+						ROLLBACKStatement: &ast.ROLLBACKStatement{
+							Savepoint: "sp1",
+						},
+					},
+					{
+						RELEASEStatement: &ast.RELEASEStatement{
+							Savepoint: "sp1",
+						},
+					},
+					{
+						COMMITStatement: &ast.COMMITStatement{},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.BEGIN,
+					Operand1: vm.VMValue{
+						Type:   vm.String,
+						String: "DEFERRED",
+					},
+				},
+				{
+					Operator: vm.SAVEPOINT,
+					Operand1: vm.VMValue{
+						Type:   vm.String,
+						String: "sp1",
+					},
+				},
+				{
+					Operator: vm.ROLLBACK_TO,
+					Operand1: vm.VMValue{
+						Type:   vm.String,
+						String: "sp1",
+					},
+				},
+				{
+					Operator: vm.RELEASE,
+					Operand1: vm.VMValue{
+						Type:   vm.String,
+						String: "sp1",
+					},
+				},
+				{
+					Operator: vm.COMMIT,
+				},
+			},
+		},
+		{
+			sql: "SELECT ? + 1;",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						SELECTStatement: &ast.SELECTStatement{
+							Select: &ast.SELECTClause{
+								ResultColumns: []ast.ResultColumn{
+									{
+										Expression: &ast.Expression{
+											BinaryOperation: &ast.BinaryOpe{
+												Operator: ast.B_PLUS,
+												Left: &ast.Expression{
+													Parameter: &ast.Parameter{
+														Index: 1,
+													},
+												},
+												Right: &ast.Expression{
+													Literal: &ast.Literal{
+														Numeric: &ast.Numeric{
+															Integral: 1,
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				Parameters: []string{""},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.PARAM,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 1,
+					},
+				},
+				{
+					Operator: vm.PUSH,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 1,
+					},
+				},
+				{
+					Operator: vm.ADD,
+				},
+				{
+					Operator: vm.STORE,
+				},
+				{
+					Operator: vm.ROW,
+				},
+			},
+		},
+		{
+			sql: "ANALYZE; ANALYZE tbl1;",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						ANALYZEStatement: &ast.ANALYZEStatement{},
+					},
+					{
+						ANALYZEStatement: &ast.ANALYZEStatement{
+							Table: &ast.Table{
+								Table: "tbl1",
+							},
+						},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.ANALYZE,
+					Operand1: vm.VMValue{
+						Table: vm.VMTable{
+							DB:     "_",
+							Schema: "LOCAL",
+						},
+					},
+				},
+				{
+					Operator: vm.ANALYZE,
+					Operand1: vm.VMValue{
+						Table: vm.VMTable{
+							Table:  "tbl1",
+							DB:     "_",
+							Schema: "LOCAL",
+						},
+					},
+				},
+			},
+		},
+		{
+			sql: "SELECT 1 = 2;",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						SELECTStatement: &ast.SELECTStatement{
+							Select: &ast.SELECTClause{
+								ResultColumns: []ast.ResultColumn{
+									{
+										Expression: &ast.Expression{
+											BinaryOperation: &ast.BinaryOpe{
+												Operator: ast.B_EQUAL,
+												Left: &ast.Expression{
+													Literal: &ast.Literal{
+														Numeric: &ast.Numeric{
+															Integral: 1,
+														},
+													},
+												},
+												Right: &ast.Expression{
+													Literal: &ast.Literal{
+														Numeric: &ast.Numeric{
+															Integral: 2,
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.PUSH,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 1,
+					},
+				},
+				{
+					Operator: vm.PUSH,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 2,
+					},
+				},
+				{
+					Operator: vm.EQ,
+				},
+				{
+					Operator: vm.STORE,
+				},
+				{
+					Operator: vm.ROW,
+				},
+			},
+		},
+		{
+			sql: "SET statement_timeout = 100;",
+			ast: ast.AST{
+				SQL: []ast.SQL{
+					{
+						SETStatement: &ast.SETStatement{
+							Name: "statement_timeout",
+							Value: &ast.Expression{
+								Literal: &ast.Literal{
+									Numeric: &ast.Numeric{
+										Integral: 100,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: []vm.VMCode{
+				{
+					Operator: vm.PUSH,
+					Operand1: vm.VMValue{
+						Type:     vm.Integer,
+						Integral: 100,
+					},
+				},
+				{
+					Operator: vm.SET,
+					Operand1: vm.VMValue{
+						Type:   vm.String,
+						String: "statement_timeout",
+					},
+				},
 			},
 		},
 	}
@@ -532,3 +922,167 @@ func TestTranslate(t *testing.T) {
 		}
 	}
 }
+
+func TestLowerUnsupported(t *testing.T) {
+	n := &plan.Project{
+		Input: &plan.Aggregate{
+			Input:   &plan.Values{},
+			GroupBy: []*ast.Expression{{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: 1}}}},
+		},
+	}
+	if _, err := Lower(n); err == nil {
+		t.Fatalf("unsupported operator is lowered")
+	}
+}
+
+func TestLowerJumps(t *testing.T) {
+	num := func(n int) *ast.Expression {
+		return &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: n}}}
+	}
+	project := func(input plan.Node) plan.Node {
+		return &plan.Project{Input: input, Columns: []*ast.Expression{num(7)}}
+	}
+	testCases := []struct {
+		nodes    []plan.Node
+		expected []string
+	}{
+		{
+			[]plan.Node{project(&plan.Filter{Input: &plan.Values{}, Condition: num(0)}), project(&plan.Values{})},
+			[]string{"PUSH 0", "JUMP_IF_TRUE 3", "JUMP 6", "PUSH 7", "STORE", "ROW", "PUSH 7", "STORE", "ROW"},
+		},
+		{
+			[]plan.Node{project(&plan.Limit{Input: &plan.Values{}, Limit: num(0)})},
+			[]string{"PUSH 0", "PUSH 0", "LIMIT 0", "TAKE 8, 0", "JUMP_IF_FALSE 8", "PUSH 7", "STORE", "ROW"},
+		},
+		{
+			[]plan.Node{project(&plan.Limit{Input: &plan.Sort{Input: &plan.Values{}, Keys: []plan.SortKey{{Expression: num(1), Desc: true}}}, Limit: num(2), Offset: num(1)})},
+			[]string{"PUSH 2", "PUSH 1", "LIMIT 0", "HOLD", "PUSH 7", "STORE", "PUSH 1", "STORE", "ROW", "SORT D, 0"},
+		},
+		{
+			[]plan.Node{&plan.Project{
+				Input: &plan.Join{
+					Type:      plan.InnerJoin,
+					Left:      &plan.Scan{Table: &ast.Table{Table: "tbl1"}},
+					Right:     &plan.Scan{Table: &ast.Table{Table: "tbl2", Alias: "t2"}},
+					Condition: &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ast.B_EQUAL, Left: &ast.Expression{Column: &ast.Column{Table: "t2", Column: "colA"}}, Right: &ast.Expression{Column: &ast.Column{Column: "colA"}}}},
+				},
+				Columns: []*ast.Expression{{Column: &ast.Column{Table: "t2", Column: "colB"}}},
+			}},
+			[]string{
+				"READ tbl1, 0", "NEXT 14, 0", "READ tbl2, 1", "NEXT 13, 1", "FETCH colA, 1", "FETCH colA", "EQ", "JUMP_IF_TRUE 9", "JUMP 12",
+				"FETCH colB, 1", "STORE", "ROW", "JUMP 3", "JUMP 1",
+			},
+		},
+		{
+			[]plan.Node{&plan.Project{
+				Input: &plan.Join{
+					Type:      plan.InnerJoin,
+					Algorithm: plan.HashJoin,
+					Left:      &plan.Scan{Table: &ast.Table{Table: "tbl1"}},
+					Right:     &plan.Scan{Table: &ast.Table{Table: "tbl2"}},
+					Condition: &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ast.B_EQUAL, Left: &ast.Expression{Column: &ast.Column{Table: "tbl2", Column: "colA"}}, Right: &ast.Expression{Column: &ast.Column{Table: "tbl1", Column: "colA"}}}},
+				},
+				Columns: []*ast.Expression{{Column: &ast.Column{Table: "tbl2", Column: "colB"}}},
+			}},
+			[]string{
+				"READ tbl1, 0", "NEXT 11, 0", "READ tbl2, 1", "FETCH colA", "LOOKUP tbl2 (colA), 1", "NEXT 10, 1",
+				"FETCH colB, 1", "STORE", "ROW", "JUMP 5", "JUMP 1",
+			},
+		},
+		{
+			[]plan.Node{&plan.Project{
+				Input: &plan.Join{
+					Type:      plan.InnerJoin,
+					Algorithm: plan.IndexLookup,
+					Left:      &plan.Scan{Table: &ast.Table{Table: "tbl1"}},
+					Right: &plan.IndexScan{
+						Scan:   &plan.Scan{Table: &ast.Table{Table: "tbl2"}, Filters: []*ast.Expression{{Column: &ast.Column{Table: "tbl2", Column: "colB"}}}},
+						Index:  "idx_colA",
+						Column: "colA",
+						Key:    &ast.Expression{Column: &ast.Column{Table: "tbl1", Column: "colA"}},
+					},
+					Condition: &ast.Expression{BinaryOperation: &ast.BinaryOpe{Operator: ast.B_EQUAL, Left: &ast.Expression{Column: &ast.Column{Table: "tbl2", Column: "colA"}}, Right: &ast.Expression{Column: &ast.Column{Table: "tbl1", Column: "colA"}}}},
+				},
+				Columns: []*ast.Expression{{Column: &ast.Column{Table: "tbl2", Column: "colB"}}},
+			}},
+			[]string{
+				"READ tbl1, 0", "NEXT 18, 0", "FETCH colA", "LOOKUP idx_colA ON tbl2 (colA), 1", "NEXT 17, 1",
+				"FETCH colB, 1", "JUMP_IF_TRUE 8", "JUMP 16", "FETCH colA, 1", "FETCH colA", "EQ", "JUMP_IF_TRUE 13", "JUMP 16",
+				"FETCH colB, 1", "STORE", "ROW", "JUMP 4", "JUMP 1",
+			},
+		},
+		{
+			[]plan.Node{&plan.Explain{Analyze: true, Input: project(&plan.Filter{Input: &plan.Values{}, Condition: num(1)})}},
+			[]string{
+				"ROW", "PROFILE 6", "PUSH 1", "JUMP_IF_TRUE 5", "JUMP 8", "PUSH 7", "STORE", "ROW",
+				"PUSH 1", "STORE", "PUSH 0", "STORE", "PUSH Project [7]", "STORE", "REPORT 0, 5", "ROW",
+				"PUSH 2", "STORE", "PUSH 1", "STORE", "PUSH Filter 1", "STORE", "REPORT 0, 3", "ROW",
+				"PUSH 3", "STORE", "PUSH 2", "STORE", "PUSH Values", "STORE", "REPORT 0, 0", "ROW",
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		vc, err := LowerAll(tc.nodes, nil)
+		if err != nil {
+			t.Fatalf("[%d] error: %s", tn, err)
+		}
+		actual := []string{}
+		for _, c := range vc {
+			actual = append(actual, c.String())
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] expected %q but got %q", tn, tc.expected, actual)
+		}
+		if err := vm.Verify(vc); err != nil {
+			t.Fatalf("[%d] Verify error: %s", tn, err)
+		}
+	}
+}
+
+func TestLowerExplain(t *testing.T) {
+	one := &ast.Expression{Literal: &ast.Literal{Numeric: &ast.Numeric{Integral: 1}}}
+	project := &plan.Project{Input: &plan.Values{}, Columns: []*ast.Expression{one}}
+	testCases := []struct {
+		node     *plan.Explain
+		expected [][]string
+	}{
+		{
+			&plan.Explain{Input: project},
+			[][]string{{"0", "PUSH", "1", "push a constant"}, {"1", "STORE", "", "output a column"}, {"2", "ROW", "", "end of a result row"}},
+		},
+		{
+			&plan.Explain{QueryPlan: true, Input: project, Estimates: map[plan.Node]float64{project: 1}},
+			[][]string{{"1", "0", "Project [1] (~1 rows)"}, {"2", "1", "Values"}},
+		},
+		{
+			&plan.Explain{Analyze: true, Input: project},
+			[][]string{{"1", "0", "Project [1]", "0, 2"}, {"2", "1", "Values", "0, 0"}},
+		},
+	}
+
+	for tn, tc := range testCases {
+		vc, err := Lower(tc.node)
+		if err != nil {
+			t.Fatalf("[%d] error: %s", tn, err)
+		}
+		rows := [][]string{}
+		row := []string{}
+		for pc := 0; pc < len(vc); pc++ {
+			switch c := vc[pc]; c.Operator {
+			case vm.PROFILE:
+				pc += c.Operand1.Integral
+			case vm.PUSH, vm.REPORT:
+				row = append(row, c.Operands())
+			case vm.ROW:
+				if len(row) != 0 {
+					rows = append(rows, row)
+				}
+				row = []string{}
+			}
+		}
+		if fmt.Sprint(rows) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] expected %q but got %q", tn, tc.expected, rows)
+		}
+	}
+}
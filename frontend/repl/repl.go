@@ -3,22 +3,33 @@ package repl
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/yakawa/simpleDB/common/result"
-	"github.com/yakawa/simpleDB/compiler/lexer"
-	"github.com/yakawa/simpleDB/compiler/parser"
-	"github.com/yakawa/simpleDB/compiler/planner"
+	"github.com/yakawa/simpleDB/compiler"
+	"github.com/yakawa/simpleDB/runtime"
 	"github.com/yakawa/simpleDB/runtime/vm"
 )
 
 const PROMPT = ">>"
 
+// Start reads statements from in and runs them until .exit or the end of
+// in. An interrupt (Ctrl-C) cancels the statement being run instead of
+// stopping the process.
 func Start(in io.ReadCloser, out io.Writer) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
 	r := bufio.NewReader(in)
-	for {
+	vectorized := false
+	eof := false
+	for !eof {
 		fmt.Fprintf(out, PROMPT)
 
 		var bb *bytes.Buffer
@@ -27,6 +38,7 @@ func Start(in io.ReadCloser, out io.Writer) {
 			buf, cont, err := r.ReadLine()
 			if err != nil {
 				if err == io.EOF {
+					eof = true
 					break
 				}
 				panic(err)
@@ -51,26 +63,55 @@ func Start(in io.ReadCloser, out io.Writer) {
 		}
 
 		line := bb.String()
+		if eof && strings.TrimSpace(line) == "" {
+			break
+		}
 
+		rt := runtime.GetInstance()
+		var codes []vm.VMCode
+		var params []vm.VMValue
 		if strings.HasPrefix(line, ".") {
-			if parseCommand(line, out) {
+			var exit bool
+			codes, exit = parseCommand(line, out, &vectorized)
+			if exit {
 				return
 			}
 		} else {
-			tokens := lexer.Lex(line)
-			a, _ := parser.Parse(tokens)
-			vc := planner.Translate(a)
-			for _, c := range vc {
-				fmt.Fprintf(out, "%#+v\n", c)
+			st, err := compiler.CompileCached(rt, line)
+			if err != nil {
+				fmt.Fprintf(out, "%s\n", err)
+				continue
+			}
+			codes, params = st.Codes, st.Literals
+		}
+		if codes != nil {
+			execute := vm.ExecuteRowsContext
+			if vectorized {
+				execute = vm.ExecuteVectorizedContext
 			}
-			rs := vm.Run(vc)
-			for i, col := range rs {
-				switch col.Type {
-				case result.Integral:
-					fmt.Fprintf(out, "%d", col.Integral)
+			ctx, cancel := interruptible(interrupts)
+			rows, err := execute(ctx, rt.Session(), codes, params...)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(out, "%s\n", err)
+				continue
+			}
+			for n, row := range rows {
+				if n != 0 {
+					fmt.Fprintf(out, "\n")
 				}
-				if i != (len(rs) - 1) {
-					fmt.Fprintf(out, ",")
+				for i, col := range row {
+					switch col.Type {
+					case result.Integral:
+						fmt.Fprintf(out, "%d", col.Integral)
+					case result.String:
+						fmt.Fprintf(out, "%s", col.String)
+					case result.Null:
+						fmt.Fprintf(out, "NULL")
+					}
+					if i != (len(row) - 1) {
+						fmt.Fprintf(out, ",")
+					}
 				}
 			}
 		}
@@ -78,12 +119,98 @@ func Start(in io.ReadCloser, out io.Writer) {
 	}
 }
 
-func parseCommand(line string, out io.Writer) bool {
-	switch line {
-	case ".exit":
-		return true
+// interruptible returns a context that is canceled by the next interrupt,
+// dropping one that came while no statement was running.
+func interruptible(interrupts chan os.Signal) (context.Context, func()) {
+	select {
+	case <-interrupts:
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel()
+	}
+}
+
+// parseCommand runs a dot command. It returns the code to run for .run,
+// and whether the REPL should exit. ".mode vector" runs the following
+// queries with the vectorized engine and ".mode row" a row at a time.
+// ".disasm" lists the code of a statement and ".run" runs code written by
+// vm.Encode or vm.Disassemble to a file. ".import FILE TABLE" creates a
+// table of the storage from a CSV file, ".external FILE TABLE" one that
+// reads the file, and ".export TABLE FILE" writes a table to a CSV file.
+func parseCommand(line string, out io.Writer, vectorized *bool) ([]vm.VMCode, bool) {
+	line = strings.TrimSpace(line)
+	arg := ""
+	if i := strings.IndexAny(line, " \t\n"); i >= 0 {
+		line, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+	switch {
+	case line == ".exit" && arg == "":
+		return nil, true
+	case line == ".mode" && arg == "row":
+		*vectorized = false
+	case line == ".mode" && arg == "vector":
+		*vectorized = true
+	case line == ".disasm":
+		st, err := compiler.Compile(runtime.GetInstance(), arg)
+		if err != nil {
+			fmt.Fprintf(out, "%s", err)
+			break
+		}
+		var sb strings.Builder
+		if err := vm.Disassemble(&sb, st.Codes); err != nil {
+			fmt.Fprintf(out, "%s", err)
+			break
+		}
+		fmt.Fprintf(out, "%s", strings.TrimRight(sb.String(), "\n"))
+	case line == ".run":
+		f, err := os.Open(arg)
+		if err != nil {
+			fmt.Fprintf(out, "%s", err)
+			break
+		}
+		defer f.Close()
+		codes, err := vm.Load(f)
+		if err != nil {
+			fmt.Fprintf(out, "%s", err)
+			break
+		}
+		return codes, false
+	case line == ".import" || line == ".external" || line == ".export":
+		args := strings.Fields(arg)
+		if len(args) != 2 {
+			usage := "FILE TABLE"
+			if line == ".export" {
+				usage = "TABLE FILE"
+			}
+			fmt.Fprintf(out, "Usage: %s %s", line, usage)
+			break
+		}
+		sess := runtime.GetInstance().Session()
+		var err error
+		switch line {
+		case ".import":
+			err = sess.ImportCSV("_", args[1], args[0])
+		case ".external":
+			err = sess.CreateExternalTable("_", args[1], args[0])
+		case ".export":
+			err = sess.ExportCSV("_", args[0], args[1])
+		}
+		if err != nil {
+			fmt.Fprintf(out, "%s", err)
+		}
 	default:
 		fmt.Fprintf(out, "Unknown Command")
-		return false
 	}
+	return nil, false
 }
@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"github.com/yakawa/simpleDB/runtime/stats"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func (r *Runtime) Analyze(db string, tbl string) error {
+	return r.session.Analyze(db, tbl, r.session.NewUsage())
+}
+
+// Analyze collects the statistics of a table, or of every table of db when
+// tbl is empty, and stores them in the catalog. Cached plans are compiled
+// again so that they can use them. The values kept to build the statistics
+// are counted in usage.
+func (s *Session) Analyze(db string, tbl string, usage *Usage) error {
+	r := s.r
+	tbls := []string{tbl}
+	if tbl == "" {
+		tbls = r.LocalTables(db)
+		if db == "_" {
+			tbls = append(tbls, r.storage.ReadTables()...)
+		}
+	}
+	collected := map[string]*stats.Table{}
+	for _, t := range tbls {
+		cols, err := r.readHeaderFromLocalTable(db, t)
+		if err != nil {
+			return err
+		}
+		// Each part of the table is collected on its own and merged.
+		parts := make([]*stats.Collector, r.Parallelism())
+		for n := range parts {
+			parts[n] = stats.NewCollector(cols)
+		}
+		err = s.scanColumnsParallel(db, t, nil, len(parts), func(pn int, row []table.ColumnValue) error {
+			held := parts[pn].Memory()
+			parts[pn].Add(row)
+			return usage.Memory(parts[pn].Memory() - held)
+		}, func(pn int) {
+			usage.Memory(-parts[pn].Memory())
+			parts[pn] = stats.NewCollector(cols)
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range parts[1:] {
+			parts[0].Merge(c)
+		}
+		collected[tableKey(db, t)] = parts[0].Table()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schema++
+	for k, st := range collected {
+		r.stats[k] = st
+	}
+	return nil
+}
+
+// Statistics returns the statistics ANALYZE collected on a table.
+func (r *Runtime) Statistics(db string, tbl string) (*stats.Table, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	st, exists := r.stats[tableKey(db, tbl)]
+	return st, exists
+}
+
+// IndexOn returns the name of an index of a table whose first column is
+// col, which ScanIndex reads.
+func (r *Runtime) IndexOn(db string, tbl string, col string) (string, bool) {
+	if r.stored(db, tbl) {
+		ti, exists := r.storage.Table(tbl)
+		if !exists {
+			return "", false
+		}
+		for _, idx := range ti.Indexes {
+			if len(idx.Columns) != 0 && idx.Columns[0] == col {
+				return idx.Name, true
+			}
+		}
+		return "", false
+	}
+	if idx := r.FindIndex(db, tbl, []string{col}); idx != nil {
+		return idx.Name, true
+	}
+	return "", false
+}
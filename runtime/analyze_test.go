@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), []byte("#colA, colB\n1,2\n3,\n3,6\n7,\n"), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	r := New().Set(dir)
+	if _, exists := r.Statistics("_", "tbl1"); exists {
+		t.Fatalf("Statistics exist before ANALYZE")
+	}
+	if err := r.Analyze("_", "tbl2"); err == nil {
+		t.Fatalf("Unknown table is analyzed")
+	}
+	version := r.SchemaVersion()
+	if err := r.Analyze("_", ""); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if r.SchemaVersion() == version {
+		t.Fatalf("Schema version is not changed")
+	}
+
+	st, exists := r.Statistics("_", "tbl1")
+	if !exists {
+		t.Fatalf("Statistics are not stored")
+	}
+	if st.Rows != 4 {
+		t.Fatalf("Rows mismatch %d", st.Rows)
+	}
+	a, _ := st.Column("colA")
+	if a.Distinct != 3 || a.Min != 1 || a.Max != 7 || a.NullFraction != 0 {
+		t.Fatalf("colA mismatch %+v", a)
+	}
+	b, _ := st.Column("colB")
+	if b.Distinct != 2 || b.NullFraction != 0.5 {
+		t.Fatalf("colB mismatch %+v", b)
+	}
+
+	if _, exists := r.IndexOn("_", "tbl1", "colB"); exists {
+		t.Fatalf("Index is found before it is created")
+	}
+	if err := r.CreateIndex("_", "tbl1", "idx_colB", []string{"colB", "colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if name, _ := r.IndexOn("_", "tbl1", "colB"); name != "idx_colB" {
+		t.Fatalf("IndexOn mismatch %s", name)
+	}
+	if _, exists := r.IndexOn("_", "tbl1", "colA"); exists {
+		t.Fatalf("IndexOn mismatch")
+	}
+
+	r.Set(dir)
+	if _, exists := r.Statistics("_", "tbl1"); exists {
+		t.Fatalf("Statistics are kept after the table directory is changed")
+	}
+}
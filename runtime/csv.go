@@ -0,0 +1,68 @@
+package runtime
+
+import (
+	"path/filepath"
+
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// ImportCSV creates tbl as a table of the storage holding the rows of the
+// CSV file fn, with the columns of its header.
+func (s *Session) ImportCSV(db string, tbl string, fn string) error {
+	if err := s.r.checkNewTable(db, tbl); err != nil {
+		return err
+	}
+	return s.statement(func() error {
+		release, err := s.write(db, tbl)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return s.r.storage.ImportCSV(tbl, fn)
+	})
+}
+
+// CreateExternalTable creates tbl as a table of the storage whose rows are
+// read from the CSV file fn each time, and which cannot be written.
+func (s *Session) CreateExternalTable(db string, tbl string, fn string) error {
+	if err := s.r.checkNewTable(db, tbl); err != nil {
+		return err
+	}
+	// The path is kept in the database, which may be opened from another
+	// directory.
+	fn, err := filepath.Abs(fn)
+	if err != nil {
+		return err
+	}
+	return s.statement(func() error {
+		release, err := s.write(db, tbl)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return s.r.storage.CreateExternalTable(tbl, fn)
+	})
+}
+
+// ExportCSV writes the rows of a table the session sees to the CSV file
+// fn.
+func (s *Session) ExportCSV(db string, tbl string, fn string) error {
+	cols, err := s.r.readHeaderFromLocalTable(db, tbl)
+	if err != nil {
+		return err
+	}
+	tv := &table.TableValue{Header: cols}
+	err = s.ScanColumnsFromLocalTable(db, tbl, nil, func(row []table.ColumnValue) error {
+		values := map[string]table.ColumnValue{}
+		for _, c := range row {
+			values[c.Name] = c
+		}
+		tv.Values = append(tv.Values, values)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return csv.Write(fn, tv)
+}
@@ -0,0 +1,505 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+type ReferentialAction int
+
+const (
+	NoAction ReferentialAction = iota
+	Restrict
+	Cascade
+	SetNull
+	SetDefault
+)
+
+func (a ReferentialAction) String() string {
+	switch a {
+	case NoAction:
+		return "NO ACTION"
+	case Restrict:
+		return "RESTRICT"
+	case Cascade:
+		return "CASCADE"
+	case SetNull:
+		return "SET NULL"
+	case SetDefault:
+		return "SET DEFAULT"
+	default:
+		return "Unknown Action"
+	}
+}
+
+type ForeignKey struct {
+	Name       string
+	DB         string
+	Table      string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   ReferentialAction
+	OnUpdate   ReferentialAction
+	Deferred   bool
+}
+
+func tableKey(db string, tbl string) string {
+	return db + "." + tbl
+}
+
+func (r *Runtime) AddForeignKey(fk ForeignKey) error {
+	if fk.DB == "" {
+		fk.DB = "_"
+	}
+	if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.RefColumns) {
+		return errors.New(fmt.Sprintf("Foreign Key (%s) column count mismatch", fk.Name))
+	}
+	if err := checkActions(fk); err != nil {
+		return err
+	}
+
+	if err := r.checkColumns(fk.DB, fk.Table, fk.Columns); err != nil {
+		return err
+	}
+	if err := r.checkColumns(fk.DB, fk.RefTable, fk.RefColumns); err != nil {
+		return err
+	}
+
+	if !fk.Deferred {
+		if err := r.CheckForeignKey(fk); err != nil {
+			return err
+		}
+	}
+
+	return r.addForeignKey(fk)
+}
+
+// checkActions rejects SET DEFAULT, as the columns have no default to set.
+func checkActions(fk ForeignKey) error {
+	if fk.OnDelete == SetDefault || fk.OnUpdate == SetDefault {
+		return errors.New(fmt.Sprintf("Foreign Key (%s) SET DEFAULT is not supported: columns have no default", fk.Name))
+	}
+	return nil
+}
+
+func (r *Runtime) addForeignKey(fk ForeignKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schema++
+	child := tableKey(fk.DB, fk.Table)
+	parent := tableKey(fk.DB, fk.RefTable)
+	for _, k := range r.foreignKeys[child] {
+		if k.Name == fk.Name {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) already exists", fk.Name))
+		}
+	}
+	r.foreignKeys[child] = append(r.foreignKeys[child], fk)
+	r.referencedBy[parent] = append(r.referencedBy[parent], fk)
+	return nil
+}
+
+func (r *Runtime) DropForeignKey(db string, tbl string, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schema++
+
+	child := tableKey(db, tbl)
+	for n, fk := range r.foreignKeys[child] {
+		if fk.Name != name {
+			continue
+		}
+		r.foreignKeys[child] = append(r.foreignKeys[child][:n], r.foreignKeys[child][n+1:]...)
+
+		parent := tableKey(fk.DB, fk.RefTable)
+		for m, ref := range r.referencedBy[parent] {
+			if ref.Name == name && ref.Table == tbl {
+				r.referencedBy[parent] = append(r.referencedBy[parent][:m], r.referencedBy[parent][m+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Foreign Key (%s) Not Found", name))
+}
+
+func (r *Runtime) ForeignKeys(db string, tbl string) []ForeignKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]ForeignKey{}, r.foreignKeys[tableKey(db, tbl)]...)
+}
+
+func (r *Runtime) ReferencingKeys(db string, tbl string) []ForeignKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]ForeignKey{}, r.referencedBy[tableKey(db, tbl)]...)
+}
+
+func (r *Runtime) DependentTables(db string, tbl string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	visited := map[string]bool{tableKey(db, tbl): true}
+	queue := []string{tableKey(db, tbl)}
+	deps := []string{}
+
+	for len(queue) != 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, fk := range r.referencedBy[t] {
+			k := tableKey(fk.DB, fk.Table)
+			if visited[k] {
+				continue
+			}
+			visited[k] = true
+			deps = append(deps, fk.Table)
+			queue = append(queue, k)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func (r *Runtime) CheckForeignKey(fk ForeignKey) error {
+	return r.checkForeignKey(r.session, fk)
+}
+
+func (r *Runtime) checkForeignKey(s *Session, fk ForeignKey) error {
+	if fk.DB == "" {
+		fk.DB = "_"
+	}
+	parentKeys := map[string]bool{}
+	err := r.readTuples(s, fk.DB, fk.RefTable, fk.RefColumns, func(k string) error {
+		parentKeys[k] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.readTuples(s, fk.DB, fk.Table, fk.Columns, func(k string) error {
+		if !parentKeys[k] {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) violation: %s(%s) not found in %s", fk.Name, fk.Table, k, fk.RefTable))
+		}
+		return nil
+	})
+}
+
+func (r *Runtime) CheckDeferredForeignKeys(db string) error {
+	return r.checkDeferredForeignKeys(r.session, db)
+}
+
+func (r *Runtime) checkDeferredForeignKeys(s *Session, db string) error {
+	r.mu.RLock()
+	keys := []string{}
+	for k := range r.foreignKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fks := []ForeignKey{}
+	for _, k := range keys {
+		for _, fk := range r.foreignKeys[k] {
+			if fk.DB == db && fk.Deferred {
+				fks = append(fks, fk)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, fk := range fks {
+		if err := r.checkForeignKey(s, fk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deferredKeys are the keys of a deferred foreign key a transaction wrote.
+type deferredKeys struct {
+	fk   ForeignKey
+	keys map[string]bool
+}
+
+// deferKey records a key of a deferred foreign key written by the
+// transaction, to be checked on commit.
+func (s *Session) deferKey(fk ForeignKey, key string) {
+	if s.tx == nil {
+		return
+	}
+	if s.tx.deferred == nil {
+		s.tx.deferred = map[string]*deferredKeys{}
+	}
+	name := tableKey(fk.DB, fk.Table) + "." + fk.Name
+	d, exists := s.tx.deferred[name]
+	if !exists {
+		d = &deferredKeys{fk: fk, keys: map[string]bool{}}
+		s.tx.deferred[name] = d
+	}
+	d.keys[key] = true
+}
+
+// checkForeignKeys is checkForeignKey for the rows of fk whose key is in
+// keys. The tables are read once whatever the number of keys. A foreign
+// key dropped since the keys were recorded is not checked.
+func (r *Runtime) checkForeignKeys(s *Session, fk ForeignKey, keys map[string]bool) error {
+	exists := false
+	for _, k := range r.ForeignKeys(fk.DB, fk.Table) {
+		if k.Name == fk.Name {
+			exists = true
+		}
+	}
+	if !exists {
+		return nil
+	}
+	missing := map[string]bool{}
+	for k := range keys {
+		missing[k] = true
+	}
+	err := r.readTuples(s, fk.DB, fk.RefTable, fk.RefColumns, func(k string) error {
+		delete(missing, k)
+		return nil
+	})
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	return r.readTuples(s, fk.DB, fk.Table, fk.Columns, func(k string) error {
+		if missing[k] {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) violation: %s(%s) not found in %s", fk.Name, fk.Table, k, fk.RefTable))
+		}
+		return nil
+	})
+}
+
+func (r *Runtime) checkColumns(db string, tbl string, cols []string) error {
+	header, err := r.readHeaderFromLocalTable(db, tbl)
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		found := false
+		for _, h := range header {
+			if h == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New(fmt.Sprintf("Column (%s.%s) Not Found", tbl, c))
+		}
+	}
+	return nil
+}
+
+func (r *Runtime) readTuples(s *Session, db string, tbl string, cols []string, fn func(string) error) error {
+	header, err := r.readHeaderFromLocalTable(db, tbl)
+	if err != nil {
+		return err
+	}
+	pos := positions(header, cols)
+
+	return s.scanTable(db, tbl, func(rowid int64, row []table.Value) error {
+		k, ok := keyOf(row, pos)
+		if !ok {
+			return nil
+		}
+		return fn(k)
+	})
+}
+
+func positions(header []string, cols []string) []int {
+	pos := []int{}
+	for _, c := range cols {
+		for n, h := range header {
+			if h == c {
+				pos = append(pos, n)
+				break
+			}
+		}
+	}
+	return pos
+}
+
+// keyOf returns the key of a row in the columns at pos. A key with a NULL
+// references nothing, so it is not reported.
+func keyOf(row []table.Value, pos []int) (string, bool) {
+	vals := []string{}
+	for _, n := range pos {
+		if n >= len(row) || row[n].Type != table.Integer {
+			return "", false
+		}
+		vals = append(vals, fmt.Sprintf("%d", row[n].Integral))
+	}
+	return strings.Join(vals, ","), true
+}
+
+func checkHeader(tbl string, header []string, cols []string) error {
+	for _, c := range cols {
+		if len(positions(header, []string{c})) == 0 {
+			return errors.New(fmt.Sprintf("Column (%s.%s) Not Found", tbl, c))
+		}
+	}
+	return nil
+}
+
+type keyedRow struct {
+	rowid int64
+	row   []table.Value
+}
+
+// matching returns the rows of a table whose key in cols is key.
+func (s *Session) matching(db string, tbl string, cols []string, key string) ([]keyedRow, error) {
+	header, err := s.r.readHeaderFromLocalTable(db, tbl)
+	if err != nil {
+		return nil, err
+	}
+	pos := positions(header, cols)
+	rows := []keyedRow{}
+	err = s.scanTable(db, tbl, func(rowid int64, row []table.Value) error {
+		if k, ok := keyOf(row, pos); ok && k == key {
+			rows = append(rows, keyedRow{rowid: rowid, row: row})
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// checkParents checks that a row written to tbl references existing rows
+// through the foreign keys of tbl. The keys of the deferred ones are
+// recorded to be checked on commit. old is the row before an update; keys
+// it already had are not checked again.
+func (s *Session) checkParents(db string, tbl string, old []table.Value, row []table.Value) error {
+	header, err := s.r.readHeaderFromLocalTable(db, tbl)
+	if err != nil {
+		return err
+	}
+	for _, fk := range s.r.ForeignKeys(db, tbl) {
+		pos := positions(header, fk.Columns)
+		k, ok := keyOf(row, pos)
+		if !ok {
+			continue
+		}
+		if prev, _ := keyOf(old, pos); old != nil && prev == k {
+			continue
+		}
+		if fk.Deferred {
+			s.deferKey(fk, k)
+			continue
+		}
+		parents, err := s.matching(db, fk.RefTable, fk.RefColumns, k)
+		if err != nil {
+			return err
+		}
+		if len(parents) == 0 {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) violation: %s(%s) not found in %s", fk.Name, fk.Table, k, fk.RefTable))
+		}
+	}
+	return nil
+}
+
+// runActions runs the ON DELETE action, or the ON UPDATE one when row is
+// not nil, of the foreign keys referencing tbl on the rows that referenced
+// old. NO ACTION is checked once the other actions have run, so that rows
+// they removed no longer count; the key of a deferred one is recorded to be
+// checked on commit.
+func (s *Session) runActions(db string, tbl string, old []table.Value, row []table.Value) error {
+	header, err := s.r.readHeaderFromLocalTable(db, tbl)
+	if err != nil {
+		return err
+	}
+	noAction := []ForeignKey{}
+	keys := []string{}
+	for _, fk := range s.r.ReferencingKeys(db, tbl) {
+		pos := positions(header, fk.RefColumns)
+		k, ok := keyOf(old, pos)
+		if !ok {
+			continue
+		}
+		action := fk.OnDelete
+		if row != nil {
+			action = fk.OnUpdate
+			if nk, ok := keyOf(row, pos); ok && nk == k {
+				continue
+			}
+		}
+		if parents, err := s.matching(db, tbl, fk.RefColumns, k); err != nil {
+			return err
+		} else if len(parents) != 0 {
+			continue
+		}
+
+		if action == NoAction {
+			if fk.Deferred {
+				s.deferKey(fk, k)
+			} else {
+				noAction = append(noAction, fk)
+				keys = append(keys, k)
+			}
+			continue
+		}
+		children, err := s.matching(db, fk.Table, fk.Columns, k)
+		if err != nil {
+			return err
+		}
+		if len(children) == 0 {
+			continue
+		}
+		if action == Restrict {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) violation: %s(%s) is referenced by %s", fk.Name, tbl, k, fk.Table))
+		}
+		if !s.r.stored(db, fk.Table) {
+			return errors.New(fmt.Sprintf("Table (%s) is read only", fk.Table))
+		}
+		if err := s.applyAction(db, fk, action, children, row, pos); err != nil {
+			return err
+		}
+	}
+
+	for n, fk := range noAction {
+		children, err := s.matching(db, fk.Table, fk.Columns, keys[n])
+		if err != nil {
+			return err
+		}
+		if len(children) != 0 {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) violation: %s(%s) is referenced by %s", fk.Name, tbl, keys[n], fk.Table))
+		}
+	}
+	return nil
+}
+
+// applyAction runs action on the children of a parent row. parent is the
+// new parent row of an update, and nil for a delete; pos are the positions
+// of the referenced columns in it.
+func (s *Session) applyAction(db string, fk ForeignKey, action ReferentialAction, children []keyedRow, parent []table.Value, pos []int) error {
+	header, err := s.r.readHeaderFromLocalTable(db, fk.Table)
+	if err != nil {
+		return err
+	}
+	cols := positions(header, fk.Columns)
+	for _, c := range children {
+		if action == Cascade && parent == nil {
+			if err := s.delete(db, fk.Table, c.rowid); err != nil {
+				return err
+			}
+			continue
+		}
+		row := append([]table.Value{}, c.row...)
+		for n, col := range cols {
+			if action == Cascade {
+				row[col] = parent[pos[n]]
+			} else {
+				row[col] = table.Value{Type: table.Null}
+			}
+		}
+		if err := s.update(db, fk.Table, c.rowid, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddForeignKey(t *testing.T) {
+	testCases := []struct {
+		fk  ForeignKey
+		err bool
+	}{
+		{
+			fk: ForeignKey{
+				Name:       "fk_child",
+				DB:         "fk",
+				Table:      "child",
+				Columns:    []string{"parentId"},
+				RefTable:   "parent",
+				RefColumns: []string{"id"},
+				OnDelete:   Cascade,
+			},
+			err: false,
+		},
+		{
+			fk: ForeignKey{
+				Name:       "fk_grandchild",
+				DB:         "fk",
+				Table:      "grandchild",
+				Columns:    []string{"childId"},
+				RefTable:   "child",
+				RefColumns: []string{"id"},
+				OnDelete:   SetNull,
+			},
+			err: false,
+		},
+		{
+			fk: ForeignKey{
+				Name:       "fk_orphan",
+				DB:         "fk",
+				Table:      "orphan",
+				Columns:    []string{"parentId"},
+				RefTable:   "parent",
+				RefColumns: []string{"id"},
+			},
+			err: true,
+		},
+		{
+			fk: ForeignKey{
+				Name:       "fk_orphan_deferred",
+				DB:         "fk",
+				Table:      "orphan",
+				Columns:    []string{"parentId"},
+				RefTable:   "parent",
+				RefColumns: []string{"id"},
+				Deferred:   true,
+			},
+			err: false,
+		},
+		{
+			fk: ForeignKey{
+				Name:       "fk_set_default",
+				DB:         "fk",
+				Table:      "child",
+				Columns:    []string{"parentId"},
+				RefTable:   "parent",
+				RefColumns: []string{"id"},
+				OnDelete:   SetDefault,
+			},
+			err: true,
+		},
+		{
+			fk: ForeignKey{
+				Name:       "fk_unknown_column",
+				DB:         "fk",
+				Table:      "child",
+				Columns:    []string{"unknown"},
+				RefTable:   "parent",
+				RefColumns: []string{"id"},
+			},
+			err: true,
+		},
+		{
+			fk: ForeignKey{
+				Name:       "fk_unknown_table",
+				DB:         "fk",
+				Table:      "child",
+				Columns:    []string{"parentId"},
+				RefTable:   "unknown",
+				RefColumns: []string{"id"},
+			},
+			err: true,
+		},
+	}
+
+	r := GetInstance().Set("../testdata")
+	for tn, tc := range testCases {
+		err := r.AddForeignKey(tc.fk)
+		if err != nil && !tc.err {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if err == nil && tc.err {
+			t.Fatalf("[%d] Expected Error", tn)
+		}
+	}
+
+	if len(r.ForeignKeys("fk", "child")) != 1 {
+		t.Fatalf("child foreign keys mismatch")
+	}
+	if len(r.ReferencingKeys("fk", "parent")) != 2 {
+		t.Fatalf("parent referencing keys mismatch")
+	}
+	if deps := r.DependentTables("fk", "parent"); !reflect.DeepEqual(deps, []string{"child", "grandchild", "orphan"}) {
+		t.Fatalf("dependent tables mismatch %#+v", deps)
+	}
+	if err := r.CheckDeferredForeignKeys("fk"); err == nil {
+		t.Fatalf("deferred foreign key violation is not detected")
+	}
+
+	if err := r.DropForeignKey("fk", "orphan", "fk_orphan_deferred"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.CheckDeferredForeignKeys("fk"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if deps := r.DependentTables("fk", "parent"); !reflect.DeepEqual(deps, []string{"child", "grandchild"}) {
+		t.Fatalf("dependent tables mismatch %#+v", deps)
+	}
+}
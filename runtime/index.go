@@ -0,0 +1,413 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/yakawa/simpleDB/runtime/storage"
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/index"
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+const indexExt = ".idx"
+
+func indexPath(tablePath string, tbl string, name string) string {
+	return filepath.Join(filepath.Dir(tablePath), tbl+"."+name+indexExt)
+}
+
+func (r *Runtime) CreateIndex(db string, tbl string, name string, cols []string, unique bool) error {
+	return r.session.CreateIndex(db, tbl, name, cols, unique)
+}
+
+func (r *Runtime) DropIndex(db string, name string) error {
+	return r.session.DropIndex(db, name)
+}
+
+func (s *Session) CreateIndex(db string, tbl string, name string, cols []string, unique bool) error {
+	r := s.r
+	if _, exists := r.index(db, name); exists {
+		return errors.New(fmt.Sprintf("Index (%s) already exists", name))
+	}
+	if db == "_" {
+		if _, exists := r.storage.Table(tbl); exists {
+			release, err := s.write(db, tbl)
+			if err != nil {
+				return err
+			}
+			defer release()
+			return r.storage.CreateIndex(tbl, name, cols, unique)
+		}
+	}
+	fp, exists := r.tablePath(db, tbl)
+	if !exists {
+		return errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
+	}
+
+	release, err := s.acquire(tableLock(db, tbl), Exclusive)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	idx, err := buildIndex(fp, tbl, name, cols, unique)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schema++
+	if _, exists := r.indexes[tableKey(db, name)]; exists {
+		return errors.New(fmt.Sprintf("Index (%s) already exists", name))
+	}
+	if err := idx.Save(indexPath(fp, tbl, name)); err != nil {
+		return err
+	}
+	r.indexes[tableKey(db, name)] = idx
+	s.addUndo(func() error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.schema++
+		delete(r.indexes, tableKey(db, name))
+		return os.Remove(indexPath(fp, tbl, name))
+	})
+	return nil
+}
+
+func (s *Session) DropIndex(db string, name string) error {
+	r := s.r
+	idx, exists := r.index(db, name)
+	if !exists {
+		if db == "_" {
+			if tbl, exists := r.nativeIndexTable(name); exists {
+				release, err := s.write(db, tbl)
+				if err != nil {
+					return err
+				}
+				defer release()
+				return r.storage.DropIndex(tbl, name)
+			}
+		}
+		return errors.New(fmt.Sprintf("Index (%s) Not Found", name))
+	}
+
+	release, err := s.acquire(tableLock(db, idx.Table), Exclusive)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schema++
+	if r.indexes[tableKey(db, name)] != idx {
+		return errors.New(fmt.Sprintf("Index (%s) Not Found", name))
+	}
+	delete(r.indexes, tableKey(db, name))
+
+	fp, exists := r.localTables[db][idx.Table]
+	if !exists {
+		return nil
+	}
+	err = os.Remove(indexPath(fp, idx.Table, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.addUndo(func() error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.schema++
+		r.indexes[tableKey(db, name)] = idx
+		return idx.Save(indexPath(fp, idx.Table, name))
+	})
+	return nil
+}
+
+func (r *Runtime) index(db string, name string) (*index.Index, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	idx, exists := r.indexes[tableKey(db, name)]
+	return idx, exists
+}
+
+func (r *Runtime) nativeIndexTable(name string) (string, bool) {
+	for _, tbl := range r.storage.ReadTables() {
+		ti, exists := r.storage.Table(tbl)
+		if !exists {
+			continue
+		}
+		for _, idx := range ti.Indexes {
+			if idx.Name == name {
+				return tbl, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (r *Runtime) Indexes(db string, tbl string) []*index.Index {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	idxs := []*index.Index{}
+	for k, idx := range r.indexes {
+		if idx.Table == tbl && k == tableKey(db, idx.Name) {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Slice(idxs, func(i, j int) bool {
+		return idxs[i].Name < idxs[j].Name
+	})
+	return idxs
+}
+
+func (r *Runtime) FindIndex(db string, tbl string, cols []string) *index.Index {
+	for _, idx := range r.Indexes(db, tbl) {
+		if len(idx.Columns) < len(cols) {
+			continue
+		}
+		match := true
+		for n, c := range cols {
+			if idx.Columns[n] != c {
+				match = false
+				break
+			}
+		}
+		if match {
+			return idx
+		}
+	}
+	return nil
+}
+
+func (r *Runtime) loadIndex(db string, fn string) error {
+	idx, err := index.Load(fn)
+	if err != nil {
+		return err
+	}
+	fp, exists := r.localTables[db][idx.Table]
+	if !exists {
+		return errors.New(fmt.Sprintf("Table (%s) Not Found", idx.Table))
+	}
+
+	st, err := os.Stat(fp)
+	if err != nil {
+		return err
+	}
+	if st.Size() != idx.SourceSize || st.ModTime().UnixNano() != idx.SourceModTime || idx.Outdated() {
+		idx, err = buildIndex(fp, idx.Table, idx.Name, idx.Columns, idx.Unique)
+		if err != nil {
+			return err
+		}
+		if err := idx.Save(fn); err != nil {
+			return err
+		}
+	}
+	r.indexes[tableKey(db, idx.Name)] = idx
+	return nil
+}
+
+// buildIndex builds an index of a CSV file in one pass over the file,
+// reading only the columns of the index.
+func buildIndex(fp string, tbl string, name string, cols []string, unique bool) (*index.Index, error) {
+	st, err := os.Stat(fp)
+	if err != nil {
+		return nil, err
+	}
+	header, err := csv.ReadHeader(fp)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.New(name, tbl, header, cols, unique)
+	if err != nil {
+		return nil, err
+	}
+	err = csv.ScanOffsets(context.Background(), fp, cols, func(offset int64, row []table.ColumnValue) error {
+		key := make([]table.Value, len(cols))
+		for n, c := range cols {
+			for _, cv := range row {
+				if cv.Name == c {
+					key[n] = cv.Value
+				}
+			}
+		}
+		idx.Add(key, offset)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.Finish(); err != nil {
+		return nil, err
+	}
+	idx.SourceSize = st.Size()
+	idx.SourceModTime = st.ModTime().UnixNano()
+	return idx, nil
+}
+
+// KeyOrder is the order ScanIndex passes the rows in.
+type KeyOrder int
+
+const (
+	// TableOrder passes the rows in the order of the table.
+	TableOrder KeyOrder = iota
+	// Ascending passes the rows by the first column of the index, and the
+	// rows with the same value in the order of the table, NULL first.
+	Ascending
+	// Descending is Ascending with the values in the reverse order, NULL
+	// last.
+	Descending
+)
+
+// KeyRange is the values of the first column of an index ScanIndex reads
+// the rows of, Low and High included. A nil bound leaves the range open on
+// its side. The rows whose value is NULL are only in the range open on
+// both sides.
+type KeyRange struct {
+	Low   *int
+	High  *int
+	Order KeyOrder
+}
+
+// LookupIndex passes to fn the rows of the table of an index whose first
+// column equals key, as ScanIndex does. A NULL key matches no row.
+func (s *Session) LookupIndex(db string, name string, key table.Value, cols []string, fn func([]table.ColumnValue) error) error {
+	if _, exists := s.r.index(db, name); !exists && !s.r.storageIndex(db, name) {
+		return errors.New(fmt.Sprintf("Index (%s) Not Found", name))
+	}
+	if key.Type == table.Null {
+		return nil
+	}
+	return s.ScanIndex(db, name, KeyRange{Low: &key.Integral, High: &key.Integral}, cols, fn)
+}
+
+// ScanIndex passes to fn the rows of the table of an index in the range
+// rg, reading only those rows, as ScanColumnsFromLocalTable does. When the
+// CSV file of an index changed since it was built, every row is passed in
+// table order, so fn has to check the rows it gets, unless rg is ordered:
+// the rows are then found in an index built again for the scan. The rows
+// of the storage are read from the snapshot of the session.
+func (s *Session) ScanIndex(db string, name string, rg KeyRange, cols []string, fn func([]table.ColumnValue) error) error {
+	n, err := s.scanIndex(db, name, rg, cols, fn)
+	s.bytesRead += n
+	return err
+}
+
+// scanIndex is ScanIndex returning the number of bytes read, as
+// readColumnsFromLocalTable does.
+func (s *Session) scanIndex(db string, name string, rg KeyRange, cols []string, fn func([]table.ColumnValue) error) (int64, error) {
+	r := s.r
+	idx, exists := r.index(db, name)
+	if !exists {
+		if tbl, exists := r.nativeIndexTable(name); exists && db == "_" {
+			return s.scanStorageIndex(tbl, name, rg, cols, fn)
+		}
+		return 0, errors.New(fmt.Sprintf("Index (%s) Not Found", name))
+	}
+	fp, exists := r.tablePath(db, idx.Table)
+	if !exists {
+		return 0, errors.New(fmt.Sprintf("Table (%s) Not Found", idx.Table))
+	}
+	release, err := s.read(db, idx.Table)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	st, err := os.Stat(fp)
+	if err != nil {
+		return 0, err
+	}
+	var read int64
+	if idx.Outdated() || st.Size() != idx.SourceSize || st.ModTime().UnixNano() != idx.SourceModTime {
+		if rg.Order == TableOrder {
+			return s.readColumnsFromLocalTable(db, idx.Table, cols, fn)
+		}
+		if idx, err = buildIndex(fp, idx.Table, idx.Name, idx.Columns, false); err != nil {
+			return 0, err
+		}
+		read = idx.SourceSize
+	}
+
+	var lo, hi []int
+	if rg.Low != nil {
+		lo = []int{*rg.Low}
+	}
+	if rg.High != nil {
+		hi = []int{*rg.High}
+	}
+	entries := idx.Between(lo, hi)
+	if rg.Order != TableOrder {
+		entries = index.Order(entries, rg.Order == Descending)
+	}
+	offsets := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		offsets = append(offsets, e.Offset)
+	}
+	if rg.Order == TableOrder {
+		sort.Slice(offsets, func(i, j int) bool {
+			return offsets[i] < offsets[j]
+		})
+	}
+	n, err := csv.ReadAt(fp, cols, offsets, fn)
+	return read + n, err
+}
+
+// scanStorageIndex passes the rows of a table of the storage in the range
+// rg of its index name to fn, as readColumnsFromLocalTable does. The rows
+// of the range are in the order of the index unless rg asks for the
+// descending one.
+func (s *Session) scanStorageIndex(tbl string, name string, rg KeyRange, cols []string, fn func([]table.ColumnValue) error) (int64, error) {
+	r, ctx := s.r, s.Context()
+	ti, exists := r.storage.Table(tbl)
+	if !exists {
+		return 0, errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
+	}
+	var low, high []table.Value
+	if rg.Low != nil {
+		low = []table.Value{{Type: table.Integer, Integral: *rg.Low}}
+	}
+	if rg.High != nil {
+		high = []table.Value{{Type: table.Integer, Integral: *rg.High}}
+	}
+	misses := r.storage.CacheStats().Misses
+	read := func() int64 {
+		return (r.storage.CacheStats().Misses - misses) * pager.PageSize
+	}
+	scan := func(tx *storage.Tx) error {
+		n := 0
+		return tx.ScanIndex(tbl, name, low, high, rg.Order == Descending, func(rowid int64, row []table.Value) (bool, error) {
+			if n++; n%checkInterval == 0 && ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			if err := fn(columnValues(ti.Columns, row, cols)); err != nil {
+				return false, err
+			}
+			return true, nil
+		})
+	}
+	if s.tx != nil && s.tx.snapshot != nil {
+		err := scan(s.tx.snapshot)
+		return read(), err
+	}
+	tx, err := r.storage.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	err = scan(tx)
+	return read(), err
+}
+
+// storageIndex tells whether name is an index of a table of the storage.
+func (r *Runtime) storageIndex(db string, name string) bool {
+	if db != "_" {
+		return false
+	}
+	_, exists := r.nativeIndexTable(name)
+	return exists
+}
@@ -0,0 +1,197 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func TestCreateIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	r := GetInstance().Set(dir)
+	if err := r.CreateIndex("_", "tbl1", "idx_colB", []string{"colB"}, true); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.CreateIndex("_", "tbl1", "idx_colB", []string{"colB"}, true); err == nil {
+		t.Fatalf("Duplicated index is created")
+	}
+	if err := r.CreateIndex("_", "tbl2", "idx_tbl2", []string{"colB"}, true); err == nil {
+		t.Fatalf("Index on unknown table is created")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tbl1.idx_colB.idx")); err != nil {
+		t.Fatalf("Index file is not created: %s", err)
+	}
+
+	r = GetInstance().Set(dir)
+	if _, exists := r.localTables["_"]["tbl1"]; !exists || len(r.localTables["_"]) != 1 {
+		t.Fatalf("Index file is registered as table %#+v", r.localTables)
+	}
+	idx := r.FindIndex("_", "tbl1", []string{"colB"})
+	if idx == nil {
+		t.Fatalf("Index is not loaded")
+	}
+	if rows := idx.Range([]int{4}, true, []int{8}, false); !reflect.DeepEqual(rows, []int{1, 2}) {
+		t.Fatalf("Range mismatch %v", rows)
+	}
+
+	if err := r.DropIndex("_", "idx_colB"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if r.FindIndex("_", "tbl1", []string{"colB"}) != nil {
+		t.Fatalf("Index is not dropped")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tbl1.idx_colB.idx")); !os.IsNotExist(err) {
+		t.Fatalf("Index file is not removed")
+	}
+}
+
+func TestLookupIndex(t *testing.T) {
+	dir := t.TempDir()
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	fn := filepath.Join(dir, "tbl1.csv")
+	if err := ioutil.WriteFile(fn, src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	r := New().Set(dir)
+	if err := r.CreateIndex("_", "tbl1", "idx_colB", []string{"colB"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	lookup := func(key table.Value) ([][]table.ColumnValue, int64) {
+		s := r.NewSession()
+		rows := [][]table.ColumnValue{}
+		err := s.LookupIndex("_", "idx_colB", key, nil, func(row []table.ColumnValue) error {
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		return rows, s.BytesRead()
+	}
+
+	rows, read := lookup(table.Value{Type: table.Integer, Integral: 6})
+	if len(rows) != 1 || rows[0][0].Value.Integral != 5 || rows[0][1].Value.Integral != 6 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+	if read >= int64(len(src)) {
+		t.Fatalf("%d bytes read of %d", read, len(src))
+	}
+	if rows, _ := lookup(table.Value{Type: table.Null}); len(rows) != 0 {
+		t.Fatalf("NULL matches %v", rows)
+	}
+
+	// Once the table changed, every row is read.
+	if err := ioutil.WriteFile(fn, append(src, []byte("11,6\n")...), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if rows, _ := lookup(table.Value{Type: table.Integer, Integral: 6}); len(rows) != 6 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	dir := t.TempDir()
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	fn := filepath.Join(dir, "tbl1.csv")
+	if err := ioutil.WriteFile(fn, src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	r := New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	s := r.Session()
+	if err := r.CreateIndex("_", "tbl1", "idx_colB", []string{"colB"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateTable("_", "tbl", []string{"colA", "colB"}, nil); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for n, v := range []int{5, 1, 3, 1, 9, -1} {
+		b := table.Value{Type: table.Integer, Integral: v}
+		if v < 0 {
+			b = table.Value{Type: table.Null}
+		}
+		if _, err := s.Insert("_", "tbl", []table.Value{{Type: table.Integer, Integral: n + 1}, b}); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	if err := r.CreateIndex("_", "tbl", "idx_tbl", []string{"colB"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if name, _ := r.IndexOn("_", "tbl", "colB"); name != "idx_tbl" {
+		t.Fatalf("IndexOn mismatch %s", name)
+	}
+
+	scan := func(name string, rg KeyRange) []int {
+		rows := []int{}
+		err := r.NewSession().ScanIndex("_", name, rg, []string{"colA"}, func(row []table.ColumnValue) error {
+			rows = append(rows, row[0].Value.Integral)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		return rows
+	}
+	bound := func(n int) *int {
+		return &n
+	}
+	testCases := []struct {
+		name     string
+		rg       KeyRange
+		expected []int
+	}{
+		{"idx_colB", KeyRange{Low: bound(4), High: bound(8)}, []int{3, 5, 7}},
+		{"idx_colB", KeyRange{High: bound(6), Order: Descending}, []int{5, 3, 1}},
+		{"idx_colB", KeyRange{Low: bound(7), Order: Ascending}, []int{7, 9}},
+		{"idx_colB", KeyRange{Low: bound(11)}, []int{}},
+		{"idx_tbl", KeyRange{Order: Ascending}, []int{6, 2, 4, 3, 1, 5}},
+		{"idx_tbl", KeyRange{Order: Descending}, []int{5, 1, 3, 2, 4, 6}},
+		{"idx_tbl", KeyRange{Low: bound(1), High: bound(3)}, []int{2, 4, 3}},
+		{"idx_tbl", KeyRange{Low: bound(2), Order: Descending}, []int{5, 1, 3}},
+	}
+	for tn, tc := range testCases {
+		if actual := scan(tc.name, tc.rg); !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("[%d] rows mismatch %v", tn, actual)
+		}
+	}
+
+	// Once the table changed, an ordered scan finds its rows in an index
+	// built again.
+	if err := ioutil.WriteFile(fn, append(src, []byte("11,6\n")...), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if actual := scan("idx_colB", KeyRange{Order: Descending}); !reflect.DeepEqual(actual, []int{9, 7, 5, 11, 3, 1}) {
+		t.Fatalf("rows mismatch %v", actual)
+	}
+	if actual := scan("idx_colB", KeyRange{Low: bound(6), High: bound(6), Order: Ascending}); !reflect.DeepEqual(actual, []int{5, 11}) {
+		t.Fatalf("rows mismatch %v", actual)
+	}
+}
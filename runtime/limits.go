@@ -0,0 +1,109 @@
+package runtime
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Limits bound the resources a statement may use. A limit of 0 does not
+// bound anything. Statements keep their intermediate rows in memory and
+// write no temporary files, so the disk space they use is not limited.
+type Limits struct {
+	// Instructions is the number of VM instructions a statement may
+	// execute. An instruction of the vectorized engine counts once for
+	// each row of the batch it is applied to.
+	Instructions int64
+	// Memory is the number of bytes a statement may hold in the VM stack,
+	// in column vectors, in the rows of the tables it reads, in the values
+	// ANALYZE collects and in its result. It is not given back until the
+	// statement ends.
+	Memory int64
+	// Rows is the number of rows a statement may return.
+	Rows int64
+}
+
+// lower returns the tightest of the limits of l and o.
+func (l Limits) lower(o Limits) Limits {
+	min := func(a int64, b int64) int64 {
+		if a == 0 || (b != 0 && b < a) {
+			return b
+		}
+		return a
+	}
+	return Limits{
+		Instructions: min(l.Instructions, o.Instructions),
+		Memory:       min(l.Memory, o.Memory),
+		Rows:         min(l.Rows, o.Rows),
+	}
+}
+
+// ResourceLimitError is returned when a statement uses more of a resource
+// than its limit.
+type ResourceLimitError struct {
+	Resource string
+	Limit    int64
+}
+
+func (e *ResourceLimitError) Error() string {
+	return fmt.Sprintf("Resource limit exceeded: %s (%d)", e.Resource, e.Limit)
+}
+
+// Limits returns the limits of every session of the runtime.
+func (r *Runtime) Limits() Limits {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.limits
+}
+
+// SetLimits sets the limits of every session of the runtime. Sessions can
+// lower them with SET max_instructions, max_memory and max_rows, but not
+// raise them.
+func (r *Runtime) SetLimits(l Limits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = l
+}
+
+// Limits returns the limits of the statements of the session.
+func (s *Session) Limits() Limits {
+	return s.r.Limits().lower(s.limits)
+}
+
+// Usage counts the resources used by a statement. It may be used by
+// several goroutines at once.
+type Usage struct {
+	limits       Limits
+	instructions int64
+	memory       int64
+	rows         int64
+}
+
+// NewUsage returns the Usage of a statement about to be run in the session.
+func (s *Session) NewUsage() *Usage {
+	return &Usage{limits: s.Limits()}
+}
+
+func (u *Usage) add(counter *int64, n int64, limit int64, resource string) error {
+	if limit == 0 {
+		return nil
+	}
+	if atomic.AddInt64(counter, n) > limit {
+		return &ResourceLimitError{Resource: resource, Limit: limit}
+	}
+	return nil
+}
+
+// Instructions counts n instructions executed.
+func (u *Usage) Instructions(n int64) error {
+	return u.add(&u.instructions, n, u.limits.Instructions, "max_instructions")
+}
+
+// Memory counts n bytes allocated.
+func (u *Usage) Memory(n int64) error {
+	return u.add(&u.memory, n, u.limits.Memory, "max_memory")
+}
+
+// Rows counts n result rows.
+func (u *Usage) Rows(n int64) error {
+	return u.add(&u.rows, n, u.limits.Rows, "max_rows")
+}
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	r := New()
+	s := r.NewSession()
+	if s.Limits() != (Limits{}) {
+		t.Fatalf("Limits are set by default %+v", s.Limits())
+	}
+
+	r.SetLimits(Limits{Instructions: 1000, Rows: 10})
+	if err := s.Set("max_rows", 100); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Set("max_memory", 4096); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Set("max_instructions", 10); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Set("max_rows", -1); err == nil {
+		t.Fatalf("negative max_rows is accepted")
+	}
+	// A session can lower the limits of the runtime but not raise them.
+	expected := Limits{Instructions: 10, Memory: 4096, Rows: 10}
+	if s.Limits() != expected {
+		t.Fatalf("Limits mismatch %+v", s.Limits())
+	}
+	if r.NewSession().Limits() != r.Limits() {
+		t.Fatalf("Limits of a session are shared %+v", r.NewSession().Limits())
+	}
+
+	u := s.NewUsage()
+	if err := u.Rows(10); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	err := u.Rows(1)
+	if le, ok := err.(*ResourceLimitError); !ok || le.Resource != "max_rows" || le.Limit != 10 {
+		t.Fatalf("max_rows is not exceeded %v", err)
+	}
+	if err := u.Memory(4096); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := u.Memory(1); err == nil || err.Error() != "Resource limit exceeded: max_memory (4096)" {
+		t.Fatalf("max_memory is not exceeded %v", err)
+	}
+	if err := r.NewSession().NewUsage().Memory(1 << 40); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+}
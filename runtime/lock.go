@@ -0,0 +1,177 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type LockMode int
+
+const (
+	_ LockMode = iota
+	Shared
+	Exclusive
+)
+
+func (m LockMode) String() string {
+	switch m {
+	case Shared:
+		return "SHARED"
+	case Exclusive:
+		return "EXCLUSIVE"
+	default:
+		return "Unknown"
+	}
+}
+
+// databaseLock is the resource every writer holds exclusively while it has
+// uncommitted changes in storage.
+const databaseLock = "database"
+
+func tableLock(db string, tbl string) string {
+	return "table:" + tableKey(db, tbl)
+}
+
+type LockManager struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	holders map[string]map[uint64]LockMode
+	waiting map[uint64]string
+	modes   map[uint64]LockMode
+}
+
+func NewLockManager() *LockManager {
+	m := &LockManager{
+		holders: make(map[string]map[uint64]LockMode),
+		waiting: make(map[uint64]string),
+		modes:   make(map[uint64]LockMode),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *LockManager) compatible(owner uint64, resource string, mode LockMode) bool {
+	for o, held := range m.holders[resource] {
+		if o == owner {
+			continue
+		}
+		if mode == Exclusive || held == Exclusive {
+			return false
+		}
+	}
+	return true
+}
+
+// blockers returns the owners that prevent owner from taking the lock it
+// waits for.
+func (m *LockManager) blockers(owner uint64) []uint64 {
+	resource, exists := m.waiting[owner]
+	if !exists {
+		return []uint64{}
+	}
+	mode := m.modes[owner]
+	owners := []uint64{}
+	for o, held := range m.holders[resource] {
+		if o != owner && (mode == Exclusive || held == Exclusive) {
+			owners = append(owners, o)
+		}
+	}
+	return owners
+}
+
+func (m *LockManager) deadlocked(owner uint64) bool {
+	visited := map[uint64]bool{}
+	stack := m.blockers(owner)
+	for len(stack) != 0 {
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if o == owner {
+			return true
+		}
+		if visited[o] {
+			continue
+		}
+		visited[o] = true
+		stack = append(stack, m.blockers(o)...)
+	}
+	return false
+}
+
+// Lock acquires resource in mode for owner, waiting while other owners hold
+// a conflicting lock. A shared lock held by owner is upgraded in place. If
+// waiting would close a cycle in the wait-for graph the request fails and
+// owner should roll back.
+func (m *LockManager) Lock(owner uint64, resource string, mode LockMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if held, exists := m.holders[resource][owner]; exists && held >= mode {
+		return nil
+	}
+	for !m.compatible(owner, resource, mode) {
+		m.waiting[owner] = resource
+		m.modes[owner] = mode
+		if m.deadlocked(owner) {
+			delete(m.waiting, owner)
+			delete(m.modes, owner)
+			return errors.New(fmt.Sprintf("Deadlock detected while waiting for %s lock on %s", mode, resource))
+		}
+		m.cond.Wait()
+	}
+	delete(m.waiting, owner)
+	delete(m.modes, owner)
+
+	if _, exists := m.holders[resource]; !exists {
+		m.holders[resource] = make(map[uint64]LockMode)
+	}
+	m.holders[resource][owner] = mode
+	return nil
+}
+
+func (m *LockManager) Unlock(owner uint64, resource string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unlock(owner, resource)
+	m.cond.Broadcast()
+}
+
+func (m *LockManager) unlock(owner uint64, resource string) {
+	delete(m.holders[resource], owner)
+	if len(m.holders[resource]) == 0 {
+		delete(m.holders, resource)
+	}
+}
+
+func (m *LockManager) UnlockAll(owner uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for resource := range m.holders {
+		m.unlock(owner, resource)
+	}
+	m.cond.Broadcast()
+}
+
+func (m *LockManager) Held(owner uint64) map[string]LockMode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held := map[string]LockMode{}
+	for resource, owners := range m.holders {
+		if mode, exists := owners[owner]; exists {
+			held[resource] = mode
+		}
+	}
+	return held
+}
+
+func (m *LockManager) Resources() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	resources := []string{}
+	for resource := range m.holders {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
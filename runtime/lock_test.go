@@ -0,0 +1,109 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockCompatibility(t *testing.T) {
+	testCases := []struct {
+		held     LockMode
+		request  LockMode
+		expected bool
+	}{
+		{Shared, Shared, true},
+		{Shared, Exclusive, false},
+		{Exclusive, Shared, false},
+		{Exclusive, Exclusive, false},
+	}
+
+	for tn, tc := range testCases {
+		m := NewLockManager()
+		if err := m.Lock(1, "tbl", tc.held); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- m.Lock(2, "tbl", tc.request)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+			if !tc.expected {
+				t.Fatalf("[%d] %s lock granted while %s lock is held", tn, tc.request, tc.held)
+			}
+		case <-time.After(50 * time.Millisecond):
+			if tc.expected {
+				t.Fatalf("[%d] %s lock blocked by %s lock", tn, tc.request, tc.held)
+			}
+			m.UnlockAll(1)
+			if err := <-done; err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+		}
+		if m.Held(2)["tbl"] != tc.request {
+			t.Fatalf("[%d] lock mode mismatch %s", tn, m.Held(2)["tbl"])
+		}
+	}
+}
+
+func TestLockUpgrade(t *testing.T) {
+	m := NewLockManager()
+	if err := m.Lock(1, "tbl", Shared); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := m.Lock(1, "tbl", Exclusive); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := m.Lock(1, "tbl", Shared); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if m.Held(1)["tbl"] != Exclusive {
+		t.Fatalf("lock is downgraded to %s", m.Held(1)["tbl"])
+	}
+	m.Unlock(1, "tbl")
+	if len(m.Resources()) != 0 {
+		t.Fatalf("lock is not released %v", m.Resources())
+	}
+}
+
+func TestDeadlock(t *testing.T) {
+	m := NewLockManager()
+	if err := m.Lock(1, "tblA", Exclusive); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := m.Lock(2, "tblB", Exclusive); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Lock(1, "tblB", Exclusive)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := m.Lock(2, "tblA", Exclusive); err == nil {
+		t.Fatalf("deadlock is not detected")
+	}
+	m.UnlockAll(2)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Two shared holders upgrading at the same time wait for each other.
+	m = NewLockManager()
+	m.Lock(1, "tbl", Shared)
+	m.Lock(2, "tbl", Shared)
+	go func() {
+		done <- m.Lock(1, "tbl", Exclusive)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := m.Lock(2, "tbl", Exclusive); err == nil {
+		t.Fatalf("upgrade deadlock is not detected")
+	}
+	m.UnlockAll(2)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+}
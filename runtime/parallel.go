@@ -0,0 +1,254 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"sync"
+
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// DefaultParallelism is the number of goroutines a table is scanned with
+// unless PRAGMA parallelism says otherwise.
+var DefaultParallelism = goruntime.NumCPU()
+
+// Parallelism returns the number of goroutines a table is scanned with.
+func (r *Runtime) Parallelism() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.parallelism
+}
+
+func (r *Runtime) SetParallelism(n int) error {
+	if n < 1 {
+		return errors.New(fmt.Sprintf("Invalid parallelism (%d)", n))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.parallelism = n
+	return nil
+}
+
+// ReadColumnsFromLocalTableParallel is ReadColumnsFromLocalTable splitting
+// the table into parts that are read by as many goroutines at once. fn is
+// called with the index of the part of the row; the rows of a part are
+// given in table order by a single goroutine, but fn is called for
+// different parts concurrently. A part found to start inside a record once
+// the part before it is read is read again: reset is called with its index
+// before its rows are given anew. Tables of the storage are read as part 0.
+func (s *Session) ReadColumnsFromLocalTableParallel(db string, tbl string, cols []string, parts int, fn func(int, []table.ColumnValue), reset func(int)) error {
+	return s.scanColumnsParallel(db, tbl, cols, parts, func(pn int, row []table.ColumnValue) error {
+		fn(pn, row)
+		return nil
+	}, reset)
+}
+
+// scanColumnsParallel is ReadColumnsFromLocalTableParallel stopping with
+// the first error fn returns.
+func (s *Session) scanColumnsParallel(db string, tbl string, cols []string, parts int, fn func(int, []table.ColumnValue) error, reset func(int)) error {
+	release, err := s.read(db, tbl)
+	if err != nil {
+		return err
+	}
+	defer release()
+	n, err := s.readColumnsParallel(db, tbl, cols, parts, fn, reset)
+	s.bytesRead += n
+	return err
+}
+
+// readColumnsParallel reads the parts of a table at once, each from the
+// start csv.Split found for it. Only once a part is read is it known where
+// the next one really starts, so the parts are checked in order after they
+// were read. The error of a part that started inside a record does not
+// count, as its rows are read again; the errors fn returns do.
+func (s *Session) readColumnsParallel(db string, tbl string, cols []string, parts int, fn func(int, []table.ColumnValue) error, reset func(int)) (int64, error) {
+	r := s.r
+	fp, exists := r.tablePath(db, tbl)
+	if r.stored(db, tbl) || !exists || parts < 2 {
+		return s.readColumnsFromLocalTable(db, tbl, cols, func(row []table.ColumnValue) error {
+			return fn(0, row)
+		})
+	}
+
+	ranges, err := csv.Split(fp, parts)
+	if err != nil {
+		return 0, err
+	}
+	// The parts still being read stop once fn or the first part failed.
+	ctx, cancel := context.WithCancel(s.Context())
+	defer cancel()
+	var mu sync.Mutex
+	var failed error
+	nexts := make([]int64, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for pn, rg := range ranges {
+		wg.Add(1)
+		go func(pn int, rg csv.Range) {
+			defer wg.Done()
+			nexts[pn], errs[pn] = csv.ScanColumnsRange(ctx, fp, cols, rg, func(row []table.ColumnValue) error {
+				if err := fn(pn, row); err != nil {
+					mu.Lock()
+					if failed == nil {
+						failed = err
+					}
+					mu.Unlock()
+					return err
+				}
+				return nil
+			})
+			if errs[pn] != nil && pn == 0 {
+				cancel()
+			}
+		}(pn, rg)
+	}
+	wg.Wait()
+	if failed != nil {
+		return 0, failed
+	}
+	if err := s.Context().Err(); err != nil {
+		return 0, err
+	}
+
+	next := ranges[0].Start
+	for pn, rg := range ranges {
+		if next == rg.Start {
+			if errs[pn] != nil {
+				return 0, errs[pn]
+			}
+			next = nexts[pn]
+			continue
+		}
+		reset(pn)
+		if rg.Start = next; rg.Start >= rg.End {
+			continue
+		}
+		next, err = csv.ScanColumnsRange(s.Context(), fp, cols, rg, func(row []table.ColumnValue) error {
+			return fn(pn, row)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	var size int64
+	if fi, err := os.Stat(fp); err == nil {
+		size = fi.Size()
+	}
+	return size, nil
+}
+
+// minPartSize is the size of the smallest part readColumnsOrdered reads on
+// its own, below which the goroutines cost more than they save.
+var minPartSize int64 = 64 * 1024
+
+// The rows of a part that readColumnsOrdered has not passed on yet are held
+// in at most partAhead batches of partBatch rows.
+const (
+	partBatch = 256
+	partAhead = 16
+)
+
+// part is a part of a table read by readColumnsOrdered. rows is closed
+// once the part is read, and next and err are set.
+type part struct {
+	rows   chan [][]table.ColumnValue
+	next   int64
+	err    error
+	cancel func()
+}
+
+// readColumnsOrdered is readColumnsFromLocalTable reading parts of the
+// table at once, as readColumnsParallel does, but still passing the rows
+// to fn in table order from the calling goroutine. Each part is read ahead
+// of fn by a few batches only. A part found to start inside a record is
+// stopped and read from where the part before it ended.
+func (s *Session) readColumnsOrdered(db string, tbl string, cols []string, parts int, fn func([]table.ColumnValue) error) (int64, error) {
+	r := s.r
+	fp, exists := r.tablePath(db, tbl)
+	if exists && !r.stored(db, tbl) {
+		if fi, err := os.Stat(fp); err == nil && fi.Size()/minPartSize < int64(parts) {
+			parts = int(fi.Size() / minPartSize)
+		}
+	}
+	if r.stored(db, tbl) || !exists || parts < 2 {
+		return s.readColumnsFromLocalTable(db, tbl, cols, fn)
+	}
+
+	ranges, err := csv.Split(fp, parts)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithCancel(s.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	ps := make([]*part, len(ranges))
+	for pn, rg := range ranges {
+		pctx, pcancel := context.WithCancel(ctx)
+		p := &part{rows: make(chan [][]table.ColumnValue, partAhead), cancel: pcancel}
+		ps[pn] = p
+		wg.Add(1)
+		go func(rg csv.Range) {
+			defer wg.Done()
+			defer close(p.rows)
+			batch := make([][]table.ColumnValue, 0, partBatch)
+			send := func() error {
+				select {
+				case p.rows <- batch:
+					batch = make([][]table.ColumnValue, 0, partBatch)
+					return nil
+				case <-pctx.Done():
+					return pctx.Err()
+				}
+			}
+			p.next, p.err = csv.ScanColumnsRange(pctx, fp, cols, rg, func(row []table.ColumnValue) error {
+				if batch = append(batch, row); len(batch) == partBatch {
+					return send()
+				}
+				return nil
+			})
+			if p.err == nil && len(batch) != 0 {
+				p.err = send()
+			}
+		}(rg)
+	}
+
+	next := ranges[0].Start
+	for pn, rg := range ranges {
+		p := ps[pn]
+		if next == rg.Start {
+			for batch := range p.rows {
+				for _, row := range batch {
+					if err := fn(row); err != nil {
+						return 0, err
+					}
+				}
+			}
+			if p.err != nil {
+				return 0, p.err
+			}
+			next = p.next
+			continue
+		}
+		p.cancel()
+		for range p.rows {
+		}
+		if rg.Start = next; rg.Start >= rg.End {
+			continue
+		}
+		if next, err = csv.ScanColumnsRange(ctx, fp, cols, rg, fn); err != nil {
+			return 0, err
+		}
+	}
+	var size int64
+	if fi, err := os.Stat(fp); err == nil {
+		size = fi.Size()
+	}
+	return size, nil
+}
@@ -0,0 +1,179 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func TestReadColumnsParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sb strings.Builder
+	sb.WriteString("#colA, colB\n")
+	for n := 0; n < 1000; n++ {
+		if n%100 == 50 {
+			// A comment with a quoted newline, which must be read as one record.
+			sb.WriteString("#\"x\n1,2\n\\\"y\"\n")
+		}
+		if n%7 == 0 {
+			fmt.Fprintf(&sb, "%d,\n", n)
+			continue
+		}
+		fmt.Fprintf(&sb, "%d,%d\n", n, n%13)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	r := New().Set(dir)
+	s := r.NewSession()
+
+	expected := [][]table.ColumnValue{}
+	if err := s.ReadColumnsFromLocalTable("_", "tbl1", []string{"colB"}, func(row []table.ColumnValue) {
+		expected = append(expected, row)
+	}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(expected) != 1000 {
+		t.Fatalf("Number of rows mismatch %d", len(expected))
+	}
+	for _, parts := range []int{1, 2, 5, 16} {
+		rows := make([][][]table.ColumnValue, parts)
+		err := s.ReadColumnsFromLocalTableParallel("_", "tbl1", []string{"colB"}, parts, func(pn int, row []table.ColumnValue) {
+			rows[pn] = append(rows[pn], row)
+		}, func(pn int) {
+			rows[pn] = nil
+		})
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", parts, err)
+		}
+		actual := [][]table.ColumnValue{}
+		for _, part := range rows {
+			actual = append(actual, part...)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("[%d] rows mismatch", parts)
+		}
+	}
+	if err := s.ReadColumnsFromLocalTableParallel("_", "tbl2", nil, 4, func(int, []table.ColumnValue) {}, func(int) {}); err == nil {
+		t.Fatalf("Unknown table is read")
+	}
+
+	if v, err := r.Pragma("parallelism", nil); err != nil || v[0] != DefaultParallelism {
+		t.Fatalf("Default parallelism mismatch %v %v", v, err)
+	}
+	zero, one := 0, 1
+	if _, err := r.Pragma("parallelism", &zero); err == nil {
+		t.Fatalf("Parallelism 0 is accepted")
+	}
+	if _, err := r.Pragma("parallelism", &one); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.Analyze("_", "tbl1"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	sequential, _ := r.Statistics("_", "tbl1")
+	four := 4
+	if v, err := r.Pragma("parallelism", &four); err != nil || v[0] != 4 {
+		t.Fatalf("Parallelism mismatch %v %v", v, err)
+	}
+	if err := r.Analyze("_", "tbl1"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	parallel, _ := r.Statistics("_", "tbl1")
+	if !reflect.DeepEqual(parallel, sequential) {
+		t.Fatalf("Statistics mismatch %+v %+v", parallel, sequential)
+	}
+}
+
+func TestReadColumnsMisaligned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The middle of the table is a comment of quoted lines that look like
+	// rows, so the second part starts inside it.
+	var sb strings.Builder
+	sb.WriteString("#colA, colB\n")
+	for n := 0; n < 100; n++ {
+		fmt.Fprintf(&sb, "%d,%d\n", n, n%13)
+	}
+	sb.WriteString("#\"")
+	for n := 0; n < 20000; n++ {
+		fmt.Fprintf(&sb, "%d,%d\n", n, n)
+	}
+	sb.WriteString("\"\n")
+	for n := 100; n < 200; n++ {
+		fmt.Fprintf(&sb, "%d,%d\n", n, n%13)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	r := New().Set(dir)
+	s := r.NewSession()
+
+	expected := [][]table.ColumnValue{}
+	if err := s.ReadColumnsFromLocalTable("_", "tbl1", nil, func(row []table.ColumnValue) {
+		expected = append(expected, row)
+	}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(expected) != 200 {
+		t.Fatalf("Number of rows mismatch %d", len(expected))
+	}
+
+	rows := make([][][]table.ColumnValue, 2)
+	resets := 0
+	err = s.ReadColumnsFromLocalTableParallel("_", "tbl1", nil, 2, func(pn int, row []table.ColumnValue) {
+		rows[pn] = append(rows[pn], row)
+	}, func(pn int) {
+		resets++
+		rows[pn] = nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if resets != 1 {
+		t.Fatalf("Number of resets mismatch %d", resets)
+	}
+	if actual := append(rows[0], rows[1]...); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Parallel rows mismatch")
+	}
+
+	defer func(size int64) { minPartSize = size }(minPartSize)
+	minPartSize = 1
+	for _, parts := range []int{2, 3, 16} {
+		if err := r.SetParallelism(parts); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		actual := [][]table.ColumnValue{}
+		scan := s.OpenScan("_", "tbl1", nil)
+		for {
+			row, err := scan.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", parts, err)
+			}
+			actual = append(actual, row)
+		}
+		scan.Close()
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("[%d] Ordered rows mismatch", parts)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package runtime
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const DefaultPlanCacheSize = 128
+
+type PlanCacheStats struct {
+	Size          int
+	Entries       int
+	Hits          int64
+	Misses        int64
+	Invalidations int64
+	Evictions     int64
+}
+
+// PlanCache keeps compiled programs keyed by normalized SQL text. An entry
+// is only returned while the schema version it was compiled against is
+// current. Plans are opaque to the runtime.
+type PlanCache struct {
+	mu           sync.Mutex
+	size         int
+	parameterize bool
+	lru          *list.List
+	entries      map[string]*list.Element
+
+	hits          int64
+	misses        int64
+	invalidations int64
+	evictions     int64
+}
+
+type planEntry struct {
+	key    string
+	schema uint64
+	plan   interface{}
+}
+
+func newPlanCache(size int) *PlanCache {
+	return &PlanCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *PlanCache) Get(key string, schema uint64) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, exists := c.entries[key]
+	if !exists {
+		c.misses++
+		return nil, false
+	}
+	pe := e.Value.(*planEntry)
+	if pe.schema != schema {
+		c.remove(e)
+		c.invalidations++
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	c.hits++
+	return pe.plan, true
+}
+
+func (c *PlanCache) Put(key string, schema uint64, plan interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 {
+		return
+	}
+	if e, exists := c.entries[key]; exists {
+		e.Value = &planEntry{key: key, schema: schema, plan: plan}
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&planEntry{key: key, schema: schema, plan: plan})
+	c.evict()
+}
+
+func (c *PlanCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*planEntry).key)
+}
+
+func (c *PlanCache) evict() {
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *PlanCache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// SetSize changes the number of plans kept. 0 disables the cache.
+func (c *PlanCache) SetSize(size int) error {
+	if size < 0 {
+		return errors.New(fmt.Sprintf("Invalid plan cache size %d", size))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.evict()
+	return nil
+}
+
+// Parameterize reports whether literals are replaced by parameters before
+// the cache is looked up, so that statements differing only in literals
+// share a plan.
+func (c *PlanCache) Parameterize() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.parameterize
+}
+
+func (c *PlanCache) SetParameterize(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parameterize = on
+}
+
+func (c *PlanCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *PlanCache) Stats() PlanCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return PlanCacheStats{
+		Size:          c.size,
+		Entries:       c.lru.Len(),
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+		Evictions:     c.evictions,
+	}
+}
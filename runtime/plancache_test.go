@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestPlanCache(t *testing.T) {
+	c := newPlanCache(2)
+	c.Put("a", 1, "plan a")
+	c.Put("b", 1, "plan b")
+	if plan, ok := c.Get("a", 1); !ok || plan != "plan a" {
+		t.Fatalf("plan mismatch %v", plan)
+	}
+	c.Put("c", 1, "plan c")
+	if _, ok := c.Get("b", 1); ok {
+		t.Fatalf("least recently used plan is not evicted")
+	}
+	if _, ok := c.Get("a", 2); ok {
+		t.Fatalf("plan of an old schema is returned")
+	}
+	if _, ok := c.Get("a", 1); ok {
+		t.Fatalf("invalidated plan is kept")
+	}
+
+	st := c.Stats()
+	expected := PlanCacheStats{Size: 2, Entries: 1, Hits: 1, Misses: 3, Invalidations: 1, Evictions: 1}
+	if st != expected {
+		t.Fatalf("stats mismatch %+v", st)
+	}
+
+	if err := c.SetSize(-1); err == nil {
+		t.Fatalf("negative size is accepted")
+	}
+	c.SetSize(0)
+	c.Put("d", 1, "plan d")
+	if _, ok := c.Get("d", 1); ok || c.Stats().Entries != 0 {
+		t.Fatalf("disabled cache keeps plans")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	r := New().Set("../testdata")
+	v := r.SchemaVersion()
+	if err := r.AddForeignKey(ForeignKey{Name: "fk", DB: "fk", Table: "child", Columns: []string{"parentId"}, RefTable: "parent", RefColumns: []string{"id"}, Deferred: true}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if r.SchemaVersion() == v {
+		t.Fatalf("schema version is not changed by a foreign key")
+	}
+	v = r.SchemaVersion()
+	r.DropForeignKey("fk", "child", "fk")
+	if r.SchemaVersion() == v {
+		t.Fatalf("schema version is not changed by dropping a foreign key")
+	}
+}
@@ -0,0 +1,62 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+)
+
+func (r *Runtime) Pragma(name string, value *int) ([]int, error) {
+	s := r.storage
+	switch name {
+	case "cache_size":
+		if value != nil {
+			if err := s.SetCacheSize(*value); err != nil {
+				return []int{}, err
+			}
+		}
+		return []int{s.CacheSize()}, nil
+	case "cache_stats":
+		st := s.CacheStats()
+		return []int{int(st.Hits), int(st.Misses), int(st.Evictions), int(st.Writes), st.Pages, st.Size}, nil
+	case "wal_autocheckpoint":
+		if value != nil {
+			if err := s.SetAutoCheckpoint(*value); err != nil {
+				return []int{}, err
+			}
+		}
+		return []int{s.AutoCheckpoint()}, nil
+	case "wal_checkpoint":
+		if err := s.Checkpoint(); err != nil {
+			return []int{}, err
+		}
+		st := s.WALStats()
+		return []int{int(st.Commits), int(st.Syncs), int(st.Size)}, nil
+	case "plan_cache_size":
+		if value != nil {
+			if err := r.planCache.SetSize(*value); err != nil {
+				return []int{}, err
+			}
+		}
+		return []int{r.planCache.Size()}, nil
+	case "plan_cache_parameterize":
+		if value != nil {
+			r.planCache.SetParameterize(*value != 0)
+		}
+		if r.planCache.Parameterize() {
+			return []int{1}, nil
+		}
+		return []int{0}, nil
+	case "plan_cache_stats":
+		st := r.planCache.Stats()
+		return []int{int(st.Hits), int(st.Misses), int(st.Invalidations), int(st.Evictions), st.Entries, st.Size}, nil
+	case "parallelism":
+		if value != nil {
+			if err := r.SetParallelism(*value); err != nil {
+				return []int{}, err
+			}
+		}
+		return []int{r.Parallelism()}, nil
+	default:
+		return []int{}, errors.New(fmt.Sprintf("Unknown PRAGMA (%s)", name))
+	}
+}
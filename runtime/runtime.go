@@ -4,36 +4,145 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
+	"github.com/yakawa/simpleDB/runtime/stats"
+	"github.com/yakawa/simpleDB/runtime/storage"
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/index"
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
 	"github.com/yakawa/simpleDB/runtime/storage/table"
 )
 
+// DatabaseFile is the name of the native storage file kept in a table
+// directory. It and its WAL are not read as local tables.
+const DatabaseFile = "simpledb.db"
+
 var instance *Runtime
 var once sync.Once
 
 func GetInstance() *Runtime {
 	once.Do(func() {
-		instance = &Runtime{
-			localTables: make(map[string]map[string]string),
-		}
+		instance = newRuntime(storage.GetInstance())
 	})
 	return instance
 }
 
+// New returns a Runtime with its own catalog, storage and locks, independent
+// of the one returned by GetInstance.
+func New() *Runtime {
+	return newRuntime(storage.New())
+}
+
+func newRuntime(s *storage.StorageController) *Runtime {
+	r := &Runtime{
+		localTables:  make(map[string]map[string]string),
+		foreignKeys:  make(map[string][]ForeignKey),
+		referencedBy: make(map[string][]ForeignKey),
+		indexes:      make(map[string]*index.Index),
+		stats:        make(map[string]*stats.Table),
+		storage:      s,
+		locks:        NewLockManager(),
+		planCache:    newPlanCache(DefaultPlanCacheSize),
+		parallelism:  DefaultParallelism,
+	}
+	r.session = r.NewSession()
+	return r
+}
+
 type Runtime struct {
+	mu            sync.RWMutex
 	localTableDir string
 	localTables   map[string]map[string]string
+	foreignKeys   map[string][]ForeignKey
+	referencedBy  map[string][]ForeignKey
+	indexes       map[string]*index.Index
+	stats         map[string]*stats.Table
+
+	schema      uint64
+	planCache   *PlanCache
+	parallelism int
+	limits      Limits
+
+	storage  *storage.StorageController
+	locks    *LockManager
+	session  *Session
+	sessions uint64
 }
 
 func (r *Runtime) Set(t string) *Runtime {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schema++
+
 	r.localTableDir = t
+	r.localTables = make(map[string]map[string]string)
+	r.indexes = make(map[string]*index.Index)
+	r.stats = make(map[string]*stats.Table)
 	r.readLocalTables("_")
 	return r
 }
 
+func (r *Runtime) Open(fn string) error {
+	return r.storage.Open(fn)
+}
+
+func (r *Runtime) Close() error {
+	return r.storage.Close()
+}
+
+func (r *Runtime) Storage() *storage.StorageController {
+	return r.storage
+}
+
+func (r *Runtime) Locks() *LockManager {
+	return r.locks
+}
+
+func (r *Runtime) Session() *Session {
+	return r.session
+}
+
+func (r *Runtime) PlanCache() *PlanCache {
+	return r.planCache
+}
+
+// SchemaVersion changes whenever the catalog of local tables, indexes or
+// foreign keys, or the catalog of the storage, may have changed.
+func (r *Runtime) SchemaVersion() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.schema + r.storage.SchemaVersion()
+}
+
+// stored tells whether a table is in the storage rather than a CSV file.
+func (r *Runtime) stored(db string, tbl string) bool {
+	_, exists := r.storage.Table(tbl)
+	return exists && db == "_"
+}
+
+func (r *Runtime) tablePath(db string, tbl string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fp, exists := r.localTables[db][tbl]
+	return fp, exists
+}
+
+func (r *Runtime) LocalTables(db string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tbls := []string{}
+	for tbl := range r.localTables[db] {
+		tbls = append(tbls, tbl)
+	}
+	sort.Strings(tbls)
+	return tbls
+}
+
 func (r *Runtime) readLocalTables(db string) {
 	r.localTables[db] = make(map[string]string)
 	dbPath := ""
@@ -43,6 +152,7 @@ func (r *Runtime) readLocalTables(db string) {
 		dbPath = r.localTableDir
 	}
 	files, _ := ioutil.ReadDir(dbPath)
+	idxFiles := []string{}
 	for _, f := range files {
 		if f.IsDir() {
 			if db == "_" {
@@ -51,17 +161,103 @@ func (r *Runtime) readLocalTables(db string) {
 			continue
 		}
 		fn := f.Name()
-		fp := filepath.Join(r.localTableDir, fn)
+		if strings.HasPrefix(fn, DatabaseFile) {
+			continue
+		}
+		fp := filepath.Join(dbPath, fn)
+		if filepath.Ext(fn) == indexExt {
+			idxFiles = append(idxFiles, fp)
+			continue
+		}
 		w := strings.Split(fn, ".")
-		r.localTables["_"][w[0]] = fp
+		r.localTables[db][w[0]] = fp
+	}
+	for _, fp := range idxFiles {
+		r.loadIndex(db, fp)
 	}
 }
 
 func (r *Runtime) ReadLineFromLocalTable(db string, tbl string, fn func([]table.ColumnValue)) error {
-	fp, exists := r.localTables[db][tbl]
+	return r.session.ReadLineFromLocalTable(db, tbl, fn)
+}
+
+// checkInterval is the number of rows read between two checks of the
+// context of a read.
+const checkInterval = 1024
+
+// readColumnsFromLocalTable reads the rows of a table keeping only cols, in
+// table order. A nil cols keeps every column. It returns the number of
+// bytes read: the size of a CSV file, or the pages of the storage that were
+// not in the buffer pool. Tables of the storage are read from the snapshot
+// of the session. Reading stops with the error fn returns, or with the
+// error of the context of the session once it is done.
+func (s *Session) readColumnsFromLocalTable(db string, tbl string, cols []string, fn func([]table.ColumnValue) error) (int64, error) {
+	r, ctx := s.r, s.Context()
+	if ti, exists := r.storage.Table(tbl); exists && db == "_" {
+		misses := r.storage.CacheStats().Misses
+		n := 0
+		err := s.scanStorage(tbl, func(rowid int64, row []table.Value) (bool, error) {
+			if n++; n%checkInterval == 0 && ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			if err := fn(columnValues(ti.Columns, row, cols)); err != nil {
+				return false, err
+			}
+			return true, nil
+		})
+		return (r.storage.CacheStats().Misses - misses) * pager.PageSize, err
+	}
+
+	fp, exists := r.tablePath(db, tbl)
+	if !exists {
+		return 0, errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
+	}
+
+	if err := csv.ScanColumns(ctx, fp, cols, fn); err != nil {
+		return 0, err
+	}
+	var size int64
+	if fi, err := os.Stat(fp); err == nil {
+		size = fi.Size()
+	}
+	return size, nil
+}
+
+// columnValues returns the values of cols in a row of a table of the
+// storage whose columns are header, in the order of header. A nil cols
+// keeps every column.
+func columnValues(header []string, row []table.Value, cols []string) []table.ColumnValue {
+	values := []table.ColumnValue{}
+	for n, h := range header {
+		wanted := cols == nil
+		for _, c := range cols {
+			if c == h {
+				wanted = true
+				break
+			}
+		}
+		if wanted {
+			values = append(values, table.ColumnValue{Name: h, Value: row[n]})
+		}
+	}
+	return values
+}
+
+// Columns returns the column names of a table in the storage or in the
+// table directory.
+func (r *Runtime) Columns(db string, tbl string) ([]string, error) {
+	return r.readHeaderFromLocalTable(db, tbl)
+}
+
+func (r *Runtime) readHeaderFromLocalTable(db string, tbl string) ([]string, error) {
+	if ti, exists := r.storage.Table(tbl); exists && db == "_" {
+		return ti.Columns, nil
+	}
+
+	fp, exists := r.tablePath(db, tbl)
 	if !exists {
-		return errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
+		return []string{}, errors.New(fmt.Sprintf("Table (%s) Not Found", tbl))
 	}
 
-	return nil
+	return csv.ReadHeader(fp)
 }
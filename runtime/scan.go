@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+var errScanClosed = errors.New("Scan is closed")
+
+// Scan reads the rows of a table one at a time, as Next asks for them. The
+// table is read by a goroutine that is at most a row ahead of Next, so a
+// scan closed early, as it is once a LIMIT is reached, reads little of it.
+// The bytes read are counted when the scan ends or is closed.
+type Scan struct {
+	s    *Session
+	rows chan []table.ColumnValue
+	stop chan struct{}
+	n    int64
+	err  error
+	done bool
+}
+
+// OpenScan starts a scan of cols of a table, as ScanColumnsFromLocalTable
+// reads them. A large table is read in as many parts at once as the
+// parallelism of the runtime, still giving the rows in table order. The scan must be closed once it is no longer read, before
+// the transaction or the context of the session change.
+func (s *Session) OpenScan(db string, tbl string, cols []string) *Scan {
+	return s.openScan(func(fn func([]table.ColumnValue) error) (int64, error) {
+		release, err := s.read(db, tbl)
+		if err != nil {
+			return 0, err
+		}
+		defer release()
+		return s.readColumnsOrdered(db, tbl, cols, s.r.Parallelism(), fn)
+	})
+}
+
+// OpenIndexScan is OpenScan reading the rows of the range rg of an index,
+// as ScanIndex does.
+func (s *Session) OpenIndexScan(db string, name string, rg KeyRange, cols []string) *Scan {
+	return s.openScan(func(fn func([]table.ColumnValue) error) (int64, error) {
+		return s.scanIndex(db, name, rg, cols, fn)
+	})
+}
+
+// openScan starts a scan of the rows read passes to its function.
+func (s *Session) openScan(read func(func([]table.ColumnValue) error) (int64, error)) *Scan {
+	sc := &Scan{
+		s:    s,
+		rows: make(chan []table.ColumnValue),
+		stop: make(chan struct{}),
+	}
+	go func() {
+		defer close(sc.rows)
+		sc.n, sc.err = read(func(row []table.ColumnValue) error {
+			select {
+			case sc.rows <- row:
+				return nil
+			case <-sc.stop:
+				return errScanClosed
+			}
+		})
+	}()
+	return sc
+}
+
+// Next returns the next row of the scan, or io.EOF after the last one.
+func (sc *Scan) Next() ([]table.ColumnValue, error) {
+	if !sc.done {
+		if row, ok := <-sc.rows; ok {
+			return row, nil
+		}
+		sc.finish()
+	}
+	if sc.err != nil {
+		return nil, sc.err
+	}
+	return nil, io.EOF
+}
+
+// Close stops the scan and waits for its goroutine to end.
+func (sc *Scan) Close() {
+	if sc.done {
+		return
+	}
+	close(sc.stop)
+	for range sc.rows {
+	}
+	if sc.err == errScanClosed {
+		sc.err = nil
+	}
+	sc.finish()
+}
+
+func (sc *Scan) finish() {
+	sc.done = true
+	sc.s.bytesRead += sc.n
+}
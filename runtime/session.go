@@ -0,0 +1,195 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// Session holds the transaction state and the locks of one connection to a
+// Runtime. Sessions of the same Runtime may run on different goroutines, but
+// a single Session must not be used concurrently.
+type Session struct {
+	r         *Runtime
+	id        uint64
+	tx        *transaction
+	bytesRead int64
+	changes   int64
+
+	// ctx is the context of the statement being run. Reads of tables stop
+	// with its error once it is done.
+	ctx              context.Context
+	statementTimeout time.Duration
+	limits           Limits
+}
+
+func (r *Runtime) NewSession() *Session {
+	return &Session{
+		r:  r,
+		id: atomic.AddUint64(&r.sessions, 1),
+	}
+}
+
+func (s *Session) Runtime() *Runtime {
+	return s.r
+}
+
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// BytesRead returns the number of bytes the session has read from tables.
+func (s *Session) BytesRead() int64 {
+	return s.bytesRead
+}
+
+// Changes returns the number of rows the session has inserted, updated or
+// deleted.
+func (s *Session) Changes() int64 {
+	return s.changes
+}
+
+// Context returns the context of the statement the session is running.
+func (s *Session) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *Session) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// StatementTimeout returns how long a statement of the session may run, 0
+// for no limit.
+func (s *Session) StatementTimeout() time.Duration {
+	return s.statementTimeout
+}
+
+// Set sets a variable of the session. statement_timeout is in milliseconds
+// and max_memory in bytes; 0 removes a limit set by the session.
+func (s *Session) Set(name string, value int) error {
+	limit := func(l *int64) error {
+		if value < 0 {
+			return errors.New(fmt.Sprintf("Invalid %s (%d)", name, value))
+		}
+		*l = int64(value)
+		return nil
+	}
+	switch name {
+	case "statement_timeout":
+		if value < 0 {
+			return errors.New(fmt.Sprintf("Invalid statement_timeout (%d)", value))
+		}
+		s.statementTimeout = time.Duration(value) * time.Millisecond
+		return nil
+	case "max_instructions":
+		return limit(&s.limits.Instructions)
+	case "max_memory":
+		return limit(&s.limits.Memory)
+	case "max_rows":
+		return limit(&s.limits.Rows)
+	default:
+		return errors.New(fmt.Sprintf("Unknown variable (%s)", name))
+	}
+}
+
+func (s *Session) Close() error {
+	var err error
+	if s.tx != nil {
+		err = s.Rollback()
+	}
+	s.r.locks.UnlockAll(s.id)
+	return err
+}
+
+// acquire takes a lock for the session. Outside a transaction the lock only
+// lasts for the statement and is given back by the returned function.
+func (s *Session) acquire(resource string, mode LockMode) (func(), error) {
+	if err := s.r.locks.Lock(s.id, resource, mode); err != nil {
+		return func() {}, err
+	}
+	if s.tx != nil {
+		return func() {}, nil
+	}
+	return func() {
+		s.r.locks.Unlock(s.id, resource)
+	}, nil
+}
+
+// write takes the locks needed to modify a table in storage.
+func (s *Session) write(db string, tbl string) (func(), error) {
+	release, err := s.acquire(tableLock(db, tbl), Exclusive)
+	if err != nil {
+		return release, err
+	}
+	if s.tx != nil {
+		if err := s.beginStorage(); err != nil {
+			return release, err
+		}
+		return release, nil
+	}
+	if err := s.r.locks.Lock(s.id, databaseLock, Exclusive); err != nil {
+		release()
+		return func() {}, err
+	}
+	return func() {
+		s.r.locks.Unlock(s.id, databaseLock)
+		release()
+	}, nil
+}
+
+// read takes the locks needed to read a table. Tables of the storage are
+// read from a snapshot, which writers do not change, so only CSV files are
+// locked.
+func (s *Session) read(db string, tbl string) (func(), error) {
+	if s.r.stored(db, tbl) {
+		return func() {}, nil
+	}
+	return s.acquire(tableLock(db, tbl), Shared)
+}
+
+// scanStorage scans a table of the storage from the snapshot of the
+// transaction of the session, or from one taken for the scan outside a
+// transaction.
+func (s *Session) scanStorage(tbl string, fn func(int64, []table.Value) (bool, error)) error {
+	if s.tx != nil && s.tx.snapshot != nil {
+		return s.tx.snapshot.Scan(tbl, fn)
+	}
+	tx, err := s.r.storage.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return tx.Scan(tbl, fn)
+}
+
+func (s *Session) ReadLineFromLocalTable(db string, tbl string, fn func([]table.ColumnValue)) error {
+	return s.ReadColumnsFromLocalTable(db, tbl, nil, fn)
+}
+
+// ReadColumnsFromLocalTable is ReadLineFromLocalTable reading only cols.
+func (s *Session) ReadColumnsFromLocalTable(db string, tbl string, cols []string, fn func([]table.ColumnValue)) error {
+	return s.ScanColumnsFromLocalTable(db, tbl, cols, func(row []table.ColumnValue) error {
+		fn(row)
+		return nil
+	})
+}
+
+// ScanColumnsFromLocalTable is ReadColumnsFromLocalTable stopping with the
+// error fn returns.
+func (s *Session) ScanColumnsFromLocalTable(db string, tbl string, cols []string, fn func([]table.ColumnValue) error) error {
+	release, err := s.read(db, tbl)
+	if err != nil {
+		return err
+	}
+	defer release()
+	n, err := s.readColumnsFromLocalTable(db, tbl, cols, fn)
+	s.bytesRead += n
+	return err
+}
@@ -0,0 +1,153 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func TestIndependentRuntimes(t *testing.T) {
+	dir1, _ := ioutil.TempDir("", "runtime")
+	defer os.RemoveAll(dir1)
+	dir2, _ := ioutil.TempDir("", "runtime")
+	defer os.RemoveAll(dir2)
+
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir1, "tbl1.csv"), src, 0644)
+	ioutil.WriteFile(filepath.Join(dir2, "tbl2.csv"), src, 0644)
+
+	r1 := New().Set(dir1)
+	r2 := New().Set(dir2)
+	if err := r1.Open(filepath.Join(dir1, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r1.Close()
+	if err := r2.Open(filepath.Join(dir2, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r2.Close()
+
+	if tbls := r1.LocalTables("_"); len(tbls) != 1 || tbls[0] != "tbl1" {
+		t.Fatalf("tables mismatch %v", tbls)
+	}
+	if tbls := r2.LocalTables("_"); len(tbls) != 1 || tbls[0] != "tbl2" {
+		t.Fatalf("tables mismatch %v", tbls)
+	}
+	if err := r1.Storage().CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, exists := r2.Storage().Table("tbl"); exists {
+		t.Fatalf("table is shared between runtimes")
+	}
+	if err := r1.CreateIndex("_", "tbl1", "idx", []string{"colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(r2.Indexes("_", "tbl2")) != 0 {
+		t.Fatalf("index is shared between runtimes")
+	}
+}
+
+func TestSessionLocks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "runtime")
+	defer os.RemoveAll(dir)
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644)
+
+	r := New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	if err := r.Storage().CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	s1 := r.NewSession()
+	s2 := r.NewSession()
+	if err := s1.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	err = s1.ReadLineFromLocalTable("_", "tbl1", func([]table.ColumnValue) {})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if r.Locks().Held(s1.ID())[tableLock("_", "tbl1")] != Shared {
+		t.Fatalf("shared lock is not held until the end of the transaction")
+	}
+	err = s2.ReadLineFromLocalTable("_", "tbl1", func([]table.ColumnValue) {})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(r.Locks().Held(s2.ID())) != 0 {
+		t.Fatalf("autocommit read keeps its lock")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s2.CreateIndex("_", "tbl1", "idx", []string{"colA"}, false)
+	}()
+	if err := s1.CreateIndex("_", "tbl", "idx_tbl", []string{"colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(r.Locks().Resources()) != 0 {
+		t.Fatalf("locks are not released %v", r.Locks().Resources())
+	}
+	ti, _ := r.Storage().Table("tbl")
+	if len(r.Indexes("_", "tbl1")) != 1 || len(ti.Indexes) != 1 {
+		t.Fatalf("indexes are not created")
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "runtime")
+	defer os.RemoveAll(dir)
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644)
+
+	r := New().Set(dir)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			s := r.NewSession()
+			defer s.Close()
+			for i := 0; i < 20; i++ {
+				if n%4 == 0 {
+					r.Set(dir)
+					continue
+				}
+				err := s.ReadLineFromLocalTable("_", "tbl1", func([]table.ColumnValue) {})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+}
@@ -0,0 +1,325 @@
+// Package stats holds the statistics ANALYZE collects on a table and the
+// estimates the optimizer derives from them.
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"unsafe"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// DefaultBuckets is the number of buckets of a histogram.
+const DefaultBuckets = 16
+
+// Bucket is a range of an equi-depth histogram. It covers the values above
+// the upper bound of the previous bucket, or from Min for the first one, up
+// to and including Upper.
+type Bucket struct {
+	Upper    int
+	Count    int
+	Distinct int
+}
+
+type Column struct {
+	Name string
+	// Values is the number of non-NULL values.
+	Values       int
+	Distinct     int
+	NullFraction float64
+	Min          int
+	Max          int
+	Histogram    []Bucket
+}
+
+type Table struct {
+	Rows    int
+	Columns map[string]*Column
+}
+
+// Column returns the statistics of a column.
+func (t *Table) Column(name string) (*Column, bool) {
+	c, exists := t.Columns[name]
+	return c, exists
+}
+
+// EqualSelectivity estimates the fraction of the rows of the table whose
+// column equals v.
+func (c *Column) EqualSelectivity(v int) float64 {
+	if c.Values == 0 || v < c.Min || v > c.Max {
+		return 0
+	}
+	nonNull := 1 - c.NullFraction
+	for _, b := range c.Histogram {
+		if v <= b.Upper {
+			return nonNull * float64(b.Count) / float64(b.Distinct) / float64(c.Values)
+		}
+	}
+	return nonNull / float64(c.Distinct)
+}
+
+// RangeSelectivity estimates the fraction of the rows of the table whose
+// column is from low to high, both included. A nil bound leaves the range
+// open on its side. The values of a bucket are taken as spread evenly over
+// its range.
+func (c *Column) RangeSelectivity(low *int, high *int) float64 {
+	lo, hi := c.Min, c.Max
+	if low != nil && *low > lo {
+		lo = *low
+	}
+	if high != nil && *high < hi {
+		hi = *high
+	}
+	if c.Values == 0 || lo > hi {
+		return 0
+	}
+	nonNull := 1 - c.NullFraction
+	if len(c.Histogram) == 0 {
+		return nonNull * float64(hi-lo+1) / float64(c.Max-c.Min+1)
+	}
+	in, total := 0.0, 0.0
+	from := c.Min
+	for _, b := range c.Histogram {
+		l, h := from, b.Upper
+		if lo > l {
+			l = lo
+		}
+		if hi < h {
+			h = hi
+		}
+		if l <= h {
+			in += float64(b.Count) * float64(h-l+1) / float64(b.Upper-from+1)
+		}
+		total += float64(b.Count)
+		from = b.Upper + 1
+	}
+	return nonNull * in / total
+}
+
+// DefaultSampleSize is the number of rows a collector keeps to build the
+// histograms and estimate the number of distinct values.
+const DefaultSampleSize = 10000
+
+// Collector builds the statistics of a table one row at a time. The number
+// of rows, of NULLs and the bounds of each column are counted on every row,
+// the rest is estimated from a uniform sample of the rows, so that the
+// memory used does not grow with the table.
+type Collector struct {
+	columns []string
+	index   map[string]int
+	rows    int
+	values  []int
+	min     []int
+	max     []int
+	sample  [][]table.Value
+	size    int
+	rand    *rand.Rand
+	buckets int
+}
+
+func NewCollector(cols []string) *Collector {
+	c := &Collector{
+		columns: cols,
+		index:   make(map[string]int),
+		values:  make([]int, len(cols)),
+		min:     make([]int, len(cols)),
+		max:     make([]int, len(cols)),
+		sample:  [][]table.Value{},
+		size:    DefaultSampleSize,
+		rand:    rand.New(rand.NewSource(1)),
+		buckets: DefaultBuckets,
+	}
+	for n, name := range cols {
+		c.index[name] = n
+	}
+	return c
+}
+
+// SetBuckets sets the number of buckets of the histograms.
+func (c *Collector) SetBuckets(n int) {
+	c.buckets = n
+}
+
+// SetSampleSize sets the number of rows sampled. It has to be called
+// before the first row is added.
+func (c *Collector) SetSampleSize(n int) {
+	c.size = n
+}
+
+// Add adds a row. Columns that are missing from row count as NULL.
+func (c *Collector) Add(row []table.ColumnValue) {
+	c.rows++
+	for _, cv := range row {
+		n, exists := c.index[cv.Name]
+		if !exists || cv.Value.Type != table.Integer {
+			continue
+		}
+		v := cv.Value.Integral
+		if c.values[n] == 0 || v < c.min[n] {
+			c.min[n] = v
+		}
+		if c.values[n] == 0 || v > c.max[n] {
+			c.max[n] = v
+		}
+		c.values[n]++
+	}
+
+	// Reservoir sampling: the row replaces a sampled one with the
+	// probability that keeps every row as likely to be in the sample.
+	slot := len(c.sample)
+	if slot >= c.size {
+		slot = int(c.rand.Int63n(int64(c.rows)))
+		if slot >= c.size {
+			return
+		}
+	}
+	values := make([]table.Value, len(c.columns))
+	for _, cv := range row {
+		if n, exists := c.index[cv.Name]; exists {
+			values[n] = cv.Value
+		}
+	}
+	if slot == len(c.sample) {
+		c.sample = append(c.sample, values)
+	} else {
+		c.sample[slot] = values
+	}
+}
+
+// Merge adds the rows collected by o, which must have been made for the
+// same columns, so that a table can be collected in parts. The merged
+// sample draws from each part in proportion to its number of rows.
+func (c *Collector) Merge(o *Collector) {
+	for n := range c.columns {
+		if o.values[n] == 0 {
+			continue
+		}
+		if c.values[n] == 0 || o.min[n] < c.min[n] {
+			c.min[n] = o.min[n]
+		}
+		if c.values[n] == 0 || o.max[n] > c.max[n] {
+			c.max[n] = o.max[n]
+		}
+		c.values[n] += o.values[n]
+	}
+
+	a, b := c.sample, append([][]table.Value{}, o.sample...)
+	ra, rb := int64(c.rows), int64(o.rows)
+	c.rows += o.rows
+	if len(a)+len(b) <= c.size {
+		c.sample = append(a, b...)
+		return
+	}
+	merged := make([][]table.Value, 0, c.size)
+	for len(merged) < c.size && (len(a) != 0 || len(b) != 0) {
+		from := &b
+		if len(b) == 0 || (len(a) != 0 && c.rand.Int63n(ra+rb) < ra) {
+			from = &a
+			ra--
+		} else {
+			rb--
+		}
+		k := c.rand.Intn(len(*from))
+		merged = append(merged, (*from)[k])
+		(*from)[k] = (*from)[len(*from)-1]
+		*from = (*from)[:len(*from)-1]
+	}
+	c.sample = merged
+}
+
+// Memory returns the number of bytes of the values the collector holds.
+func (c *Collector) Memory() int64 {
+	return int64(len(c.sample)*len(c.columns)) * int64(unsafe.Sizeof(table.Value{}))
+}
+
+func (c *Collector) Table() *Table {
+	t := &Table{
+		Rows:    c.rows,
+		Columns: make(map[string]*Column),
+	}
+	for n, name := range c.columns {
+		col := &Column{Name: name, Values: c.values[n]}
+		if c.rows != 0 {
+			col.NullFraction = float64(c.rows-c.values[n]) / float64(c.rows)
+		}
+		values := []int{}
+		for _, row := range c.sample {
+			if row[n].Type == table.Integer {
+				values = append(values, row[n].Integral)
+			}
+		}
+		sort.Ints(values)
+		if len(values) != 0 {
+			col.Min = c.min[n]
+			col.Max = c.max[n]
+			col.Distinct = distinct(values)
+			col.Histogram = histogram(values, c.buckets)
+			if len(values) < col.Values {
+				scale(col, values)
+			}
+		}
+		t.Columns[name] = col
+	}
+	return t
+}
+
+// scale turns the statistics of a column computed on a sample of its
+// values into those of the whole column. The number of distinct values is
+// estimated from how many values the sample holds only once.
+func scale(col *Column, sorted []int) {
+	n := float64(len(sorted))
+	total := float64(col.Values)
+	once := 0
+	for k, v := range sorted {
+		if (k == 0 || sorted[k-1] != v) && (k == len(sorted)-1 || sorted[k+1] != v) {
+			once++
+		}
+	}
+	d := float64(col.Distinct)
+	f1 := float64(once)
+	estimated := n * d / (n - f1 + f1*n/total)
+	estimated = math.Max(d, math.Min(estimated, total))
+
+	for k := range col.Histogram {
+		b := &col.Histogram[k]
+		b.Count = int(math.Max(1, math.Round(float64(b.Count)*total/n)))
+		b.Distinct = int(math.Max(1, math.Min(float64(b.Count), math.Round(float64(b.Distinct)*estimated/d))))
+	}
+	col.Distinct = int(math.Round(estimated))
+}
+
+func distinct(sorted []int) int {
+	d := 0
+	for n, v := range sorted {
+		if n == 0 || sorted[n-1] != v {
+			d++
+		}
+	}
+	return d
+}
+
+// histogram splits sorted values into buckets of about the same number of
+// values. All the occurrences of a value go to the same bucket.
+func histogram(sorted []int, buckets int) []Bucket {
+	if buckets < 1 {
+		return nil
+	}
+	depth := (len(sorted) + buckets - 1) / buckets
+	hist := []Bucket{}
+	b := Bucket{}
+	for n, v := range sorted {
+		b.Count++
+		if n == 0 || sorted[n-1] != v {
+			b.Distinct++
+		}
+		last := n == len(sorted)-1
+		if last || (b.Count >= depth && sorted[n+1] != v) {
+			b.Upper = v
+			hist = append(hist, b)
+			b = Bucket{}
+		}
+	}
+	return hist
+}
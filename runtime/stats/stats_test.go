@@ -0,0 +1,156 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func row(a *int, b int) []table.ColumnValue {
+	cols := []table.ColumnValue{{Name: "colB", Value: table.Value{Type: table.Integer, Integral: b}}}
+	if a == nil {
+		return append(cols, table.ColumnValue{Name: "colA", Value: table.Value{Type: table.Null}})
+	}
+	return append(cols, table.ColumnValue{Name: "colA", Value: table.Value{Type: table.Integer, Integral: *a}})
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector([]string{"colA", "colB"})
+	c.SetBuckets(4)
+	for n := 0; n < 10; n++ {
+		v := n % 5
+		if n == 9 {
+			c.Add(row(nil, 7))
+			continue
+		}
+		c.Add(row(&v, 7))
+	}
+	tbl := c.Table()
+	if tbl.Rows != 10 {
+		t.Fatalf("Rows mismatch %d", tbl.Rows)
+	}
+
+	a, exists := tbl.Column("colA")
+	if !exists {
+		t.Fatalf("colA is missing")
+	}
+	if a.Values != 9 || a.Distinct != 5 || a.Min != 0 || a.Max != 4 || a.NullFraction != 0.1 {
+		t.Fatalf("colA mismatch %+v", a)
+	}
+	expected := []Bucket{{Upper: 1, Count: 4, Distinct: 2}, {Upper: 3, Count: 4, Distinct: 2}, {Upper: 4, Count: 1, Distinct: 1}}
+	if len(a.Histogram) != len(expected) {
+		t.Fatalf("Histogram mismatch %+v", a.Histogram)
+	}
+	for n, b := range expected {
+		if a.Histogram[n] != b {
+			t.Fatalf("[%d] Bucket mismatch %+v", n, a.Histogram[n])
+		}
+	}
+
+	b, _ := tbl.Column("colB")
+	if b.Distinct != 1 || len(b.Histogram) != 1 || b.Histogram[0] != (Bucket{Upper: 7, Count: 10, Distinct: 1}) {
+		t.Fatalf("colB mismatch %+v", b)
+	}
+
+	testCases := []struct {
+		col      *Column
+		value    int
+		expected float64
+	}{
+		{a, 0, 0.2},
+		{a, 4, 0.1},
+		{a, 5, 0},
+		{a, -1, 0},
+		{b, 7, 1},
+	}
+	for tn, tc := range testCases {
+		if actual := tc.col.EqualSelectivity(tc.value); math.Abs(actual-tc.expected) > 1e-9 {
+			t.Fatalf("[%d] %s = %d expected %f but got %f", tn, tc.col.Name, tc.value, tc.expected, actual)
+		}
+	}
+
+	one, three, five := 1, 3, 5
+	rangeCases := []struct {
+		col      *Column
+		low      *int
+		high     *int
+		expected float64
+	}{
+		{a, &one, &three, 0.6},
+		{a, nil, &one, 0.4},
+		{a, &three, nil, 0.3},
+		{a, nil, nil, 0.9},
+		{a, &five, nil, 0},
+		{a, &three, &one, 0},
+		{b, nil, &five, 0},
+		{b, &five, nil, 1},
+	}
+	for tn, tc := range rangeCases {
+		if actual := tc.col.RangeSelectivity(tc.low, tc.high); math.Abs(actual-tc.expected) > 1e-9 {
+			t.Fatalf("[%d] %s expected %f but got %f", tn, tc.col.Name, tc.expected, actual)
+		}
+	}
+}
+
+func TestCollectorMerge(t *testing.T) {
+	whole := NewCollector([]string{"colA", "colB"})
+	parts := []*Collector{NewCollector([]string{"colA", "colB"}), NewCollector([]string{"colA", "colB"}), NewCollector([]string{"colA", "colB"})}
+	for n := 0; n < 50; n++ {
+		v := (n * 7) % 11
+		r := row(&v, n)
+		if n%6 == 0 {
+			r = row(nil, n)
+		}
+		whole.Add(r)
+		parts[n%len(parts)].Add(r)
+	}
+	for _, c := range parts[1:] {
+		parts[0].Merge(c)
+	}
+	expected := whole.Table()
+	actual := parts[0].Table()
+	if actual.Rows != expected.Rows {
+		t.Fatalf("Rows mismatch %d", actual.Rows)
+	}
+	for name, col := range expected.Columns {
+		if !reflect.DeepEqual(actual.Columns[name], col) {
+			t.Fatalf("%s mismatch %+v", name, actual.Columns[name])
+		}
+	}
+}
+
+func TestCollectorSample(t *testing.T) {
+	const rows = 100000
+	c := NewCollector([]string{"colA", "colB"})
+	c.SetSampleSize(1000)
+	for n := 0; n < rows; n++ {
+		v := n % 500
+		c.Add(row(&v, n))
+	}
+	if m := c.Memory(); m > int64(1000*2*unsafe.Sizeof(table.Value{})) {
+		t.Fatalf("Memory %d is not bounded by the sample", m)
+	}
+	tbl := c.Table()
+	a, _ := tbl.Column("colA")
+	b, _ := tbl.Column("colB")
+	if tbl.Rows != rows || a.Values != rows || a.Min != 0 || a.Max != 499 || b.Min != 0 || b.Max != rows-1 {
+		t.Fatalf("counts mismatch %d %+v %+v", tbl.Rows, a, b)
+	}
+	testCases := []struct {
+		actual   float64
+		expected float64
+	}{
+		{float64(a.Distinct), 500},
+		{float64(b.Distinct), rows},
+		{a.EqualSelectivity(250), 1.0 / 500},
+		{b.EqualSelectivity(250), 1.0 / rows},
+	}
+	for tn, tc := range testCases {
+		if tc.actual < tc.expected/2 || tc.actual > tc.expected*2 {
+			t.Fatalf("[%d] expected about %g but got %g", tn, tc.expected, tc.actual)
+		}
+	}
+}
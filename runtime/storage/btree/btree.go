@@ -0,0 +1,405 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/runtime/storage/buffer"
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+const MaxKeySize = 512
+const maxInlineValue = 1024
+const overflowHeaderSize = 6
+
+type BTree struct {
+	pool *buffer.Pool
+	root uint32
+}
+
+func Create(b *buffer.Pool) (*BTree, error) {
+	t := &BTree{
+		pool: b,
+	}
+	pgno, err := t.allocate()
+	if err != nil {
+		return nil, err
+	}
+	t.root = pgno
+	if err := t.writeNode(&node{pgno: pgno, kind: leafNode}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func Open(b *buffer.Pool, root uint32) *BTree {
+	return &BTree{
+		pool: b,
+		root: root,
+	}
+}
+
+func (t *BTree) Root() uint32 {
+	return t.root
+}
+
+func (t *BTree) allocate() (uint32, error) {
+	f, err := t.pool.Allocate()
+	if err != nil {
+		return 0, err
+	}
+	t.pool.Unpin(f, true)
+	return f.PageNo(), nil
+}
+
+func (t *BTree) readPage(pgno uint32) ([]byte, error) {
+	f, err := t.pool.Fetch(pgno)
+	if err != nil {
+		return nil, err
+	}
+	defer t.pool.Unpin(f, false)
+	return append([]byte{}, f.Data()...), nil
+}
+
+func (t *BTree) writePage(pgno uint32, data []byte) error {
+	f, err := t.pool.Fetch(pgno)
+	if err != nil {
+		return err
+	}
+	buf := f.Data()
+	n := copy(buf, data)
+	for ; n < len(buf); n++ {
+		buf[n] = 0
+	}
+	t.pool.Unpin(f, true)
+	return nil
+}
+
+func (t *BTree) readNode(pgno uint32) (*node, error) {
+	f, err := t.pool.Fetch(pgno)
+	if err != nil {
+		return nil, err
+	}
+	defer t.pool.Unpin(f, false)
+	return decode(pgno, f.Data())
+}
+
+func (t *BTree) writeNode(n *node) error {
+	return t.writePage(n.pgno, n.encode())
+}
+
+func (t *BTree) findLeaf(key []byte) (*node, error) {
+	n, err := t.readNode(t.root)
+	if err != nil {
+		return nil, err
+	}
+	for !n.isLeaf() {
+		child := n.children[0]
+		if key != nil {
+			child = n.children[n.childIndex(key)]
+		}
+		n, err = t.readNode(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (t *BTree) Get(key []byte) ([]byte, bool, error) {
+	n, err := t.findLeaf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	i := n.search(key)
+	if i >= len(n.cells) || !bytes.Equal(n.cells[i].key, key) {
+		return nil, false, nil
+	}
+	v, err := t.readValue(n.cells[i])
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func (t *BTree) Put(key []byte, value []byte) error {
+	if len(key) == 0 || len(key) > MaxKeySize {
+		return errors.New(fmt.Sprintf("Key size %d is out of range", len(key)))
+	}
+	c, err := t.makeCell(key, value)
+	if err != nil {
+		return err
+	}
+
+	promoted, right, err := t.put(t.root, c)
+	if err != nil {
+		return err
+	}
+	if right == 0 {
+		return nil
+	}
+
+	left, err := t.readNode(t.root)
+	if err != nil {
+		return err
+	}
+	left.pgno, err = t.allocate()
+	if err != nil {
+		return err
+	}
+	if err := t.writeNode(left); err != nil {
+		return err
+	}
+	root := &node{
+		pgno:     t.root,
+		kind:     internalNode,
+		keys:     [][]byte{promoted},
+		children: []uint32{left.pgno, right},
+	}
+	return t.writeNode(root)
+}
+
+func (t *BTree) put(pgno uint32, c cell) ([]byte, uint32, error) {
+	n, err := t.readNode(pgno)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if n.isLeaf() {
+		i := n.search(c.key)
+		if i < len(n.cells) && bytes.Equal(n.cells[i].key, c.key) {
+			if err := t.freeOverflow(n.cells[i].overflow); err != nil {
+				return nil, 0, err
+			}
+			n.cells[i] = c
+		} else {
+			n.cells = append(n.cells, cell{})
+			copy(n.cells[i+1:], n.cells[i:])
+			n.cells[i] = c
+		}
+		if n.size() <= pager.PageSize {
+			return nil, 0, t.writeNode(n)
+		}
+		return t.splitLeaf(n)
+	}
+
+	i := n.childIndex(c.key)
+	promoted, right, err := t.put(n.children[i], c)
+	if err != nil || right == 0 {
+		return nil, 0, err
+	}
+
+	n.keys = append(n.keys, nil)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = promoted
+	n.children = append(n.children, 0)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+
+	if n.size() <= pager.PageSize {
+		return nil, 0, t.writeNode(n)
+	}
+	return t.splitInternal(n)
+}
+
+func (t *BTree) splitLeaf(n *node) ([]byte, uint32, error) {
+	half := (n.size() - nodeHeaderSize) / 2
+	mid := 0
+	for s := 0; mid < len(n.cells)-1; mid++ {
+		s += n.cells[mid].size()
+		if s > half {
+			break
+		}
+	}
+	if mid == 0 {
+		mid = 1
+	}
+
+	pgno, err := t.allocate()
+	if err != nil {
+		return nil, 0, err
+	}
+	right := &node{
+		pgno:  pgno,
+		kind:  leafNode,
+		next:  n.next,
+		cells: append([]cell{}, n.cells[mid:]...),
+	}
+	n.cells = append([]cell{}, n.cells[:mid]...)
+	n.next = pgno
+
+	if err := t.writeNode(right); err != nil {
+		return nil, 0, err
+	}
+	if err := t.writeNode(n); err != nil {
+		return nil, 0, err
+	}
+	return right.cells[0].key, pgno, nil
+}
+
+func (t *BTree) splitInternal(n *node) ([]byte, uint32, error) {
+	mid := len(n.keys) / 2
+	promoted := n.keys[mid]
+
+	pgno, err := t.allocate()
+	if err != nil {
+		return nil, 0, err
+	}
+	right := &node{
+		pgno:     pgno,
+		kind:     internalNode,
+		keys:     append([][]byte{}, n.keys[mid+1:]...),
+		children: append([]uint32{}, n.children[mid+1:]...),
+	}
+	n.keys = append([][]byte{}, n.keys[:mid]...)
+	n.children = append([]uint32{}, n.children[:mid+1]...)
+
+	if err := t.writeNode(right); err != nil {
+		return nil, 0, err
+	}
+	if err := t.writeNode(n); err != nil {
+		return nil, 0, err
+	}
+	return promoted, pgno, nil
+}
+
+func (t *BTree) Delete(key []byte) (bool, error) {
+	n, err := t.findLeaf(key)
+	if err != nil {
+		return false, err
+	}
+	i := n.search(key)
+	if i >= len(n.cells) || !bytes.Equal(n.cells[i].key, key) {
+		return false, nil
+	}
+	if err := t.freeOverflow(n.cells[i].overflow); err != nil {
+		return false, err
+	}
+	n.cells = append(n.cells[:i], n.cells[i+1:]...)
+	return true, t.writeNode(n)
+}
+
+func (t *BTree) Scan(from []byte, fn func([]byte, []byte) (bool, error)) error {
+	n, err := t.findLeaf(from)
+	if err != nil {
+		return err
+	}
+	i := 0
+	if from != nil {
+		i = n.search(from)
+	}
+	for {
+		for ; i < len(n.cells); i++ {
+			v, err := t.readValue(n.cells[i])
+			if err != nil {
+				return err
+			}
+			cont, err := fn(n.cells[i].key, v)
+			if err != nil || !cont {
+				return err
+			}
+		}
+		if n.next == 0 {
+			return nil
+		}
+		n, err = t.readNode(n.next)
+		if err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+func (t *BTree) Drop() error {
+	return t.drop(t.root)
+}
+
+func (t *BTree) drop(pgno uint32) error {
+	n, err := t.readNode(pgno)
+	if err != nil {
+		return err
+	}
+	if n.isLeaf() {
+		for _, c := range n.cells {
+			if err := t.freeOverflow(c.overflow); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, child := range n.children {
+			if err := t.drop(child); err != nil {
+				return err
+			}
+		}
+	}
+	return t.pool.Free(pgno)
+}
+
+func (t *BTree) makeCell(key []byte, value []byte) (cell, error) {
+	c := cell{
+		key:    append([]byte{}, key...),
+		length: uint32(len(value)),
+	}
+	if len(value) <= maxInlineValue {
+		c.value = append([]byte{}, value...)
+		return c, nil
+	}
+
+	chunk := pager.PageSize - overflowHeaderSize
+	next := uint32(0)
+	for end := len(value); end > 0; {
+		start := ((end - 1) / chunk) * chunk
+		pgno, err := t.allocate()
+		if err != nil {
+			return c, err
+		}
+		buf := make([]byte, overflowHeaderSize, pager.PageSize)
+		binary.LittleEndian.PutUint32(buf, next)
+		binary.LittleEndian.PutUint16(buf[4:], uint16(end-start))
+		buf = append(buf, value[start:end]...)
+		if err := t.writePage(pgno, buf); err != nil {
+			return c, err
+		}
+		next = pgno
+		end = start
+	}
+	c.overflow = next
+	return c, nil
+}
+
+func (t *BTree) readValue(c cell) ([]byte, error) {
+	if c.overflow == 0 {
+		return c.value, nil
+	}
+	v := make([]byte, 0, c.length)
+	for pgno := c.overflow; pgno != 0; {
+		buf, err := t.readPage(pgno)
+		if err != nil {
+			return nil, err
+		}
+		l := int(binary.LittleEndian.Uint16(buf[4:]))
+		v = append(v, buf[overflowHeaderSize:overflowHeaderSize+l]...)
+		pgno = binary.LittleEndian.Uint32(buf)
+	}
+	if uint32(len(v)) != c.length {
+		return nil, errors.New("Overflow chain is broken")
+	}
+	return v, nil
+}
+
+func (t *BTree) freeOverflow(pgno uint32) error {
+	for pgno != 0 {
+		buf, err := t.readPage(pgno)
+		if err != nil {
+			return err
+		}
+		next := binary.LittleEndian.Uint32(buf)
+		if err := t.pool.Free(pgno); err != nil {
+			return err
+		}
+		pgno = next
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/buffer"
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+func openPool(t *testing.T) (*buffer.Pool, string, func()) {
+	dir, err := ioutil.TempDir("", "btree")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	fn := filepath.Join(dir, "test.db")
+	p, err := pager.Open(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	b := buffer.New(p, 16)
+	return b, fn, func() {
+		p.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func key(n int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+func TestPutGet(t *testing.T) {
+	b, fn, cleanup := openPool(t)
+	defer cleanup()
+
+	tr, err := Create(b)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	const n = 5000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		if err := tr.Put(key(i), bytes.Repeat([]byte{byte(i)}, i%100)); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", i, err)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		v, found, err := tr.Get(key(i))
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", i, err)
+		}
+		if !found || !bytes.Equal(v, bytes.Repeat([]byte{byte(i)}, i%100)) {
+			t.Fatalf("[%d] value mismatch", i)
+		}
+	}
+
+	prev := -1
+	err = tr.Scan(nil, func(k []byte, v []byte) (bool, error) {
+		i := int(binary.BigEndian.Uint64(k))
+		if i != prev+1 {
+			t.Fatalf("scan order mismatch %d after %d", i, prev)
+		}
+		prev = i
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if prev != n-1 {
+		t.Fatalf("scan count mismatch %d", prev)
+	}
+
+	root := tr.Root()
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := b.Pager().Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	b.Pager().Close()
+	p, err := pager.Open(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer p.Close()
+	tr = Open(buffer.New(p, 16), root)
+	v, found, err := tr.Get(key(1234))
+	if err != nil || !found || !bytes.Equal(v, bytes.Repeat([]byte{byte(1234 % 256)}, 1234%100)) {
+		t.Fatalf("value mismatch after reopen")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	b, _, cleanup := openPool(t)
+	defer cleanup()
+
+	tr, err := Create(b)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tr.Put(key(i), key(i)); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", i, err)
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		deleted, err := tr.Delete(key(i))
+		if err != nil || !deleted {
+			t.Fatalf("[%d] delete failed: %v", i, err)
+		}
+	}
+	if deleted, _ := tr.Delete(key(0)); deleted {
+		t.Fatalf("deleted twice")
+	}
+
+	count := 0
+	err = tr.Scan(key(500), func(k []byte, v []byte) (bool, error) {
+		i := int(binary.BigEndian.Uint64(k))
+		if i%2 == 0 || i < 500 {
+			t.Fatalf("unexpected key %d", i)
+		}
+		count++
+		return i < 600, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if count != 51 {
+		t.Fatalf("scan count mismatch %d", count)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	b, _, cleanup := openPool(t)
+	defer cleanup()
+
+	tr, err := Create(b)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	large := make([]byte, 3*pager.PageSize+123)
+	rand.New(rand.NewSource(2)).Read(large)
+	for i := 0; i < 20; i++ {
+		if err := tr.Put(key(i), large); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", i, err)
+		}
+	}
+	v, found, err := tr.Get(key(7))
+	if err != nil || !found || !bytes.Equal(v, large) {
+		t.Fatalf("overflow value mismatch")
+	}
+
+	p := b.Pager()
+	pages := p.PageCount()
+	if err := tr.Put(key(7), []byte("small")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if p.FreeCount() != 4 {
+		t.Fatalf("overflow pages are not freed %d", p.FreeCount())
+	}
+	if err := tr.Put(key(7), large); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if p.PageCount() != pages {
+		t.Fatalf("free pages are not reused %d != %d", p.PageCount(), pages)
+	}
+
+	if err := tr.Drop(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if p.FreeCount() != p.PageCount()-1 {
+		t.Fatalf("pages are not freed %d/%d", p.FreeCount(), p.PageCount())
+	}
+}
@@ -0,0 +1,169 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+const (
+	leafNode     byte = 1
+	internalNode byte = 2
+)
+
+const nodeHeaderSize = 7
+
+const (
+	inlineValue   byte = 0
+	overflowValue byte = 1
+)
+
+type cell struct {
+	key      []byte
+	value    []byte
+	overflow uint32
+	length   uint32
+}
+
+func (c cell) size() int {
+	if c.overflow != 0 {
+		return 2 + len(c.key) + 1 + 4 + 4
+	}
+	return 2 + len(c.key) + 1 + 4 + len(c.value)
+}
+
+type node struct {
+	pgno     uint32
+	kind     byte
+	next     uint32
+	cells    []cell
+	keys     [][]byte
+	children []uint32
+}
+
+func (n *node) isLeaf() bool {
+	return n.kind == leafNode
+}
+
+func (n *node) size() int {
+	s := nodeHeaderSize
+	if n.isLeaf() {
+		for _, c := range n.cells {
+			s += c.size()
+		}
+		return s
+	}
+	for _, k := range n.keys {
+		s += 2 + len(k) + 4
+	}
+	return s
+}
+
+func (n *node) search(key []byte) int {
+	return sort.Search(len(n.cells), func(i int) bool {
+		return bytes.Compare(n.cells[i].key, key) >= 0
+	})
+}
+
+func (n *node) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+func (n *node) encode() []byte {
+	buf := make([]byte, 0, pager.PageSize)
+	buf = append(buf, n.kind)
+	if n.isLeaf() {
+		buf = appendUint16(buf, uint16(len(n.cells)))
+		buf = appendUint32(buf, n.next)
+		for _, c := range n.cells {
+			buf = appendUint16(buf, uint16(len(c.key)))
+			buf = append(buf, c.key...)
+			if c.overflow != 0 {
+				buf = append(buf, overflowValue)
+				buf = appendUint32(buf, c.length)
+				buf = appendUint32(buf, c.overflow)
+			} else {
+				buf = append(buf, inlineValue)
+				buf = appendUint32(buf, uint32(len(c.value)))
+				buf = append(buf, c.value...)
+			}
+		}
+		return buf
+	}
+
+	buf = appendUint16(buf, uint16(len(n.keys)))
+	buf = appendUint32(buf, n.children[0])
+	for i, k := range n.keys {
+		buf = appendUint16(buf, uint16(len(k)))
+		buf = append(buf, k...)
+		buf = appendUint32(buf, n.children[i+1])
+	}
+	return buf
+}
+
+func decode(pgno uint32, buf []byte) (*node, error) {
+	n := &node{
+		pgno: pgno,
+		kind: buf[0],
+	}
+	if n.kind != leafNode && n.kind != internalNode {
+		return n, errors.New(fmt.Sprintf("Page (%d) is not a B+tree node", pgno))
+	}
+
+	nkeys := int(binary.LittleEndian.Uint16(buf[1:]))
+	pos := 3
+	if n.isLeaf() {
+		n.next = binary.LittleEndian.Uint32(buf[pos:])
+		pos += 4
+		for i := 0; i < nkeys; i++ {
+			c := cell{}
+			kl := int(binary.LittleEndian.Uint16(buf[pos:]))
+			pos += 2
+			c.key = append([]byte{}, buf[pos:pos+kl]...)
+			pos += kl
+			flag := buf[pos]
+			pos++
+			c.length = binary.LittleEndian.Uint32(buf[pos:])
+			pos += 4
+			if flag == overflowValue {
+				c.overflow = binary.LittleEndian.Uint32(buf[pos:])
+				pos += 4
+			} else {
+				c.value = append([]byte{}, buf[pos:pos+int(c.length)]...)
+				pos += int(c.length)
+			}
+			n.cells = append(n.cells, c)
+		}
+		return n, nil
+	}
+
+	n.children = append(n.children, binary.LittleEndian.Uint32(buf[pos:]))
+	pos += 4
+	for i := 0; i < nkeys; i++ {
+		kl := int(binary.LittleEndian.Uint16(buf[pos:]))
+		pos += 2
+		n.keys = append(n.keys, append([]byte{}, buf[pos:pos+kl]...))
+		pos += kl
+		n.children = append(n.children, binary.LittleEndian.Uint32(buf[pos:]))
+		pos += 4
+	}
+	return n, nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return append(buf, b...)
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return append(buf, b...)
+}
@@ -0,0 +1,279 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+const DefaultSize = 1024
+
+type Frame struct {
+	pgno  uint32
+	data  []byte
+	pin   int
+	dirty bool
+	ref   bool
+}
+
+func (f *Frame) PageNo() uint32 {
+	return f.pgno
+}
+
+func (f *Frame) Data() []byte {
+	return f.data
+}
+
+type Stats struct {
+	Size      int
+	Pages     int
+	Pinned    int
+	Dirty     int
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Writes    int64
+}
+
+type Pool struct {
+	pager  *pager.Pager
+	size   int
+	frames []*Frame
+	pages  map[uint32]int
+	hand   int
+
+	hits      int64
+	misses    int64
+	evictions int64
+	writes    int64
+}
+
+func New(p *pager.Pager, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		pager: p,
+		size:  size,
+		pages: make(map[uint32]int),
+	}
+}
+
+func (b *Pool) Pager() *pager.Pager {
+	return b.pager
+}
+
+func (b *Pool) Size() int {
+	return b.size
+}
+
+func (b *Pool) SetSize(size int) error {
+	if size < 1 {
+		return errors.New(fmt.Sprintf("Invalid buffer pool size %d", size))
+	}
+	b.size = size
+	b.compact()
+	for len(b.pages) > b.size {
+		n, err := b.victim()
+		if err != nil {
+			return err
+		}
+		if err := b.evict(n); err != nil {
+			return err
+		}
+		b.compact()
+	}
+	return nil
+}
+
+func (b *Pool) compact() {
+	frames := []*Frame{}
+	for _, f := range b.frames {
+		if f.pgno != 0 {
+			frames = append(frames, f)
+		}
+	}
+	b.frames = frames
+	b.pages = make(map[uint32]int)
+	for n, f := range b.frames {
+		b.pages[f.pgno] = n
+	}
+	b.hand = 0
+}
+
+func (b *Pool) Stats() Stats {
+	s := Stats{
+		Size:      b.size,
+		Pages:     len(b.pages),
+		Hits:      b.hits,
+		Misses:    b.misses,
+		Evictions: b.evictions,
+		Writes:    b.writes,
+	}
+	for _, n := range b.pages {
+		if b.frames[n].pin > 0 {
+			s.Pinned++
+		}
+		if b.frames[n].dirty {
+			s.Dirty++
+		}
+	}
+	return s
+}
+
+func (b *Pool) ResetStats() {
+	b.hits = 0
+	b.misses = 0
+	b.evictions = 0
+	b.writes = 0
+}
+
+func (b *Pool) Fetch(pgno uint32) (*Frame, error) {
+	if n, exists := b.pages[pgno]; exists {
+		f := b.frames[n]
+		f.pin++
+		f.ref = true
+		b.hits++
+		return f, nil
+	}
+
+	b.misses++
+	data, err := b.pager.Read(pgno)
+	if err != nil {
+		return nil, err
+	}
+	f, err := b.frame(pgno)
+	if err != nil {
+		return nil, err
+	}
+	copy(f.data, data)
+	return f, nil
+}
+
+func (b *Pool) Unpin(f *Frame, dirty bool) {
+	if f.pin > 0 {
+		f.pin--
+	}
+	if dirty {
+		f.dirty = true
+	}
+}
+
+func (b *Pool) Allocate() (*Frame, error) {
+	pgno, err := b.pager.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	f, err := b.frame(pgno)
+	if err != nil {
+		return nil, err
+	}
+	for i := range f.data {
+		f.data[i] = 0
+	}
+	return f, nil
+}
+
+func (b *Pool) Free(pgno uint32) error {
+	if n, exists := b.pages[pgno]; exists {
+		f := b.frames[n]
+		if f.pin > 0 {
+			return errors.New(fmt.Sprintf("Page (%d) is pinned", pgno))
+		}
+		delete(b.pages, pgno)
+		f.pgno = 0
+		f.dirty = false
+		f.ref = false
+	}
+	return b.pager.Free(pgno)
+}
+
+func (b *Pool) Flush() error {
+	for _, n := range b.pages {
+		if err := b.write(b.frames[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Pool) Discard() {
+	b.frames = []*Frame{}
+	b.pages = make(map[uint32]int)
+	b.hand = 0
+}
+
+func (b *Pool) frame(pgno uint32) (*Frame, error) {
+	var f *Frame
+	n := 0
+	if len(b.frames) < b.size {
+		f = &Frame{data: make([]byte, pager.PageSize)}
+		b.frames = append(b.frames, f)
+		n = len(b.frames) - 1
+	} else {
+		v, err := b.victim()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.evict(v); err != nil {
+			return nil, err
+		}
+		f = b.frames[v]
+		n = v
+	}
+
+	f.pgno = pgno
+	f.pin = 1
+	f.dirty = false
+	f.ref = true
+	b.pages[pgno] = n
+	return f, nil
+}
+
+func (b *Pool) victim() (int, error) {
+	for i := 0; i < 2*len(b.frames); i++ {
+		n := b.hand
+		b.hand = (b.hand + 1) % len(b.frames)
+
+		f := b.frames[n]
+		if f.pgno == 0 {
+			return n, nil
+		}
+		if f.pin > 0 {
+			continue
+		}
+		if f.ref {
+			f.ref = false
+			continue
+		}
+		return n, nil
+	}
+	return 0, errors.New("Buffer pool is exhausted: all pages are pinned")
+}
+
+func (b *Pool) evict(n int) error {
+	f := b.frames[n]
+	if f.pgno == 0 {
+		return nil
+	}
+	if err := b.write(f); err != nil {
+		return err
+	}
+	delete(b.pages, f.pgno)
+	f.pgno = 0
+	b.evictions++
+	return nil
+}
+
+func (b *Pool) write(f *Frame) error {
+	if !f.dirty {
+		return nil
+	}
+	if err := b.pager.Write(f.pgno, f.data); err != nil {
+		return err
+	}
+	f.dirty = false
+	b.writes++
+	return nil
+}
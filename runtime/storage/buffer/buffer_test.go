@@ -0,0 +1,126 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+func openPager(t *testing.T, pages int) (*pager.Pager, func()) {
+	dir, err := ioutil.TempDir("", "buffer")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	p, err := pager.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for i := 0; i < pages; i++ {
+		pgno, err := p.Allocate()
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if err := p.Write(pgno, []byte{byte(pgno)}); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	return p, func() {
+		p.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	p, cleanup := openPager(t, 8)
+	defer cleanup()
+
+	b := New(p, 4)
+	for _, pgno := range []uint32{1, 2, 3, 4, 5, 2, 6, 2} {
+		f, err := b.Fetch(pgno)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", pgno, err)
+		}
+		if f.Data()[0] != byte(pgno) {
+			t.Fatalf("[%d] page content mismatch %d", pgno, f.Data()[0])
+		}
+		b.Unpin(f, false)
+	}
+
+	st := b.Stats()
+	if st.Hits != 2 || st.Misses != 6 || st.Evictions != 2 || st.Pages != 4 {
+		t.Fatalf("stats mismatch %#+v", st)
+	}
+	if _, exists := b.pages[3]; exists {
+		t.Fatalf("unreferenced page is not evicted")
+	}
+}
+
+func TestDirty(t *testing.T) {
+	p, cleanup := openPager(t, 4)
+	defer cleanup()
+
+	b := New(p, 2)
+	f, err := b.Fetch(1)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	f.Data()[0] = 100
+	b.Unpin(f, true)
+
+	if buf, _ := p.Read(1); buf[0] != 1 {
+		t.Fatalf("dirty page is written before eviction")
+	}
+
+	for _, pgno := range []uint32{2, 3, 4} {
+		f, err := b.Fetch(pgno)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", pgno, err)
+		}
+		b.Unpin(f, false)
+	}
+	if buf, _ := p.Read(1); buf[0] != 100 {
+		t.Fatalf("dirty page is not written on eviction")
+	}
+	if b.Stats().Writes != 1 {
+		t.Fatalf("write count mismatch %#+v", b.Stats())
+	}
+}
+
+func TestPinned(t *testing.T) {
+	p, cleanup := openPager(t, 4)
+	defer cleanup()
+
+	b := New(p, 2)
+	f1, _ := b.Fetch(1)
+	f2, _ := b.Fetch(2)
+	if _, err := b.Fetch(3); err == nil {
+		t.Fatalf("pinned page is evicted")
+	}
+	if err := b.Free(1); err == nil {
+		t.Fatalf("pinned page is freed")
+	}
+
+	b.Unpin(f2, true)
+	f3, err := b.Fetch(3)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if f3.PageNo() != 3 || f1.PageNo() != 1 {
+		t.Fatalf("frame mismatch")
+	}
+	b.Unpin(f3, false)
+
+	if err := b.SetSize(1); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if st := b.Stats(); st.Pages != 1 || st.Pinned != 1 {
+		t.Fatalf("stats mismatch %#+v", st)
+	}
+	b.Unpin(f1, false)
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+type crashState struct {
+	walSize int64
+	tables  map[string]map[int64][]int
+}
+
+func snapshot(t *testing.T, s *StorageController) map[string]map[int64][]int {
+	tables := map[string]map[int64][]int{}
+	for _, name := range s.ReadTables() {
+		rows := map[int64][]int{}
+		for rowid, row := range scanAll(t, s, name) {
+			vals := []int{}
+			for _, v := range row {
+				vals = append(vals, v.Integral)
+			}
+			rows[rowid] = vals
+		}
+		tables[name] = rows
+	}
+	return tables
+}
+
+// runWorkload applies a mix of modifications and records the database state
+// together with the WAL size after every commit.
+func runWorkload(t *testing.T, s *StorageController) []crashState {
+	states := []crashState{{walSize: s.WALStats().Size, tables: snapshot(t, s)}}
+	record := func() {
+		states = append(states, crashState{walSize: s.WALStats().Size, tables: snapshot(t, s)})
+	}
+
+	r := rand.New(rand.NewSource(30))
+	if err := s.CreateTable("tbl", []string{"colA", "colB"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	record()
+	if err := s.CreateIndex("tbl", "idx", []string{"colA"}, true); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	record()
+	for i := 0; i < 60; i++ {
+		if _, err := s.Insert("tbl", integers(i, r.Intn(1000))); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", i, err)
+		}
+		record()
+	}
+	if _, err := s.Insert("tbl", integers(1, 0)); err == nil {
+		t.Fatalf("Unique constraint is not checked")
+	}
+	record()
+	for i := 0; i < 20; i++ {
+		rowid := int64(r.Intn(60) + 1)
+		if r.Intn(2) == 0 {
+			s.Delete("tbl", rowid)
+		} else {
+			s.Update("tbl", rowid, integers(1000+i, r.Intn(1000)))
+		}
+		record()
+	}
+	if err := s.CreateTable("other", []string{"colX"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	record()
+	if _, err := s.Insert("other", integers(42)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	record()
+	if err := s.DropTable("other"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	record()
+	return states
+}
+
+func expectedState(states []crashState, size int64) crashState {
+	expected := states[0]
+	for _, st := range states {
+		if st.walSize <= size {
+			expected = st
+		}
+	}
+	return expected
+}
+
+func reopen(t *testing.T, db []byte, wal []byte) map[string]map[int64][]int {
+	dir, err := ioutil.TempDir("", "crash")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "test.db")
+	if err := ioutil.WriteFile(fn, db, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(pager.WALPath(fn), wal, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	s := New()
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.Close()
+	return snapshot(t, s)
+}
+
+func TestCrashRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "test.db")
+
+	s := New()
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.SetAutoCheckpoint(0); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	states := runWorkload(t, s)
+
+	db, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	wal, err := ioutil.ReadFile(pager.WALPath(fn))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	final := states[len(states)-1]
+	if int64(len(wal)) != final.walSize {
+		t.Fatalf("WAL size mismatch %d, %d", len(wal), final.walSize)
+	}
+
+	cuts := []int64{0, 1}
+	for _, st := range states {
+		cuts = append(cuts, st.walSize-1, st.walSize, st.walSize+1)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		cuts = append(cuts, r.Int63n(int64(len(wal))))
+	}
+
+	// A crash in the middle of a write leaves a prefix of the log.
+	for _, cut := range cuts {
+		if cut < 0 || cut > int64(len(wal)) {
+			continue
+		}
+		got := reopen(t, db, wal[:cut])
+		if expected := expectedState(states, cut); !reflect.DeepEqual(got, expected.tables) {
+			t.Fatalf("[cut %d] state mismatch\nexpected %v\ngot      %v", cut, expected.tables, got)
+		}
+	}
+
+	// A crash that damages bytes of the log loses everything from the
+	// damaged record.
+	for i := 0; i < 30; i++ {
+		pos := 16 + r.Int63n(int64(len(wal))-16)
+		damaged := append([]byte{}, wal...)
+		damaged[pos] ^= 0xff
+		got := reopen(t, db, damaged)
+		if expected := expectedState(states, pos); !reflect.DeepEqual(got, expected.tables) {
+			t.Fatalf("[damage %d] state mismatch\nexpected %v\ngot      %v", pos, expected.tables, got)
+		}
+	}
+
+	// A crash during a checkpoint leaves a partially written data file
+	// while the log is still intact.
+	partial := append([]byte{}, db...)
+	for len(partial) < len(db)+8*pager.PageSize {
+		partial = append(partial, 0xaa)
+	}
+	for n := 0; n < len(partial) && n < 3*pager.PageSize; n++ {
+		partial[n] = 0xaa
+	}
+	if got := reopen(t, partial, wal); !reflect.DeepEqual(got, final.tables) {
+		t.Fatalf("[checkpoint] state mismatch\nexpected %v\ngot      %v", final.tables, got)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if st, err := os.Stat(pager.WALPath(fn)); err != nil || st.Size() > 16 {
+		t.Fatalf("WAL is not checkpointed on close")
+	}
+	s = New()
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.Close()
+	if got := snapshot(t, s); !reflect.DeepEqual(got, final.tables) {
+		t.Fatalf("state mismatch after checkpoint %v", got)
+	}
+}
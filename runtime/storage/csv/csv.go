@@ -2,6 +2,7 @@ package csv
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"os"
@@ -12,66 +13,330 @@ import (
 )
 
 func Read(fn string) (*table.TableValue, error) {
-	tbl := &table.TableValue{}
+	return ReadColumns(fn, nil)
+}
+
+// ReadColumns reads a CSV file parsing only the values of cols. The other
+// columns are left out of the rows. A nil cols reads every column.
+func ReadColumns(fn string, cols []string) (*table.TableValue, error) {
+	return ReadColumnsContext(context.Background(), fn, cols)
+}
 
+// checkInterval is the number of lines read between two checks of the
+// context of a read.
+const checkInterval = 1024
+
+// ReadColumnsContext is ReadColumns stopping with the error of ctx once it
+// is done.
+func ReadColumnsContext(ctx context.Context, fn string, cols []string) (*table.TableValue, error) {
+	tbl := &table.TableValue{}
 	f, err := os.Open(fn)
 	if err != nil {
 		return tbl, errors.New("Reading Error")
 	}
 	defer f.Close()
 
-	ri := bufio.NewReader(f)
+	rs := newRecords(f)
+	tbl.Header, err = rs.header()
+	if err != nil {
+		return tbl, err
+	}
+	return tbl, rs.read(ctx, tbl, cols)
+}
+
+// scanner finds the ends of the records of a CSV file. A record ends with
+// a newline that is not inside a quoted value, and a backslash escapes the
+// character that follows it.
+type scanner struct {
+	inQuote bool
+	escaped bool
+}
 
-	line := ""
+// step moves s over ch and reports whether ch ends a record.
+func (s *scanner) step(ch byte) bool {
+	switch {
+	case s.escaped:
+		s.escaped = false
+	case ch == '\\':
+		s.escaped = true
+	case ch == '"':
+		s.inQuote = !s.inQuote
+	case ch == '\n':
+		return !s.inQuote
+	}
+	return false
+}
 
-	for ln := 0; ; ln++ {
-		l, cont, err := ri.ReadLine()
-		if err == io.EOF {
+// records reads the records of a CSV file one at a time. offset is the
+// number of bytes read so far. The records starting at or after end are
+// not read, unless it is negative.
+type records struct {
+	r      *bufio.Reader
+	s      scanner
+	buf    []byte
+	offset int64
+	end    int64
+}
+
+func newRecords(r io.Reader) *records {
+	return &records{r: bufio.NewReader(r), end: -1}
+}
+
+// next returns the next record without its line ending, or io.EOF once
+// there is none.
+func (rs *records) next() (string, error) {
+	rs.buf = rs.buf[:0]
+	rs.s = scanner{}
+	for {
+		ch, err := rs.r.ReadByte()
+		if err == io.EOF && len(rs.buf) != 0 {
 			break
 		}
 		if err != nil {
-			return tbl, err
+			return "", err
 		}
-		line += string(l)
+		rs.offset++
+		if rs.s.step(ch) {
+			break
+		}
+		rs.buf = append(rs.buf, ch)
+	}
+	return strings.TrimRight(string(rs.buf), "\r"), nil
+}
 
-		if !cont {
-			if ln == 0 {
-				cols := []string{}
-				if strings.HasPrefix(line, "#") {
-					line = line[1:]
-				}
-				cols, err = splitColumn(line)
-				if err != nil {
-					return tbl, err
-				}
-				tbl.Header = cols
-			} else {
-				if !strings.HasPrefix(line, "#") {
-					colsValue, _ := splitColumn(line)
-					lineValue := map[string]table.ColumnValue{}
-					for n, c := range colsValue {
-						c = strings.Trim(c, " ")
-						iv, err := strconv.ParseInt(c, 10, 64)
-						if err != nil {
-							return tbl, err
-						}
-						t := table.ColumnValue{
-							Name: tbl.Header[n],
-							Value: table.Value{
-								Type:     table.Integer,
-								Integral: int(iv),
-							},
-						}
-						lineValue[tbl.Header[n]] = t
-					}
-					tbl.Values = append(tbl.Values, lineValue)
-				}
+// header reads the first record as the column names.
+func (rs *records) header() ([]string, error) {
+	line, err := rs.next()
+	if err == io.EOF {
+		return []string{}, nil
+	}
+	if err != nil {
+		return []string{}, err
+	}
+	return splitColumn(strings.TrimPrefix(line, "#"))
+}
+
+// read appends the remaining records to tbl, parsing only the values of
+// cols.
+func (rs *records) read(ctx context.Context, tbl *table.TableValue, cols []string) error {
+	return rs.scan(ctx, tbl.Header, cols, func(_ int64, row map[string]table.ColumnValue) error {
+		tbl.Values = append(tbl.Values, row)
+		return nil
+	})
+}
+
+// wantedColumns returns the set of cols, or nil for every column.
+func wantedColumns(cols []string) map[string]bool {
+	if cols == nil {
+		return nil
+	}
+	wanted := map[string]bool{}
+	for _, c := range cols {
+		wanted[c] = true
+	}
+	return wanted
+}
+
+// scan passes the remaining records to fn one at a time with their offset,
+// parsing only the values of cols. Records starting with # are comments.
+func (rs *records) scan(ctx context.Context, header []string, cols []string, fn func(int64, map[string]table.ColumnValue) error) error {
+	wanted := wantedColumns(cols)
+	for n := 0; ; n++ {
+		if n%checkInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		offset := rs.offset
+		if rs.end >= 0 && offset >= rs.end {
+			return nil
+		}
+		line, err := rs.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		row, err := parseRow(header, line, wanted)
+		if err != nil {
+			return err
+		}
+		if err := fn(offset, row); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanColumns reads a CSV file as ReadColumnsContext does, but passes the
+// rows to fn one at a time instead of keeping them. A row holds the values
+// of cols in the order of the header, a missing one being NULL. Reading
+// stops with the error fn returns.
+func ScanColumns(ctx context.Context, fn string, cols []string, each func([]table.ColumnValue) error) error {
+	return ScanOffsets(ctx, fn, cols, func(_ int64, row []table.ColumnValue) error {
+		return each(row)
+	})
+}
+
+// ScanOffsets is ScanColumns also passing the offset in the file where
+// each row starts.
+func ScanOffsets(ctx context.Context, fn string, cols []string, each func(int64, []table.ColumnValue) error) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return errors.New("Reading Error")
+	}
+	defer f.Close()
+
+	rs := newRecords(f)
+	header, err := rs.header()
+	if err != nil {
+		return err
+	}
+	return rs.scan(ctx, header, cols, offsetColumns(header, cols, each))
+}
+
+// ReadAt reads the records of a CSV file that start at offsets, as
+// ScanColumns does, and returns the number of bytes read. The rest of the
+// file is not read.
+func ReadAt(fn string, cols []string, offsets []int64, each func([]table.ColumnValue) error) (int64, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, errors.New("Reading Error")
+	}
+	defer f.Close()
+
+	rs := newRecords(f)
+	header, err := rs.header()
+	if err != nil {
+		return rs.offset, err
+	}
+	read := rs.offset
+	row := columns(header, cols, each)
+	wanted := wantedColumns(cols)
+	for _, offset := range offsets {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return read, err
+		}
+		rs := newRecords(f)
+		line, err := rs.next()
+		read += rs.offset
+		if err != nil {
+			return read, err
+		}
+		values, err := parseRow(header, line, wanted)
+		if err != nil {
+			return read, err
+		}
+		if err := row(offset, values); err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// columns turns the rows of a file into lists of the values of cols in the
+// order of header, which it passes to fn.
+func columns(header []string, cols []string, fn func([]table.ColumnValue) error) func(int64, map[string]table.ColumnValue) error {
+	return offsetColumns(header, cols, func(_ int64, row []table.ColumnValue) error {
+		return fn(row)
+	})
+}
+
+// offsetColumns is columns passing the offset of the rows along.
+func offsetColumns(header []string, cols []string, fn func(int64, []table.ColumnValue) error) func(int64, map[string]table.ColumnValue) error {
+	kept := []string{}
+	for _, h := range header {
+		for _, c := range cols {
+			if c == h {
+				kept = append(kept, h)
+				break
+			}
+		}
+		if cols == nil {
+			kept = append(kept, h)
+		}
+	}
+	return func(offset int64, row map[string]table.ColumnValue) error {
+		values := make([]table.ColumnValue, 0, len(kept))
+		for _, h := range kept {
+			v, exists := row[h]
+			if !exists {
+				v = table.ColumnValue{Name: h, Value: table.Value{Type: table.Null}}
 			}
+			values = append(values, v)
+		}
+		return fn(offset, values)
+	}
+}
 
-			line = ""
+func parseRow(header []string, line string, wanted map[string]bool) (map[string]table.ColumnValue, error) {
+	colsValue, _ := splitColumn(line)
+	lineValue := map[string]table.ColumnValue{}
+	for n, c := range colsValue {
+		if wanted != nil && !wanted[header[n]] {
+			continue
+		}
+		c = strings.Trim(c, " ")
+		if c == "" {
+			lineValue[header[n]] = table.ColumnValue{
+				Name: header[n],
+				Value: table.Value{
+					Type: table.Null,
+				},
+			}
+			continue
+		}
+		iv, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			return lineValue, err
 		}
+		lineValue[header[n]] = table.ColumnValue{
+			Name: header[n],
+			Value: table.Value{
+				Type:     table.Integer,
+				Integral: int(iv),
+			},
+		}
+	}
+	return lineValue, nil
+}
+
+func Write(fn string, tbl *table.TableValue) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return errors.New("Writing Error")
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	cols := []string{}
+	for _, h := range tbl.Header {
+		cols = append(cols, quoteColumn(h))
+	}
+	w.WriteString("#" + strings.Join(cols, ", ") + "\n")
+
+	for _, row := range tbl.Values {
+		vals := []string{}
+		for _, h := range tbl.Header {
+			v := row[h].Value
+			switch v.Type {
+			case table.Integer:
+				vals = append(vals, strconv.Itoa(v.Integral))
+			default:
+				vals = append(vals, "")
+			}
+		}
+		w.WriteString(strings.Join(vals, ",") + "\n")
+	}
+	return w.Flush()
+}
+
+func quoteColumn(col string) string {
+	if !strings.ContainsAny(col, ", ") {
+		return col
 	}
-	return tbl, nil
+	return "\"" + col + "\""
 }
 
 func splitColumn(line string) ([]string, error) {
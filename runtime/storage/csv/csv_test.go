@@ -1,6 +1,16 @@
 package csv
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
 
 func TestSplitColumn(t *testing.T) {
 	testCases := []struct {
@@ -37,3 +47,161 @@ func TestSplitColumn(t *testing.T) {
 		}
 	}
 }
+
+func TestReadColumns(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "tbl.csv")
+	if err := ioutil.WriteFile(fn, []byte("#colA, colB, colC\n1,x,3\n4,y,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(fn); err == nil {
+		t.Fatalf("colB is parsed")
+	}
+
+	tbl, err := ReadColumns(fn, []string{"colC", "colA"})
+	if err != nil {
+		t.Fatalf("Unexpected Exception: %s", err)
+	}
+	if len(tbl.Header) != 3 || len(tbl.Values) != 2 {
+		t.Fatalf("Length mismatch %d %d", len(tbl.Header), len(tbl.Values))
+	}
+	for n, expected := range []int{1, 4} {
+		row := tbl.Values[n]
+		if _, exists := row["colB"]; exists {
+			t.Fatalf("[%d] colB is read", n)
+		}
+		if row["colA"].Value.Integral != expected {
+			t.Fatalf("[%d] colA mismatch %d", n, row["colA"].Value.Integral)
+		}
+	}
+	if tbl.Values[0]["colC"].Value.Integral != 3 || tbl.Values[1]["colC"].Value.Type != table.Null {
+		t.Fatalf("colC mismatch %v", tbl.Values)
+	}
+
+	rows := [][]table.ColumnValue{}
+	err = ScanColumns(context.Background(), fn, []string{"colC", "colA"}, func(row []table.ColumnValue) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Exception: %s", err)
+	}
+	// The values are in the order of the header.
+	if len(rows) != 2 || len(rows[0]) != 2 || rows[0][0].Name != "colA" || rows[0][0].Value.Integral != 1 || rows[0][1].Value.Integral != 3 || rows[1][1].Value.Type != table.Null {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = ScanColumns(context.Background(), fn, []string{"colA"}, func(row []table.ColumnValue) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Fatalf("Reading does not stop %v %d", err, n)
+	}
+
+	// The records, which do not parse, are not read for the header.
+	header, err := ReadHeader(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Exception: %s", err)
+	}
+	if fmt.Sprintf("%q", header) != fmt.Sprintf("%q", tbl.Header) {
+		t.Fatalf("header mismatch %q", header)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("#colA, colB\n")
+	for i := 0; i < 100; i++ {
+		if i%10 == 5 {
+			// A comment with a quoted newline, which must not end a range.
+			sb.WriteString("#\"x\n1,2\n\\\"y\"\n")
+		}
+		fmt.Fprintf(&sb, "%d,\"%d\"\n", i, i*2)
+	}
+	fn := filepath.Join(t.TempDir(), "tbl.csv")
+	if err := ioutil.WriteFile(fn, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	misaligned := 0
+	for _, n := range []int{1, 2, 3, 7, 16, 200} {
+		ranges, err := Split(fn, n)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Exception: %s", n, err)
+		}
+		if len(ranges) != n {
+			t.Fatalf("[%d] expected %d ranges but got %d", n, n, len(ranges))
+		}
+		i := 0
+		next := ranges[0].Start
+		for rn, rg := range ranges {
+			if rn != 0 && rg.Start != ranges[rn-1].End {
+				t.Fatalf("[%d] range %d does not follow the previous one %v", n, rn, ranges)
+			}
+			// A range starting inside a record is read from where the
+			// previous one ended.
+			if next != rg.Start {
+				misaligned++
+				rg.Start = next
+			}
+			if rg.Start >= rg.End {
+				continue
+			}
+			next, err = ScanColumnsRange(context.Background(), fn, nil, rg, func(row []table.ColumnValue) error {
+				if row[0].Value.Integral != i || row[1].Value.Integral != i*2 {
+					t.Fatalf("[%d] row %d mismatch %v", n, i, row)
+				}
+				i++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("[%d] range %d: Unexpected Exception: %s", n, rn, err)
+			}
+		}
+		if i != 100 {
+			t.Fatalf("[%d] expected 100 rows but got %d", n, i)
+		}
+	}
+	if misaligned == 0 {
+		t.Fatalf("No range starts inside a quoted value")
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	content := "#colA, colB\n1,2\n#comment\n3,\"4\"\n5,6\n"
+	fn := filepath.Join(t.TempDir(), "tbl.csv")
+	if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int64{}
+	err := ScanOffsets(context.Background(), fn, []string{"colB"}, func(offset int64, row []table.ColumnValue) error {
+		if len(row) != 1 || row[0].Name != "colB" {
+			t.Fatalf("row mismatch %v", row)
+		}
+		offsets = append(offsets, offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Exception: %s", err)
+	}
+	if fmt.Sprintf("%v", offsets) != "[12 25 31]" {
+		t.Fatalf("offsets mismatch %v", offsets)
+	}
+
+	rows := [][]table.ColumnValue{}
+	read, err := ReadAt(fn, []string{"colA"}, []int64{offsets[2], offsets[0]}, func(row []table.ColumnValue) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Exception: %s", err)
+	}
+	if len(rows) != 2 || len(rows[0]) != 1 || rows[0][0].Value.Integral != 5 || rows[1][0].Value.Integral != 1 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+	// The header and the two records.
+	if read != 12+4+4 {
+		t.Fatalf("expected %d bytes read but got %d", 20, read)
+	}
+}
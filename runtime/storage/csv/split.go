@@ -0,0 +1,134 @@
+package csv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// Range is the byte range [Start, End) of a CSV file holding whole records.
+type Range struct {
+	Start int64
+	End   int64
+}
+
+// ReadHeader reads the column names of a CSV file from its first record,
+// without reading the others.
+func ReadHeader(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return []string{}, errors.New("Reading Error")
+	}
+	defer f.Close()
+	cols, _, err := readHeader(f)
+	return cols, err
+}
+
+// readHeader reads the header of a CSV file and returns it with the offset
+// of the first record.
+func readHeader(f *os.File) ([]string, int64, error) {
+	rs := newRecords(f)
+	cols, err := rs.header()
+	if err != nil {
+		return cols, 0, err
+	}
+	return cols, rs.offset, nil
+}
+
+// Split divides the records of a CSV file into n ranges of about the same
+// size. The file is cut at n - 1 offsets, each moved to the start of the
+// next line, so only the bytes up to a newline are read around each cut.
+// A newline inside a quoted value does not end a record, so a range may
+// start inside one: ScanColumnsRange returns where the next range really
+// starts, and a range that does not start there has to be read again from
+// that offset. Ranges may be empty.
+func Split(fn string, n int) ([]Range, error) {
+	if n < 1 {
+		return []Range{}, errors.New("Number of ranges must be positive")
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return []Range{}, errors.New("Reading Error")
+	}
+	defer f.Close()
+	_, start, err := readHeader(f)
+	if err != nil {
+		return []Range{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return []Range{}, err
+	}
+	size := fi.Size()
+
+	ranges := []Range{}
+	from := start
+	for k := 1; k < n; k++ {
+		end, err := nextLine(f, start+(size-start)*int64(k)/int64(n), size)
+		if err != nil {
+			return []Range{}, err
+		}
+		if end < from {
+			end = from
+		}
+		ranges = append(ranges, Range{Start: from, End: end})
+		from = end
+	}
+	return append(ranges, Range{Start: from, End: size}), nil
+}
+
+// nextLine returns the offset of the first line of f starting at or after
+// offset, or size if there is none.
+func nextLine(f *os.File, offset int64, size int64) (int64, error) {
+	if offset <= 0 || offset >= size {
+		return offset, nil
+	}
+	if _, err := f.Seek(offset-1, io.SeekStart); err != nil {
+		return 0, err
+	}
+	ri := bufio.NewReader(f)
+	pos := offset - 1
+	for {
+		ch, err := ri.ReadByte()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos++
+		if ch == '\n' {
+			return pos, nil
+		}
+	}
+}
+
+// ScanColumnsRange is ScanColumns reading only the records that start in
+// rg, rg.Start being taken as the start of a record. The last one is read
+// to its end even past rg.End. It returns the offset of the first record
+// starting at or after rg.End, or the size of the file, which is where the
+// next range starts.
+func ScanColumnsRange(ctx context.Context, fn string, cols []string, rg Range, each func([]table.ColumnValue) error) (int64, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, errors.New("Reading Error")
+	}
+	defer f.Close()
+
+	header, _, err := readHeader(f)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(rg.Start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	rs := newRecords(f)
+	rs.offset = rg.Start
+	rs.end = rg.End
+	err = rs.scan(ctx, header, cols, columns(header, cols, each))
+	return rs.offset, err
+}
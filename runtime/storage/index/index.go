@@ -0,0 +1,420 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+const magic = "SIDX"
+const version = 3
+
+// Entry is a key of the index and the row it is in. Bit n of Nulls is set
+// when part n of the key is NULL, which comes before the other values.
+// Offset is where the row starts in the file of the table, or -1 when the
+// index does not know.
+type Entry struct {
+	Key    []int
+	Nulls  int64
+	Row    int
+	Offset int64
+}
+
+type Index struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+	Entries []Entry
+
+	SourceSize    int64
+	SourceModTime int64
+
+	version int64
+}
+
+// New returns an empty index on cols of a table whose columns are header.
+// Its rows are added by Add and it is ready once Finish has sorted them.
+func New(name string, tbl string, header []string, cols []string, unique bool) (*Index, error) {
+	idx := &Index{
+		Name:    name,
+		Table:   tbl,
+		Columns: cols,
+		Unique:  unique,
+		version: version,
+	}
+
+	for _, c := range cols {
+		found := false
+		for _, h := range header {
+			if h == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return idx, errors.New(fmt.Sprintf("Column (%s.%s) Not Found", tbl, c))
+		}
+	}
+	return idx, nil
+}
+
+// Add adds the next row of the table, key being its values of the columns
+// of the index and offset where it starts in the file.
+func (idx *Index) Add(key []table.Value, offset int64) {
+	e := Entry{Key: make([]int, len(key)), Row: idx.rows(), Offset: offset}
+	for n, v := range key {
+		if v.Type != table.Integer {
+			e.Nulls |= 1 << uint(n)
+			continue
+		}
+		e.Key[n] = v.Integral
+	}
+	idx.Entries = append(idx.Entries, e)
+}
+
+func (idx *Index) rows() int {
+	if len(idx.Entries) == 0 {
+		return 0
+	}
+	return idx.Entries[len(idx.Entries)-1].Row + 1
+}
+
+// Finish sorts the entries added and checks that a unique index has no
+// duplicated key. Keys with a NULL part are never duplicated.
+func (idx *Index) Finish() error {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		return compareEntries(idx.Entries[i], idx.Entries[j]) < 0
+	})
+
+	if idx.Unique {
+		for n := 1; n < len(idx.Entries); n++ {
+			if idx.Entries[n].Nulls == 0 && compareEntries(idx.Entries[n-1], idx.Entries[n]) == 0 {
+				return errors.New(fmt.Sprintf("Index (%s) UNIQUE constraint failed: %v", idx.Name, idx.Entries[n].Key))
+			}
+		}
+	}
+	return nil
+}
+
+func Build(name string, tbl string, tv *table.TableValue, cols []string, unique bool) (*Index, error) {
+	idx, err := New(name, tbl, tv.Header, cols, unique)
+	if err != nil {
+		return idx, err
+	}
+	for _, row := range tv.Values {
+		key := []table.Value{}
+		for _, c := range cols {
+			key = append(key, row[c].Value)
+		}
+		idx.Add(key, -1)
+	}
+	return idx, idx.Finish()
+}
+
+// compare compares the first len(key) parts of the key of e with key.
+func compare(e Entry, key []int) int {
+	for n := 0; n < len(e.Key) && n < len(key); n++ {
+		if e.Nulls&(1<<uint(n)) != 0 {
+			return -1
+		}
+		if e.Key[n] < key[n] {
+			return -1
+		}
+		if e.Key[n] > key[n] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareEntries(a Entry, b Entry) int {
+	for n := 0; n < len(a.Key) && n < len(b.Key); n++ {
+		if c := comparePart(a, b, n); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func comparePart(a Entry, b Entry, n int) int {
+	an, bn := a.Nulls&(1<<uint(n)) != 0, b.Nulls&(1<<uint(n)) != 0
+	switch {
+	case an && bn:
+		return 0
+	case an:
+		return -1
+	case bn:
+		return 1
+	case a.Key[n] < b.Key[n]:
+		return -1
+	case a.Key[n] > b.Key[n]:
+		return 1
+	}
+	return 0
+}
+
+func (idx *Index) lowerBound(key []int) int {
+	return sort.Search(len(idx.Entries), func(i int) bool {
+		return compare(idx.Entries[i], key) >= 0
+	})
+}
+
+func (idx *Index) upperBound(key []int) int {
+	return sort.Search(len(idx.Entries), func(i int) bool {
+		return compare(idx.Entries[i], key) > 0
+	})
+}
+
+// nulls returns the number of entries whose first part is NULL, which come
+// first.
+func (idx *Index) nulls() int {
+	return sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Nulls&1 == 0
+	})
+}
+
+func (idx *Index) Lookup(key []int) []int {
+	rows := []int{}
+	for _, e := range idx.Between(key, key) {
+		rows = append(rows, e.Row)
+	}
+	return rows
+}
+
+// Offsets returns the offsets of the rows whose key starts with key, in
+// the order of the file.
+func (idx *Index) Offsets(key []int) []int64 {
+	offsets := []int64{}
+	for _, e := range idx.Between(key, key) {
+		offsets = append(offsets, e.Offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	return offsets
+}
+
+// Outdated tells whether the index was loaded from a file of an older
+// version and has to be built again.
+func (idx *Index) Outdated() bool {
+	return idx.version < version
+}
+
+func (idx *Index) Range(lo []int, loInclusive bool, hi []int, hiInclusive bool) []int {
+	rows := []int{}
+	for _, e := range idx.span(lo, loInclusive, hi, hiInclusive) {
+		rows = append(rows, e.Row)
+	}
+	return rows
+}
+
+// Between returns the entries whose key starts with a value from lo to hi,
+// both included, in the order of the index. A nil bound leaves the range
+// open on its side. The keys starting with NULL are only in the range
+// open on both sides.
+func (idx *Index) Between(lo []int, hi []int) []Entry {
+	return idx.span(lo, true, hi, true)
+}
+
+func (idx *Index) span(lo []int, loInclusive bool, hi []int, hiInclusive bool) []Entry {
+	start := 0
+	switch {
+	case lo != nil && loInclusive:
+		start = idx.lowerBound(lo)
+	case lo != nil:
+		start = idx.upperBound(lo)
+	case hi != nil:
+		start = idx.nulls()
+	}
+	end := len(idx.Entries)
+	if hi != nil {
+		if hiInclusive {
+			end = idx.upperBound(hi)
+		} else {
+			end = idx.lowerBound(hi)
+		}
+	}
+	if end < start {
+		end = start
+	}
+	return idx.Entries[start:end]
+}
+
+// Order returns entries sorted by the first part of their key, descending
+// if desc is set, and the entries with the same first part by their row,
+// as a stable sort of the rows of the table on the first column sorts
+// them: NULL comes first, or last when descending.
+func Order(entries []Entry, desc bool) []Entry {
+	sorted := append([]Entry{}, entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		c := comparePart(sorted[i], sorted[j], 0)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return sorted[i].Row < sorted[j].Row
+	})
+	return sorted
+}
+
+func (idx *Index) Save(fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.WriteString(magic)
+	writeInt(w, version)
+	writeString(w, idx.Name)
+	writeString(w, idx.Table)
+	if idx.Unique {
+		writeInt(w, 1)
+	} else {
+		writeInt(w, 0)
+	}
+	writeInt(w, int64(len(idx.Columns)))
+	for _, c := range idx.Columns {
+		writeString(w, c)
+	}
+	writeInt(w, idx.SourceSize)
+	writeInt(w, idx.SourceModTime)
+	writeInt(w, int64(len(idx.Entries)))
+	for _, e := range idx.Entries {
+		for _, k := range e.Key {
+			writeInt(w, int64(k))
+		}
+		writeInt(w, e.Nulls)
+		writeInt(w, int64(e.Row))
+		writeInt(w, e.Offset)
+	}
+	return w.Flush()
+}
+
+func Load(fn string) (*Index, error) {
+	idx := &Index{}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return idx, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, m); err != nil || string(m) != magic {
+		return idx, errors.New(fmt.Sprintf("Index file (%s) is broken", fn))
+	}
+
+	v, err := readInt(r)
+	if err != nil {
+		return idx, err
+	}
+	if v < 1 || v > version {
+		return idx, errors.New(fmt.Sprintf("Index file (%s) unsupported version %d", fn, v))
+	}
+	idx.version = v
+	if idx.Name, err = readString(r); err != nil {
+		return idx, err
+	}
+	if idx.Table, err = readString(r); err != nil {
+		return idx, err
+	}
+	u, err := readInt(r)
+	if err != nil {
+		return idx, err
+	}
+	idx.Unique = u == 1
+
+	ncols, err := readInt(r)
+	if err != nil {
+		return idx, err
+	}
+	for i := int64(0); i < ncols; i++ {
+		c, err := readString(r)
+		if err != nil {
+			return idx, err
+		}
+		idx.Columns = append(idx.Columns, c)
+	}
+	if idx.SourceSize, err = readInt(r); err != nil {
+		return idx, err
+	}
+	if idx.SourceModTime, err = readInt(r); err != nil {
+		return idx, err
+	}
+
+	n, err := readInt(r)
+	if err != nil {
+		return idx, err
+	}
+	for i := int64(0); i < n; i++ {
+		e := Entry{}
+		for c := int64(0); c < ncols; c++ {
+			k, err := readInt(r)
+			if err != nil {
+				return idx, err
+			}
+			e.Key = append(e.Key, int(k))
+		}
+		if v >= 3 {
+			if e.Nulls, err = readInt(r); err != nil {
+				return idx, err
+			}
+		}
+		row, err := readInt(r)
+		if err != nil {
+			return idx, err
+		}
+		e.Row = int(row)
+		e.Offset = -1
+		if v >= 2 {
+			if e.Offset, err = readInt(r); err != nil {
+				return idx, err
+			}
+		}
+		idx.Entries = append(idx.Entries, e)
+	}
+	return idx, nil
+}
+
+func writeInt(w *bufio.Writer, v int64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	w.Write(b)
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeInt(w, int64(len(s)))
+	w.WriteString(s)
+}
+
+func readInt(r io.Reader) (int64, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+func readString(r io.Reader) (string, error) {
+	l, err := readInt(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
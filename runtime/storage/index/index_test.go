@@ -0,0 +1,234 @@
+package index
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func testTable(rows [][]int) *table.TableValue {
+	tv := &table.TableValue{
+		Header: []string{"colA", "colB"},
+	}
+	for _, r := range rows {
+		tv.Values = append(tv.Values, map[string]table.ColumnValue{
+			"colA": {Name: "colA", Value: table.Value{Type: table.Integer, Integral: r[0]}},
+			"colB": {Name: "colB", Value: table.Value{Type: table.Integer, Integral: r[1]}},
+		})
+	}
+	return tv
+}
+
+func TestBuild(t *testing.T) {
+	testCases := []struct {
+		rows   [][]int
+		cols   []string
+		unique bool
+		err    bool
+	}{
+		{
+			rows:   [][]int{{1, 2}, {3, 4}},
+			cols:   []string{"colA"},
+			unique: true,
+			err:    false,
+		},
+		{
+			rows:   [][]int{{1, 2}, {1, 4}},
+			cols:   []string{"colA"},
+			unique: true,
+			err:    true,
+		},
+		{
+			rows:   [][]int{{1, 2}, {1, 4}},
+			cols:   []string{"colA", "colB"},
+			unique: true,
+			err:    false,
+		},
+		{
+			rows:   [][]int{{1, 2}},
+			cols:   []string{"colC"},
+			unique: false,
+			err:    true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		_, err := Build("idx", "tbl", testTable(tc.rows), tc.cols, tc.unique)
+		if err != nil && !tc.err {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if err == nil && tc.err {
+			t.Fatalf("[%d] Expected Error", tn)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	idx, err := Build("idx", "tbl", testTable([][]int{{5, 0}, {1, 1}, {3, 2}, {1, 3}, {9, 4}}), []string{"colA"}, false)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	testCases := []struct {
+		lo          []int
+		loInclusive bool
+		hi          []int
+		hiInclusive bool
+		expected    []int
+	}{
+		{lo: []int{1}, loInclusive: true, hi: []int{1}, hiInclusive: true, expected: []int{1, 3}},
+		{lo: []int{1}, loInclusive: false, hi: []int{5}, hiInclusive: true, expected: []int{2, 0}},
+		{lo: []int{3}, loInclusive: true, hi: nil, expected: []int{2, 0, 4}},
+		{lo: nil, hi: []int{5}, hiInclusive: false, expected: []int{1, 3, 2}},
+		{lo: []int{6}, loInclusive: true, hi: []int{8}, hiInclusive: true, expected: []int{}},
+	}
+
+	for tn, tc := range testCases {
+		rows := idx.Range(tc.lo, tc.loInclusive, tc.hi, tc.hiInclusive)
+		if !reflect.DeepEqual(rows, tc.expected) {
+			t.Fatalf("[%d] Range mismatch %v != %v", tn, rows, tc.expected)
+		}
+	}
+
+	if rows := idx.Lookup([]int{1}); !reflect.DeepEqual(rows, []int{1, 3}) {
+		t.Fatalf("Lookup mismatch %v", rows)
+	}
+	if rows := idx.Lookup([]int{2}); len(rows) != 0 {
+		t.Fatalf("Lookup mismatch %v", rows)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	idx, err := New("idx", "tbl", []string{"colA", "colB"}, []string{"colA"}, true)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for n, v := range []int{5, 1, -1, 3, -1, 9} {
+		key := table.Value{Type: table.Integer, Integral: v}
+		if v < 0 {
+			key = table.Value{Type: table.Null}
+		}
+		idx.Add([]table.Value{key}, int64(n*10))
+	}
+	if err := idx.Finish(); err != nil {
+		t.Fatalf("NULL keys are duplicated: %s", err)
+	}
+
+	rows := func(entries []Entry) []int {
+		rows := []int{}
+		for _, e := range entries {
+			rows = append(rows, e.Row)
+		}
+		return rows
+	}
+	testCases := []struct {
+		lo       []int
+		hi       []int
+		expected []int
+		asc      []int
+		desc     []int
+	}{
+		{nil, nil, []int{2, 4, 1, 3, 0, 5}, []int{2, 4, 1, 3, 0, 5}, []int{5, 0, 3, 1, 2, 4}},
+		{[]int{3}, []int{5}, []int{3, 0}, []int{3, 0}, []int{0, 3}},
+		{nil, []int{3}, []int{1, 3}, []int{1, 3}, []int{3, 1}},
+		{[]int{4}, nil, []int{0, 5}, []int{0, 5}, []int{5, 0}},
+		{[]int{6}, []int{8}, []int{}, []int{}, []int{}},
+	}
+	for tn, tc := range testCases {
+		entries := idx.Between(tc.lo, tc.hi)
+		if actual := rows(entries); !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("[%d] Between mismatch %v", tn, actual)
+		}
+		if actual := rows(Order(entries, false)); !reflect.DeepEqual(actual, tc.asc) {
+			t.Fatalf("[%d] Order mismatch %v", tn, actual)
+		}
+		if actual := rows(Order(entries, true)); !reflect.DeepEqual(actual, tc.desc) {
+			t.Fatalf("[%d] Order DESC mismatch %v", tn, actual)
+		}
+	}
+	if actual := rows(idx.Entries); !reflect.DeepEqual(actual, []int{2, 4, 1, 3, 0, 5}) {
+		t.Fatalf("Order changed the index %v", actual)
+	}
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "tbl.idx.idx")
+	if err := idx.Save(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	loaded, err := Load(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(idx, loaded) {
+		t.Fatalf("Loaded index mismatch %#+v", loaded)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := Build("idx", "tbl", testTable([][]int{{5, 0}, {1, 1}, {3, 2}}), []string{"colA", "colB"}, true)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	idx.SourceSize = 10
+	idx.SourceModTime = 20
+	for n := range idx.Entries {
+		idx.Entries[n].Offset = int64(idx.Entries[n].Row * 4)
+	}
+
+	fn := filepath.Join(dir, "tbl.idx.idx")
+	if err := idx.Save(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	loaded, err := Load(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(idx, loaded) {
+		t.Fatalf("Loaded index mismatch %#+v", loaded)
+	}
+	if loaded.Outdated() {
+		t.Fatalf("Loaded index is outdated")
+	}
+	if offsets := loaded.Offsets([]int{3}); !reflect.DeepEqual(offsets, []int64{8}) {
+		t.Fatalf("Offsets mismatch %v", offsets)
+	}
+
+	// A file of version 1 has no offsets.
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(magic)
+	writeInt(w, 1)
+	writeString(w, "idx")
+	writeString(w, "tbl")
+	writeInt(w, 0)
+	writeInt(w, 1)
+	writeString(w, "colA")
+	writeInt(w, 10)
+	writeInt(w, 20)
+	writeInt(w, 1)
+	writeInt(w, 5)
+	writeInt(w, 0)
+	w.Flush()
+	f.Close()
+	loaded, err = Load(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !loaded.Outdated() || !reflect.DeepEqual(loaded.Entries, []Entry{{Key: []int{5}, Row: 0, Offset: -1}}) {
+		t.Fatalf("Loaded index mismatch %#+v", loaded)
+	}
+}
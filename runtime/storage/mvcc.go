@@ -0,0 +1,653 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/yakawa/simpleDB/runtime/storage/btree"
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+const scanChunk = 256
+
+// version is the image of a row before a modification. csn is the commit
+// sequence number of the modification, or 0 while it is not committed.
+type version struct {
+	csn    uint64
+	exists bool
+	row    []table.Value
+}
+
+type rowRef struct {
+	table string
+	rowid int64
+}
+
+type scanRow struct {
+	rowid int64
+	row   []table.Value
+}
+
+func (s *StorageController) addVersion(name string, rowid int64, before []table.Value, exists bool) {
+	if _, ok := s.versions[name]; !ok {
+		s.versions[name] = make(map[int64][]version)
+	}
+	s.versions[name][rowid] = append(s.versions[name][rowid], version{exists: exists, row: before})
+	s.pending = append(s.pending, rowRef{table: name, rowid: rowid})
+}
+
+func (s *StorageController) publish() {
+	s.csn++
+	for _, ref := range s.pending {
+		vs := s.versions[ref.table][ref.rowid]
+		for n := range vs {
+			if vs[n].csn == 0 {
+				vs[n].csn = s.csn
+			}
+		}
+		if _, ok := s.lastWrite[ref.table]; !ok {
+			s.lastWrite[ref.table] = make(map[int64]uint64)
+		}
+		s.lastWrite[ref.table][ref.rowid] = s.csn
+	}
+	s.pending = []rowRef{}
+	s.gc()
+}
+
+func (s *StorageController) discard(n int) {
+	for i := len(s.pending) - 1; i >= n; i-- {
+		ref := s.pending[i]
+		vs := s.versions[ref.table][ref.rowid]
+		if len(vs) <= 1 {
+			delete(s.versions[ref.table], ref.rowid)
+		} else {
+			s.versions[ref.table][ref.rowid] = vs[:len(vs)-1]
+		}
+	}
+	s.pending = s.pending[:n]
+}
+
+// gc drops the row versions and write records that no active snapshot can
+// observe any more.
+func (s *StorageController) gc() {
+	oldest := s.csn
+	for _, snapshot := range s.snapshots {
+		if snapshot < oldest {
+			oldest = snapshot
+		}
+	}
+
+	for name, rows := range s.versions {
+		for rowid, vs := range rows {
+			n := 0
+			for n < len(vs) && vs[n].csn != 0 && vs[n].csn <= oldest {
+				n++
+			}
+			if n == len(vs) {
+				delete(rows, rowid)
+			} else if n > 0 {
+				rows[rowid] = append([]version{}, vs[n:]...)
+			}
+		}
+		if len(rows) == 0 {
+			delete(s.versions, name)
+		}
+	}
+	for name, rows := range s.lastWrite {
+		for rowid, csn := range rows {
+			if csn <= oldest {
+				delete(rows, rowid)
+			}
+		}
+		if len(rows) == 0 {
+			delete(s.lastWrite, name)
+		}
+	}
+}
+
+func (s *StorageController) VersionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, rows := range s.versions {
+		for _, vs := range rows {
+			n += len(vs)
+		}
+	}
+	return n
+}
+
+// visible returns the row as seen by a snapshot, given the latest image.
+func (s *StorageController) visible(name string, rowid int64, snapshot uint64, row []table.Value, found bool) ([]table.Value, bool) {
+	for _, v := range s.versions[name][rowid] {
+		if v.csn == 0 || v.csn > snapshot {
+			return v.row, v.exists
+		}
+	}
+	return row, found
+}
+
+// scanChunk reads up to scanChunk rows whose rowid is greater than after.
+// It returns the rows, the largest rowid covered by the chunk and whether
+// more rows may follow.
+func (s *StorageController) scanChunk(name string, after int64, versioned bool, snapshot uint64) ([]scanRow, int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ti, err := s.lookupNativeTable(name)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	latest := map[int64][]table.Value{}
+	rowids := []int64{}
+	err = btree.Open(s.pool, ti.Root).Scan(rowKey(after+1), func(k []byte, v []byte) (bool, error) {
+		row, err := decodeRow(v)
+		if err != nil {
+			return false, err
+		}
+		rowid := decodeRowKey(k)
+		latest[rowid] = row
+		rowids = append(rowids, rowid)
+		return len(rowids) < scanChunk, nil
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+	end := int64(math.MaxInt64)
+	more := len(rowids) == scanChunk
+	if more {
+		end = rowids[len(rowids)-1]
+	}
+
+	if versioned {
+		for rowid := range s.versions[name] {
+			if _, exists := latest[rowid]; !exists && rowid > after && rowid <= end {
+				rowids = append(rowids, rowid)
+			}
+		}
+	}
+
+	rows := []scanRow{}
+	for _, rowid := range rowids {
+		row, found := latest[rowid]
+		if versioned {
+			row, found = s.visible(name, rowid, snapshot, row, found)
+		}
+		if found {
+			rows = append(rows, scanRow{rowid: rowid, row: row})
+		}
+	}
+	return rows, end, more, nil
+}
+
+func (s *StorageController) scanRows(name string, snapshot uint64, versioned bool, own map[int64]*txWrite, fn func(int64, []table.Value) (bool, error)) error {
+	s.mu.Lock()
+	ti, err := s.lookupTable(name)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if ti.Type == ExternalCSV {
+		tv, err := csv.Read(ti.Path)
+		if err != nil {
+			return err
+		}
+		for n, r := range tv.Values {
+			row := []table.Value{}
+			for _, h := range ti.Columns {
+				row = append(row, r[h].Value)
+			}
+			cont, err := fn(int64(n+1), row)
+			if err != nil || !cont {
+				return err
+			}
+		}
+		return nil
+	}
+
+	after := int64(0)
+	for {
+		rows, end, more, err := s.scanChunk(name, after, versioned, snapshot)
+		if err != nil {
+			return err
+		}
+		if len(own) != 0 {
+			rows = mergeWrites(rows, own, after, end)
+		}
+		for _, r := range rows {
+			cont, err := fn(r.rowid, r.row)
+			if err != nil || !cont {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+		after = end
+	}
+}
+
+func mergeWrites(rows []scanRow, own map[int64]*txWrite, after int64, end int64) []scanRow {
+	merged := []scanRow{}
+	for _, r := range rows {
+		if _, exists := own[r.rowid]; !exists {
+			merged = append(merged, r)
+		}
+	}
+	for rowid, w := range own {
+		if rowid > after && rowid <= end && !w.deleted {
+			merged = append(merged, scanRow{rowid: rowid, row: w.row})
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].rowid < merged[j].rowid
+	})
+	return merged
+}
+
+type txWrite struct {
+	inserted bool
+	deleted  bool
+	row      []table.Value
+}
+
+// Tx is a transaction that reads from a snapshot taken when it began.
+// Modifications are kept in the transaction and written on Commit, which
+// fails if another transaction committed a change to the same row after the
+// snapshot was taken. Tx values may be used from different goroutines at
+// the same time, but a single Tx must not be shared.
+type Tx struct {
+	s        *StorageController
+	snapshot uint64
+	writes   map[string]map[int64]*txWrite
+	order    []rowRef
+	done     bool
+}
+
+func (s *StorageController) BeginTx() (*Tx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return nil, errors.New("Database is not opened")
+	}
+	tx := &Tx{
+		s:        s,
+		snapshot: s.csn,
+		writes:   make(map[string]map[int64]*txWrite),
+	}
+	s.snapshots[tx] = s.csn
+	return tx, nil
+}
+
+func (tx *Tx) check() error {
+	if tx.done {
+		return errors.New("Transaction is already finished")
+	}
+	return nil
+}
+
+func (tx *Tx) Get(name string, rowid int64) ([]table.Value, bool, error) {
+	if err := tx.check(); err != nil {
+		return nil, false, err
+	}
+	if w, exists := tx.writes[name][rowid]; exists {
+		return w.row, !w.deleted, nil
+	}
+
+	s := tx.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, found, err := s.get(name, rowid)
+	if err != nil {
+		return nil, false, err
+	}
+	row, found = s.visible(name, rowid, tx.snapshot, row, found)
+	return row, found, nil
+}
+
+func (tx *Tx) Scan(name string, fn func(int64, []table.Value) (bool, error)) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.s.scanRows(name, tx.snapshot, true, tx.writes[name], fn)
+}
+
+// ScanIndex passes to fn the rows of a table, as Scan does, whose values
+// of the first columns of the index index are from low to high, both
+// included. A nil bound leaves the range open on its side, and the rows
+// whose key starts with NULL are only in the range open on both sides. The
+// rows are passed by the first column of the index, descending if desc is
+// set, NULL coming first, or last when descending, and the rows with the
+// same value by rowid. The rowids are found in the index first, and their
+// rows then read a chunk at a time. The rows the snapshot sees differently
+// from the index, or that the transaction wrote, are read before the
+// others to know their key.
+func (tx *Tx) ScanIndex(name string, index string, low []table.Value, high []table.Value, desc bool, fn func(int64, []table.Value) (bool, error)) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	s := tx.s
+	lo, hi := encodeKey(low), encodeKey(high)
+	inRange := func(k []byte) bool {
+		switch {
+		case low != nil && bytes.Compare(k, lo) < 0:
+			return false
+		case high != nil && (len(k) < len(hi) || bytes.Compare(k[:len(hi)], hi) > 0):
+			return false
+		case low == nil && high != nil && len(k) != 0 && k[0] == 0:
+			return false
+		}
+		return true
+	}
+
+	s.mu.Lock()
+	pos, rows, err := s.indexRange(name, index, lo, inRange)
+	if err == nil {
+		rows, err = tx.changedRows(name, pos, rows, inRange)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		c := bytes.Compare(firstPart(rows[i].key), firstPart(rows[j].key))
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return rows[i].rowid < rows[j].rowid
+	})
+	for start := 0; start < len(rows); start += scanChunk {
+		chunk := rows[start:]
+		if len(chunk) > scanChunk {
+			chunk = chunk[:scanChunk]
+		}
+		s.mu.Lock()
+		for n := range chunk {
+			r := &chunk[n]
+			if r.row != nil {
+				continue
+			}
+			row, found, err := s.get(name, r.rowid)
+			if err != nil {
+				s.mu.Unlock()
+				return err
+			}
+			// A row changed since its rowid was found is seen as it was.
+			row, found = s.visible(name, r.rowid, tx.snapshot, row, found)
+			if found && inRange(keyOf(row, pos)) {
+				r.row = row
+			}
+		}
+		s.mu.Unlock()
+		for _, r := range chunk {
+			if r.row == nil {
+				continue
+			}
+			cont, err := fn(r.rowid, r.row)
+			if err != nil || !cont {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changedRows replaces the rows of an index range that changed since the
+// snapshot, or that the transaction wrote, with the rows in the range as
+// the transaction sees them.
+func (tx *Tx) changedRows(name string, pos []int, rows []indexRow, inRange func([]byte) bool) ([]indexRow, error) {
+	s := tx.s
+	changed := map[int64]bool{}
+	for rowid := range s.versions[name] {
+		changed[rowid] = true
+	}
+	for rowid := range tx.writes[name] {
+		changed[rowid] = true
+	}
+	kept := rows[:0]
+	for _, r := range rows {
+		if !changed[r.rowid] {
+			kept = append(kept, r)
+		}
+	}
+	for rowid := range changed {
+		row, found := []table.Value(nil), false
+		if w, exists := tx.writes[name][rowid]; exists {
+			row, found = w.row, !w.deleted
+		} else {
+			var err error
+			if row, found, err = s.get(name, rowid); err != nil {
+				return nil, err
+			}
+			row, found = s.visible(name, rowid, tx.snapshot, row, found)
+		}
+		if k := keyOf(row, pos); found && inRange(k) {
+			kept = append(kept, indexRow{key: k, rowid: rowid, row: row})
+		}
+	}
+	return kept, nil
+}
+
+// indexRow is a row found in an index, row being nil until it is read.
+type indexRow struct {
+	key   []byte
+	rowid int64
+	row   []table.Value
+}
+
+// indexRange returns the positions of the columns of an index and the
+// rowids of the index whose key, from lo, is in the range.
+func (s *StorageController) indexRange(name string, index string, lo []byte, inRange func([]byte) bool) ([]int, []indexRow, error) {
+	ti, err := s.lookupNativeTable(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, idx := range ti.Indexes {
+		if idx.Name != index {
+			continue
+		}
+		pos, err := columnIndexes(ti, idx.Columns)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows := []indexRow{}
+		err = btree.Open(s.pool, idx.Root).Scan(lo, func(k []byte, v []byte) (bool, error) {
+			key := k[:len(k)-8]
+			if !inRange(key) {
+				// The keys starting with NULL come before the range.
+				return len(key) != 0 && key[0] == 0, nil
+			}
+			rows = append(rows, indexRow{key: append([]byte{}, key...), rowid: decodeRowKey(k)})
+			return true, nil
+		})
+		return pos, rows, err
+	}
+	return nil, nil, errors.New(fmt.Sprintf("Index (%s) Not Found", index))
+}
+
+func keyOf(row []table.Value, pos []int) []byte {
+	vals := []table.Value{}
+	for _, p := range pos {
+		if p < len(row) {
+			vals = append(vals, row[p])
+		}
+	}
+	return encodeKey(vals)
+}
+
+// firstPart returns the encoding of the first value of a key.
+func firstPart(k []byte) []byte {
+	switch {
+	case len(k) == 0:
+		return k
+	case k[0] == 0:
+		return k[:1]
+	}
+	return k[:9]
+}
+
+func (tx *Tx) write(name string, rowid int64, w *txWrite) {
+	if _, exists := tx.writes[name]; !exists {
+		tx.writes[name] = make(map[int64]*txWrite)
+	}
+	if prev, exists := tx.writes[name][rowid]; exists {
+		w.inserted = prev.inserted
+	} else {
+		tx.order = append(tx.order, rowRef{table: name, rowid: rowid})
+	}
+	tx.writes[name][rowid] = w
+}
+
+func (tx *Tx) Insert(name string, row []table.Value) (int64, error) {
+	if err := tx.check(); err != nil {
+		return 0, err
+	}
+	s := tx.s
+	s.mu.Lock()
+	ti, err := s.lookupNativeTable(name)
+	if err == nil && len(row) != len(ti.Columns) {
+		err = errors.New(fmt.Sprintf("Table (%s) has %d columns but %d values were supplied", name, len(ti.Columns), len(row)))
+	}
+	rowid := int64(0)
+	if err == nil {
+		rowid = s.allocateRowID(ti)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	tx.write(name, rowid, &txWrite{inserted: true, row: row})
+	return rowid, nil
+}
+
+func (tx *Tx) Update(name string, rowid int64, row []table.Value) error {
+	old, found, err := tx.Get(name, rowid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Row (%d) Not Found", rowid))
+	}
+	if len(row) != len(old) {
+		return errors.New(fmt.Sprintf("Table (%s) has %d columns but %d values were supplied", name, len(old), len(row)))
+	}
+	tx.write(name, rowid, &txWrite{row: row})
+	return nil
+}
+
+func (tx *Tx) Delete(name string, rowid int64) error {
+	_, found, err := tx.Get(name, rowid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Row (%d) Not Found", rowid))
+	}
+	tx.write(name, rowid, &txWrite{deleted: true})
+	return nil
+}
+
+func (tx *Tx) finish() {
+	tx.done = true
+	delete(tx.s.snapshots, tx)
+	tx.s.gc()
+}
+
+func (tx *Tx) Commit() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	s := tx.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer tx.finish()
+
+	if len(tx.order) == 0 {
+		return nil
+	}
+	if s.pager == nil {
+		return errors.New("Database is not opened")
+	}
+	if s.tx {
+		return errors.New("Database is locked by another transaction")
+	}
+	if err := s.conflict(tx.order, tx.snapshot); err != nil {
+		return err
+	}
+
+	for _, ref := range tx.order {
+		if err := tx.apply(ref); err != nil {
+			s.rollback()
+			return err
+		}
+	}
+	return s.commit()
+}
+
+// Conflict returns an error if a row written in the transaction of the
+// storage, begun with Begin, was changed by a transaction that committed
+// after the snapshot of tx was taken. Writes of the storage transaction
+// are not kept in tx, but it is committed in its place by a session that
+// reads from tx.
+func (tx *Tx) Conflict() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	s := tx.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conflict(s.pending, tx.snapshot)
+}
+
+// conflict returns an error if one of refs was written by a transaction
+// that committed after snapshot.
+func (s *StorageController) conflict(refs []rowRef, snapshot uint64) error {
+	for _, ref := range refs {
+		if s.lastWrite[ref.table][ref.rowid] > snapshot {
+			return errors.New(fmt.Sprintf("Write-write conflict on row (%d) of table (%s)", ref.rowid, ref.table))
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) apply(ref rowRef) error {
+	s := tx.s
+	w := tx.writes[ref.table][ref.rowid]
+	switch {
+	case w.inserted && w.deleted:
+		return nil
+	case w.inserted:
+		ti, err := s.lookupNativeTable(ref.table)
+		if err != nil {
+			return err
+		}
+		return s.insertRow(ti, ref.rowid, w.row)
+	case w.deleted:
+		return s.delete(ref.table, ref.rowid)
+	default:
+		return s.update(ref.table, ref.rowid, w.row)
+	}
+}
+
+func (tx *Tx) Rollback() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	tx.s.mu.Lock()
+	defer tx.s.mu.Unlock()
+	tx.finish()
+	return nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func openController(t *testing.T) (*StorageController, func()) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	s := New()
+	if err := s.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func txRows(t *testing.T, tx *Tx, name string) map[int64]int {
+	rows := map[int64]int{}
+	err := tx.Scan(name, func(rowid int64, row []table.Value) (bool, error) {
+		rows[rowid] = row[0].Integral
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return rows
+}
+
+func TestSnapshot(t *testing.T) {
+	s, cleanup := openController(t)
+	defer cleanup()
+
+	if err := s.CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := s.Insert("tbl", integers(i)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	tx, err := s.BeginTx()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(4)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Update("tbl", 1, integers(10)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Delete("tbl", 2); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if s.VersionCount() != 3 {
+		t.Fatalf("version count mismatch %d", s.VersionCount())
+	}
+
+	if rows := txRows(t, tx, "tbl"); len(rows) != 3 || rows[1] != 1 || rows[2] != 2 || rows[3] != 3 {
+		t.Fatalf("snapshot mismatch %v", rows)
+	}
+	if row, found, err := tx.Get("tbl", 1); err != nil || !found || row[0].Integral != 1 {
+		t.Fatalf("snapshot row mismatch %v", row)
+	}
+	if _, found, _ := tx.Get("tbl", 4); found {
+		t.Fatalf("row inserted after snapshot is visible")
+	}
+
+	rowid, err := tx.Insert("tbl", integers(5))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx.Delete("tbl", 3); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if rows := txRows(t, tx, "tbl"); len(rows) != 3 || rows[rowid] != 5 {
+		t.Fatalf("own writes are not visible %v", rows)
+	}
+	if rows := scanAll(t, s, "tbl"); len(rows) != 3 {
+		t.Fatalf("uncommitted writes are visible %v", rows)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("finished transaction is committed")
+	}
+
+	rows := scanAll(t, s, "tbl")
+	if len(rows) != 3 || rows[1][0].Integral != 10 || rows[4][0].Integral != 4 || rows[rowid][0].Integral != 5 {
+		t.Fatalf("committed rows mismatch %v", rows)
+	}
+	if s.VersionCount() != 0 {
+		t.Fatalf("versions are not collected %d", s.VersionCount())
+	}
+}
+
+func TestWriteConflict(t *testing.T) {
+	s, cleanup := openController(t)
+	defer cleanup()
+
+	if err := s.CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for i := 1; i <= 2; i++ {
+		if _, err := s.Insert("tbl", integers(i)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	tx1, _ := s.BeginTx()
+	tx2, _ := s.BeginTx()
+	tx3, _ := s.BeginTx()
+	if err := tx1.Update("tbl", 1, integers(100)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx2.Update("tbl", 1, integers(200)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx3.Delete("tbl", 2); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx2.Commit(); err == nil {
+		t.Fatalf("write-write conflict is not detected")
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	rows := scanAll(t, s, "tbl")
+	if len(rows) != 1 || rows[1][0].Integral != 100 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+
+	tx4, _ := s.BeginTx()
+	tx4.Insert("tbl", integers(3))
+	if err := tx4.Rollback(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(scanAll(t, s, "tbl")) != 1 {
+		t.Fatalf("rolled back insert is visible")
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	s, cleanup := openController(t)
+	defer cleanup()
+
+	if err := s.CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := s.Insert("tbl", integers(1)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 16)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := s.BeginTx()
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			defer tx.Rollback()
+			first := -1
+			for pass := 0; pass < 3; pass++ {
+				sum := 0
+				err := tx.Scan("tbl", func(rowid int64, row []table.Value) (bool, error) {
+					sum += row[0].Integral
+					return true, nil
+				})
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				if first >= 0 && sum != first {
+					errs <- "snapshot is not consistent"
+					return
+				}
+				first = sum
+			}
+		}()
+	}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				tx, err := s.BeginTx()
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				rowid := int64(w*250 + i + 1)
+				tx.Update("tbl", rowid, integers(2))
+				tx.Insert("tbl", integers(3))
+				if err := tx.Commit(); err != nil {
+					errs <- err.Error()
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Fatalf("%s", e)
+	}
+
+	if rows := scanAll(t, s, "tbl"); len(rows) != 1200 {
+		t.Fatalf("row count mismatch %d", len(rows))
+	}
+	if s.VersionCount() != 0 {
+		t.Fatalf("versions are not collected %d", s.VersionCount())
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	s, cleanup := openController(t)
+	defer cleanup()
+
+	if err := s.CreateTable("tbl", []string{"colA", "colB"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	null := table.Value{Type: table.Null}
+	for _, v := range []int{5, 1, 3, 1, 9} {
+		if _, err := s.Insert("tbl", []table.Value{{Type: table.Integer, Integral: v}, null}); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	if _, err := s.Insert("tbl", []table.Value{null, null}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateIndex("tbl", "idx_colA", []string{"colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	tx, err := s.BeginTx()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer tx.Rollback()
+	// Changes committed after the snapshot are not seen.
+	if err := s.Update("tbl", 1, []table.Value{{Type: table.Integer, Integral: 2}, null}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Delete("tbl", 2); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(4, 0)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	// The writes of the transaction are.
+	if err := tx.Update("tbl", 3, integers(7, 0)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	testCases := []struct {
+		low      []table.Value
+		high     []table.Value
+		desc     bool
+		expected []int64
+	}{
+		{nil, nil, false, []int64{6, 2, 4, 1, 3, 5}},
+		{nil, nil, true, []int64{5, 3, 1, 2, 4, 6}},
+		{integers(1), integers(1), false, []int64{2, 4}},
+		{integers(2), integers(7), false, []int64{1, 3}},
+		{nil, integers(4), true, []int64{2, 4}},
+		{integers(6), nil, false, []int64{3, 5}},
+		{integers(10), nil, false, []int64{}},
+	}
+	for tn, tc := range testCases {
+		rowids := []int64{}
+		err := tx.ScanIndex("tbl", "idx_colA", tc.low, tc.high, tc.desc, func(rowid int64, row []table.Value) (bool, error) {
+			rowids = append(rowids, rowid)
+			return true, nil
+		})
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if fmt.Sprint(rowids) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] rowids mismatch %v", tn, rowids)
+		}
+	}
+	if err := tx.ScanIndex("tbl", "missing", nil, nil, false, func(int64, []table.Value) (bool, error) { return true, nil }); err == nil {
+		t.Fatalf("Missing index is scanned")
+	}
+}
@@ -0,0 +1,375 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yakawa/simpleDB/runtime/storage/wal"
+)
+
+const PageSize = 4096
+const RootSlots = 8
+const DefaultAutoCheckpoint = 1000
+
+const magic = "SimpleDB"
+
+const (
+	offsetMagic     = 0
+	offsetPageSize  = 8
+	offsetPageCount = 12
+	offsetFreeHead  = 16
+	offsetFreeCount = 20
+	offsetRoots     = 24
+)
+
+type header struct {
+	pageCount uint32
+	freeHead  uint32
+	freeCount uint32
+	roots     [RootSlots]uint32
+}
+
+type Savepoint struct {
+	header header
+	frames map[uint32]int64
+	end    int64
+	lsn    uint64
+	dirty  bool
+}
+
+type Pager struct {
+	file *os.File
+	wal  *wal.WAL
+	header
+
+	frames    map[uint32]int64
+	dirty     bool
+	committed header
+	commitEnd int64
+	commitLSN uint64
+	cframes   map[uint32]int64
+
+	autoCheckpoint int
+}
+
+func WALPath(fn string) string {
+	return fn + "-wal"
+}
+
+func Open(fn string) (*Pager, error) {
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wal.Open(WALPath(fn))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	p := &Pager{
+		file:           f,
+		wal:            w,
+		frames:         make(map[uint32]int64),
+		cframes:        make(map[uint32]int64),
+		autoCheckpoint: DefaultAutoCheckpoint,
+	}
+
+	if err := p.recover(); err != nil {
+		p.close()
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	if st.Size() == 0 {
+		p.pageCount = 1
+		if _, err := p.file.WriteAt(p.encodeHeader(), 0); err != nil {
+			p.close()
+			return nil, err
+		}
+		if err := p.file.Sync(); err != nil {
+			p.close()
+			return nil, err
+		}
+	} else if err := p.readHeader(); err != nil {
+		p.close()
+		return nil, err
+	}
+	p.committed = p.header
+	p.commitEnd = p.wal.Size()
+	p.commitLSN = p.wal.LSN()
+	return p, nil
+}
+
+func (p *Pager) recover() error {
+	frames, end, err := p.wal.Recover()
+	if err != nil {
+		return err
+	}
+	if err := p.wal.Truncate(end, p.wal.LSN()); err != nil {
+		return err
+	}
+	p.frames = frames
+	return p.checkpoint()
+}
+
+func (p *Pager) readHeader() error {
+	buf := make([]byte, PageSize)
+	if _, err := p.file.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if string(buf[offsetMagic:offsetMagic+len(magic)]) != magic {
+		return errors.New("Database file is broken")
+	}
+	if ps := binary.LittleEndian.Uint32(buf[offsetPageSize:]); ps != PageSize {
+		return errors.New(fmt.Sprintf("Unsupported page size %d", ps))
+	}
+	p.pageCount = binary.LittleEndian.Uint32(buf[offsetPageCount:])
+	p.freeHead = binary.LittleEndian.Uint32(buf[offsetFreeHead:])
+	p.freeCount = binary.LittleEndian.Uint32(buf[offsetFreeCount:])
+	for n := 0; n < RootSlots; n++ {
+		p.roots[n] = binary.LittleEndian.Uint32(buf[offsetRoots+4*n:])
+	}
+	return nil
+}
+
+func (p *Pager) encodeHeader() []byte {
+	buf := make([]byte, PageSize)
+	copy(buf[offsetMagic:], magic)
+	binary.LittleEndian.PutUint32(buf[offsetPageSize:], PageSize)
+	binary.LittleEndian.PutUint32(buf[offsetPageCount:], p.pageCount)
+	binary.LittleEndian.PutUint32(buf[offsetFreeHead:], p.freeHead)
+	binary.LittleEndian.PutUint32(buf[offsetFreeCount:], p.freeCount)
+	for n := 0; n < RootSlots; n++ {
+		binary.LittleEndian.PutUint32(buf[offsetRoots+4*n:], p.roots[n])
+	}
+	return buf
+}
+
+func (p *Pager) PageCount() uint32 {
+	return p.pageCount
+}
+
+func (p *Pager) FreeCount() uint32 {
+	return p.freeCount
+}
+
+func (p *Pager) WALStats() wal.Stats {
+	return p.wal.Stats()
+}
+
+func (p *Pager) AutoCheckpoint() int {
+	return p.autoCheckpoint
+}
+
+func (p *Pager) SetAutoCheckpoint(n int) {
+	p.autoCheckpoint = n
+}
+
+func (p *Pager) Read(pgno uint32) ([]byte, error) {
+	if pgno == 0 || pgno >= p.pageCount {
+		return nil, errors.New(fmt.Sprintf("Page (%d) out of range", pgno))
+	}
+	if offset, exists := p.frames[pgno]; exists {
+		return p.wal.ReadPage(offset, PageSize)
+	}
+	buf := make([]byte, PageSize)
+	_, err := p.file.ReadAt(buf, int64(pgno)*PageSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (p *Pager) Write(pgno uint32, data []byte) error {
+	if pgno == 0 || pgno >= p.pageCount {
+		return errors.New(fmt.Sprintf("Page (%d) out of range", pgno))
+	}
+	if len(data) > PageSize {
+		return errors.New(fmt.Sprintf("Page (%d) overflow", pgno))
+	}
+	return p.writePage(pgno, data)
+}
+
+func (p *Pager) writePage(pgno uint32, data []byte) error {
+	buf := make([]byte, PageSize)
+	copy(buf, data)
+	offset, err := p.wal.AppendPage(pgno, buf)
+	if err != nil {
+		return err
+	}
+	p.frames[pgno] = offset
+	p.dirty = true
+	return nil
+}
+
+func (p *Pager) Allocate() (uint32, error) {
+	if p.freeHead != 0 {
+		pgno := p.freeHead
+		buf, err := p.Read(pgno)
+		if err != nil {
+			return 0, err
+		}
+		p.freeHead = binary.LittleEndian.Uint32(buf)
+		p.freeCount--
+		if err := p.Write(pgno, []byte{}); err != nil {
+			return 0, err
+		}
+		return pgno, nil
+	}
+
+	pgno := p.pageCount
+	p.pageCount++
+	if err := p.Write(pgno, []byte{}); err != nil {
+		p.pageCount--
+		return 0, err
+	}
+	return pgno, nil
+}
+
+func (p *Pager) Free(pgno uint32) error {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, p.freeHead)
+	if err := p.Write(pgno, buf); err != nil {
+		return err
+	}
+	p.freeHead = pgno
+	p.freeCount++
+	return nil
+}
+
+func (p *Pager) Root(n int) uint32 {
+	return p.roots[n]
+}
+
+func (p *Pager) SetRoot(n int, pgno uint32) {
+	p.roots[n] = pgno
+	p.dirty = true
+}
+
+func (p *Pager) Commit() error {
+	if !p.dirty {
+		return nil
+	}
+	if err := p.writePage(0, p.encodeHeader()); err != nil {
+		return err
+	}
+	lsn, err := p.wal.AppendCommit()
+	if err != nil {
+		return err
+	}
+	if err := p.wal.Sync(lsn); err != nil {
+		return err
+	}
+
+	p.dirty = false
+	p.committed = p.header
+	p.commitEnd = p.wal.Size()
+	p.commitLSN = lsn
+	p.cframes = make(map[uint32]int64)
+	for pgno, offset := range p.frames {
+		p.cframes[pgno] = offset
+	}
+
+	if p.autoCheckpoint > 0 && len(p.frames) >= p.autoCheckpoint {
+		return p.Checkpoint()
+	}
+	return nil
+}
+
+func (p *Pager) Rollback() error {
+	return p.RollbackTo(&Savepoint{
+		header: p.committed,
+		frames: p.cframes,
+		end:    p.commitEnd,
+		lsn:    p.commitLSN,
+	})
+}
+
+func (p *Pager) Savepoint() *Savepoint {
+	sp := &Savepoint{
+		header: p.header,
+		frames: make(map[uint32]int64),
+		end:    p.wal.Size(),
+		lsn:    p.wal.LSN(),
+		dirty:  p.dirty,
+	}
+	for pgno, offset := range p.frames {
+		sp.frames[pgno] = offset
+	}
+	return sp
+}
+
+func (p *Pager) RollbackTo(sp *Savepoint) error {
+	if sp.end < p.commitEnd {
+		return errors.New("Savepoint is already committed")
+	}
+	if err := p.wal.Truncate(sp.end, sp.lsn); err != nil {
+		return err
+	}
+	p.header = sp.header
+	p.frames = make(map[uint32]int64)
+	for pgno, offset := range sp.frames {
+		p.frames[pgno] = offset
+	}
+	p.dirty = sp.dirty
+	return nil
+}
+
+func (p *Pager) Checkpoint() error {
+	if p.dirty {
+		return errors.New("Could not checkpoint uncommitted pages")
+	}
+	return p.checkpoint()
+}
+
+func (p *Pager) checkpoint() error {
+	if len(p.frames) == 0 {
+		return nil
+	}
+	for pgno, offset := range p.frames {
+		buf, err := p.wal.ReadPage(offset, PageSize)
+		if err != nil {
+			return err
+		}
+		if _, err := p.file.WriteAt(buf, int64(pgno)*PageSize); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := p.wal.Reset(); err != nil {
+		return err
+	}
+	p.frames = make(map[uint32]int64)
+	p.cframes = make(map[uint32]int64)
+	p.commitEnd = p.wal.Size()
+	p.commitLSN = p.wal.LSN()
+	return nil
+}
+
+func (p *Pager) Close() error {
+	err := p.Rollback()
+	if err == nil {
+		err = p.Checkpoint()
+	}
+	if cerr := p.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (p *Pager) close() error {
+	werr := p.wal.Close()
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	return werr
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func encodeRow(row []table.Value) []byte {
+	buf := make([]byte, 2, 2+9*len(row))
+	binary.LittleEndian.PutUint16(buf, uint16(len(row)))
+	for _, v := range row {
+		buf = append(buf, byte(v.Type))
+		if v.Type == table.Integer {
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, uint64(v.Integral))
+			buf = append(buf, b...)
+		}
+	}
+	return buf
+}
+
+func decodeRow(buf []byte) ([]table.Value, error) {
+	if len(buf) < 2 {
+		return nil, errors.New("Record is broken")
+	}
+	n := int(binary.LittleEndian.Uint16(buf))
+	row := make([]table.Value, 0, n)
+	pos := 2
+	for i := 0; i < n; i++ {
+		if pos >= len(buf) {
+			return nil, errors.New("Record is broken")
+		}
+		v := table.Value{Type: table.ValueType(buf[pos])}
+		pos++
+		if v.Type == table.Integer {
+			if pos+8 > len(buf) {
+				return nil, errors.New("Record is broken")
+			}
+			v.Integral = int(int64(binary.LittleEndian.Uint64(buf[pos:])))
+			pos += 8
+		}
+		row = append(row, v)
+	}
+	return row, nil
+}
+
+func encodeKey(vals []table.Value) []byte {
+	buf := make([]byte, 0, 9*len(vals))
+	for _, v := range vals {
+		if v.Type != table.Integer {
+			buf = append(buf, 0)
+			continue
+		}
+		b := make([]byte, 9)
+		b[0] = 1
+		binary.BigEndian.PutUint64(b[1:], uint64(v.Integral)^(1<<63))
+		buf = append(buf, b...)
+	}
+	return buf
+}
+
+func rowKey(rowid int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(rowid)^(1<<63))
+	return b
+}
+
+func decodeRowKey(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b[len(b)-8:]) ^ (1 << 63))
+}
+
+func hasNull(vals []table.Value) bool {
+	for _, v := range vals {
+		if v.Type != table.Integer {
+			return true
+		}
+	}
+	return false
+}
+
+func keyHasNull(k []byte) bool {
+	for pos := 0; pos < len(k); pos += 9 {
+		if k[pos] == 0 {
+			return true
+		}
+	}
+	return false
+}
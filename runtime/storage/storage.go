@@ -1,17 +1,828 @@
 package storage
 
-import "sync"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 
-type StorageController struct{}
+	"github.com/yakawa/simpleDB/runtime/storage/btree"
+	"github.com/yakawa/simpleDB/runtime/storage/buffer"
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+	"github.com/yakawa/simpleDB/runtime/storage/wal"
+)
+
+const catalogSlot = 0
+
+type TableType int
+
+const (
+	_ TableType = iota
+	Native
+	ExternalCSV
+)
+
+func (t TableType) String() string {
+	switch t {
+	case Native:
+		return "Native"
+	case ExternalCSV:
+		return "External CSV"
+	default:
+		return "Unknown"
+	}
+}
+
+type TableInfo struct {
+	Name      string
+	Type      TableType
+	Columns   []string
+	Root      uint32
+	NextRowID int64
+	Path      string
+	Indexes   []IndexInfo
+}
+
+type IndexInfo struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	Root    uint32
+}
+
+type StorageController struct {
+	mu        sync.Mutex
+	pager     *pager.Pager
+	pool      *buffer.Pool
+	cacheSize int
+	catalog   *btree.BTree
+	tables    map[string]*TableInfo
+
+	tx         bool
+	savepoints []savepoint
+
+	csn       uint64
+	versions  map[string]map[int64][]version
+	lastWrite map[string]map[int64]uint64
+	pending   []rowRef
+	snapshots map[*Tx]uint64
+	rowids    map[string]int64
+
+	schema uint64
+}
 
 var instance *StorageController
 var once sync.Once
 
 func GetInstance() *StorageController {
 	once.Do(func() {
-		instance = &StorageController{}
+		instance = New()
 	})
 	return instance
 }
 
-func (s *StorageController) ReadTables() {}
+func New() *StorageController {
+	s := &StorageController{
+		cacheSize: buffer.DefaultSize,
+	}
+	s.reset()
+	return s
+}
+
+func (s *StorageController) reset() {
+	s.tables = make(map[string]*TableInfo)
+	s.tx = false
+	s.savepoints = []savepoint{}
+	s.versions = make(map[string]map[int64][]version)
+	s.lastWrite = make(map[string]map[int64]uint64)
+	s.pending = []rowRef{}
+	s.snapshots = make(map[*Tx]uint64)
+	s.rowids = make(map[string]int64)
+}
+
+func (s *StorageController) Open(fn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager != nil {
+		return errors.New("Database is already opened")
+	}
+	p, err := pager.Open(fn)
+	if err != nil {
+		return err
+	}
+
+	pool := buffer.New(p, s.cacheSize)
+	root := p.Root(catalogSlot)
+	var catalog *btree.BTree
+	if root == 0 {
+		catalog, err = btree.Create(pool)
+		if err != nil {
+			p.Close()
+			return err
+		}
+		p.SetRoot(catalogSlot, catalog.Root())
+		if err := pool.Flush(); err != nil {
+			p.Close()
+			return err
+		}
+		if err := p.Commit(); err != nil {
+			p.Close()
+			return err
+		}
+	} else {
+		catalog = btree.Open(pool, root)
+	}
+
+	s.reset()
+	s.pager = p
+	s.pool = pool
+	s.catalog = catalog
+	if err := s.loadCatalog(); err != nil {
+		s.pager = nil
+		s.pool = nil
+		s.catalog = nil
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *StorageController) loadCatalog() error {
+	tables := make(map[string]*TableInfo)
+	err := s.catalog.Scan(nil, func(k []byte, v []byte) (bool, error) {
+		ti := &TableInfo{}
+		if err := json.Unmarshal(v, ti); err != nil {
+			return false, err
+		}
+		tables[ti.Name] = ti
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.tables = tables
+	s.schema++
+	return nil
+}
+
+// SchemaVersion changes whenever the catalog may have changed.
+func (s *StorageController) SchemaVersion() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schema
+}
+
+func (s *StorageController) commit() error {
+	if err := s.pool.Flush(); err != nil {
+		s.rollback()
+		return err
+	}
+	if err := s.pager.Commit(); err != nil {
+		s.rollback()
+		return err
+	}
+	s.publish()
+	return nil
+}
+
+func (s *StorageController) rollback() error {
+	s.discard(0)
+	s.pool.Discard()
+	if err := s.pager.Rollback(); err != nil {
+		return err
+	}
+	return s.loadCatalog()
+}
+
+func (s *StorageController) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return nil
+	}
+	s.pool.Discard()
+	err := s.pager.Close()
+	s.pager = nil
+	s.pool = nil
+	s.catalog = nil
+	s.reset()
+	return err
+}
+
+func (s *StorageController) CacheSize() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cacheSize
+}
+
+// SetCacheSize follows PRAGMA cache_size: a positive value is a number of
+// pages and a negative value is a budget in KiB.
+func (s *StorageController) SetCacheSize(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pages := n
+	if n < 0 {
+		pages = -n * 1024 / pager.PageSize
+	}
+	if pages < 1 {
+		return errors.New(fmt.Sprintf("Invalid cache size %d", n))
+	}
+	if s.pool != nil {
+		if err := s.pool.SetSize(pages); err != nil {
+			return err
+		}
+	}
+	s.cacheSize = pages
+	return nil
+}
+
+func (s *StorageController) CacheStats() buffer.Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pool == nil {
+		return buffer.Stats{Size: s.cacheSize}
+	}
+	return s.pool.Stats()
+}
+
+func (s *StorageController) WALStats() wal.Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return wal.Stats{}
+	}
+	return s.pager.WALStats()
+}
+
+func (s *StorageController) AutoCheckpoint() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return pager.DefaultAutoCheckpoint
+	}
+	return s.pager.AutoCheckpoint()
+}
+
+func (s *StorageController) SetAutoCheckpoint(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return errors.New("Database is not opened")
+	}
+	s.pager.SetAutoCheckpoint(n)
+	return nil
+}
+
+func (s *StorageController) Checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return errors.New("Database is not opened")
+	}
+	if s.tx {
+		return errors.New("Could not checkpoint within a transaction")
+	}
+	return s.pager.Checkpoint()
+}
+
+func (s *StorageController) ReadTables() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := []string{}
+	for n := range s.tables {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *StorageController) Table(name string) (*TableInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ti, exists := s.tables[name]
+	return ti, exists
+}
+
+func (s *StorageController) saveTable(ti *TableInfo) error {
+	v, err := json.Marshal(ti)
+	if err != nil {
+		return err
+	}
+	return s.catalog.Put([]byte(ti.Name), v)
+}
+
+func (s *StorageController) lookupTable(name string) (*TableInfo, error) {
+	if s.pager == nil {
+		return nil, errors.New("Database is not opened")
+	}
+	ti, exists := s.tables[name]
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("Table (%s) Not Found", name))
+	}
+	return ti, nil
+}
+
+func (s *StorageController) lookupNativeTable(name string) (*TableInfo, error) {
+	ti, err := s.lookupTable(name)
+	if err != nil {
+		return nil, err
+	}
+	if ti.Type != Native {
+		return nil, errors.New(fmt.Sprintf("Table (%s) is read only", name))
+	}
+	return ti, nil
+}
+
+func (s *StorageController) CreateTable(name string, cols []string) error {
+	return s.run(func() error {
+		s.schema++
+		return s.createTable(name, cols)
+	})
+}
+
+func (s *StorageController) createTable(name string, cols []string) error {
+	if s.pager == nil {
+		return errors.New("Database is not opened")
+	}
+	if _, exists := s.tables[name]; exists {
+		return errors.New(fmt.Sprintf("Table (%s) already exists", name))
+	}
+	tree, err := btree.Create(s.pool)
+	if err != nil {
+		return err
+	}
+	ti := &TableInfo{
+		Name:      name,
+		Type:      Native,
+		Columns:   cols,
+		Root:      tree.Root(),
+		NextRowID: 1,
+	}
+	if err := s.saveTable(ti); err != nil {
+		return err
+	}
+	s.tables[name] = ti
+	return nil
+}
+
+func (s *StorageController) CreateExternalTable(name string, fn string) error {
+	return s.run(func() error {
+		s.schema++
+		return s.createExternalTable(name, fn)
+	})
+}
+
+func (s *StorageController) createExternalTable(name string, fn string) error {
+	if s.pager == nil {
+		return errors.New("Database is not opened")
+	}
+	if _, exists := s.tables[name]; exists {
+		return errors.New(fmt.Sprintf("Table (%s) already exists", name))
+	}
+	tv, err := csv.Read(fn)
+	if err != nil {
+		return err
+	}
+	ti := &TableInfo{
+		Name:    name,
+		Type:    ExternalCSV,
+		Columns: tv.Header,
+		Path:    fn,
+	}
+	if err := s.saveTable(ti); err != nil {
+		return err
+	}
+	s.tables[name] = ti
+	return nil
+}
+
+func (s *StorageController) DropTable(name string) error {
+	return s.run(func() error {
+		s.schema++
+		return s.dropTable(name)
+	})
+}
+
+func (s *StorageController) dropTable(name string) error {
+	ti, err := s.lookupTable(name)
+	if err != nil {
+		return err
+	}
+	if ti.Type == Native {
+		for _, idx := range ti.Indexes {
+			if err := btree.Open(s.pool, idx.Root).Drop(); err != nil {
+				return err
+			}
+		}
+		if err := btree.Open(s.pool, ti.Root).Drop(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.catalog.Delete([]byte(name)); err != nil {
+		return err
+	}
+	delete(s.tables, name)
+	return nil
+}
+
+func (s *StorageController) Insert(name string, row []table.Value) (int64, error) {
+	rowid := int64(0)
+	err := s.run(func() error {
+		var err error
+		rowid, err = s.insert(name, row)
+		return err
+	})
+	return rowid, err
+}
+
+func (s *StorageController) insert(name string, row []table.Value) (int64, error) {
+	ti, err := s.lookupNativeTable(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(row) != len(ti.Columns) {
+		return 0, errors.New(fmt.Sprintf("Table (%s) has %d columns but %d values were supplied", name, len(ti.Columns), len(row)))
+	}
+
+	rowid := s.allocateRowID(ti)
+	return rowid, s.insertRow(ti, rowid, row)
+}
+
+func (s *StorageController) allocateRowID(ti *TableInfo) int64 {
+	rowid := ti.NextRowID
+	if next := s.rowids[ti.Name]; next > rowid {
+		rowid = next
+	}
+	s.rowids[ti.Name] = rowid + 1
+	return rowid
+}
+
+func (s *StorageController) insertRow(ti *TableInfo, rowid int64, row []table.Value) error {
+	if err := s.checkUnique(ti, rowid, row); err != nil {
+		return err
+	}
+	if err := btree.Open(s.pool, ti.Root).Put(rowKey(rowid), encodeRow(row)); err != nil {
+		return err
+	}
+	if err := s.insertIndexes(ti, rowid, row); err != nil {
+		return err
+	}
+	s.addVersion(ti.Name, rowid, nil, false)
+
+	if rowid >= ti.NextRowID {
+		ti.NextRowID = rowid + 1
+	}
+	return s.saveTable(ti)
+}
+
+func (s *StorageController) Get(name string, rowid int64) ([]table.Value, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(name, rowid)
+}
+
+func (s *StorageController) get(name string, rowid int64) ([]table.Value, bool, error) {
+	ti, err := s.lookupNativeTable(name)
+	if err != nil {
+		return nil, false, err
+	}
+	v, found, err := btree.Open(s.pool, ti.Root).Get(rowKey(rowid))
+	if err != nil || !found {
+		return nil, found, err
+	}
+	row, err := decodeRow(v)
+	return row, err == nil, err
+}
+
+func (s *StorageController) Update(name string, rowid int64, row []table.Value) error {
+	return s.run(func() error {
+		return s.update(name, rowid, row)
+	})
+}
+
+func (s *StorageController) update(name string, rowid int64, row []table.Value) error {
+	ti, err := s.lookupNativeTable(name)
+	if err != nil {
+		return err
+	}
+	if len(row) != len(ti.Columns) {
+		return errors.New(fmt.Sprintf("Table (%s) has %d columns but %d values were supplied", name, len(ti.Columns), len(row)))
+	}
+	old, found, err := s.get(name, rowid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Row (%d) Not Found", rowid))
+	}
+	if err := s.checkUnique(ti, rowid, row); err != nil {
+		return err
+	}
+	if err := s.deleteIndexes(ti, rowid, old); err != nil {
+		return err
+	}
+	if err := btree.Open(s.pool, ti.Root).Put(rowKey(rowid), encodeRow(row)); err != nil {
+		return err
+	}
+	if err := s.insertIndexes(ti, rowid, row); err != nil {
+		return err
+	}
+	s.addVersion(name, rowid, old, true)
+	return nil
+}
+
+func (s *StorageController) Delete(name string, rowid int64) error {
+	return s.run(func() error {
+		return s.delete(name, rowid)
+	})
+}
+
+func (s *StorageController) delete(name string, rowid int64) error {
+	ti, err := s.lookupNativeTable(name)
+	if err != nil {
+		return err
+	}
+	old, found, err := s.get(name, rowid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Row (%d) Not Found", rowid))
+	}
+	if err := s.deleteIndexes(ti, rowid, old); err != nil {
+		return err
+	}
+	if _, err := btree.Open(s.pool, ti.Root).Delete(rowKey(rowid)); err != nil {
+		return err
+	}
+	s.addVersion(name, rowid, old, true)
+	return nil
+}
+
+func (s *StorageController) Scan(name string, fn func(int64, []table.Value) (bool, error)) error {
+	return s.scanRows(name, 0, false, nil, fn)
+}
+
+func (s *StorageController) ImportCSV(name string, fn string) error {
+	return s.run(func() error {
+		s.schema++
+		return s.importCSV(name, fn)
+	})
+}
+
+func (s *StorageController) importCSV(name string, fn string) error {
+	tv, err := csv.Read(fn)
+	if err != nil {
+		return err
+	}
+	if err := s.createTable(name, tv.Header); err != nil {
+		return err
+	}
+	for _, r := range tv.Values {
+		row := []table.Value{}
+		for _, h := range tv.Header {
+			row = append(row, r[h].Value)
+		}
+		if _, err := s.insert(name, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StorageController) ExportCSV(name string, fn string) error {
+	s.mu.Lock()
+	ti, err := s.lookupTable(name)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	cols := ti.Columns
+	tv := &table.TableValue{
+		Header: cols,
+	}
+	err = s.Scan(name, func(rowid int64, row []table.Value) (bool, error) {
+		r := map[string]table.ColumnValue{}
+		for n, h := range cols {
+			r[h] = table.ColumnValue{Name: h, Value: row[n]}
+		}
+		tv.Values = append(tv.Values, r)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return csv.Write(fn, tv)
+}
+
+func columnIndexes(ti *TableInfo, cols []string) ([]int, error) {
+	idx := []int{}
+	for _, c := range cols {
+		found := false
+		for n, h := range ti.Columns {
+			if h == c {
+				idx = append(idx, n)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return idx, errors.New(fmt.Sprintf("Column (%s.%s) Not Found", ti.Name, c))
+		}
+	}
+	return idx, nil
+}
+
+func indexValues(ti *TableInfo, idx IndexInfo, row []table.Value) []table.Value {
+	pos, _ := columnIndexes(ti, idx.Columns)
+	vals := []table.Value{}
+	for _, p := range pos {
+		vals = append(vals, row[p])
+	}
+	return vals
+}
+
+func (s *StorageController) CreateIndex(tbl string, name string, cols []string, unique bool) error {
+	return s.run(func() error {
+		s.schema++
+		return s.createIndex(tbl, name, cols, unique)
+	})
+}
+
+func (s *StorageController) createIndex(tbl string, name string, cols []string, unique bool) error {
+	ti, err := s.lookupNativeTable(tbl)
+	if err != nil {
+		return err
+	}
+	for _, idx := range ti.Indexes {
+		if idx.Name == name {
+			return errors.New(fmt.Sprintf("Index (%s) already exists", name))
+		}
+	}
+	if _, err := columnIndexes(ti, cols); err != nil {
+		return err
+	}
+
+	tree, err := btree.Create(s.pool)
+	if err != nil {
+		return err
+	}
+	idx := IndexInfo{
+		Name:    name,
+		Columns: cols,
+		Unique:  unique,
+		Root:    tree.Root(),
+	}
+
+	err = btree.Open(s.pool, ti.Root).Scan(nil, func(k []byte, v []byte) (bool, error) {
+		row, err := decodeRow(v)
+		if err != nil {
+			return false, err
+		}
+		k = append(encodeKey(indexValues(ti, idx, row)), k...)
+		return true, tree.Put(k, []byte{})
+	})
+	if err == nil && unique {
+		var prev []byte
+		err = tree.Scan(nil, func(k []byte, v []byte) (bool, error) {
+			p := k[:len(k)-8]
+			if prev != nil && bytes.Equal(prev, p) && !keyHasNull(p) {
+				return false, errors.New(fmt.Sprintf("Index (%s) UNIQUE constraint failed", name))
+			}
+			prev = append([]byte{}, p...)
+			return true, nil
+		})
+	}
+	if err != nil {
+		tree.Drop()
+		return err
+	}
+
+	ti.Indexes = append(ti.Indexes, idx)
+	return s.saveTable(ti)
+}
+
+func (s *StorageController) DropIndex(tbl string, name string) error {
+	return s.run(func() error {
+		s.schema++
+		return s.dropIndex(tbl, name)
+	})
+}
+
+func (s *StorageController) dropIndex(tbl string, name string) error {
+	ti, err := s.lookupNativeTable(tbl)
+	if err != nil {
+		return err
+	}
+	for n, idx := range ti.Indexes {
+		if idx.Name != name {
+			continue
+		}
+		if err := btree.Open(s.pool, idx.Root).Drop(); err != nil {
+			return err
+		}
+		ti.Indexes = append(ti.Indexes[:n], ti.Indexes[n+1:]...)
+		return s.saveTable(ti)
+	}
+	return errors.New(fmt.Sprintf("Index (%s) Not Found", name))
+}
+
+func (s *StorageController) IndexLookup(tbl string, name string, key []table.Value, fn func(int64) (bool, error)) error {
+	s.mu.Lock()
+	rowids, err := s.indexLookup(tbl, name, key)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, rowid := range rowids {
+		cont, err := fn(rowid)
+		if err != nil || !cont {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StorageController) indexLookup(tbl string, name string, key []table.Value) ([]int64, error) {
+	ti, err := s.lookupNativeTable(tbl)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range ti.Indexes {
+		if idx.Name != name {
+			continue
+		}
+		rowids := []int64{}
+		prefix := encodeKey(key)
+		err := btree.Open(s.pool, idx.Root).Scan(prefix, func(k []byte, v []byte) (bool, error) {
+			if !bytes.HasPrefix(k, prefix) {
+				return false, nil
+			}
+			rowids = append(rowids, decodeRowKey(k))
+			return true, nil
+		})
+		return rowids, err
+	}
+	return nil, errors.New(fmt.Sprintf("Index (%s) Not Found", name))
+}
+
+func (s *StorageController) checkUnique(ti *TableInfo, rowid int64, row []table.Value) error {
+	for _, idx := range ti.Indexes {
+		if !idx.Unique {
+			continue
+		}
+		vals := indexValues(ti, idx, row)
+		if hasNull(vals) {
+			continue
+		}
+		prefix := encodeKey(vals)
+		err := btree.Open(s.pool, idx.Root).Scan(prefix, func(k []byte, v []byte) (bool, error) {
+			if !bytes.HasPrefix(k, prefix) {
+				return false, nil
+			}
+			if decodeRowKey(k) != rowid {
+				return false, errors.New(fmt.Sprintf("Index (%s) UNIQUE constraint failed", idx.Name))
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StorageController) insertIndexes(ti *TableInfo, rowid int64, row []table.Value) error {
+	for _, idx := range ti.Indexes {
+		k := append(encodeKey(indexValues(ti, idx, row)), rowKey(rowid)...)
+		if err := btree.Open(s.pool, idx.Root).Put(k, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StorageController) deleteIndexes(ti *TableInfo, rowid int64, row []table.Value) error {
+	for _, idx := range ti.Indexes {
+		k := append(encodeKey(indexValues(ti, idx, row)), rowKey(rowid)...)
+		if _, err := btree.Open(s.pool, idx.Root).Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
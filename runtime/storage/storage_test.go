@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/csv"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func integers(vals ...int) []table.Value {
+	row := []table.Value{}
+	for _, v := range vals {
+		row = append(row, table.Value{Type: table.Integer, Integral: v})
+	}
+	return row
+}
+
+func scanAll(t *testing.T, s *StorageController, name string) map[int64][]table.Value {
+	rows := map[int64][]table.Value{}
+	err := s.Scan(name, func(rowid int64, row []table.Value) (bool, error) {
+		rows[rowid] = row
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return rows
+}
+
+func TestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "test.db")
+
+	s := GetInstance()
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.Close()
+
+	if err := s.CreateTable("tbl", []string{"colA", "colB"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateTable("tbl", []string{"colA"}); err == nil {
+		t.Fatalf("Duplicated table is created")
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := s.Insert("tbl", integers(i, i*10)); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", i, err)
+		}
+	}
+	if _, err := s.Insert("tbl", integers(1)); err == nil {
+		t.Fatalf("Insert with wrong column count succeeded")
+	}
+
+	if err := s.CreateIndex("tbl", "idx_colB", []string{"colB"}, true); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(1000, 500)); err == nil {
+		t.Fatalf("UNIQUE constraint is not checked")
+	}
+	if err := s.Update("tbl", 1, integers(-1, -10)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Update("tbl", 2, integers(0, 20)); err == nil {
+		t.Fatalf("UNIQUE constraint is not checked on update")
+	}
+	if err := s.Delete("tbl", 3); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	rows := []int64{}
+	lookup := func(rowid int64) (bool, error) {
+		rows = append(rows, rowid)
+		return true, nil
+	}
+	if err := s.IndexLookup("tbl", "idx_colB", integers(-10), lookup); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.IndexLookup("tbl", "idx_colB", integers(20), lookup); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(rows, []int64{1}) {
+		t.Fatalf("Index lookup mismatch %v", rows)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	all := scanAll(t, s, "tbl")
+	if len(all) != 99 {
+		t.Fatalf("Row count mismatch %d", len(all))
+	}
+	if !reflect.DeepEqual(all[1], integers(-1, -10)) {
+		t.Fatalf("Updated row mismatch %v", all[1])
+	}
+	if _, exists := all[3]; exists {
+		t.Fatalf("Deleted row still exists")
+	}
+	if rowid, err := s.Insert("tbl", integers(100, 1000)); err != nil || rowid != 101 {
+		t.Fatalf("rowid mismatch %d: %v", rowid, err)
+	}
+
+	if err := s.DropTable("tbl"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(s.ReadTables(), []string{}) {
+		t.Fatalf("Table is not dropped %v", s.ReadTables())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := GetInstance()
+	if err := s.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.Close()
+
+	if err := s.ImportCSV("tbl1", "../../testdata/tbl1.csv"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateExternalTable("ext", "../../testdata/tbl1.csv"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(scanAll(t, s, "tbl1"), scanAll(t, s, "ext")) {
+		t.Fatalf("Imported table mismatch")
+	}
+	if _, err := s.Insert("ext", integers(1, 2)); err == nil {
+		t.Fatalf("External table is writable")
+	}
+
+	out := filepath.Join(dir, "out.csv")
+	if err := s.ExportCSV("tbl1", out); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	expected, _ := csv.Read("../../testdata/tbl1.csv")
+	exported, err := csv.Read(out)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(expected, exported) {
+		t.Fatalf("Exported table mismatch %#+v", exported)
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/runtime/storage/pager"
+)
+
+type savepoint struct {
+	name string
+	mark mark
+}
+
+type mark struct {
+	pager   *pager.Savepoint
+	pending int
+}
+
+func (s *StorageController) Opened() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pager != nil
+}
+
+func (s *StorageController) InTransaction() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tx
+}
+
+// run executes a single modification. Outside a transaction it is committed
+// on its own; inside a transaction a failure only undoes the modification.
+func (s *StorageController) run(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return fn()
+	}
+	if !s.tx {
+		if err := fn(); err != nil {
+			s.rollback()
+			return err
+		}
+		return s.commit()
+	}
+
+	mark, err := s.mark()
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		s.restore(mark)
+		return err
+	}
+	return nil
+}
+
+func (s *StorageController) mark() (mark, error) {
+	if err := s.pool.Flush(); err != nil {
+		return mark{}, err
+	}
+	return mark{pager: s.pager.Savepoint(), pending: len(s.pending)}, nil
+}
+
+func (s *StorageController) restore(m mark) error {
+	s.discard(m.pending)
+	s.pool.Discard()
+	if err := s.pager.RollbackTo(m.pager); err != nil {
+		return err
+	}
+	return s.loadCatalog()
+}
+
+func (s *StorageController) Begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pager == nil {
+		return errors.New("Database is not opened")
+	}
+	if s.tx {
+		return errors.New("Transaction is already active")
+	}
+	s.tx = true
+	s.savepoints = []savepoint{}
+	return nil
+}
+
+func (s *StorageController) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tx {
+		return errors.New("No transaction is active")
+	}
+	s.tx = false
+	s.savepoints = []savepoint{}
+	return s.commit()
+}
+
+func (s *StorageController) Rollback() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tx {
+		return errors.New("No transaction is active")
+	}
+	s.tx = false
+	s.savepoints = []savepoint{}
+	return s.rollback()
+}
+
+func (s *StorageController) Savepoint(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tx {
+		return errors.New("No transaction is active")
+	}
+	mark, err := s.mark()
+	if err != nil {
+		return err
+	}
+	s.savepoints = append(s.savepoints, savepoint{name: name, mark: mark})
+	return nil
+}
+
+func (s *StorageController) findSavepoint(name string) (int, error) {
+	for n := len(s.savepoints) - 1; n >= 0; n-- {
+		if s.savepoints[n].name == name {
+			return n, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Savepoint (%s) Not Found", name))
+}
+
+func (s *StorageController) Release(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	s.savepoints = s.savepoints[:n]
+	return nil
+}
+
+func (s *StorageController) RollbackTo(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	s.savepoints = s.savepoints[:n+1]
+	return s.restore(s.savepoints[n].mark)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "test.db")
+
+	s := New()
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateIndex("tbl", "idx", []string{"colA"}, true); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Commit(); err == nil {
+		t.Fatalf("COMMIT without transaction succeeded")
+	}
+
+	if err := s.Begin(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Begin(); err == nil {
+		t.Fatalf("Nested transaction is started")
+	}
+	if _, err := s.Insert("tbl", integers(1)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(1)); err == nil {
+		t.Fatalf("Unique constraint is not checked")
+	}
+	if err := s.Savepoint("a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(2)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.CreateTable("tmp", []string{"colX"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Savepoint("b"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(3)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Checkpoint(); err == nil {
+		t.Fatalf("Checkpoint within a transaction succeeded")
+	}
+	if got := len(scanAll(t, s, "tbl")); got != 3 {
+		t.Fatalf("row count mismatch %d", got)
+	}
+
+	if err := s.RollbackTo("a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if got := len(scanAll(t, s, "tbl")); got != 1 {
+		t.Fatalf("row count mismatch after ROLLBACK TO %d", got)
+	}
+	if _, exists := s.Table("tmp"); exists {
+		t.Fatalf("table created after savepoint exists")
+	}
+	if err := s.Release("b"); err == nil {
+		t.Fatalf("savepoint rolled back over is released")
+	}
+	if _, err := s.Insert("tbl", integers(4)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Release("a"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := s.Begin(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.DropTable("tbl"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Rollback(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := s.Begin(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", integers(5)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	s = New()
+	if err := s.Open(fn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer s.Close()
+	rows := scanAll(t, s, "tbl")
+	vals := []int{}
+	for _, rowid := range []int64{1, 2, 3, 4, 5} {
+		if row, exists := rows[rowid]; exists {
+			vals = append(vals, row[0].Integral)
+		}
+	}
+	if !reflect.DeepEqual(vals, []int{1, 4}) {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+}
@@ -0,0 +1,309 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+const magic = "SDBWAL01"
+
+const headerSize = 16
+const recordHeaderSize = 21
+
+type RecordType byte
+
+const (
+	_ RecordType = iota
+	Page
+	Commit
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case Page:
+		return "Page"
+	case Commit:
+		return "Commit"
+	default:
+		return "Unknown"
+	}
+}
+
+type Stats struct {
+	Commits int64
+	Syncs   int64
+	Size    int64
+	LSN     uint64
+}
+
+type WAL struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	file    *os.File
+	salt    uint32
+	size    int64
+	lsn     uint64
+	synced  uint64
+	syncing bool
+
+	commits int64
+	syncs   int64
+}
+
+func Open(fn string) (*WAL, error) {
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		file: f,
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if st.Size() < headerSize {
+		if err := w.Reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	buf := make([]byte, headerSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if string(buf[:len(magic)]) != magic || crc32.ChecksumIEEE(buf[:12]) != binary.LittleEndian.Uint32(buf[12:]) {
+		if err := w.Reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+	w.salt = binary.LittleEndian.Uint32(buf[8:])
+	w.size = st.Size()
+	return w, nil
+}
+
+func (w *WAL) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.salt = rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+	buf := make([]byte, headerSize)
+	copy(buf, magic)
+	binary.LittleEndian.PutUint32(buf[8:], w.salt)
+	binary.LittleEndian.PutUint32(buf[12:], crc32.ChecksumIEEE(buf[:12]))
+
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size = headerSize
+	w.synced = w.lsn
+	return nil
+}
+
+func (w *WAL) checksum(lsn uint64, tp RecordType, pgno uint32, data []byte) uint32 {
+	h := make([]byte, 21)
+	binary.LittleEndian.PutUint32(h, w.salt)
+	binary.LittleEndian.PutUint64(h[4:], lsn)
+	h[12] = byte(tp)
+	binary.LittleEndian.PutUint32(h[13:], pgno)
+	binary.LittleEndian.PutUint32(h[17:], uint32(len(data)))
+	return crc32.Update(crc32.ChecksumIEEE(h), crc32.IEEETable, data)
+}
+
+func (w *WAL) append(tp RecordType, pgno uint32, data []byte) (int64, uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	lsn := w.lsn + 1
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint64(buf, lsn)
+	buf[8] = byte(tp)
+	binary.LittleEndian.PutUint32(buf[9:], pgno)
+	binary.LittleEndian.PutUint32(buf[13:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[17:], w.checksum(lsn, tp, pgno, data))
+	buf = append(buf, data...)
+
+	offset := w.size
+	if _, err := w.file.WriteAt(buf, offset); err != nil {
+		return 0, 0, err
+	}
+	w.size += int64(len(buf))
+	w.lsn = lsn
+	return offset, lsn, nil
+}
+
+func (w *WAL) AppendPage(pgno uint32, data []byte) (int64, error) {
+	offset, _, err := w.append(Page, pgno, data)
+	return offset, err
+}
+
+func (w *WAL) AppendCommit() (uint64, error) {
+	_, lsn, err := w.append(Commit, 0, []byte{})
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	w.commits++
+	w.mu.Unlock()
+	return lsn, nil
+}
+
+// Sync makes every record up to lsn durable. Concurrent callers share a
+// single fsync: one caller syncs while the others wait for it to finish.
+func (w *WAL) Sync(lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.synced < lsn {
+		if w.syncing {
+			w.cond.Wait()
+			continue
+		}
+		w.syncing = true
+		target := w.lsn
+		w.mu.Unlock()
+		err := w.file.Sync()
+		w.mu.Lock()
+		w.syncing = false
+		w.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+		w.syncs++
+		if target > w.synced {
+			w.synced = target
+		}
+	}
+	return nil
+}
+
+func (w *WAL) ReadPage(offset int64, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := w.file.ReadAt(buf, offset+recordHeaderSize); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+func (w *WAL) LSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lsn
+}
+
+func (w *WAL) Truncate(size int64, lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if size < headerSize || size > w.size || lsn > w.lsn {
+		return errors.New(fmt.Sprintf("Invalid WAL position %d (LSN %d)", size, lsn))
+	}
+	if err := w.file.Truncate(size); err != nil {
+		return err
+	}
+	w.size = size
+	w.lsn = lsn
+	if w.synced > lsn {
+		w.synced = lsn
+	}
+	return nil
+}
+
+func (w *WAL) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Stats{
+		Commits: w.commits,
+		Syncs:   w.syncs,
+		Size:    w.size,
+		LSN:     w.lsn,
+	}
+}
+
+// Recover returns the latest committed image offset of every page and the
+// end of the last complete commit. Records after a torn or corrupted record
+// and records of an unfinished commit are ignored.
+func (w *WAL) Recover() (map[uint32]int64, int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	committed := make(map[uint32]int64)
+	pending := make(map[uint32]int64)
+	end := int64(headerSize)
+	lsn := uint64(0)
+	commitLSN := uint64(0)
+
+	h := make([]byte, recordHeaderSize)
+	for offset := int64(headerSize); offset+recordHeaderSize <= w.size; {
+		if _, err := w.file.ReadAt(h, offset); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, err
+		}
+		rlsn := binary.LittleEndian.Uint64(h)
+		tp := RecordType(h[8])
+		pgno := binary.LittleEndian.Uint32(h[9:])
+		l := int64(binary.LittleEndian.Uint32(h[13:]))
+		sum := binary.LittleEndian.Uint32(h[17:])
+
+		if (lsn != 0 && rlsn != lsn+1) || (tp != Page && tp != Commit) || offset+recordHeaderSize+l > w.size {
+			break
+		}
+		data := make([]byte, l)
+		if _, err := w.file.ReadAt(data, offset+recordHeaderSize); err != nil {
+			break
+		}
+		if w.checksum(rlsn, tp, pgno, data) != sum {
+			break
+		}
+
+		if tp == Page {
+			pending[pgno] = offset
+		} else {
+			for p, o := range pending {
+				committed[p] = o
+			}
+			pending = make(map[uint32]int64)
+			end = offset + recordHeaderSize + l
+			commitLSN = rlsn
+		}
+		lsn = rlsn
+		offset += recordHeaderSize + l
+	}
+
+	w.lsn = commitLSN
+	w.synced = commitLSN
+	return committed, end, nil
+}
+
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,187 @@
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func openWAL(t *testing.T) (*WAL, string, func()) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	fn := filepath.Join(dir, "test.db-wal")
+	w, err := Open(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return w, fn, func() {
+		w.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func page(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 64)
+}
+
+func TestRecover(t *testing.T) {
+	w, fn, cleanup := openWAL(t)
+	defer cleanup()
+
+	for _, pgno := range []uint32{1, 2, 1} {
+		if _, err := w.AppendPage(pgno, page(byte(pgno)+10)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	lsn, err := w.AppendCommit()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if lsn != 4 {
+		t.Fatalf("LSN mismatch %d", lsn)
+	}
+	if err := w.Sync(lsn); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	committed := w.Size()
+	if _, err := w.AppendPage(3, page(13)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	w.Close()
+
+	w, err = Open(fn)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	frames, end, err := w.Recover()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if end != committed || len(frames) != 2 || w.LSN() != 4 {
+		t.Fatalf("recovery mismatch end=%d frames=%v lsn=%d", end, frames, w.LSN())
+	}
+	if _, exists := frames[3]; exists {
+		t.Fatalf("uncommitted page is recovered")
+	}
+	buf, err := w.ReadPage(frames[1], 64)
+	if err != nil || !bytes.Equal(buf, page(11)) {
+		t.Fatalf("latest page image is not recovered")
+	}
+	if err := w.Truncate(end, w.LSN()); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := w.AppendPage(3, page(13)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if lsn, err := w.AppendCommit(); err != nil || lsn != 6 {
+		t.Fatalf("LSN mismatch %d (%v)", lsn, err)
+	}
+	frames, _, err = w.Recover()
+	if err != nil || len(frames) != 3 {
+		t.Fatalf("commit after truncation is not recovered %v (%v)", frames, err)
+	}
+	w.Close()
+}
+
+func TestTornWrite(t *testing.T) {
+	testCases := []struct {
+		name   string
+		damage func(f *os.File, size int64)
+	}{
+		{
+			name: "truncated record",
+			damage: func(f *os.File, size int64) {
+				f.Truncate(size - 1)
+			},
+		},
+		{
+			name: "corrupted data",
+			damage: func(f *os.File, size int64) {
+				f.WriteAt([]byte{0xff}, size-recordHeaderSize-10)
+			},
+		},
+		{
+			name: "corrupted header",
+			damage: func(f *os.File, size int64) {
+				f.WriteAt([]byte{0xff}, size-recordHeaderSize-64-recordHeaderSize+9)
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		w, fn, cleanup := openWAL(t)
+		w.AppendPage(1, page(1))
+		lsn, _ := w.AppendCommit()
+		w.Sync(lsn)
+		committed := w.Size()
+		w.AppendPage(2, page(2))
+		lsn, _ = w.AppendCommit()
+		w.Sync(lsn)
+		size := w.Size()
+		w.Close()
+
+		f, err := os.OpenFile(fn, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		tc.damage(f, size)
+		f.Close()
+
+		w, err = Open(fn)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		frames, end, err := w.Recover()
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if end != committed || len(frames) != 1 {
+			t.Fatalf("[%d] %s: recovery mismatch end=%d frames=%v", tn, tc.name, end, frames)
+		}
+		cleanup()
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	w, _, cleanup := openWAL(t)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := w.AppendPage(uint32(i+1), page(byte(i))); err != nil {
+				errs <- err
+				return
+			}
+			lsn, err := w.AppendCommit()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := w.Sync(lsn); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	st := w.Stats()
+	if st.Commits != 16 || st.Syncs < 1 || st.Syncs > 16 || st.LSN != 32 {
+		t.Fatalf("stats mismatch %#+v", st)
+	}
+	if err := w.Sync(st.LSN); err != nil || w.Stats().Syncs != st.Syncs {
+		t.Fatalf("durable records are synced again")
+	}
+}
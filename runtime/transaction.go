@@ -0,0 +1,294 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/yakawa/simpleDB/runtime/storage"
+)
+
+type TxMode int
+
+const (
+	_ TxMode = iota
+	Deferred
+	Immediate
+)
+
+func (m TxMode) String() string {
+	switch m {
+	case Deferred:
+		return "DEFERRED"
+	case Immediate:
+		return "IMMEDIATE"
+	default:
+		return "Unknown"
+	}
+}
+
+type savepoint struct {
+	name string
+	undo int
+}
+
+// transaction is the transaction of a session. Tables of the storage are
+// read from its snapshot, taken on BEGIN. storage is set once the session
+// holds the storage transaction of the database, which writes need.
+// deferred are the keys of the deferred foreign keys the transaction
+// wrote, checked on commit.
+type transaction struct {
+	mode       TxMode
+	implicit   bool
+	snapshot   *storage.Tx
+	storage    bool
+	savepoints []savepoint
+	undo       []func() error
+	deferred   map[string]*deferredKeys
+}
+
+func (r *Runtime) InTransaction() bool {
+	return r.session.InTransaction()
+}
+
+func (r *Runtime) Begin(mode TxMode) error {
+	return r.session.Begin(mode)
+}
+
+func (r *Runtime) Commit() error {
+	return r.session.Commit()
+}
+
+func (r *Runtime) Rollback() error {
+	return r.session.Rollback()
+}
+
+func (r *Runtime) Abort() {
+	r.session.Abort()
+}
+
+func (r *Runtime) Savepoint(name string) error {
+	return r.session.Savepoint(name)
+}
+
+func (r *Runtime) Release(name string) error {
+	return r.session.Release(name)
+}
+
+func (r *Runtime) RollbackTo(name string) error {
+	return r.session.RollbackTo(name)
+}
+
+func (s *Session) InTransaction() bool {
+	return s.tx != nil
+}
+
+func (s *Session) addUndo(fn func() error) {
+	if s.tx != nil {
+		s.tx.undo = append(s.tx.undo, fn)
+	}
+}
+
+func (s *Session) undo(n int) error {
+	var err error
+	for i := len(s.tx.undo) - 1; i >= n; i-- {
+		if uerr := s.tx.undo[i](); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	s.tx.undo = s.tx.undo[:n]
+	return err
+}
+
+// beginStorage takes the database write lock and starts the storage
+// transaction. A DEFERRED transaction does this on its first write, an
+// IMMEDIATE one on BEGIN. The storage has a single transaction, so writers
+// run one at a time; a transaction whose snapshot is older than a commit
+// that changed a row it writes fails on COMMIT, instead of overwriting a
+// change it never saw.
+func (s *Session) beginStorage() error {
+	if s.tx == nil || s.tx.storage {
+		return nil
+	}
+	if err := s.r.locks.Lock(s.id, databaseLock, Exclusive); err != nil {
+		return err
+	}
+	st := s.r.storage
+	if !st.Opened() {
+		return nil
+	}
+	if err := st.Begin(); err != nil {
+		return err
+	}
+	for _, sp := range s.tx.savepoints {
+		if err := st.Savepoint(sp.name); err != nil {
+			return err
+		}
+	}
+	s.tx.storage = true
+	return nil
+}
+
+func (s *Session) Begin(mode TxMode) error {
+	if s.tx != nil {
+		return errors.New("Transaction is already active")
+	}
+	s.tx = &transaction{
+		mode: mode,
+	}
+	// An IMMEDIATE transaction takes its snapshot once no other writer can
+	// commit, so that it never conflicts.
+	if mode == Immediate {
+		if err := s.beginStorage(); err != nil {
+			s.Rollback()
+			return err
+		}
+	}
+	if s.r.storage.Opened() {
+		snapshot, err := s.r.storage.BeginTx()
+		if err != nil {
+			s.Rollback()
+			return err
+		}
+		s.tx.snapshot = snapshot
+	}
+	return nil
+}
+
+func (s *Session) end() {
+	s.tx = nil
+	s.r.locks.UnlockAll(s.id)
+}
+
+func (s *Session) Commit() error {
+	if s.tx == nil {
+		return errors.New("No transaction is active")
+	}
+	if err := s.checkDeferredForeignKeys(); err != nil {
+		s.Rollback()
+		return err
+	}
+	if s.tx.storage && s.tx.snapshot != nil {
+		if err := s.tx.snapshot.Conflict(); err != nil {
+			s.Rollback()
+			return err
+		}
+	}
+	defer s.end()
+	var err error
+	if s.tx.storage {
+		err = s.r.storage.Commit()
+	}
+	if s.tx.snapshot != nil {
+		if err != nil {
+			s.tx.snapshot.Rollback()
+		} else {
+			err = s.tx.snapshot.Commit()
+		}
+	}
+	return err
+}
+
+func (s *Session) Rollback() error {
+	if s.tx == nil {
+		return errors.New("No transaction is active")
+	}
+	defer s.end()
+	err := s.undo(0)
+	if s.tx.storage {
+		if serr := s.r.storage.Rollback(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if s.tx.snapshot != nil {
+		s.tx.snapshot.Rollback()
+	}
+	return err
+}
+
+// Abort rolls back the active transaction, if any, after a failed statement
+// so that a batch never leaves partial changes behind.
+func (s *Session) Abort() {
+	if s.tx != nil {
+		s.Rollback()
+	}
+}
+
+func (s *Session) Savepoint(name string) error {
+	if s.tx == nil {
+		if err := s.Begin(Deferred); err != nil {
+			return err
+		}
+		s.tx.implicit = true
+	}
+	if s.tx.storage {
+		if err := s.r.storage.Savepoint(name); err != nil {
+			return err
+		}
+	}
+	s.tx.savepoints = append(s.tx.savepoints, savepoint{name: name, undo: len(s.tx.undo)})
+	return nil
+}
+
+func (s *Session) findSavepoint(name string) (int, error) {
+	if s.tx != nil {
+		for n := len(s.tx.savepoints) - 1; n >= 0; n-- {
+			if s.tx.savepoints[n].name == name {
+				return n, nil
+			}
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Savepoint (%s) Not Found", name))
+}
+
+func (s *Session) Release(name string) error {
+	n, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	if n == 0 && s.tx.implicit {
+		return s.Commit()
+	}
+	if s.tx.storage {
+		if err := s.r.storage.Release(name); err != nil {
+			return err
+		}
+	}
+	s.tx.savepoints = s.tx.savepoints[:n]
+	return nil
+}
+
+func (s *Session) RollbackTo(name string) error {
+	n, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	err = s.undo(s.tx.savepoints[n].undo)
+	if s.tx.storage {
+		if serr := s.r.storage.RollbackTo(name); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	s.tx.savepoints = s.tx.savepoints[:n+1]
+	return err
+}
+
+// checkDeferredForeignKeys checks the keys of the deferred foreign keys
+// the transaction wrote: the rows written to a child table and the rows
+// removed from, or changed in, a parent table. The other rows were checked
+// when they were written.
+func (s *Session) checkDeferredForeignKeys() error {
+	names := []string{}
+	for name := range s.tx.deferred {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d := s.tx.deferred[name]
+		if err := s.r.checkForeignKeys(s, d.fk, d.keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func TestTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	r := GetInstance().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	s := storage.GetInstance()
+	if err := s.CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if err := r.Commit(); err == nil {
+		t.Fatalf("COMMIT without transaction succeeded")
+	}
+	if err := r.Begin(Immediate); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.Begin(Deferred); err == nil {
+		t.Fatalf("Nested transaction is started")
+	}
+	if err := r.CreateIndex("_", "tbl1", "idx_colA", []string{"colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.Savepoint("sp1"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.CreateIndex("_", "tbl", "idx_tbl", []string{"colA"}, true); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("tbl", []table.Value{{Type: table.Integer, Integral: 1}}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.RollbackTo("sp1"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if ti, _ := s.Table("tbl"); len(ti.Indexes) != 0 || ti.NextRowID != 1 {
+		t.Fatalf("changes after savepoint are not undone %#+v", ti)
+	}
+	if r.FindIndex("_", "tbl1", []string{"colA"}) == nil {
+		t.Fatalf("changes before savepoint are undone")
+	}
+	if err := r.Rollback(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if r.FindIndex("_", "tbl1", []string{"colA"}) != nil {
+		t.Fatalf("Index is not rolled back")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tbl1.idx_colA.idx")); !os.IsNotExist(err) {
+		t.Fatalf("Index file is not removed on rollback")
+	}
+
+	if err := r.Savepoint("sp2"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !r.InTransaction() {
+		t.Fatalf("SAVEPOINT does not start a transaction")
+	}
+	if err := r.CreateIndex("_", "tbl", "idx_tbl", []string{"colA"}, true); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.Release("sp2"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if r.InTransaction() {
+		t.Fatalf("RELEASE of the outermost savepoint does not commit")
+	}
+	if err := r.RollbackTo("sp2"); err == nil {
+		t.Fatalf("ROLLBACK TO released savepoint succeeded")
+	}
+	if ti, _ := s.Table("tbl"); len(ti.Indexes) != 1 {
+		t.Fatalf("Index is not committed")
+	}
+}
+
+func TestSnapshotRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	st := r.Storage()
+	if err := st.CreateTable("tbl", []string{"colA"}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for n := 1; n <= 1000; n++ {
+		if _, err := st.Insert("tbl", []table.Value{{Type: table.Integer, Integral: n}}); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+	integer := func(n int) []table.Value {
+		return []table.Value{{Type: table.Integer, Integral: n}}
+	}
+	sum := func(s *Session, write func()) (int, int) {
+		rows, total := 0, 0
+		err := s.ReadColumnsFromLocalTable("_", "tbl", nil, func(row []table.ColumnValue) {
+			if rows == 0 && write != nil {
+				write()
+			}
+			rows++
+			total += row[0].Value.Integral
+		})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		return rows, total
+	}
+
+	// Writes committed while the table is scanned are not seen by the scan.
+	s := r.NewSession()
+	rows, total := sum(s, func() {
+		if err := st.Update("tbl", 900, integer(0)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		tx, err := st.BeginTx()
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if err := tx.Delete("tbl", 500); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if _, err := tx.Insert("tbl", integer(1001)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	})
+	if rows != 1000 || total != 500500 {
+		t.Fatalf("Scan mismatch %d rows, total %d", rows, total)
+	}
+	if rows, total = sum(s, nil); rows != 1000 || total != 500500-900-500+1001 {
+		t.Fatalf("Scan mismatch %d rows, total %d", rows, total)
+	}
+
+	// A transaction reads from the snapshot taken on BEGIN until it ends.
+	if err := s.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := st.Update("tbl", 1, integer(0)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if rows, total = sum(s, nil); rows != 1000 || total != 500500-900-500+1001 {
+		t.Fatalf("Scan mismatch %d rows, total %d", rows, total)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if rows, total = sum(s, nil); rows != 1000 || total != 500500-900-500+1001-1 {
+		t.Fatalf("Scan mismatch %d rows, total %d", rows, total)
+	}
+	if n := st.VersionCount(); n != 0 {
+		t.Fatalf("%d versions are left", n)
+	}
+}
+
+func TestWriteConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	integer := func(n int) []table.Value {
+		return []table.Value{{Type: table.Integer, Integral: n}}
+	}
+	s1, s2 := r.NewSession(), r.NewSession()
+	if err := s1.CreateTable("_", "tbl", []string{"colA"}, nil); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for n := 1; n <= 2; n++ {
+		if _, err := s1.Insert("_", "tbl", integer(n)); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+	}
+
+	// A row another session changed after BEGIN may not be written.
+	if err := s1.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s2.Update("_", "tbl", 1, integer(10)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Update("_", "tbl", 2, integer(20)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Update("_", "tbl", 1, integer(30)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Commit(); err == nil {
+		t.Fatalf("write-write conflict is not detected")
+	}
+	for rowid, expected := range map[int64]int{1: 10, 2: 2} {
+		if row, _, _ := r.Storage().Get("tbl", rowid); row[0].Integral != expected {
+			t.Fatalf("row (%d) mismatch %v", rowid, row)
+		}
+	}
+	if s1.InTransaction() {
+		t.Fatalf("conflicting transaction is not rolled back")
+	}
+
+	// Other rows, and rows written after the snapshot of an IMMEDIATE
+	// transaction, are not conflicts.
+	if err := s1.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s2.Update("_", "tbl", 1, integer(40)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Update("_", "tbl", 2, integer(50)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Begin(Immediate); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Update("_", "tbl", 1, integer(60)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s1.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if row, _, _ := r.Storage().Get("tbl", 1); row[0].Integral != 60 {
+		t.Fatalf("row (1) mismatch %v", row)
+	}
+}
@@ -0,0 +1,414 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes a listing of codes to w, an instruction per line:
+//
+//	0  READ          table LOCAL._.tbl1 (colA), int 0 ; open a table as cursor 0
+//	1  NEXT          int 5, int 0                     ; next row of cursor 0 or goto 5
+//	2  FETCH         column LOCAL._.tbl1.colA         ; read a column of cursor 0
+//
+// An operand is its type followed by the fields that type uses. Names are
+// quoted when they are not identifiers, and qualified by their schema and
+// database. Assemble reads the listing back.
+func Disassemble(w io.Writer, codes []VMCode) error {
+	for addr, c := range codes {
+		if c.Operator <= 0 || c.Operator >= numOperators {
+			return errors.New(fmt.Sprintf("Operator (%d) at %d is unknown", c.Operator, addr))
+		}
+		ops := []string{}
+		for _, v := range []VMValue{c.Operand1, c.Operand2} {
+			op, err := v.assembly()
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s at %d", err, addr))
+			}
+			ops = append(ops, op)
+		}
+		switch {
+		case c.Operand2.Type != 0:
+		case c.Operand1.Type != 0:
+			ops = ops[:1]
+		default:
+			ops = ops[:0]
+		}
+		line := fmt.Sprintf("%4d  %-13s %s", addr, c.Operator, strings.Join(ops, ", "))
+		if comment := c.Comment(); comment != "" {
+			line = fmt.Sprintf("%-52s ; %s", line, comment)
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v VMValue) assembly() (string, error) {
+	switch v.Type {
+	case 0:
+		return "none", nil
+	case Nothing:
+		return "nothing", nil
+	case Null:
+		return "null", nil
+	case Integer:
+		return fmt.Sprintf("int %d", v.Integral), nil
+	case Label:
+		return fmt.Sprintf("label %d", v.Integral), nil
+	case String:
+		return "str " + strconv.Quote(v.String), nil
+	case Table:
+		return "table " + path(v.Table.Schema, v.Table.DB, v.Table.Table) + columnList(v.Table.Columns), nil
+	case Column:
+		return "column " + path(v.Column.Schema, v.Column.DB, v.Column.Table, v.Column.Column), nil
+	case Index:
+		s := "index " + path(v.Index.Schema, v.Index.DB, v.Index.Table, v.Index.Index) + columnList(v.Index.Columns)
+		if v.Index.Unique {
+			s += " unique"
+		}
+		return s, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Value type (%d) is unknown", v.Type))
+	}
+}
+
+func path(names ...string) string {
+	for n, name := range names {
+		names[n] = asmName(name)
+	}
+	return strings.Join(names, ".")
+}
+
+func columnList(cols []string) string {
+	if cols == nil {
+		return ""
+	}
+	names := []string{}
+	for _, c := range cols {
+		names = append(names, asmName(c))
+	}
+	return " (" + strings.Join(names, ", ") + ")"
+}
+
+// asmName quotes name unless it is an identifier.
+func asmName(name string) string {
+	for n, ch := range name {
+		if !(ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || (n > 0 && '0' <= ch && ch <= '9')) {
+			return strconv.Quote(name)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
+
+var operators = map[string]OpeType{}
+
+func init() {
+	for o := PUSH; o < numOperators; o++ {
+		operators[o.String()] = o
+	}
+}
+
+// Assemble reads codes from a listing written by Disassemble. The address
+// at the start of a line may be left out, and everything after a ';' is a
+// comment. Blank lines are skipped.
+func Assemble(r io.Reader) ([]VMCode, error) {
+	codes := []VMCode{}
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		toks, err := tokenizeAsm(sc.Text())
+		if err != nil {
+			return []VMCode{}, errors.New(fmt.Sprintf("%s at line %d", err, ln))
+		}
+		if len(toks) == 0 {
+			continue
+		}
+		c, err := parseAsm(toks, len(codes))
+		if err != nil {
+			return []VMCode{}, errors.New(fmt.Sprintf("%s at line %d", err, ln))
+		}
+		codes = append(codes, c)
+	}
+	if err := sc.Err(); err != nil {
+		return []VMCode{}, err
+	}
+	return codes, nil
+}
+
+// Load reads codes written either by Encode or by Disassemble.
+func Load(r io.Reader) ([]VMCode, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return []VMCode{}, err
+	}
+	if bytes.HasPrefix(data, []byte(magic[:len(magic)-2])) {
+		return Decode(bytes.NewReader(data))
+	}
+	return Assemble(bytes.NewReader(data))
+}
+
+// asmToken is a word, a quoted name or string, or one of ".,()".
+type asmToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeAsm(line string) ([]asmToken, error) {
+	toks := []asmToken{}
+	for i := 0; i < len(line); {
+		ch := line[i]
+		switch {
+		case ch == ';':
+			return toks, nil
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case strings.IndexByte(".,()", ch) >= 0:
+			toks = append(toks, asmToken{text: line[i : i+1]})
+			i++
+		case ch == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return toks, errors.New("Unterminated string")
+			}
+			s, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return toks, errors.New(fmt.Sprintf("Invalid string (%s)", line[i:j+1]))
+			}
+			toks = append(toks, asmToken{text: s, quoted: true})
+			i = j + 1
+		case ch == '_' || ch == '-' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9'):
+			j := i + 1
+			for ; j < len(line); j++ {
+				c := line[j]
+				if !(c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')) {
+					break
+				}
+			}
+			toks = append(toks, asmToken{text: line[i:j]})
+			i = j
+		default:
+			return toks, errors.New(fmt.Sprintf("Unexpected character (%c)", ch))
+		}
+	}
+	return toks, nil
+}
+
+// asmParser reads an instruction from the tokens of its line.
+type asmParser struct {
+	toks []asmToken
+	pos  int
+}
+
+func (p *asmParser) next() (asmToken, bool) {
+	if p.pos >= len(p.toks) {
+		return asmToken{}, false
+	}
+	p.pos++
+	return p.toks[p.pos-1], true
+}
+
+// peek reports whether the next token is the word s.
+func (p *asmParser) peek(s string) bool {
+	return p.pos < len(p.toks) && !p.toks[p.pos].quoted && p.toks[p.pos].text == s
+}
+
+func (p *asmParser) expect(s string) error {
+	if !p.peek(s) {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
+
+func (p *asmParser) unexpected() error {
+	if p.pos >= len(p.toks) {
+		return errors.New("Unexpected end of line")
+	}
+	return errors.New(fmt.Sprintf("Unexpected token (%s)", p.toks[p.pos].text))
+}
+
+func (p *asmParser) integer() (int, error) {
+	t, ok := p.next()
+	if !ok || t.quoted {
+		if ok {
+			p.pos--
+		}
+		return 0, p.unexpected()
+	}
+	n, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid integer (%s)", t.text))
+	}
+	return n, nil
+}
+
+func (p *asmParser) name() (string, error) {
+	t, ok := p.next()
+	if !ok || (!t.quoted && (strings.IndexAny(t.text, ".,()-") >= 0 || ('0' <= t.text[0] && t.text[0] <= '9'))) {
+		if ok {
+			p.pos--
+		}
+		return "", p.unexpected()
+	}
+	return t.text, nil
+}
+
+// path reads n names separated by '.'.
+func (p *asmParser) path(n int) ([]string, error) {
+	names := []string{}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if err := p.expect("."); err != nil {
+				return names, err
+			}
+		}
+		name, err := p.name()
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// columns reads an optional list of names in parentheses.
+func (p *asmParser) columns() ([]string, error) {
+	if !p.peek("(") {
+		return nil, nil
+	}
+	p.pos++
+	cols := []string{}
+	for !p.peek(")") {
+		if len(cols) > 0 {
+			if err := p.expect(","); err != nil {
+				return cols, err
+			}
+		}
+		name, err := p.name()
+		if err != nil {
+			return cols, err
+		}
+		cols = append(cols, name)
+	}
+	p.pos++
+	return cols, nil
+}
+
+func (p *asmParser) value() (VMValue, error) {
+	t, ok := p.next()
+	if !ok || t.quoted {
+		if ok {
+			p.pos--
+		}
+		return VMValue{}, p.unexpected()
+	}
+	switch t.text {
+	case "none":
+		return VMValue{}, nil
+	case "nothing":
+		return VMValue{Type: Nothing}, nil
+	case "null":
+		return VMValue{Type: Null}, nil
+	case "int", "label":
+		n, err := p.integer()
+		if t.text == "label" {
+			return VMValue{Type: Label, Integral: n}, err
+		}
+		return VMValue{Type: Integer, Integral: n}, err
+	case "str":
+		s, ok := p.next()
+		if !ok || !s.quoted {
+			if ok {
+				p.pos--
+			}
+			return VMValue{}, p.unexpected()
+		}
+		return VMValue{Type: String, String: s.text}, nil
+	case "table":
+		names, err := p.path(3)
+		if err != nil {
+			return VMValue{}, err
+		}
+		cols, err := p.columns()
+		return VMValue{Type: Table, Table: VMTable{Schema: names[0], DB: names[1], Table: names[2], Columns: cols}}, err
+	case "column":
+		names, err := p.path(4)
+		if err != nil {
+			return VMValue{}, err
+		}
+		return VMValue{Type: Column, Column: VMColumn{Schema: names[0], DB: names[1], Table: names[2], Column: names[3]}}, nil
+	case "index":
+		names, err := p.path(4)
+		if err != nil {
+			return VMValue{}, err
+		}
+		cols, err := p.columns()
+		if err != nil {
+			return VMValue{}, err
+		}
+		v := VMValue{Type: Index, Index: VMIndex{Schema: names[0], DB: names[1], Table: names[2], Index: names[3], Columns: cols}}
+		if p.peek("unique") {
+			p.pos++
+			v.Index.Unique = true
+		}
+		return v, nil
+	default:
+		return VMValue{}, errors.New(fmt.Sprintf("Unknown operand type (%s)", t.text))
+	}
+}
+
+func parseAsm(toks []asmToken, addr int) (VMCode, error) {
+	p := &asmParser{toks: toks}
+	if t := toks[0]; !t.quoted && '0' <= t.text[0] && t.text[0] <= '9' {
+		n, err := p.integer()
+		if err != nil {
+			return VMCode{}, err
+		}
+		if n != addr {
+			return VMCode{}, errors.New(fmt.Sprintf("Address (%d) does not match %d", n, addr))
+		}
+	}
+	t, ok := p.next()
+	if !ok {
+		return VMCode{}, p.unexpected()
+	}
+	op, exists := operators[t.text]
+	if t.quoted || !exists {
+		return VMCode{}, errors.New(fmt.Sprintf("Unknown operator (%s)", t.text))
+	}
+	c := VMCode{Operator: op}
+	if p.pos < len(p.toks) {
+		v, err := p.value()
+		if err != nil {
+			return VMCode{}, err
+		}
+		c.Operand1 = v
+	}
+	if p.peek(",") {
+		p.pos++
+		v, err := p.value()
+		if err != nil {
+			return VMCode{}, err
+		}
+		c.Operand2 = v
+	}
+	if p.pos < len(p.toks) {
+		return VMCode{}, p.unexpected()
+	}
+	return c, nil
+}
@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codeCases hold a value of every type, with names that need quoting.
+var codeCases = []VMCode{
+	read("colA", "colB"),
+	fetch("colA"),
+	push(-1),
+	{Operator: PUSH, Operand1: VMValue{Type: Null}},
+	{Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}},
+	{Operator: STORE, Operand1: VMValue{Type: Nothing}},
+	{Operator: JUMP_IF_FALSE, Operand1: VMValue{Type: Label, Integral: 3}},
+	{Operator: READ, Operand1: VMValue{Type: Table, Table: VMTable{Table: "tbl 1", DB: "db.1", Schema: "LOCAL", Columns: []string{}}}},
+	{Operator: FETCH, Operand1: VMValue{Type: Column, Column: VMColumn{Column: "1col", DB: "_", Schema: "LOCAL"}}},
+	{Operator: CREATE_INDEX, Operand1: VMValue{Type: Index, Index: VMIndex{Index: "idx1", Table: "tbl1", DB: "_", Schema: "LOCAL", Columns: []string{"colA", "colB"}, Unique: true}}},
+	{Operator: DROP_INDEX, Operand1: VMValue{Type: Index, Index: VMIndex{Index: "idx1", DB: "_", Schema: "LOCAL"}}},
+	{Operator: PRAGMA, Operand1: VMValue{Type: String, String: "say \"hi\";\n"}, Operand2: VMValue{Type: Integer, Integral: 1}},
+	{Operator: REPORT, Operand2: VMValue{Type: Integer, Integral: 2}},
+	{Operator: ROW},
+}
+
+func TestDisassemble(t *testing.T) {
+	expected := `   0  READ          table LOCAL._.tbl1 (colA, colB)  ; open a table as cursor 0
+   1  FETCH         column LOCAL._."".colA           ; read a column of cursor 0
+   2  PUSH          int -1                           ; push a constant
+   3  PUSH          null                             ; push a constant
+   4  CALL          str "ABS"                        ; call ABS()
+   5  STORE         nothing                          ; output a column
+   6  JUMP_IF_FALSE label 3                          ; if a is false goto L3
+   7  READ          table LOCAL."db.1"."tbl 1" ()    ; open a table as cursor 0
+   8  FETCH         column LOCAL._.""."1col"         ; read a column of cursor 0
+   9  CREATE_INDEX  index LOCAL._.tbl1.idx1 (colA, colB) unique ; create an index
+  10  DROP_INDEX    index LOCAL._."".idx1            ; drop an index
+  11  PRAGMA        str "say \"hi\";\n", int 1       ; run a pragma
+  12  REPORT        none, int 2                      ; output the profile of 0..2
+  13  ROW                                            ; end of a result row
+`
+	var sb strings.Builder
+	if err := Disassemble(&sb, codeCases); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if sb.String() != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, sb.String())
+	}
+
+	codes, err := Assemble(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if !reflect.DeepEqual(codes, codeCases) {
+		t.Fatalf("expected %v but got %v", codeCases, codes)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected []VMCode
+	}{
+		{"", []VMCode{}},
+		{"PUSH int 1\nPUSH int 2\nADD\nSTORE", []VMCode{push(1), push(2), {Operator: ADD}, {Operator: STORE}}},
+		{"; 1 + 2\n\n  0 PUSH int 1 ; a\n1 PUSH int 2\r\n", []VMCode{push(1), push(2)}},
+		{"READ table LOCAL._.tbl1 (colA,colB)\nFETCH column LOCAL . _ . \"\" . colA", []VMCode{read("colA", "colB"), fetch("colA")}},
+		{"JUMP int 0", []VMCode{jump(JUMP, 0)}},
+	}
+
+	for tn, tc := range testCases {
+		codes, err := Assemble(strings.NewReader(tc.src))
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if !reflect.DeepEqual(codes, tc.expected) {
+			t.Fatalf("[%d] expected %v but got %v", tn, tc.expected, codes)
+		}
+	}
+}
+
+func TestAssembleError(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected string
+	}{
+		{"PUSH int 1\nPOKE", "Unknown operator (POKE) at line 2"},
+		{"1 PUSH int 1", "Address (1) does not match 0 at line 1"},
+		{"PUSH int", "Unexpected end of line at line 1"},
+		{"PUSH int one", "Invalid integer (one) at line 1"},
+		{"PUSH float 1", "Unknown operand type (float) at line 1"},
+		{"PUSH int 1 2", "Unexpected token (2) at line 1"},
+		{"CALL str ABS", "Unexpected token (ABS) at line 1"},
+		{"CALL str \"ABS", "Unterminated string at line 1"},
+		{"READ table LOCAL._", "Unexpected end of line at line 1"},
+		{"READ table LOCAL._.tbl1 (colA colB)", "Unexpected token (colB) at line 1"},
+		{"FETCH column LOCAL._.tbl1.1col", "Unexpected token (1col) at line 1"},
+		{"PUSH int 1 # one", "Unexpected character (#) at line 1"},
+	}
+
+	for tn, tc := range testCases {
+		_, err := Assemble(strings.NewReader(tc.src))
+		if err == nil {
+			t.Fatalf("[%d] expected error", tn)
+		}
+		if err.Error() != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, err)
+		}
+	}
+}
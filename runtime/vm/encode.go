@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// magic starts the binary format of Encode. The last two characters are
+// its version.
+const magic = "SDBVMC01"
+
+// Encode writes codes to w in a binary format read back by Decode. It is
+// the magic, the number of codes, the codes and a CRC-32 of all of them,
+// in little endian. A code is its operator followed by its two operands,
+// each of them its type and the fields that type uses; other fields of the
+// value are not written.
+func Encode(w io.Writer, codes []VMCode) error {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	putUint32(&buf, uint32(len(codes)))
+	for addr, c := range codes {
+		if c.Operator <= 0 || c.Operator >= numOperators {
+			return errors.New(fmt.Sprintf("Operator (%d) at %d is unknown", c.Operator, addr))
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(c.Operator))
+		for _, v := range []VMValue{c.Operand1, c.Operand2} {
+			if err := encodeValue(&buf, v); err != nil {
+				return errors.New(fmt.Sprintf("%s at %d", err, addr))
+			}
+		}
+	}
+	putUint32(&buf, crc32.ChecksumIEEE(buf.Bytes()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func encodeValue(buf *bytes.Buffer, v VMValue) error {
+	buf.WriteByte(byte(v.Type))
+	switch v.Type {
+	case 0, Nothing, Null:
+	case Integer, Label:
+		binary.Write(buf, binary.LittleEndian, int64(v.Integral))
+	case String:
+		putString(buf, v.String)
+	case Table:
+		putStrings(buf, v.Table.Schema, v.Table.DB, v.Table.Table)
+		putColumns(buf, v.Table.Columns)
+	case Column:
+		putStrings(buf, v.Column.Schema, v.Column.DB, v.Column.Table, v.Column.Column)
+	case Index:
+		putStrings(buf, v.Index.Schema, v.Index.DB, v.Index.Table, v.Index.Index)
+		putColumns(buf, v.Index.Columns)
+		if v.Index.Unique {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	default:
+		return errors.New(fmt.Sprintf("Value type (%d) is unknown", v.Type))
+	}
+	return nil
+}
+
+func putUint32(buf *bytes.Buffer, n uint32) {
+	binary.Write(buf, binary.LittleEndian, n)
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUint32(buf, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func putStrings(buf *bytes.Buffer, ss ...string) {
+	for _, s := range ss {
+		putString(buf, s)
+	}
+}
+
+// putColumns writes the number of columns plus one, so that nil columns,
+// written as 0, stay nil.
+func putColumns(buf *bytes.Buffer, cols []string) {
+	if cols == nil {
+		putUint32(buf, 0)
+		return
+	}
+	putUint32(buf, uint32(len(cols)+1))
+	putStrings(buf, cols...)
+}
+
+// Decode reads codes written by Encode.
+func Decode(r io.Reader) ([]VMCode, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return []VMCode{}, err
+	}
+	if len(data) < len(magic) || !strings.HasPrefix(string(data), magic[:len(magic)-2]) {
+		return []VMCode{}, errors.New("Not a bytecode")
+	}
+	if v := string(data[len(magic)-2 : len(magic)]); v != magic[len(magic)-2:] {
+		return []VMCode{}, errors.New(fmt.Sprintf("Bytecode version (%s) is not supported", v))
+	}
+	if len(data) < len(magic)+8 || crc32.ChecksumIEEE(data[:len(data)-4]) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return []VMCode{}, errors.New("Bytecode is corrupted")
+	}
+
+	d := &decoder{data: data[len(magic) : len(data)-4]}
+	n := d.readUint32()
+	codes := []VMCode{}
+	for addr := 0; addr < int(n) && d.err == nil; addr++ {
+		c := VMCode{Operator: OpeType(d.readUint16())}
+		if d.err == nil && (c.Operator <= 0 || c.Operator >= numOperators) {
+			return []VMCode{}, errors.New(fmt.Sprintf("Operator (%d) at %d is unknown", c.Operator, addr))
+		}
+		c.Operand1 = d.readValue()
+		c.Operand2 = d.readValue()
+		if d.err != nil {
+			return []VMCode{}, errors.New(fmt.Sprintf("%s at %d", d.err, addr))
+		}
+		codes = append(codes, c)
+	}
+	if d.err == nil && len(d.data) != 0 {
+		d.err = errors.New("Bytecode has trailing data")
+	}
+	if d.err != nil {
+		return []VMCode{}, d.err
+	}
+	return codes, nil
+}
+
+// decoder reads the fields of codes from data, keeping the first error.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < n {
+		d.err = errors.New("Bytecode is truncated")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) readUint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) readUint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) readString() string {
+	n := d.readUint32()
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) readColumns() []string {
+	n := d.readUint32()
+	if n == 0 {
+		return nil
+	}
+	cols := []string{}
+	for i := uint32(1); i < n && d.err == nil; i++ {
+		cols = append(cols, d.readString())
+	}
+	return cols
+}
+
+func (d *decoder) readValue() VMValue {
+	v := VMValue{Type: ValueType(d.readByte())}
+	switch v.Type {
+	case 0, Nothing, Null:
+	case Integer, Label:
+		if b := d.next(8); b != nil {
+			v.Integral = int(int64(binary.LittleEndian.Uint64(b)))
+		}
+	case String:
+		v.String = d.readString()
+	case Table:
+		v.Table = VMTable{Schema: d.readString(), DB: d.readString(), Table: d.readString()}
+		v.Table.Columns = d.readColumns()
+	case Column:
+		v.Column = VMColumn{Schema: d.readString(), DB: d.readString(), Table: d.readString(), Column: d.readString()}
+	case Index:
+		v.Index = VMIndex{Schema: d.readString(), DB: d.readString(), Table: d.readString(), Index: d.readString()}
+		v.Index.Columns = d.readColumns()
+		v.Index.Unique = d.readByte() != 0
+	default:
+		if d.err == nil {
+			d.err = errors.New(fmt.Sprintf("Value type (%d) is unknown", v.Type))
+		}
+	}
+	return v
+}
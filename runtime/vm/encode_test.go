@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	for _, codes := range [][]VMCode{{}, codeCases} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, codes); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if !reflect.DeepEqual(decoded, codes) {
+			t.Fatalf("expected %v but got %v", codes, decoded)
+		}
+		loaded, err := Load(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if !reflect.DeepEqual(loaded, codes) {
+			t.Fatalf("expected %v but got %v", codes, loaded)
+		}
+	}
+
+	// The format is stable: PUSH int 1; STORE.
+	var buf bytes.Buffer
+	if err := Encode(&buf, []VMCode{push(1), {Operator: STORE}}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	expected := "SDBVMC01\x02\x00\x00\x00" + "\x01\x00\x02\x01\x00\x00\x00\x00\x00\x00\x00\x00" + "\x09\x00\x00\x00"
+	if actual := buf.String(); !strings.HasPrefix(actual, expected) || len(actual) != len(expected)+4 {
+		t.Fatalf("expected %q but got %q", expected, actual)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, codeCases); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	data := buf.Bytes()
+	corrupted := append([]byte{}, data...)
+	corrupted[20]++
+
+	testCases := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("PUSH int 1"), "Not a bytecode"},
+		{[]byte("SDBVMC"), "Not a bytecode"},
+		{append([]byte("SDBVMC02"), data[8:]...), "Bytecode version (02) is not supported"},
+		{data[:len(data)-1], "Bytecode is corrupted"},
+		{corrupted, "Bytecode is corrupted"},
+	}
+
+	for tn, tc := range testCases {
+		_, err := Decode(bytes.NewReader(tc.data))
+		if err == nil {
+			t.Fatalf("[%d] expected error", tn)
+		}
+		if err.Error() != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, err)
+		}
+	}
+
+	if err := Encode(&buf, []VMCode{push(1), {Operator: 99}}); err == nil || err.Error() != "Operator (99) at 1 is unknown" {
+		t.Fatalf("unknown operator is encoded: %v", err)
+	}
+	if err := Encode(&buf, []VMCode{{Operator: PUSH, Operand1: VMValue{Type: 42}}}); err == nil || err.Error() != "Value type (42) is unknown at 0" {
+		t.Fatalf("unknown value type is encoded: %v", err)
+	}
+}
@@ -2,12 +2,33 @@ package functions
 
 import (
 	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/common/value"
 )
 
 type callFunction func([]interface{}) result.Value
 
-var funcs = map[string]callFunction{
-	"ABS": funcAbs,
+// Signature describes the arguments a function accepts and the type of its
+// result.
+type Signature struct {
+	Name   string
+	Args   []value.Type
+	Result value.Type
+}
+
+type function struct {
+	call      callFunction
+	signature Signature
+}
+
+var funcs = map[string]function{
+	"ABS": {
+		call: funcAbs,
+		signature: Signature{
+			Name:   "ABS",
+			Args:   []value.Type{value.INTEGER},
+			Result: value.INTEGER,
+		},
+	},
 }
 
 func LookupFunction(name string) callFunction {
@@ -16,5 +37,13 @@ func LookupFunction(name string) callFunction {
 		return nil
 	}
 
-	return f
+	return f.call
+}
+
+func LookupSignature(name string) (Signature, bool) {
+	f, exists := funcs[name]
+	if !exists {
+		return Signature{}, false
+	}
+	return f.signature, true
 }
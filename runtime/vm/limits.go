@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"unsafe"
+
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// The sizes resources are counted with by runtime.Usage.
+var (
+	vmValueSize     = int64(unsafe.Sizeof(VMValue{}))
+	valueSize       = int64(unsafe.Sizeof(value{}))
+	resultValueSize = int64(unsafe.Sizeof(result.Value{}))
+	columnValueSize = int64(unsafe.Sizeof(table.ColumnValue{}))
+	rowSize         = int64(unsafe.Sizeof([]table.ColumnValue{}))
+	intSize         = int64(unsafe.Sizeof(int(0)))
+)
+
+// resultBytes returns the memory held by vals.
+func resultBytes(vals []result.Value) int64 {
+	n := int64(0)
+	for _, v := range vals {
+		n += resultValueSize + int64(len(v.String))
+	}
+	return n
+}
+
+// rowBytes returns the memory held by a row of a cursor. The names of the
+// columns are shared by the rows and not counted.
+func rowBytes(row []table.ColumnValue) int64 {
+	return rowSize + int64(len(row))*columnValueSize
+}
+
+// vectorBytes returns the memory held by a vector of size values.
+func vectorBytes(size int) int64 {
+	return int64(size)*intSize + int64((size+63)/64)*8
+}
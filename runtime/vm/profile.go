@@ -0,0 +1,96 @@
+package vm
+
+import (
+	"time"
+
+	"github.com/yakawa/simpleDB/common/result"
+)
+
+// profile records what each instruction of a range of code did, for
+// EXPLAIN ANALYZE. The rows the range outputs are discarded once it is run.
+// exits counts the times the program went from an instruction to another
+// one, both relative to the start of the range.
+type profile struct {
+	start int
+	end   int
+	loops []int
+	times []time.Duration
+	bytes []int64
+	exits map[[2]int]int
+
+	savedRows int
+	savedCols []result.Value
+}
+
+func newProfile(m *machine, start int, n int) *profile {
+	return &profile{
+		start:     start,
+		end:       start + n,
+		loops:     make([]int, n),
+		times:     make([]time.Duration, n),
+		bytes:     make([]int64, n),
+		exits:     map[[2]int]int{},
+		savedRows: len(m.rows),
+		savedCols: m.cols,
+	}
+}
+
+func (p *profile) covers(pc int) bool {
+	return pc >= p.start && pc < p.end
+}
+
+// run executes the instruction at pc, which is in the profiled range. When
+// the program leaves the range, the rows it output are dropped.
+func (p *profile) run(m *machine, pc int) (int, error) {
+	n := pc - p.start
+	bytes := m.sess.BytesRead()
+	start := time.Now()
+	next, err := m.exec(pc)
+	p.times[n] += time.Since(start)
+	p.bytes[n] += m.sess.BytesRead() - bytes
+	p.loops[n]++
+	if err == nil && next <= p.end && !changes(m.codes[pc].Operator) {
+		p.exits[[2]int{n, next - p.start}]++
+	}
+	if !p.covers(next) {
+		m.rows = m.rows[:p.savedRows]
+		m.cols = p.savedCols
+	}
+	return next, err
+}
+
+// changes reports whether o changes the database or the session instead of
+// making rows.
+func changes(o OpeType) bool {
+	switch o {
+	case CREATE_INDEX, DROP_INDEX, FOREIGN_KEY, CREATE_TABLE, ANALYZE, SET, BEGIN, COMMIT, ROLLBACK, SAVEPOINT, RELEASE, ROLLBACK_TO:
+		return true
+	default:
+		return false
+	}
+}
+
+// report returns the rows, loops, wall time and bytes read of the
+// instructions from start to end, relative to the start of the profile.
+// The code of an operator is left once for each row it makes, by falling
+// through or jumping to end. The loops are those of the first instruction.
+func (p *profile) report(start int, end int) []result.Value {
+	rows := 0
+	loops := 0
+	var elapsed time.Duration
+	var bytes int64
+	for n := start; n < end && n < len(p.loops); n++ {
+		if n == start {
+			loops = p.loops[n]
+		}
+		rows += p.exits[[2]int{n, end}]
+		elapsed += p.times[n]
+		bytes += p.bytes[n]
+	}
+	return []result.Value{
+		{Type: result.Integral, Integral: rows},
+		{Type: result.Integral, Integral: loops},
+		{Type: result.String, String: elapsed.String()},
+		{Type: result.Integral, Integral: int(bytes)},
+	}
+}
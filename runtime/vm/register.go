@@ -0,0 +1,405 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/vm/functions"
+)
+
+type RegOpeType uint8
+
+const (
+	_ RegOpeType = iota
+	R_MOVE
+	R_ADD
+	R_SUB
+	R_MUL
+	R_DIV
+	R_MOD
+	R_EQ
+	R_PARAM
+	R_CALL
+	R_STORE
+	R_ROW
+	R_JUMP
+	R_JUMP_IF_TRUE
+	R_JUMP_IF_FALSE
+	R_JUMP_IF_NULL
+	R_HALT
+	R_LT
+	R_LE
+	R_GT
+	R_GE
+)
+
+func (o RegOpeType) String() string {
+	switch o {
+	case R_MOVE:
+		return "MOVE"
+	case R_ADD:
+		return "ADD"
+	case R_SUB:
+		return "SUB"
+	case R_MUL:
+		return "MUL"
+	case R_DIV:
+		return "DIV"
+	case R_MOD:
+		return "MOD"
+	case R_EQ:
+		return "EQ"
+	case R_PARAM:
+		return "PARAM"
+	case R_CALL:
+		return "CALL"
+	case R_STORE:
+		return "STORE"
+	case R_ROW:
+		return "ROW"
+	case R_JUMP:
+		return "JUMP"
+	case R_JUMP_IF_TRUE:
+		return "JUMP_IF_TRUE"
+	case R_JUMP_IF_FALSE:
+		return "JUMP_IF_FALSE"
+	case R_JUMP_IF_NULL:
+		return "JUMP_IF_NULL"
+	case R_HALT:
+		return "HALT"
+	case R_LT:
+		return "LT"
+	case R_LE:
+		return "LE"
+	case R_GT:
+		return "GT"
+	case R_GE:
+		return "GE"
+	default:
+		return "Unknown Operation"
+	}
+}
+
+// RegCode is a three-address instruction: Dst = A op B. An operand that is
+// not negative is a register; -1 is the first constant of the program, -2
+// the second and so on. Jumps keep their target in Dst, and CALL the index
+// of the function in B and the number of arguments, which are in the
+// registers from A, in C.
+type RegCode struct {
+	Operator RegOpeType
+	Dst      int32
+	A        int32
+	B        int32
+	C        int32
+}
+
+func (c RegCode) String() string {
+	operand := func(n int32) string {
+		if n < 0 {
+			return fmt.Sprintf("k%d", -n-1)
+		}
+		return fmt.Sprintf("r%d", n)
+	}
+	switch c.Operator {
+	case R_MOVE:
+		return fmt.Sprintf("MOVE %s -> r%d", operand(c.A), c.Dst)
+	case R_ADD, R_SUB, R_MUL, R_DIV, R_MOD, R_EQ, R_LT, R_LE, R_GT, R_GE:
+		return fmt.Sprintf("%s %s, %s -> r%d", c.Operator, operand(c.A), operand(c.B), c.Dst)
+	case R_PARAM:
+		return fmt.Sprintf("PARAM %d -> r%d", c.A, c.Dst)
+	case R_CALL:
+		return fmt.Sprintf("CALL f%d, r%d, %d -> r%d", c.B, c.A, c.C, c.Dst)
+	case R_STORE:
+		return fmt.Sprintf("STORE %s", operand(c.A))
+	case R_JUMP:
+		return fmt.Sprintf("JUMP %d", c.Dst)
+	case R_JUMP_IF_TRUE, R_JUMP_IF_FALSE, R_JUMP_IF_NULL:
+		return fmt.Sprintf("%s %s, %d", c.Operator, operand(c.A), c.Dst)
+	default:
+		return c.Operator.String()
+	}
+}
+
+// value is the compact form of a VMValue the register machine works on.
+type value struct {
+	Type     ValueType
+	Integral int
+	String   string
+}
+
+// Program is VM code translated for the register machine. Each slot of the
+// stack of the stack machine becomes a register; constants are read from
+// the program instead of being pushed.
+type Program struct {
+	Codes     []RegCode
+	consts    []value
+	funcs     []func([]interface{}) result.Value
+	names     []string
+	registers int
+}
+
+// Compile translates codes for the register machine. Only expression code
+// can be translated: statements that change the catalog, the transaction
+// or are profiled are left to the stack machine.
+func Compile(codes []VMCode) (*Program, error) {
+	states, err := analyze(codes)
+	if err != nil {
+		return nil, err
+	}
+	return compile(codes, states)
+}
+
+var registerOperators = map[OpeType]RegOpeType{
+	ADD:           R_ADD,
+	SUB:           R_SUB,
+	MUL:           R_MUL,
+	DIV:           R_DIV,
+	MOD:           R_MOD,
+	EQ:            R_EQ,
+	LT:            R_LT,
+	LE:            R_LE,
+	GT:            R_GT,
+	GE:            R_GE,
+	JUMP_IF_TRUE:  R_JUMP_IF_TRUE,
+	JUMP_IF_FALSE: R_JUMP_IF_FALSE,
+	JUMP_IF_NULL:  R_JUMP_IF_NULL,
+}
+
+// stackOperators are the operators of the stack machine the arithmetic
+// operators of the register machine come from.
+var stackOperators = [...]OpeType{
+	R_ADD: ADD,
+	R_SUB: SUB,
+	R_MUL: MUL,
+	R_DIV: DIV,
+	R_MOD: MOD,
+	R_EQ:  EQ,
+	R_LT:  LT,
+	R_LE:  LE,
+	R_GT:  GT,
+	R_GE:  GE,
+}
+
+type compiler struct {
+	p    *Program
+	regs []int32
+}
+
+// flush moves the constants still on the stack into their registers.
+func (c *compiler) flush(from int) {
+	for n := from; n < len(c.regs); n++ {
+		if c.regs[n] != int32(n) {
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_MOVE, Dst: int32(n), A: c.regs[n]})
+			c.regs[n] = int32(n)
+		}
+	}
+}
+
+func (c *compiler) pop() int32 {
+	r := c.regs[len(c.regs)-1]
+	c.regs = c.regs[:len(c.regs)-1]
+	return r
+}
+
+func (c *compiler) constant(v VMValue) int32 {
+	c.p.consts = append(c.p.consts, value{Type: v.Type, Integral: v.Integral, String: v.String})
+	return int32(-len(c.p.consts))
+}
+
+func compile(codes []VMCode, states []*state) (*Program, error) {
+	c := &compiler{p: &Program{Codes: []RegCode{}}}
+	targets := map[int]bool{}
+	for _, code := range codes {
+		switch code.Operator {
+		case JUMP, JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL:
+			targets[code.Operand1.Integral] = true
+		}
+	}
+
+	addrs := make([]int32, len(codes)+1)
+	for pc, code := range codes {
+		if targets[pc] {
+			c.flush(0)
+		}
+		addrs[pc] = int32(len(c.p.Codes))
+		if states[pc] == nil {
+			continue
+		}
+		if targets[pc] || len(c.regs) != states[pc].depth {
+			c.regs = c.regs[:0]
+			for n := 0; n < states[pc].depth; n++ {
+				c.regs = append(c.regs, int32(n))
+			}
+		}
+		if d := states[pc].depth + 1; d > c.p.registers {
+			c.p.registers = d
+		}
+
+		dst := int32(len(c.regs))
+		switch code.Operator {
+		case PUSH:
+			c.regs = append(c.regs, c.constant(code.Operand1))
+		case POP:
+			c.pop()
+		case PARAM:
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_PARAM, Dst: dst, A: int32(code.Operand1.Integral)})
+			c.regs = append(c.regs, dst)
+		case ADD, SUB, MUL, DIV, MOD, EQ, LT, LE, GT, GE:
+			b := c.pop()
+			a := c.pop()
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: registerOperators[code.Operator], Dst: dst - 2, A: a, B: b})
+			c.regs = append(c.regs, dst-2)
+		case CALL:
+			c.pop()
+			n := states[pc].top
+			first := len(c.regs) - n
+			c.flush(first)
+			c.regs = c.regs[:first]
+			c.p.funcs = append(c.p.funcs, functions.LookupFunction(code.Operand1.String))
+			c.p.names = append(c.p.names, code.Operand1.String)
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_CALL, Dst: int32(first), A: int32(first), B: int32(len(c.p.funcs) - 1), C: int32(n)})
+			c.regs = append(c.regs, int32(first))
+		case STORE:
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_STORE, A: c.pop()})
+		case ROW:
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_ROW})
+		case JUMP:
+			c.flush(0)
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_JUMP, Dst: int32(code.Operand1.Integral)})
+		case JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL:
+			a := c.pop()
+			c.flush(0)
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: registerOperators[code.Operator], Dst: int32(code.Operand1.Integral), A: a})
+		case HALT:
+			c.p.Codes = append(c.p.Codes, RegCode{Operator: R_HALT})
+			c.regs = c.regs[:0]
+		default:
+			return nil, errors.New(fmt.Sprintf("Operator (%s) is not supported by the register machine", code.Operator))
+		}
+	}
+	addrs[len(codes)] = int32(len(c.p.Codes))
+
+	for n, code := range c.p.Codes {
+		switch code.Operator {
+		case R_JUMP, R_JUMP_IF_TRUE, R_JUMP_IF_FALSE, R_JUMP_IF_NULL:
+			c.p.Codes[n].Dst = addrs[code.Dst]
+		}
+	}
+	return c.p, nil
+}
+
+// Run executes p in the session sess with params bound to the PARAM slots,
+// as Execute does. It stops with the error of the context of sess once it
+// is done.
+func (p *Program) Run(sess *runtime.Session, params ...VMValue) ([][]result.Value, error) {
+	rows, err := p.run(sess.Context(), sess.NewUsage(), params)
+	if err != nil {
+		sess.Abort()
+		return [][]result.Value{}, err
+	}
+	return rows, nil
+}
+
+func (p *Program) run(ctx context.Context, usage *runtime.Usage, params []VMValue) ([][]result.Value, error) {
+	if err := usage.Memory(int64(p.registers) * valueSize); err != nil {
+		return nil, err
+	}
+	regs := make([]value, p.registers)
+	rows := [][]result.Value{}
+	cols := []result.Value{}
+	get := func(n int32) *value {
+		if n < 0 {
+			return &p.consts[-n-1]
+		}
+		return &regs[n]
+	}
+
+	for pc, n := 0, 0; pc < len(p.Codes); pc, n = pc+1, n+1 {
+		if n%checkInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err := usage.Instructions(1); err != nil {
+			return nil, err
+		}
+		c := &p.Codes[pc]
+		switch c.Operator {
+		case R_MOVE:
+			regs[c.Dst] = *get(c.A)
+		case R_ADD, R_SUB, R_MUL, R_DIV, R_MOD, R_EQ, R_LT, R_LE, R_GT, R_GE:
+			a, b := get(c.A), get(c.B)
+			if a.Type == Null || b.Type == Null {
+				regs[c.Dst] = value{Type: Null}
+				break
+			}
+			n, err := compute(stackOperators[c.Operator], a.Integral, b.Integral)
+			if err != nil {
+				return nil, err
+			}
+			regs[c.Dst] = value{Type: Integer, Integral: n}
+		case R_PARAM:
+			n := int(c.A)
+			if n < 1 || n > len(params) || params[n-1].Type == Nothing {
+				return nil, errors.New(fmt.Sprintf("Parameter (%d) is not bound", n))
+			}
+			regs[c.Dst] = value{Type: params[n-1].Type, Integral: params[n-1].Integral, String: params[n-1].String}
+		case R_CALL:
+			// The arguments are passed last first, as by the stack machine.
+			args := []interface{}{}
+			for n := c.A + c.C - 1; n >= c.A; n-- {
+				switch regs[n].Type {
+				case Integer:
+					args = append(args, regs[n].Integral)
+				case String:
+					args = append(args, regs[n].String)
+				}
+			}
+			call := p.funcs[c.B]
+			if call == nil {
+				return nil, errors.New(fmt.Sprintf("Function (%s) Not Found", p.names[c.B]))
+			}
+			r := fromResult(call(args))
+			regs[c.Dst] = value{Type: r.Type, Integral: r.Integral, String: r.String}
+		case R_STORE:
+			v := get(c.A)
+			col := toResult(v.Type, v.Integral, v.String)
+			if err := usage.Memory(resultBytes([]result.Value{col})); err != nil {
+				return nil, err
+			}
+			cols = append(cols, col)
+		case R_ROW:
+			if len(cols) != 0 {
+				if err := usage.Rows(1); err != nil {
+					return nil, err
+				}
+				rows = append(rows, cols)
+				cols = []result.Value{}
+			}
+		case R_JUMP:
+			pc = int(c.Dst) - 1
+		case R_JUMP_IF_TRUE:
+			if v := get(c.A); v.Type != Null && v.Integral != 0 {
+				pc = int(c.Dst) - 1
+			}
+		case R_JUMP_IF_FALSE:
+			if v := get(c.A); v.Type != Null && v.Integral == 0 {
+				pc = int(c.Dst) - 1
+			}
+		case R_JUMP_IF_NULL:
+			if get(c.A).Type == Null {
+				pc = int(c.Dst) - 1
+			}
+		case R_HALT:
+			pc = len(p.Codes)
+		}
+	}
+	if len(cols) != 0 {
+		if err := usage.Rows(1); err != nil {
+			return nil, err
+		}
+		rows = append(rows, cols)
+	}
+	return rows, nil
+}
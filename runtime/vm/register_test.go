@@ -0,0 +1,223 @@
+package vm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
+)
+
+func push(n int) VMCode {
+	return VMCode{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: n}}
+}
+
+// machineCases are the expressions of TestRun, with a few jumps.
+var machineCases = []struct {
+	name  string
+	codes []VMCode
+}{
+	{"1", []VMCode{push(1), {Operator: STORE}}},
+	{"1 + 2", []VMCode{push(1), push(2), {Operator: ADD}, {Operator: STORE}}},
+	{"1 + 2 * 3 - 4", []VMCode{push(1), push(2), push(3), {Operator: MUL}, {Operator: ADD}, push(4), {Operator: SUB}, {Operator: STORE}}},
+	{"ABS(-1), 2 = 1 + 1", []VMCode{
+		push(1), push(-1), {Operator: MUL}, push(1), {Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}}, {Operator: STORE},
+		push(2), push(1), push(1), {Operator: ADD}, {Operator: EQ}, {Operator: STORE},
+	}},
+	{"?1 - ?2, ?2 % 3", []VMCode{
+		{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 1}}, {Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 2}}, {Operator: SUB}, {Operator: STORE},
+		{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 2}}, push(3), {Operator: MOD}, {Operator: STORE},
+	}},
+	{"CASE WHEN 1 = 2 THEN 3 ELSE 4 / 2 END", []VMCode{
+		push(1), push(2), {Operator: EQ}, {Operator: JUMP_IF_FALSE, Operand1: VMValue{Type: Integer, Integral: 7}},
+		push(3), {Operator: STORE}, {Operator: JUMP, Operand1: VMValue{Type: Integer, Integral: 11}},
+		push(4), push(2), {Operator: DIV}, {Operator: STORE},
+		{Operator: ROW}, push(5), {Operator: STORE},
+	}},
+	{"1 < 2, 2 <= 2, 1 > 2, 1 >= NULL", []VMCode{
+		push(1), push(2), {Operator: LT}, {Operator: STORE},
+		push(2), push(2), {Operator: LE}, {Operator: STORE},
+		push(1), push(2), {Operator: GT}, {Operator: STORE},
+		push(1), {Operator: PUSH, Operand1: VMValue{Type: Null}}, {Operator: GE}, {Operator: STORE},
+	}},
+}
+
+func TestRegisterMachine(t *testing.T) {
+	params := []VMValue{{Type: Integer, Integral: 10}, {Type: Integer, Integral: 4}}
+	sess := runtime.GetInstance().Session()
+	for _, tc := range machineCases {
+		expected, err := run(sess, tc.codes, params)
+		if err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", tc.name, err)
+		}
+		p, err := Compile(tc.codes)
+		if err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", tc.name, err)
+		}
+		actual, err := p.Run(sess, params...)
+		if err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", tc.name, err)
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(expected) {
+			t.Fatalf("%s: expected %v but got %v", tc.name, expected, actual)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	testCases := []struct {
+		codes    []VMCode
+		expected []string
+	}{
+		{
+			machineCases[2].codes,
+			[]string{"MUL k1, k2 -> r1", "ADD k0, r1 -> r0", "SUB r0, k3 -> r0", "STORE r0"},
+		},
+		{
+			machineCases[3].codes,
+			[]string{"MUL k0, k1 -> r0", "CALL f0, r0, 1 -> r0", "STORE r0", "ADD k4, k5 -> r1", "EQ k3, r1 -> r0", "STORE r0"},
+		},
+		{
+			machineCases[5].codes,
+			[]string{"EQ k0, k1 -> r0", "JUMP_IF_FALSE r0, 4", "STORE k2", "JUMP 6", "DIV k3, k4 -> r0", "STORE r0", "ROW", "STORE k5"},
+		},
+		{
+			[]VMCode{push(1), push(0), {Operator: JUMP_IF_TRUE, Operand1: VMValue{Type: Integer, Integral: 5}}, push(2), {Operator: STORE}, {Operator: STORE}},
+			[]string{"MOVE k0 -> r0", "JUMP_IF_TRUE k1, 3", "STORE k2", "STORE r0"},
+		},
+	}
+
+	for tn, tc := range testCases {
+		p, err := Compile(tc.codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		actual := []string{}
+		for _, c := range p.Codes {
+			actual = append(actual, c.String())
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] expected %q but got %q", tn, tc.expected, actual)
+		}
+	}
+
+	if _, err := Compile([]VMCode{{Operator: BEGIN}}); err == nil {
+		t.Fatalf("BEGIN is compiled for the register machine")
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	null := VMCode{Operator: PUSH, Operand1: VMValue{Type: Null}}
+	param := func(n int) VMCode {
+		return VMCode{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: n}}
+	}
+	testCases := []struct {
+		codes    []VMCode
+		expected string
+	}{
+		{[]VMCode{push(1), push(0), {Operator: DIV}, {Operator: STORE}}, "Division by zero"},
+		{[]VMCode{push(1), push(0), {Operator: MOD}, {Operator: STORE}}, "Division by zero"},
+		{[]VMCode{param(1), param(2), {Operator: DIV}, {Operator: STORE}}, "Division by zero"},
+		{[]VMCode{null, push(0), {Operator: DIV}, {Operator: STORE}}, "[[{3 0 }]]"},
+		{[]VMCode{push(1), null, {Operator: MOD}, {Operator: STORE}}, "[[{3 0 }]]"},
+	}
+
+	outcome := func(rows [][]result.Value, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(rows)
+	}
+	params := []VMValue{{Type: Integer, Integral: 1}, {Type: Integer, Integral: 0}}
+	sess := runtime.New().Session()
+	for tn, tc := range testCases {
+		p, err := Compile(tc.codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := outcome(run(sess, tc.codes, params)); actual != tc.expected {
+			t.Fatalf("[%d] stack machine: expected %s but got %s", tn, tc.expected, actual)
+		}
+		if actual := outcome(p.Run(sess, params...)); actual != tc.expected {
+			t.Fatalf("[%d] register machine: expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+
+	if err := sess.Begin(runtime.Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := ExecuteRows(sess, testCases[0].codes); err == nil {
+		t.Fatalf("division by zero is not reported")
+	}
+	if sess.InTransaction() {
+		t.Fatalf("transaction is left open after a failed statement")
+	}
+}
+
+func TestCallArguments(t *testing.T) {
+	param := VMCode{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 1}}
+	abs := VMCode{Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}}
+	// ABS(?), ?; ABS(?) over two rows, as a SELECT reading two rows does.
+	codes := []VMCode{
+		param, push(1), abs, {Operator: STORE}, param, {Operator: STORE}, {Operator: ROW},
+		param, push(1), abs, {Operator: STORE}, {Operator: ROW},
+	}
+	testCases := []struct {
+		param    VMValue
+		expected string
+	}{
+		{VMValue{Type: Integer, Integral: -3}, "[[{1 3 } {1 -3 }] [{1 3 }]]"},
+		{VMValue{Type: Null}, "[[{3 0 } {3 0 }] [{3 0 }]]"},
+		{VMValue{Type: String, String: "x"}, "[[{3 0 } {2 0 x}] [{3 0 }]]"},
+	}
+
+	sess := runtime.New().Session()
+	p, err := Compile(codes)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for tn, tc := range testCases {
+		expected, err := run(sess, codes, []VMValue{tc.param})
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if fmt.Sprint(expected) != tc.expected {
+			t.Fatalf("[%d] stack machine: expected %s but got %v", tn, tc.expected, expected)
+		}
+		actual, err := p.Run(sess, tc.param)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(expected) {
+			t.Fatalf("[%d] register machine: expected %v but got %v", tn, expected, actual)
+		}
+	}
+}
+
+func BenchmarkStackMachine(b *testing.B) {
+	params := []VMValue{{Type: Integer, Integral: 10}, {Type: Integer, Integral: 4}}
+	sess := runtime.GetInstance().Session()
+	for _, tc := range machineCases {
+		b.Run(tc.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				run(sess, tc.codes, params)
+			}
+		})
+	}
+}
+
+func BenchmarkRegisterMachine(b *testing.B) {
+	params := []VMValue{{Type: Integer, Integral: 10}, {Type: Integer, Integral: 4}}
+	sess := runtime.GetInstance().Session()
+	for _, tc := range machineCases {
+		p, err := Compile(tc.codes)
+		if err != nil {
+			b.Fatalf("%s: Unexpected Error: %s", tc.name, err)
+		}
+		b.Run(tc.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				p.Run(sess, params...)
+			}
+		})
+	}
+}
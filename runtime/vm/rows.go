@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"context"
+	"io"
+
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
+)
+
+// Rows are the result rows of a program, made as they are read: Next runs
+// the program until its next row. Expression code run on the register
+// machine makes all its rows at once.
+type Rows struct {
+	sess    *runtime.Session
+	m       *machine
+	rows    [][]result.Value
+	restore func()
+	err     error
+}
+
+// Query starts codes as ExecuteRowsContext does and returns their rows.
+// ctx is the context of the session until the rows are closed, which must
+// be done once they are read. If the program fails the transaction of the
+// session is rolled back and Next returns the error.
+func Query(ctx context.Context, sess *runtime.Session, codes []VMCode, params ...VMValue) (*Rows, error) {
+	states, err := analyze(codes)
+	if err != nil {
+		return nil, err
+	}
+	rs := &Rows{sess: sess, restore: withContext(ctx, sess)}
+	p, err := compile(codes, states)
+	if err != nil {
+		rs.m = newMachine(sess, codes, params)
+		return rs, nil
+	}
+	if rs.rows, err = p.run(sess.Context(), sess.NewUsage(), params); err != nil {
+		rs.fail(err)
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Next returns the next row, or io.EOF after the last one.
+func (rs *Rows) Next() ([]result.Value, error) {
+	if rs.err != nil {
+		return nil, rs.err
+	}
+	if rs.m == nil {
+		if len(rs.rows) == 0 {
+			rs.Close()
+			return nil, io.EOF
+		}
+		row := rs.rows[0]
+		rs.rows = rs.rows[1:]
+		return row, nil
+	}
+	row, err := rs.m.next()
+	if err == io.EOF {
+		rs.Close()
+		return nil, err
+	}
+	if err != nil {
+		rs.fail(err)
+		return nil, err
+	}
+	return row, nil
+}
+
+func (rs *Rows) fail(err error) {
+	rs.Close()
+	rs.sess.Abort()
+	rs.err = err
+}
+
+// Close stops the program and gives the session its previous context back.
+func (rs *Rows) Close() error {
+	if rs.err == nil {
+		rs.err = io.EOF
+	}
+	if rs.m != nil {
+		rs.m.closeCursors()
+	}
+	if rs.restore != nil {
+		rs.restore()
+		rs.restore = nil
+	}
+	rs.m = nil
+	rs.rows = nil
+	return nil
+}
@@ -0,0 +1,127 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/yakawa/simpleDB/common/result"
+)
+
+// and is the three-valued AND of SQL: false if either operand is false,
+// else NULL if either is NULL.
+func and(a VMValue, b VMValue) VMValue {
+	switch {
+	case a.Type != Null && a.Integral == 0, b.Type != Null && b.Integral == 0:
+		return VMValue{Type: Integer}
+	case a.Type == Null, b.Type == Null:
+		return VMValue{Type: Null}
+	default:
+		return VMValue{Type: Integer, Integral: 1}
+	}
+}
+
+// counter is set by LIMIT: it keeps limit rows, all of them if limit is
+// negative, after skipping offset.
+type counter struct {
+	limit   int
+	offset  int
+	skipped int
+	kept    int
+}
+
+func newCounter(limit VMValue, offset VMValue) *counter {
+	c := &counter{limit: limit.Integral, offset: offset.Integral}
+	if limit.Type == Null {
+		c.limit = -1
+	}
+	if offset.Type == Null || c.offset < 0 {
+		c.offset = 0
+	}
+	return c
+}
+
+// full reports whether c has kept its last row.
+func (c *counter) full() bool {
+	return c.limit >= 0 && c.kept >= c.limit
+}
+
+// take counts a row and reports whether it is kept.
+func (c *counter) take() bool {
+	if c.skipped < c.offset {
+		c.skipped++
+		return false
+	}
+	c.kept++
+	return true
+}
+
+// apply returns the rows c keeps.
+func (c *counter) apply(rows [][]result.Value) [][]result.Value {
+	kept := [][]result.Value{}
+	for _, row := range rows {
+		if c.full() {
+			break
+		}
+		if c.take() {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+// sortRows sorts rows by their last len(order) values and strips them. A
+// key is ascending if its letter in order is A and descending if it is D.
+// NULL comes before the other values, integers before strings, and rows
+// with the same keys keep their order.
+func sortRows(rows [][]result.Value, order string) ([][]result.Value, error) {
+	for n, ch := range order {
+		if ch != 'A' && ch != 'D' {
+			return nil, errors.New(fmt.Sprintf("Sort order (%c) of key %d is unknown", ch, n))
+		}
+	}
+	for _, row := range rows {
+		if len(row) < len(order) {
+			return nil, errors.New(fmt.Sprintf("Row has %d values for %d sort keys", len(row), len(order)))
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a := rows[i][len(rows[i])-len(order):]
+		b := rows[j][len(rows[j])-len(order):]
+		for n := range order {
+			c := compareValues(a[n], b[n])
+			if order[n] == 'D' {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([][]result.Value, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, row[:len(row)-len(order)])
+	}
+	return sorted, nil
+}
+
+func compareValues(a result.Value, b result.Value) int {
+	rank := map[result.ResultType]int{result.Null: 0, result.Integral: 1, result.String: 2}
+	if rank[a.Type] != rank[b.Type] {
+		return rank[a.Type] - rank[b.Type]
+	}
+	switch {
+	case a.Type == result.Integral && a.Integral != b.Integral:
+		if a.Integral < b.Integral {
+			return -1
+		}
+		return 1
+	case a.Type == result.String && a.String != b.String:
+		if a.String < b.String {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
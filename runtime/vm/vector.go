@@ -0,0 +1,513 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+	"github.com/yakawa/simpleDB/runtime/vm/functions"
+)
+
+// BatchSize is the number of rows the vectorized engine processes at once.
+const BatchSize = 1024
+
+// Vector holds the values of a column for a batch of rows. Bit n of Nulls
+// is set when value n is NULL.
+type Vector struct {
+	Values []int
+	Nulls  []uint64
+}
+
+func newVector(size int) *Vector {
+	return &Vector{
+		Values: make([]int, size),
+		Nulls:  make([]uint64, (size+63)/64),
+	}
+}
+
+func (v *Vector) IsNull(n int) bool {
+	return v.Nulls[n/64]&(1<<uint(n%64)) != 0
+}
+
+func (v *Vector) SetNull(n int, null bool) {
+	if null {
+		v.Nulls[n/64] |= 1 << uint(n%64)
+	} else {
+		v.Nulls[n/64] &^= 1 << uint(n%64)
+	}
+}
+
+// Batch is a set of rows stored by column. Sel holds the positions of the
+// rows that passed the filters so far, in order.
+type Batch struct {
+	Len     int
+	Columns []*Vector
+	Sel     []int
+}
+
+// vectorProgram is code checked for the vectorized engine, with a vector
+// for the result of each instruction made once for all batches.
+type vectorProgram struct {
+	codes []VMCode
+	table *VMTable
+	size  int
+	vecs  map[int]*Vector
+	funcs map[int]func([]interface{}) result.Value
+	argsN map[int]int
+	usage *runtime.Usage
+}
+
+// ExecuteVectorized runs codes as Execute does, but a batch of rows at a
+// time: each instruction is applied to whole column vectors, and filters
+// narrow the selection vector of the batch. A table may only be read by
+// the code of a single query. Queries without a table may be run together,
+// each of them making a row, but only the last may filter or limit it. The table
+// is split into as many parts as the parallelism of the runtime, which are
+// run at once; the rows are returned in table order. Other code is run by
+// the row engine, as ExecuteRows does.
+func ExecuteVectorized(sess *runtime.Session, codes []VMCode, params ...VMValue) ([][]result.Value, error) {
+	return ExecuteVectorizedContext(context.Background(), sess, codes, params...)
+}
+
+// ExecuteVectorizedContext is ExecuteVectorized stopping with the error of
+// ctx once it is done, or once the statement timeout of the session has
+// passed.
+func ExecuteVectorizedContext(ctx context.Context, sess *runtime.Session, codes []VMCode, params ...VMValue) ([][]result.Value, error) {
+	restore := withContext(ctx, sess)
+	rows, err := executeVectorized(sess, codes, params, BatchSize, sess.Runtime().Parallelism())
+	restore()
+	if _, ok := err.(*unsupportedError); ok {
+		// Code the vectorized engine cannot run, as that of a join, a sort
+		// or an index scan, is found before a row is read, and run by the
+		// row engine instead.
+		return ExecuteRowsContext(ctx, sess, codes, params...)
+	}
+	if err != nil {
+		sess.Abort()
+		return [][]result.Value{}, err
+	}
+	return rows, nil
+}
+
+// unsupportedError is returned by compileVector for code the vectorized
+// engine cannot run.
+type unsupportedError struct {
+	msg string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.msg
+}
+
+// vectorWorker runs a program over the rows of a part of a table.
+type vectorWorker struct {
+	ctx   context.Context
+	p     *vectorProgram
+	b     *Batch
+	names map[string]int
+	rows  [][]result.Value
+	err   error
+}
+
+// add appends row to the batch. The columns are found by name, as the
+// columns of the rows of a table may come in any order, and a column a row
+// does not have is NULL.
+func (w *vectorWorker) add(row []table.ColumnValue) {
+	b := w.b
+	if w.err != nil {
+		return
+	}
+	if w.names == nil {
+		w.names = map[string]int{}
+	}
+	for _, vec := range b.Columns {
+		vec.SetNull(b.Len, true)
+	}
+	for _, c := range row {
+		n, exists := w.names[c.Name]
+		if !exists {
+			if w.err = w.p.usage.Memory(vectorBytes(w.p.size)); w.err != nil {
+				return
+			}
+			vec := newVector(w.p.size)
+			for i := 0; i < b.Len; i++ {
+				vec.SetNull(i, true)
+			}
+			n = len(b.Columns)
+			w.names[c.Name] = n
+			b.Columns = append(b.Columns, vec)
+		}
+		b.Columns[n].Values[b.Len] = c.Value.Integral
+		b.Columns[n].SetNull(b.Len, c.Value.Type == table.Null)
+	}
+	b.Len++
+	if b.Len == w.p.size {
+		w.flush()
+	}
+}
+
+func (w *vectorWorker) flush() {
+	if w.err == nil && w.b.Len != 0 {
+		if w.err = w.ctx.Err(); w.err == nil {
+			w.err = w.p.run(w.b, w.names, &w.rows)
+		}
+	}
+	w.b.Len = 0
+}
+
+func executeVectorized(sess *runtime.Session, codes []VMCode, params []VMValue, size int, parts int) ([][]result.Value, error) {
+	usage := sess.NewUsage()
+	p, err := compileVector(codes, params, size, usage)
+	if err != nil {
+		return nil, err
+	}
+	rows := [][]result.Value{}
+	if p.table == nil {
+		b := &Batch{Len: 1, Columns: []*Vector{}, Sel: []int{0}}
+		if err := p.run(b, map[string]int{}, &rows); err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
+
+	// Every worker has a program of its own, as the vectors of a program
+	// hold the results of the batch being run.
+	workers := make([]*vectorWorker, parts)
+	for n := range workers {
+		if n != 0 {
+			if p, err = compileVector(codes, params, size, usage); err != nil {
+				return nil, err
+			}
+		}
+		workers[n] = &vectorWorker{ctx: sess.Context(), p: p, b: &Batch{Sel: make([]int, 0, size)}, rows: [][]result.Value{}}
+	}
+	err = sess.ReadColumnsFromLocalTableParallel(p.table.DB, p.table.Table, p.table.Columns, parts, func(pn int, row []table.ColumnValue) {
+		workers[pn].add(row)
+	}, func(pn int) {
+		w := workers[pn]
+		workers[pn] = &vectorWorker{ctx: w.ctx, p: w.p, b: &Batch{Sel: make([]int, 0, size)}, rows: [][]result.Value{}}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workers {
+		w.flush()
+		if w.err != nil {
+			return nil, w.err
+		}
+		rows = append(rows, w.rows...)
+	}
+	return rows, nil
+}
+
+// compileVector checks codes for the vectorized engine and makes the
+// vectors of its instructions, counting their memory in usage.
+func compileVector(codes []VMCode, params []VMValue, size int, usage *runtime.Usage) (*vectorProgram, error) {
+	codes = unloop(codes)
+	p := &vectorProgram{
+		codes: codes,
+		size:  size,
+		usage: usage,
+		vecs:  map[int]*Vector{},
+		funcs: map[int]func([]interface{}) result.Value{},
+		argsN: map[int]int{},
+	}
+	unsupported := func(pc int) error {
+		return &unsupportedError{fmt.Sprintf("Operator (%s) at %d is not supported in vectorized mode", codes[pc].Operator, pc)}
+	}
+	constant := func(v VMValue) (*Vector, error) {
+		vec := newVector(size)
+		switch v.Type {
+		case Integer:
+			for n := range vec.Values {
+				vec.Values[n] = v.Integral
+			}
+		case Null:
+			for n := range vec.Nulls {
+				vec.Nulls[n] = ^uint64(0)
+			}
+		default:
+			return nil, &unsupportedError{fmt.Sprintf("Value type (%s) is not supported in vectorized mode", v.Type)}
+		}
+		return vec, nil
+	}
+
+	depth := 0
+	for pc := 0; pc < len(codes); pc++ {
+		code := codes[pc]
+		pops, pushes := 0, 0
+		switch code.Operator {
+		case READ:
+			if p.table != nil || pc != 0 {
+				return nil, unsupported(pc)
+			}
+			p.table = &codes[pc].Operand1.Table
+		case FETCH:
+			if p.table == nil {
+				return nil, unsupported(pc)
+			}
+			pushes = 1
+		case PUSH, PARAM:
+			v := code.Operand1
+			if code.Operator == PARAM {
+				n := code.Operand1.Integral
+				if n < 1 || n > len(params) || params[n-1].Type == Nothing {
+					return nil, errors.New(fmt.Sprintf("Parameter (%d) is not bound", n))
+				}
+				v = params[n-1]
+			}
+			vec, err := constant(v)
+			if err != nil {
+				return nil, err
+			}
+			p.vecs[pc] = vec
+			pushes = 1
+		case POP, STORE:
+			pops = 1
+		case ADD, SUB, MUL, DIV, MOD, EQ, LT, LE, GT, GE:
+			p.vecs[pc] = newVector(size)
+			pops, pushes = 2, 1
+		case CALL:
+			if pc == 0 || codes[pc-1].Operator != PUSH || codes[pc-1].Operand1.Type != Integer {
+				return nil, unsupported(pc)
+			}
+			p.argsN[pc] = codes[pc-1].Operand1.Integral
+			p.funcs[pc] = functions.LookupFunction(code.Operand1.String)
+			if p.funcs[pc] == nil {
+				return nil, errors.New(fmt.Sprintf("Function (%s) Not Found", code.Operand1.String))
+			}
+			p.vecs[pc] = newVector(size)
+			pops, pushes = p.argsN[pc]+1, 1
+		case JUMP_IF_TRUE:
+			// cond; JUMP_IF_TRUE next; JUMP end keeps the rows for which
+			// cond is true.
+			if code.Operand1.Integral != pc+2 || pc+1 >= len(codes) || codes[pc+1].Operator != JUMP || codes[pc+1].Operand1.Integral != len(codes) {
+				return nil, unsupported(pc)
+			}
+			pops = 1
+			pc++
+		case JUMP_IF_FALSE:
+			// LIMIT 0 drops every row.
+			if pc == 0 || codes[pc-1].Operator != PUSH || codes[pc-1].Operand1.Type != Integer || codes[pc-1].Operand1.Integral != 0 || code.Operand1.Integral != len(codes) {
+				return nil, unsupported(pc)
+			}
+			pops = 1
+		case ROW:
+			// A query without a table ends its row, and the rows of the
+			// statements of a batch are kept apart. A table is read by a
+			// single statement, whose loop unloop has removed.
+			if p.table != nil {
+				return nil, unsupported(pc)
+			}
+		default:
+			return nil, unsupported(pc)
+		}
+		if depth < pops {
+			return nil, errors.New(fmt.Sprintf("Stack underflow at %d", pc))
+		}
+		depth += pushes - pops
+	}
+	if err := usage.Memory(int64(len(p.vecs)) * vectorBytes(size)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// unloop turns the loop the planner makes of a scan,
+//
+//	READ t, 0; NEXT end; body; ROW; JUMP 1
+//
+// into READ t followed by the body, as the engine runs the body over all
+// the rows at once. The jumps of the body to the end of an iteration or
+// of the loop go to the end of the program. Other code is returned as is.
+func unloop(codes []VMCode) []VMCode {
+	n := len(codes)
+	if n < 4 || codes[0].Operator != READ || codes[1].Operator != NEXT || codes[1].Operand1.Integral != n ||
+		codes[n-2].Operator != ROW || codes[n-1].Operator != JUMP || codes[n-1].Operand1.Integral != 1 {
+		return codes
+	}
+	flat := []VMCode{codes[0]}
+	for _, c := range codes[2 : n-2] {
+		switch c.Operator {
+		case JUMP, JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL:
+			if c.Operand1.Integral >= n-2 {
+				c.Operand1.Integral = n - 3
+			} else {
+				c.Operand1.Integral--
+			}
+		}
+		flat = append(flat, c)
+	}
+	return flat
+}
+
+// run applies the program to the rows of b and appends those selected to
+// rows.
+func (p *vectorProgram) run(b *Batch, names map[string]int, rows *[][]result.Value) error {
+	sel := b.Sel[:0]
+	for n := 0; n < b.Len; n++ {
+		sel = append(sel, n)
+	}
+	stack := []*Vector{}
+	cols := []*Vector{}
+	pop := func() *Vector {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+
+	for pc := 0; pc < len(p.codes); pc++ {
+		if err := p.usage.Instructions(int64(len(sel))); err != nil {
+			return err
+		}
+		code := p.codes[pc]
+		switch code.Operator {
+		case FETCH:
+			n, exists := names[code.Operand1.Column.Column]
+			if !exists {
+				return errors.New(fmt.Sprintf("Column (%s) Not Found", code.Operand1.Column.Column))
+			}
+			stack = append(stack, b.Columns[n])
+		case PUSH, PARAM:
+			stack = append(stack, p.vecs[pc])
+		case POP:
+			pop()
+		case STORE:
+			cols = append(cols, pop())
+		case ADD, SUB, MUL, DIV, MOD, EQ, LT, LE, GT, GE:
+			r := pop()
+			l := pop()
+			out := p.vecs[pc]
+			for n := range out.Nulls {
+				out.Nulls[n] = l.Nulls[n] | r.Nulls[n]
+			}
+			if err := arith(code.Operator, out, l, r, sel); err != nil {
+				return err
+			}
+			stack = append(stack, out)
+		case CALL:
+			n := p.argsN[pc]
+			pop()
+			args := stack[len(stack)-n:]
+			stack = stack[:len(stack)-n]
+			out := p.vecs[pc]
+			for _, i := range sel {
+				// The arguments are passed last first, as by the stack
+				// machine.
+				vals := []interface{}{}
+				for a := n - 1; a >= 0; a-- {
+					if !args[a].IsNull(i) {
+						vals = append(vals, args[a].Values[i])
+					}
+				}
+				r := p.funcs[pc](vals)
+				out.Values[i] = r.Integral
+				out.SetNull(i, r.Type != result.Integral)
+			}
+			stack = append(stack, out)
+		case JUMP_IF_TRUE:
+			cond := pop()
+			kept := sel[:0]
+			for _, i := range sel {
+				if !cond.IsNull(i) && cond.Values[i] != 0 {
+					kept = append(kept, i)
+				}
+			}
+			sel = kept
+			pc++
+		case JUMP_IF_FALSE:
+			pop()
+			sel = sel[:0]
+		case ROW:
+			if err := p.output(cols, sel, rows); err != nil {
+				return err
+			}
+			cols = cols[:0]
+			sel = append(sel[:0], 0)
+		}
+	}
+	return p.output(cols, sel, rows)
+}
+
+// output appends the selected rows of the vectors cols to rows.
+func (p *vectorProgram) output(cols []*Vector, sel []int, rows *[][]result.Value) error {
+	if len(cols) == 0 {
+		return nil
+	}
+	if err := p.usage.Rows(int64(len(sel))); err != nil {
+		return err
+	}
+	if err := p.usage.Memory(int64(len(sel)*len(cols)) * resultValueSize); err != nil {
+		return err
+	}
+	for _, i := range sel {
+		row := make([]result.Value, len(cols))
+		for n, c := range cols {
+			if c.IsNull(i) {
+				row[n] = result.Value{Type: result.Null}
+			} else {
+				row[n] = result.Value{Type: result.Integral, Integral: c.Values[i]}
+			}
+		}
+		*rows = append(*rows, row)
+	}
+	return nil
+}
+
+// arith computes out = l op r for the selected rows. Division by zero is
+// an error unless one of the operands is NULL.
+func arith(op OpeType, out *Vector, l *Vector, r *Vector, sel []int) error {
+	o, a, b := out.Values, l.Values, r.Values
+	switch op {
+	case ADD:
+		for _, i := range sel {
+			o[i] = a[i] + b[i]
+		}
+	case SUB:
+		for _, i := range sel {
+			o[i] = a[i] - b[i]
+		}
+	case MUL:
+		for _, i := range sel {
+			o[i] = a[i] * b[i]
+		}
+	case DIV, MOD:
+		for _, i := range sel {
+			if b[i] == 0 {
+				if out.IsNull(i) {
+					continue
+				}
+				return errors.New("Division by zero")
+			}
+			if op == DIV {
+				o[i] = a[i] / b[i]
+			} else {
+				o[i] = a[i] % b[i]
+			}
+		}
+	case EQ:
+		for _, i := range sel {
+			o[i] = truth(a[i] == b[i])
+		}
+	case LT:
+		for _, i := range sel {
+			o[i] = truth(a[i] < b[i])
+		}
+	case LE:
+		for _, i := range sel {
+			o[i] = truth(a[i] <= b[i])
+		}
+	case GT:
+		for _, i := range sel {
+			o[i] = truth(a[i] > b[i])
+		}
+	case GE:
+		for _, i := range sel {
+			o[i] = truth(a[i] >= b[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func read(cols ...string) VMCode {
+	return VMCode{Operator: READ, Operand1: VMValue{Type: Table, Table: VMTable{Table: "tbl1", DB: "_", Schema: "LOCAL", Columns: cols}}}
+}
+
+func fetch(col string) VMCode {
+	return VMCode{Operator: FETCH, Operand1: VMValue{Type: Column, Column: VMColumn{Column: col, DB: "_", Schema: "LOCAL"}}}
+}
+
+func jump(op OpeType, to int) VMCode {
+	return VMCode{Operator: op, Operand1: VMValue{Type: Integer, Integral: to}}
+}
+
+func TestExecuteVectorized(t *testing.T) {
+	null := VMCode{Operator: PUSH, Operand1: VMValue{Type: Null}}
+	abs := VMCode{Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}}
+
+	testCases := []struct {
+		codes    []VMCode
+		expected string
+	}{
+		{[]VMCode{push(1), push(2), {Operator: ADD}, {Operator: STORE}}, "[[{1 3 }]]"},
+		{[]VMCode{read("colA", "colB"), fetch("colA"), fetch("colB"), {Operator: MUL}, {Operator: STORE}, fetch("colB"), {Operator: STORE}}, "[[{1 2 } {1 2 }] [{1 12 } {1 4 }] [{1 30 } {1 6 }] [{1 56 } {1 8 }] [{1 90 } {1 10 }]]"},
+		{[]VMCode{read("colA"), fetch("colA"), push(4), {Operator: SUB}, push(1), abs, {Operator: STORE}}, "[[{1 3 }] [{1 1 }] [{1 1 }] [{1 3 }] [{1 5 }]]"},
+		{[]VMCode{read("colA"), fetch("colA"), push(3), {Operator: MOD}, push(0), {Operator: EQ}, jump(JUMP_IF_TRUE, 8), jump(JUMP, 10), fetch("colA"), {Operator: STORE}}, "[[{1 3 }] [{1 9 }]]"},
+		{[]VMCode{read("colA"), fetch("colA"), push(1), {Operator: SUB}, jump(JUMP_IF_TRUE, 6), jump(JUMP, 13), fetch("colA"), push(7), {Operator: EQ}, jump(JUMP_IF_TRUE, 11), jump(JUMP, 13), fetch("colA"), {Operator: STORE}}, "[[{1 7 }]]"},
+		{[]VMCode{read("colA"), fetch("colA"), null, {Operator: ADD}, {Operator: STORE}, fetch("colA"), null, {Operator: DIV}, {Operator: STORE}}, "[[{3 0 } {3 0 }] [{3 0 } {3 0 }] [{3 0 } {3 0 }] [{3 0 } {3 0 }] [{3 0 } {3 0 }]]"},
+		{[]VMCode{read("colA"), fetch("colA"), null, {Operator: EQ}, jump(JUMP_IF_TRUE, 6), jump(JUMP, 8), fetch("colA"), {Operator: STORE}}, "[]"},
+		{[]VMCode{read("colA"), fetch("colA"), push(3), {Operator: GT}, jump(JUMP_IF_TRUE, 6), jump(JUMP, 13), fetch("colA"), push(7), {Operator: LE}, jump(JUMP_IF_TRUE, 11), jump(JUMP, 13), fetch("colA"), {Operator: STORE}}, "[[{1 5 }] [{1 7 }]]"},
+		{[]VMCode{read("colA"), fetch("colA"), push(3), {Operator: LT}, {Operator: STORE}, fetch("colA"), push(9), {Operator: GE}, {Operator: STORE}}, "[[{1 1 } {1 0 }] [{1 0 } {1 0 }] [{1 0 } {1 0 }] [{1 0 } {1 0 }] [{1 0 } {1 1 }]]"},
+		{[]VMCode{read("colA"), push(0), jump(JUMP_IF_FALSE, 5), fetch("colA"), {Operator: STORE}}, "[]"},
+		{[]VMCode{{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 1}}, push(2), {Operator: MUL}, {Operator: STORE}}, "[[{1 20 }]]"},
+		{[]VMCode{push(1), {Operator: STORE}, {Operator: ROW}, push(2), {Operator: STORE}, {Operator: ROW}}, "[[{1 1 }] [{1 2 }]]"},
+		{[]VMCode{push(1), {Operator: STORE}, {Operator: ROW}, push(0), jump(JUMP_IF_FALSE, 8), push(2), {Operator: STORE}, {Operator: ROW}}, "[[{1 1 }]]"},
+	}
+
+	sess := runtime.GetInstance().Set("../../testdata").Session()
+	for tn, tc := range testCases {
+		rows, err := ExecuteVectorized(sess, tc.codes, VMValue{Type: Integer, Integral: 10})
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestNullOperands(t *testing.T) {
+	null := VMCode{Operator: PUSH, Operand1: VMValue{Type: Null}}
+	param := VMCode{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 1}}
+	binary := func(l VMCode, op OpeType, r VMCode) []VMCode {
+		return []VMCode{l, r, {Operator: op}, {Operator: STORE}}
+	}
+	expressions := [][]VMCode{
+		binary(null, ADD, push(1)),
+		binary(push(1), SUB, null),
+		binary(null, MUL, push(2)),
+		binary(null, DIV, push(1)),
+		binary(push(1), MOD, null),
+		binary(null, DIV, push(0)),
+		binary(null, EQ, push(0)),
+		binary(null, EQ, null),
+		binary(param, ADD, push(1)),
+		binary(param, EQ, param),
+	}
+
+	sess := runtime.New().Session()
+	params := []VMValue{{Type: Null}}
+	for tn, codes := range expressions {
+		stack, err := run(sess, codes, params)
+		if err != nil {
+			t.Fatalf("[%d] stack machine: Unexpected Error: %s", tn, err)
+		}
+		p, err := Compile(codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		register, err := p.Run(sess, params...)
+		if err != nil {
+			t.Fatalf("[%d] register machine: Unexpected Error: %s", tn, err)
+		}
+		vector, err := ExecuteVectorized(sess, codes, params...)
+		if err != nil {
+			t.Fatalf("[%d] vectorized engine: Unexpected Error: %s", tn, err)
+		}
+		for _, actual := range []string{fmt.Sprint(stack), fmt.Sprint(register), fmt.Sprint(vector)} {
+			if actual != "[[{3 0 }]]" {
+				t.Fatalf("[%d] expected NULL from every engine but got %v, %v and %v", tn, stack, register, vector)
+			}
+		}
+	}
+
+	// SELECT colA FROM tbl1 WHERE colB = 0, of which colB is NULL in the
+	// second row.
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), []byte("#colA, colB\n1,0\n2,\n3,5\n"), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	sess = runtime.New().Set(dir).Session()
+	codes := []VMCode{
+		read("colA", "colB"), jump(NEXT, 11),
+		fetch("colB"), push(0), {Operator: EQ}, jump(JUMP_IF_TRUE, 7), jump(JUMP, 9),
+		fetch("colA"), {Operator: STORE},
+		{Operator: ROW}, jump(JUMP, 1),
+	}
+	stack, err := run(sess, codes, nil)
+	if err != nil {
+		t.Fatalf("stack machine: Unexpected Error: %s", err)
+	}
+	vector, err := ExecuteVectorized(sess, codes)
+	if err != nil {
+		t.Fatalf("vectorized engine: Unexpected Error: %s", err)
+	}
+	if fmt.Sprint(stack) != "[[{1 1 }]]" || fmt.Sprint(vector) != fmt.Sprint(stack) {
+		t.Fatalf("expected [[{1 1 }]] from both engines but got %v and %v", stack, vector)
+	}
+}
+
+func TestExecuteVectorizedError(t *testing.T) {
+	testCases := []struct {
+		codes    []VMCode
+		expected string
+	}{
+		{[]VMCode{read("colA"), fetch("colA"), {Operator: STORE}, {Operator: ROW}}, "Operator (ROW) at 3 is not supported in vectorized mode"},
+		{[]VMCode{push(0), jump(JUMP_IF_FALSE, 4), push(1), {Operator: STORE}, {Operator: ROW}, push(2), {Operator: STORE}, {Operator: ROW}}, "Operator (JUMP_IF_FALSE) at 1 is not supported in vectorized mode"},
+		{[]VMCode{push(1), jump(JUMP_IF_TRUE, 2), jump(JUMP, 3)}, "Operator (JUMP_IF_TRUE) at 1 is not supported in vectorized mode"},
+		{[]VMCode{fetch("colA"), {Operator: STORE}}, "Operator (FETCH) at 0 is not supported in vectorized mode"},
+		{[]VMCode{{Operator: ADD}}, "Stack underflow at 0"},
+		{[]VMCode{read("colA"), fetch("colC"), {Operator: STORE}}, "Column (colC) Not Found"},
+		{[]VMCode{read("colA"), fetch("colA"), push(0), {Operator: DIV}, {Operator: STORE}}, "Division by zero"},
+		{[]VMCode{{Operator: PARAM, Operand1: VMValue{Type: Integer, Integral: 1}}, {Operator: STORE}}, "Parameter (1) is not bound"},
+	}
+
+	sess := runtime.GetInstance().Set("../../testdata").Session()
+	for tn, tc := range testCases {
+		_, err := executeVectorized(sess, tc.codes, nil, BatchSize, 1)
+		if err == nil {
+			t.Fatalf("[%d] expected error", tn)
+		}
+		if err.Error() != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, err)
+		}
+	}
+}
+
+func TestExecuteVectorizedBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vector")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sess := largeTable(t, dir, 2500)
+
+	// Keep the rows of which colA is even, in batches of several sizes
+	// and parts.
+	codes := []VMCode{read("colA", "colB"), fetch("colA"), push(2), {Operator: MOD}, push(0), {Operator: EQ}, jump(JUMP_IF_TRUE, 8), jump(JUMP, 12), fetch("colA"), fetch("colB"), {Operator: ADD}, {Operator: STORE}}
+	for _, size := range []int{1, 7, 64, BatchSize} {
+		for _, parts := range []int{1, 3, 8} {
+			rows, err := executeVectorized(sess, codes, nil, size, parts)
+			if err != nil {
+				t.Fatalf("[%d/%d] Unexpected Error: %s", size, parts, err)
+			}
+			if len(rows) != 1250 {
+				t.Fatalf("[%d/%d] expected 1250 rows but got %d", size, parts, len(rows))
+			}
+			for n, row := range rows {
+				if row[0].Integral != 4*n+1 {
+					t.Fatalf("[%d/%d] row %d: expected %d but got %d", size, parts, n, 4*n+1, row[0].Integral)
+				}
+			}
+		}
+	}
+}
+
+func TestVectorWorkerColumns(t *testing.T) {
+	codes := []VMCode{read("colA", "colB"), fetch("colA"), {Operator: STORE}, fetch("colB"), {Operator: STORE}}
+	p, err := compileVector(codes, nil, 4, runtime.GetInstance().Session().NewUsage())
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	w := &vectorWorker{ctx: context.Background(), p: p, b: &Batch{Sel: make([]int, 0, 4)}, rows: [][]result.Value{}}
+
+	// The columns of the rows come in different orders, and a column may
+	// be left out.
+	value := func(name string, n int) table.ColumnValue {
+		return table.ColumnValue{Name: name, Value: table.Value{Type: table.Integer, Integral: n}}
+	}
+	w.add([]table.ColumnValue{value("colB", 2)})
+	w.add([]table.ColumnValue{value("colA", 3), value("colB", 4)})
+	w.add([]table.ColumnValue{value("colB", 6), value("colA", 5)})
+	w.add([]table.ColumnValue{value("colA", 7)})
+	w.flush()
+	if w.err != nil {
+		t.Fatalf("Unexpected Error: %s", w.err)
+	}
+	expected := "[[{3 0 } {1 2 }] [{1 3 } {1 4 }] [{1 5 } {1 6 }] [{1 7 } {3 0 }]]"
+	if actual := fmt.Sprint(w.rows); actual != expected {
+		t.Fatalf("expected %s but got %s", expected, actual)
+	}
+}
+
+// largeTable writes a table tbl1 of n rows (i, i + 1) to dir.
+func largeTable(tb testing.TB, dir string, n int) *runtime.Session {
+	var sb strings.Builder
+	sb.WriteString("#colA, colB\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "%d,%d\n", i, i+1)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), []byte(sb.String()), 0644); err != nil {
+		tb.Fatalf("Unexpected Error: %s", err)
+	}
+	return runtime.New().Set(dir).Session()
+}
+
+// BenchmarkVectorized compares batches of BatchSize rows with batches of a
+// single row, which is interpreting the code once per row, and reading the
+// table in one part with reading it in parts at once.
+func BenchmarkVectorized(b *testing.B) {
+	dir, err := ioutil.TempDir("", "vector")
+	if err != nil {
+		b.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sess := largeTable(b, dir, 100000)
+
+	benchCases := []struct {
+		name  string
+		codes []VMCode
+	}{
+		{"scan", []VMCode{read("colA"), fetch("colA"), {Operator: STORE}}},
+		{"arithmetic", []VMCode{read("colA", "colB"), fetch("colA"), fetch("colB"), {Operator: MUL}, push(3), {Operator: ADD}, fetch("colB"), push(7), {Operator: MOD}, {Operator: SUB}, {Operator: STORE}}},
+		{"filter", []VMCode{read("colA", "colB"), fetch("colA"), push(100), {Operator: MOD}, push(0), {Operator: EQ}, jump(JUMP_IF_TRUE, 8), jump(JUMP, 12), fetch("colA"), fetch("colB"), {Operator: ADD}, {Operator: STORE}}},
+	}
+	for _, bc := range benchCases {
+		for _, size := range []int{1, BatchSize} {
+			for _, parts := range []int{1, 4} {
+				b.Run(fmt.Sprintf("%s/batch=%d/parts=%d", bc.name, size, parts), func(b *testing.B) {
+					for n := 0; n < b.N; n++ {
+						if _, err := executeVectorized(sess, bc.codes, nil, size, parts); err != nil {
+							b.Fatalf("Unexpected Error: %s", err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkVectorizedCompute is BenchmarkVectorized without reading the
+// table: it runs the code over BatchSize rows already in memory.
+func BenchmarkVectorizedCompute(b *testing.B) {
+	codes := []VMCode{read("colA", "colB"), fetch("colA"), fetch("colB"), {Operator: MUL}, push(3), {Operator: ADD}, fetch("colB"), push(7), {Operator: MOD}, {Operator: SUB}, {Operator: STORE}}
+	names := map[string]int{"colA": 0, "colB": 1}
+	for _, size := range []int{1, BatchSize} {
+		p, err := compileVector(codes, nil, size, runtime.GetInstance().Session().NewUsage())
+		if err != nil {
+			b.Fatalf("Unexpected Error: %s", err)
+		}
+		batches := []*Batch{}
+		for n := 0; n < BatchSize; n += size {
+			batch := &Batch{Len: size, Columns: []*Vector{newVector(size), newVector(size)}, Sel: make([]int, 0, size)}
+			for i := 0; i < size; i++ {
+				batch.Columns[0].Values[i] = n + i
+				batch.Columns[1].Values[i] = n + i + 1
+			}
+			batches = append(batches, batch)
+		}
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				rows := [][]result.Value{}
+				for _, batch := range batches {
+					if err := p.run(batch, names, &rows); err != nil {
+						b.Fatalf("Unexpected Error: %s", err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// state is what the verifier knows before an instruction: the depth of
+// the stack, and the integer on top of it when a PUSH put it there.
+type state struct {
+	depth int
+	top   int
+	known bool
+}
+
+// Verify checks codes before they are run: every jump lands on an
+// instruction or on the end of the program, every label was resolved,
+// profiled ranges fit in the program, and on every path each instruction
+// finds the values it pops on the stack, with the same depth whichever way
+// it is reached. The number of arguments of a CALL has to be pushed right
+// before it.
+func Verify(codes []VMCode) error {
+	_, err := analyze(codes)
+	return err
+}
+
+// analyze verifies codes and returns the state before each instruction,
+// nil for the instructions that cannot be reached. The last state is that
+// of the end of the program.
+func analyze(codes []VMCode) ([]*state, error) {
+	for pc, c := range codes {
+		if c.Operator == LABEL || c.Operand1.Type == Label || c.Operand2.Type == Label {
+			return nil, errors.New(fmt.Sprintf("Unresolved label at %d", pc))
+		}
+		switch c.Operator {
+		case JUMP, JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL, NEXT, TAKE:
+			if c.Operand1.Type != Integer || c.Operand1.Integral < 0 || c.Operand1.Integral > len(codes) {
+				return nil, errors.New(fmt.Sprintf("Invalid jump target at %d", pc))
+			}
+		case PROFILE:
+			if c.Operand1.Integral < 0 || pc+1+c.Operand1.Integral > len(codes) {
+				return nil, errors.New(fmt.Sprintf("Invalid profile range at %d", pc))
+			}
+		}
+	}
+
+	states := make([]*state, len(codes)+1)
+	states[0] = &state{}
+	queue := []int{0}
+	for len(queue) != 0 {
+		pc := queue[0]
+		queue = queue[1:]
+		if pc == len(codes) {
+			continue
+		}
+		s := *states[pc]
+		next, err := s.step(pc, codes[pc])
+		if err != nil {
+			return nil, err
+		}
+		targets := []int{}
+		// TAKE jumps before pushing its value.
+		if codes[pc].Operator == TAKE {
+			st := s
+			st.known = false
+			if err := merge(states, codes[pc].Operand1.Integral, st, &queue); err != nil {
+				return nil, err
+			}
+		}
+		switch codes[pc].Operator {
+		case JUMP:
+			targets = append(targets, codes[pc].Operand1.Integral)
+		case JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL, NEXT:
+			targets = append(targets, pc+1, codes[pc].Operand1.Integral)
+		case HALT:
+		default:
+			targets = append(targets, pc+1)
+		}
+		for _, t := range targets {
+			if err := merge(states, t, next, &queue); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return states, nil
+}
+
+// merge records that the instruction at t can be reached in state s, and
+// queues it when that is new.
+func merge(states []*state, t int, s state, queue *[]int) error {
+	prev := states[t]
+	if prev == nil {
+		states[t] = &s
+		*queue = append(*queue, t)
+		return nil
+	}
+	if prev.depth != s.depth {
+		return errors.New(fmt.Sprintf("Stack depth mismatch at %d: %d and %d", t, prev.depth, s.depth))
+	}
+	if prev.known && (!s.known || prev.top != s.top) {
+		prev.known = false
+		*queue = append(*queue, t)
+	}
+	return nil
+}
+
+// step returns the state after c.
+func (s state) step(pc int, c VMCode) (state, error) {
+	pops := 0
+	pushes := 0
+	switch c.Operator {
+	case PUSH, PARAM, FETCH, TAKE:
+		pushes = 1
+	case POP, STORE, SET, JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL, LOOKUP:
+		pops = 1
+	case ADD, SUB, MUL, DIV, MOD, EQ, LT, LE, GT, GE, AND:
+		pops = 2
+		pushes = 1
+	case LIMIT:
+		pops = 2
+	case FOREIGN_KEY, RANGE:
+		pops = 3
+	case CALL:
+		if !s.known || s.top < 0 {
+			return s, errors.New(fmt.Sprintf("Unknown number of arguments at %d", pc))
+		}
+		pops = s.top + 1
+		pushes = 1
+	case PRAGMA:
+		if c.Operand2.Type == Integer {
+			pops = 1
+		}
+	}
+	if s.depth < pops {
+		return s, errors.New(fmt.Sprintf("Stack underflow at %d", pc))
+	}
+	next := state{depth: s.depth - pops + pushes}
+	if c.Operator == PUSH && c.Operand1.Type == Integer {
+		next.top = c.Operand1.Integral
+		next.known = true
+	}
+	return next, nil
+}
@@ -1,12 +1,21 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"strings"
 
 	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/storage/table"
 	"github.com/yakawa/simpleDB/runtime/vm/functions"
 )
 
+// OpeType is an operator of the VM. The values are stored by Encode, so new
+// operators are added at the end.
 type OpeType int
 
 const (
@@ -18,10 +27,48 @@ const (
 	MUL
 	DIV
 	MOD
+	EQ
 	STORE
 	CALL
 	READ
 	FETCH
+	CREATE_INDEX
+	DROP_INDEX
+	PRAGMA
+	BEGIN
+	COMMIT
+	ROLLBACK
+	SAVEPOINT
+	RELEASE
+	ROLLBACK_TO
+	PARAM
+	ANALYZE
+	ROW
+	PROFILE
+	REPORT
+	JUMP
+	JUMP_IF_TRUE
+	JUMP_IF_FALSE
+	JUMP_IF_NULL
+	HALT
+	LABEL
+	SET
+	NEXT
+	AND
+	LIMIT
+	TAKE
+	HOLD
+	SORT
+	LOOKUP
+	FOREIGN_KEY
+	CREATE_TABLE
+	LT
+	LE
+	GT
+	GE
+	RANGE
+	// numOperators follows the last operator.
+	numOperators
 )
 
 func (o OpeType) String() string {
@@ -40,6 +87,8 @@ func (o OpeType) String() string {
 		return "DIV"
 	case MOD:
 		return "MOD"
+	case EQ:
+		return "EQ"
 	case STORE:
 		return "STORE"
 	case CALL:
@@ -48,11 +97,83 @@ func (o OpeType) String() string {
 		return "READ"
 	case FETCH:
 		return "FETCH"
+	case CREATE_INDEX:
+		return "CREATE_INDEX"
+	case DROP_INDEX:
+		return "DROP_INDEX"
+	case PRAGMA:
+		return "PRAGMA"
+	case BEGIN:
+		return "BEGIN"
+	case COMMIT:
+		return "COMMIT"
+	case ROLLBACK:
+		return "ROLLBACK"
+	case SAVEPOINT:
+		return "SAVEPOINT"
+	case RELEASE:
+		return "RELEASE"
+	case ROLLBACK_TO:
+		return "ROLLBACK_TO"
+	case PARAM:
+		return "PARAM"
+	case ANALYZE:
+		return "ANALYZE"
+	case ROW:
+		return "ROW"
+	case PROFILE:
+		return "PROFILE"
+	case REPORT:
+		return "REPORT"
+	case JUMP:
+		return "JUMP"
+	case JUMP_IF_TRUE:
+		return "JUMP_IF_TRUE"
+	case JUMP_IF_FALSE:
+		return "JUMP_IF_FALSE"
+	case JUMP_IF_NULL:
+		return "JUMP_IF_NULL"
+	case HALT:
+		return "HALT"
+	case LABEL:
+		return "LABEL"
+	case SET:
+		return "SET"
+	case NEXT:
+		return "NEXT"
+	case AND:
+		return "AND"
+	case LIMIT:
+		return "LIMIT"
+	case TAKE:
+		return "TAKE"
+	case HOLD:
+		return "HOLD"
+	case SORT:
+		return "SORT"
+	case LOOKUP:
+		return "LOOKUP"
+	case FOREIGN_KEY:
+		return "FOREIGN_KEY"
+	case CREATE_TABLE:
+		return "CREATE_TABLE"
+	case LT:
+		return "LT"
+	case LE:
+		return "LE"
+	case GT:
+		return "GT"
+	case GE:
+		return "GE"
+	case RANGE:
+		return "RANGE"
 	default:
 		return "Unknwo Operation"
 	}
 }
 
+// ValueType is the type of a VMValue. The values are stored by Encode, so
+// new types are added at the end.
 type ValueType int
 
 const (
@@ -62,6 +183,10 @@ const (
 	String
 	Table
 	Column
+	Index
+	Null
+	// Label is a jump target the planner has not resolved to an address.
+	Label
 )
 
 func (v ValueType) String() string {
@@ -76,6 +201,12 @@ func (v ValueType) String() string {
 		return "Table"
 	case Column:
 		return "Column"
+	case Index:
+		return "Index"
+	case Null:
+		return "Null"
+	case Label:
+		return "Label"
 	default:
 		return "Unknown"
 	}
@@ -87,12 +218,15 @@ type VMValue struct {
 	String   string
 	Table    VMTable
 	Column   VMColumn
+	Index    VMIndex
 }
 
 type VMTable struct {
 	Table  string
 	DB     string
 	Schema string
+	// Columns limits the columns read from the table. Nil reads them all.
+	Columns []string
 }
 
 type VMColumn struct {
@@ -101,6 +235,16 @@ type VMColumn struct {
 	DB     string
 	Schema string
 }
+
+type VMIndex struct {
+	Index   string
+	Table   string
+	DB      string
+	Schema  string
+	Columns []string
+	Unique  bool
+}
+
 type VMCode struct {
 	Operator OpeType
 	Operand1 VMValue
@@ -108,158 +252,1015 @@ type VMCode struct {
 }
 
 func (c VMCode) String() string {
-	s := ""
-	s = fmt.Sprintf("%s", c.Operator)
+	if ops := c.Operands(); ops != "" {
+		return fmt.Sprintf("%s %s", c.Operator, ops)
+	}
+	return c.Operator.String()
+}
 
-	if c.Operand1.Type != Nothing {
-		switch c.Operand1.Type {
-		case Integer:
-			s = fmt.Sprintf("%s %d", s, c.Operand1.Integral)
-		case String:
-			s = fmt.Sprintf("%s %s", s, c.Operand1.String)
+// Operands renders the operands of c, separated by a comma.
+func (c VMCode) Operands() string {
+	ops := []string{}
+	for _, v := range []VMValue{c.Operand1, c.Operand2} {
+		if s := v.operand(); s != "" {
+			ops = append(ops, s)
 		}
 	}
+	return strings.Join(ops, ", ")
+}
 
-	if c.Operand2.Type != Nothing {
-		switch c.Operand1.Type {
-		case Integer:
-			s = fmt.Sprintf("%s %d", s, c.Operand2.Integral)
-		case String:
-			s = fmt.Sprintf("%s %s", s, c.Operand2.String)
+func (v VMValue) operand() string {
+	switch v.Type {
+	case Integer:
+		return fmt.Sprintf("%d", v.Integral)
+	case String:
+		return v.String
+	case Table:
+		s := qualify(v.Table.DB, v.Table.Table)
+		if v.Table.Columns != nil {
+			s += fmt.Sprintf(" [%s]", strings.Join(v.Table.Columns, ", "))
+		}
+		return s
+	case Column:
+		return qualify(v.Column.Table, v.Column.Column)
+	case Index:
+		s := fmt.Sprintf("%s ON %s (%s)", v.Index.Index, v.Index.Table, strings.Join(v.Index.Columns, ", "))
+		switch {
+		case v.Index.Table == "":
+			s = v.Index.Index
+		case v.Index.Index == "":
+			s = fmt.Sprintf("%s (%s)", v.Index.Table, strings.Join(v.Index.Columns, ", "))
 		}
+		if v.Index.Unique {
+			s += " UNIQUE"
+		}
+		return s
+	case Null:
+		return "NULL"
+	case Label:
+		return fmt.Sprintf("L%d", v.Integral)
+	default:
+		return ""
+	}
+}
+
+func qualify(prefix string, name string) string {
+	if prefix == "" || prefix == "_" {
+		return name
 	}
+	return prefix + "." + name
+}
 
-	return s
+// Comment describes what c does, for EXPLAIN.
+func (c VMCode) Comment() string {
+	switch c.Operator {
+	case PUSH:
+		return "push a constant"
+	case POP:
+		return "discard the top value"
+	case ADD:
+		return "r = a + b"
+	case SUB:
+		return "r = a - b"
+	case MUL:
+		return "r = a * b"
+	case DIV:
+		return "r = a / b"
+	case MOD:
+		return "r = a % b"
+	case EQ:
+		return "r = (a = b)"
+	case LT:
+		return "r = (a < b)"
+	case LE:
+		return "r = (a <= b)"
+	case GT:
+		return "r = (a > b)"
+	case GE:
+		return "r = (a >= b)"
+	case AND:
+		return "r = a AND b"
+	case LIMIT:
+		return fmt.Sprintf("counter %d keeps a rows after skipping b", c.Operand1.Integral)
+	case TAKE:
+		return fmt.Sprintf("r = row kept by counter %d, or goto %s after its last", c.Operand2.Integral, c.Operand1.operand())
+	case HOLD:
+		return "hold the rows made from here"
+	case SORT:
+		return "sort the held rows and release them"
+	case LOOKUP:
+		if c.Operand1.Index.Index != "" {
+			return fmt.Sprintf("open cursor %d on the rows of %s matching a", c.Operand2.Integral, c.Operand1.Index.Table)
+		}
+		return fmt.Sprintf("keep the rows of cursor %d matching a", c.Operand2.Integral)
+	case RANGE:
+		return fmt.Sprintf("open cursor %d on the rows of %s from a to b in order c", c.Operand2.Integral, c.Operand1.Index.Table)
+	case STORE:
+		return "output a column"
+	case ROW:
+		return "end of a result row"
+	case CALL:
+		return fmt.Sprintf("call %s()", c.Operand1.String)
+	case READ:
+		return fmt.Sprintf("open a table as cursor %d", c.Operand2.Integral)
+	case NEXT:
+		return fmt.Sprintf("next row of cursor %d or goto %s", c.Operand2.Integral, c.Operand1.operand())
+	case FETCH:
+		return fmt.Sprintf("read a column of cursor %d", c.Operand2.Integral)
+	case PARAM:
+		return fmt.Sprintf("push parameter %d", c.Operand1.Integral)
+	case CREATE_INDEX:
+		return "create an index"
+	case FOREIGN_KEY:
+		return "add a foreign key with the actions and deferral popped"
+	case CREATE_TABLE:
+		return "create a table with the foreign keys added"
+	case DROP_INDEX:
+		return "drop an index"
+	case PRAGMA:
+		return "run a pragma"
+	case SET:
+		return fmt.Sprintf("set %s to a", c.Operand1.String)
+	case ANALYZE:
+		return "collect statistics"
+	case PROFILE:
+		return fmt.Sprintf("profile the next %d instructions", c.Operand1.Integral)
+	case REPORT:
+		return fmt.Sprintf("output the profile of %d..%d", c.Operand1.Integral, c.Operand2.Integral)
+	case JUMP:
+		return fmt.Sprintf("goto %s", c.Operand1.operand())
+	case JUMP_IF_TRUE:
+		return fmt.Sprintf("if a is true goto %s", c.Operand1.operand())
+	case JUMP_IF_FALSE:
+		return fmt.Sprintf("if a is false goto %s", c.Operand1.operand())
+	case JUMP_IF_NULL:
+		return fmt.Sprintf("if a is NULL goto %s", c.Operand1.operand())
+	case HALT:
+		return "stop the program"
+	case LABEL:
+		return "jump target"
+	case BEGIN, COMMIT, ROLLBACK, SAVEPOINT, RELEASE, ROLLBACK_TO:
+		return "transaction control"
+	default:
+		return ""
+	}
 }
 
 func Run(codes []VMCode) []result.Value {
-	s := newStack()
+	return RunWith(runtime.GetInstance().Session(), codes)
+}
+
+func RunWith(sess *runtime.Session, codes []VMCode) []result.Value {
+	cols, _ := Execute(sess, codes)
+	return cols
+}
+
+// Execute runs codes in the session sess with params bound to the PARAM
+// slots, starting from index 1. If a statement fails the transaction of the
+// session is rolled back and the error is returned.
+func Execute(sess *runtime.Session, codes []VMCode, params ...VMValue) ([]result.Value, error) {
+	return ExecuteContext(context.Background(), sess, codes, params...)
+}
+
+// ExecuteContext is Execute stopping with the error of ctx once it is
+// done.
+func ExecuteContext(ctx context.Context, sess *runtime.Session, codes []VMCode, params ...VMValue) ([]result.Value, error) {
+	rows, err := ExecuteRowsContext(ctx, sess, codes, params...)
+	if err != nil {
+		return []result.Value{}, err
+	}
 	cols := []result.Value{}
+	for _, row := range rows {
+		cols = append(cols, row...)
+	}
+	return cols, nil
+}
 
-	for _, code := range codes {
-		switch code.Operator {
-		case PUSH:
-			s.push(code.Operand1)
-		case ADD:
-			ope2, err := s.pop()
-			if err != nil {
-				return []result.Value{}
-			}
-			ope1, err := s.pop()
-			if err != nil {
-				return []result.Value{}
-			}
-			v := VMValue{
-				Type:     Integer,
-				Integral: ope1.Integral + ope2.Integral,
-			}
-			s.push(v)
+// ExecuteRows is Execute keeping the values of each result row apart.
+// Codes that do not pass Verify are not run. Expression code is run on the
+// register machine, the rest on the stack machine.
+func ExecuteRows(sess *runtime.Session, codes []VMCode, params ...VMValue) ([][]result.Value, error) {
+	return ExecuteRowsContext(context.Background(), sess, codes, params...)
+}
 
-		case SUB:
-			ope2, err := s.pop()
-			if err != nil {
-				return []result.Value{}
-			}
-			ope1, err := s.pop()
-			if err != nil {
-				return []result.Value{}
-			}
-			v := VMValue{
-				Type:     Integer,
-				Integral: ope1.Integral - ope2.Integral,
-			}
-			s.push(v)
-		case MUL:
-			ope2, err := s.pop()
-			if err != nil {
-				return []result.Value{}
-			}
-			ope1, err := s.pop()
-			if err != nil {
-				return []result.Value{}
-			}
-			v := VMValue{
-				Type:     Integer,
-				Integral: ope1.Integral * ope2.Integral,
-			}
-			s.push(v)
+// ExecuteRowsContext is ExecuteRows stopping with the error of ctx once it
+// is done, or once the statement timeout of the session has passed.
+func ExecuteRowsContext(ctx context.Context, sess *runtime.Session, codes []VMCode, params ...VMValue) ([][]result.Value, error) {
+	rs, err := Query(ctx, sess, codes, params...)
+	if err != nil {
+		return [][]result.Value{}, err
+	}
+	defer rs.Close()
+	rows := [][]result.Value{}
+	for {
+		row, err := rs.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return [][]result.Value{}, err
+		}
+		rows = append(rows, row)
+	}
+}
 
-		case DIV:
-			ope2, err := s.pop()
-			if err != nil {
-				return []result.Value{}
+// checkInterval is the number of instructions executed between two checks
+// of the context of the session.
+const checkInterval = 1024
+
+// withContext makes ctx, limited by the statement timeout of sess, the
+// context of sess and returns the function that restores the previous one.
+func withContext(ctx context.Context, sess *runtime.Session) func() {
+	cancel := func() {}
+	if t := sess.StatementTimeout(); t > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t)
+	}
+	prev := sess.Context()
+	sess.SetContext(ctx)
+	return func() {
+		sess.SetContext(prev)
+		cancel()
+	}
+}
+
+// run executes codes on the stack machine and returns all their rows.
+func run(sess *runtime.Session, codes []VMCode, params []VMValue) ([][]result.Value, error) {
+	m := newMachine(sess, codes, params)
+	rows := [][]result.Value{}
+	for {
+		row, err := m.next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func newMachine(sess *runtime.Session, codes []VMCode, params []VMValue) *machine {
+	return &machine{
+		sess:     sess,
+		codes:    codes,
+		params:   params,
+		stack:    newStack(),
+		rows:     [][]result.Value{},
+		cols:     []result.Value{},
+		cursors:  map[int]*cursor{},
+		counters: map[int]*counter{},
+		usage:    sess.NewUsage(),
+	}
+}
+
+// next runs the program until it has made a row and returns the row, or
+// io.EOF once the program has ended. The values stored after the last ROW
+// make up the last row. The program ends after its last instruction or at
+// HALT. The rows of a profiled range are only returned once it is left, as
+// it may drop them.
+func (m *machine) next() (row []result.Value, err error) {
+	defer func() {
+		if err != nil {
+			m.closeCursors()
+		}
+	}()
+	for len(m.rows) == 0 || (m.prof != nil && m.prof.covers(m.pc)) {
+		if m.pc >= len(m.codes) {
+			if m.ended {
+				return nil, io.EOF
 			}
-			ope1, err := s.pop()
-			if err != nil {
-				return []result.Value{}
+			m.ended = true
+			m.endRow()
+			if err := m.accountRows(); err != nil {
+				return nil, err
 			}
-			v := VMValue{
-				Type:     Integer,
-				Integral: ope1.Integral / ope2.Integral,
+			continue
+		}
+		if m.n%checkInterval == 0 && m.sess.Context().Err() != nil {
+			return nil, m.sess.Context().Err()
+		}
+		m.n++
+		if m.prof != nil && m.prof.covers(m.pc) {
+			m.pc, err = m.prof.run(m, m.pc)
+		} else {
+			m.pc, err = m.exec(m.pc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := m.account(); err != nil {
+			return nil, err
+		}
+	}
+	row = m.rows[0]
+	m.rows = m.rows[1:]
+	m.returned--
+	return row, nil
+}
+
+// machine is the stack machine. pc is the address of the next instruction,
+// n the number of instructions executed and ended whether the program has
+// ended. rows are the rows made but not returned yet.
+type machine struct {
+	sess   *runtime.Session
+	codes  []VMCode
+	params []VMValue
+	stack  *stack
+	rows   [][]result.Value
+	cols   []result.Value
+	prof   *profile
+	pc     int
+	n      int
+	ended  bool
+
+	cursors map[int]*cursor
+
+	// counters are the row counters of LIMIT. While holding, the rows made
+	// are kept in held until SORT releases them.
+	counters map[int]*counter
+	holding  bool
+	held     [][]result.Value
+
+	// foreignKeys are the foreign keys of the next CREATE_TABLE.
+	foreignKeys []runtime.ForeignKey
+
+	// usage counts the resources of the statement. depth is the deepest
+	// the stack has been, stored and returned the values and rows already
+	// counted.
+	usage    *runtime.Usage
+	depth    int
+	stored   int
+	returned int
+}
+
+// account counts an instruction executed and the memory it allocated.
+func (m *machine) account() error {
+	if err := m.usage.Instructions(1); err != nil {
+		return err
+	}
+	if d := m.stack.size(); d > m.depth {
+		if err := m.usage.Memory(int64(d-m.depth) * vmValueSize); err != nil {
+			return err
+		}
+		m.depth = d
+	}
+	return m.accountRows()
+}
+
+// accountRows counts the values stored and the rows ended since the last
+// call.
+func (m *machine) accountRows() error {
+	if len(m.cols) < m.stored {
+		m.stored = 0
+	}
+	if err := m.usage.Memory(resultBytes(m.cols[m.stored:])); err != nil {
+		return err
+	}
+	m.stored = len(m.cols)
+	if n := len(m.rows) - m.returned; n > 0 {
+		m.returned = len(m.rows)
+		return m.usage.Rows(int64(n))
+	}
+	return nil
+}
+
+// cursor is a table opened by READ, or the rows of an index opened by
+// LOOKUP or RANGE. Its rows are pulled from a scan as NEXT moves over them,
+// counting their memory, and only the current one is kept. A cursor that is
+// rewound or looked up keeps all its rows instead, read again by reopen,
+// and pos is the number of them NEXT has moved over. Once LOOKUP has run,
+// NEXT only moves over the rows in match, found in the hash table of the
+// rows on the column hashed.
+type cursor struct {
+	table  VMTable
+	reopen func() *runtime.Scan
+	scan   *runtime.Scan
+	row    []table.ColumnValue
+	kept   bool
+	rows   [][]table.ColumnValue
+	pos    int
+	match  []int
+	hashed string
+	hash   map[int][]int
+}
+
+// size returns the number of kept rows NEXT moves over.
+func (c *cursor) size() int {
+	if c.match != nil {
+		return len(c.match)
+	}
+	return len(c.rows)
+}
+
+// current returns the row NEXT moved to, or nil if there is none.
+func (c *cursor) current() []table.ColumnValue {
+	if !c.kept {
+		return c.row
+	}
+	if c.pos == 0 || c.pos > c.size() {
+		return nil
+	}
+	if c.match != nil {
+		return c.rows[c.match[c.pos-1]]
+	}
+	return c.rows[c.pos-1]
+}
+
+func (c *cursor) close() {
+	if c.scan != nil {
+		c.scan.Close()
+		c.scan = nil
+	}
+}
+
+// closeCursors stops the scans of the open cursors. It is done when the
+// program ends or fails, and before it changes the database or the
+// session, which the scans read.
+func (m *machine) closeCursors() {
+	for _, c := range m.cursors {
+		c.close()
+	}
+	m.cursors = map[int]*cursor{}
+}
+
+// open opens the table of a READ as its cursor. A cursor opened again on
+// the same table, as the inner table of a nested loop is, is rewound
+// instead, keeping the rows of the table from then on.
+func (m *machine) open(code VMCode) error {
+	id := code.Operand2.Integral
+	tbl := code.Operand1.Table
+	if c, exists := m.cursors[id]; exists && c.table.DB == tbl.DB && c.table.Table == tbl.Table && reflect.DeepEqual(c.table.Columns, tbl.Columns) {
+		if err := m.keep(c); err != nil {
+			return err
+		}
+		c.pos = 0
+		c.match = nil
+		return nil
+	}
+	m.openCursor(id, tbl, func() *runtime.Scan {
+		return m.sess.OpenScan(tbl.DB, tbl.Table, tbl.Columns)
+	})
+	return nil
+}
+
+// openCursor makes the rows of the scans open returns the cursor id,
+// closing the one it replaces. A nil open makes a cursor without rows.
+func (m *machine) openCursor(id int, tbl VMTable, open func() *runtime.Scan) {
+	if c, exists := m.cursors[id]; exists {
+		c.close()
+	}
+	c := &cursor{table: tbl, reopen: open}
+	if open == nil {
+		c.kept = true
+		c.rows = [][]table.ColumnValue{}
+	} else {
+		c.scan = open()
+	}
+	m.cursors[id] = c
+}
+
+// keep reads all the rows of the table of a cursor into it, from its scan
+// if NEXT has not moved over any row yet and from a new one otherwise.
+func (m *machine) keep(c *cursor) error {
+	if c.kept {
+		return nil
+	}
+	if c.pos != 0 {
+		c.close()
+	}
+	if c.scan == nil {
+		c.scan = c.reopen()
+	}
+	defer c.close()
+	c.rows = [][]table.ColumnValue{}
+	for {
+		row, err := c.scan.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := m.usage.Memory(rowBytes(row)); err != nil {
+			return err
+		}
+		c.rows = append(c.rows, row)
+	}
+	c.kept = true
+	c.row = nil
+	c.pos = 0
+	return nil
+}
+
+// advance moves a cursor to its next row and reports whether there was
+// one.
+func (m *machine) advance(c *cursor) (bool, error) {
+	if c.kept {
+		if c.pos >= c.size() {
+			return false, nil
+		}
+		c.pos++
+		return true, nil
+	}
+	row, err := c.scan.Next()
+	if err == io.EOF {
+		c.row = nil
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := m.usage.Memory(rowBytes(row)); err != nil {
+		return false, err
+	}
+	c.row = row
+	c.pos++
+	return true, nil
+}
+
+// lookup restricts the open cursor of a LOOKUP to its rows whose column
+// equals key, hashing the rows on the column the first time. A NULL key
+// matches no row. A LOOKUP naming an index opens the cursor instead, with
+// the rows the index finds.
+func (m *machine) lookup(code VMCode, key VMValue) error {
+	if code.Operand1.Index.Index != "" {
+		return m.lookupIndex(code, key)
+	}
+	c, err := m.cursor(code.Operand2.Integral)
+	if err != nil {
+		return err
+	}
+	if err := m.keep(c); err != nil {
+		return err
+	}
+	cols := code.Operand1.Index.Columns
+	if len(cols) != 1 {
+		return errors.New(fmt.Sprintf("Lookup on %d columns is not supported", len(cols)))
+	}
+	if c.hash == nil || c.hashed != cols[0] {
+		c.hash = map[int][]int{}
+		c.hashed = cols[0]
+		for n, row := range c.rows {
+			for _, cv := range row {
+				if cv.Name == cols[0] && cv.Value.Type == table.Integer {
+					c.hash[cv.Value.Integral] = append(c.hash[cv.Value.Integral], n)
+				}
 			}
-			s.push(v)
-		case MOD:
-			ope2, err := s.pop()
-			if err != nil {
-				return []result.Value{}
+		}
+		if err := m.usage.Memory(int64(len(c.rows)) * 2 * intSize); err != nil {
+			return err
+		}
+	}
+	c.pos = 0
+	c.match = []int{}
+	if key.Type != Null {
+		c.match = append(c.match, c.hash[key.Integral]...)
+	}
+	return nil
+}
+
+// lookupIndex opens the cursor of a LOOKUP on the rows of its table that
+// its index finds for key.
+func (m *machine) lookupIndex(code VMCode, key VMValue) error {
+	if key.Type != Integer {
+		key = VMValue{Type: Null}
+	}
+	return m.scanRange(code, key, key, VMValue{Type: Integer, Integral: int(runtime.TableOrder)})
+}
+
+// scanRange opens the cursor of a RANGE or a LOOKUP on the rows of its
+// table that its index finds from low to high, in the order order, a
+// runtime.KeyOrder. A bound that is Nothing leaves the range open on its
+// side, and any other that is not an integer matches no row.
+func (m *machine) scanRange(code VMCode, low VMValue, high VMValue, order VMValue) error {
+	idx := code.Operand1.Index
+	tbl := VMTable{Table: idx.Table, DB: idx.DB, Schema: idx.Schema}
+	rg := runtime.KeyRange{Order: runtime.KeyOrder(order.Integral)}
+	for _, b := range []struct {
+		v     VMValue
+		bound **int
+	}{{low, &rg.Low}, {high, &rg.High}} {
+		switch b.v.Type {
+		case Nothing:
+		case Integer:
+			n := b.v.Integral
+			*b.bound = &n
+		default:
+			m.openCursor(code.Operand2.Integral, tbl, nil)
+			return nil
+		}
+	}
+	m.openCursor(code.Operand2.Integral, tbl, func() *runtime.Scan {
+		return m.sess.OpenIndexScan(idx.DB, idx.Index, rg, nil)
+	})
+	return nil
+}
+
+func (m *machine) cursor(id int) (*cursor, error) {
+	c, exists := m.cursors[id]
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("Cursor (%d) is not open", id))
+	}
+	return c, nil
+}
+
+// fetch returns the value of a column in the current row of a cursor.
+func (m *machine) fetch(code VMCode) (VMValue, error) {
+	c, err := m.cursor(code.Operand2.Integral)
+	if err != nil {
+		return VMValue{}, err
+	}
+	row := c.current()
+	if row == nil {
+		return VMValue{}, errors.New(fmt.Sprintf("Cursor (%d) has no current row", code.Operand2.Integral))
+	}
+	for _, cv := range row {
+		if cv.Name != code.Operand1.Column.Column {
+			continue
+		}
+		if cv.Value.Type == table.Null {
+			return VMValue{Type: Null}, nil
+		}
+		return VMValue{Type: Integer, Integral: cv.Value.Integral}, nil
+	}
+	return VMValue{}, errors.New(fmt.Sprintf("Column (%s) Not Found", code.Operand1.Column.Column))
+}
+
+// compute applies the arithmetic or comparison operator op to a and b,
+// which are not NULL: an operand that is NULL makes the result NULL
+// instead, as it does in the vectorized engine.
+func compute(op OpeType, a int, b int) (int, error) {
+	switch op {
+	case ADD:
+		return a + b, nil
+	case SUB:
+		return a - b, nil
+	case MUL:
+		return a * b, nil
+	case DIV, MOD:
+		if b == 0 {
+			return 0, errors.New("Division by zero")
+		}
+		if op == DIV {
+			return a / b, nil
+		}
+		return a % b, nil
+	case EQ:
+		return truth(a == b), nil
+	case LT:
+		return truth(a < b), nil
+	case LE:
+		return truth(a <= b), nil
+	case GT:
+		return truth(a > b), nil
+	case GE:
+		return truth(a >= b), nil
+	default:
+		return 0, errors.New(fmt.Sprintf("Operator (%s) is not arithmetic", op))
+	}
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// toResult returns the result value of a VM value. Every value stored makes
+// exactly one column, so a value of no other type is NULL.
+func toResult(t ValueType, n int, s string) result.Value {
+	switch t {
+	case Integer:
+		return result.Value{Type: result.Integral, Integral: n}
+	case String:
+		return result.Value{Type: result.String, String: s}
+	default:
+		return result.Value{Type: result.Null}
+	}
+}
+
+// fromResult returns the VM value of the result of a function, NULL when
+// the function has no result for its arguments.
+func fromResult(r result.Value) VMValue {
+	switch r.Type {
+	case result.Integral:
+		return VMValue{Type: Integer, Integral: r.Integral}
+	case result.String:
+		return VMValue{Type: String, String: r.String}
+	default:
+		return VMValue{Type: Null}
+	}
+}
+
+func (m *machine) endRow() {
+	if len(m.cols) != 0 && m.holding {
+		m.held = append(m.held, m.cols)
+		m.cols = []result.Value{}
+	}
+	if len(m.cols) != 0 {
+		m.rows = append(m.rows, m.cols)
+		m.cols = []result.Value{}
+	}
+}
+
+// exec executes the instruction at pc and returns the address of the next
+// one.
+func (m *machine) exec(pc int) (int, error) {
+	code := m.codes[pc]
+	if changes(code.Operator) {
+		m.closeCursors()
+	}
+	switch code.Operator {
+	case PUSH:
+		m.stack.push(code.Operand1)
+	case POP:
+		if _, err := m.stack.pop(); err != nil {
+			return pc, err
+		}
+	case ADD, SUB, MUL, DIV, MOD, EQ, LT, LE, GT, GE:
+		ope2, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		ope1, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		v := VMValue{Type: Null}
+		if ope1.Type != Null && ope2.Type != Null {
+			v.Type = Integer
+			if v.Integral, err = compute(code.Operator, ope1.Integral, ope2.Integral); err != nil {
+				return pc, err
 			}
-			ope1, err := s.pop()
+		}
+		m.stack.push(v)
+	case AND:
+		ope2, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		ope1, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		m.stack.push(and(ope1, ope2))
+
+	case CALL:
+		args := []interface{}{}
+
+		argsN, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		for i := 0; i < argsN.Integral; i++ {
+			v, err := m.stack.pop()
 			if err != nil {
-				return []result.Value{}
+				return pc, err
 			}
-			v := VMValue{
-				Type:     Integer,
-				Integral: ope1.Integral % ope2.Integral,
+			switch v.Type {
+			case Integer:
+				args = append(args, v.Integral)
+			case String:
+				args = append(args, v.String)
 			}
-			s.push(v)
+		}
+
+		call := functions.LookupFunction(code.Operand1.String)
+		if call == nil {
+			return pc, errors.New(fmt.Sprintf("Function (%s) Not Found", code.Operand1.String))
+		}
+		r := call(args)
+		m.stack.push(fromResult(r))
+
+	case PARAM:
+		n := code.Operand1.Integral
+		if n < 1 || n > len(m.params) || m.params[n-1].Type == Nothing {
+			return pc, errors.New(fmt.Sprintf("Parameter (%d) is not bound", n))
+		}
+		m.stack.push(m.params[n-1])
+
+	case STORE:
+		v, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		m.cols = append(m.cols, toResult(v.Type, v.Integral, v.String))
+	case ROW:
+		m.endRow()
 
-		case CALL:
-			args := []interface{}{}
+	case JUMP:
+		return code.Operand1.Integral, nil
+	case JUMP_IF_TRUE, JUMP_IF_FALSE, JUMP_IF_NULL:
+		v, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		var jump bool
+		switch code.Operator {
+		case JUMP_IF_TRUE:
+			jump = v.Type != Null && v.Integral != 0
+		case JUMP_IF_FALSE:
+			jump = v.Type != Null && v.Integral == 0
+		case JUMP_IF_NULL:
+			jump = v.Type == Null
+		}
+		if jump {
+			return code.Operand1.Integral, nil
+		}
+	case HALT:
+		return len(m.codes), nil
+	case PROFILE:
+		m.prof = newProfile(m, pc+1, code.Operand1.Integral)
+	case REPORT:
+		if m.prof == nil {
+			return pc, errors.New("No profile to report")
+		}
+		m.cols = append(m.cols, m.prof.report(code.Operand1.Integral, code.Operand2.Integral)...)
 
-			argsN, err := s.pop()
+	case READ:
+		if err := m.open(code); err != nil {
+			return pc, err
+		}
+	case NEXT:
+		c, err := m.cursor(code.Operand2.Integral)
+		if err != nil {
+			return pc, err
+		}
+		found, err := m.advance(c)
+		if err != nil {
+			return pc, err
+		}
+		if !found {
+			return code.Operand1.Integral, nil
+		}
+	case LOOKUP:
+		key, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		if err := m.lookup(code, key); err != nil {
+			return pc, err
+		}
+	case RANGE:
+		vals := make([]VMValue, 3)
+		for n := len(vals) - 1; n >= 0; n-- {
+			v, err := m.stack.pop()
 			if err != nil {
-				return []result.Value{}
-			}
-			for i := 0; i < argsN.Integral; i++ {
-				v, err := s.pop()
-				if err != nil {
-					return []result.Value{}
-				}
-				switch v.Type {
-				case Integer:
-					args = append(args, v.Integral)
-				case String:
-					args = append(args, v.String)
-				}
+				return pc, err
 			}
+			vals[n] = v
+		}
+		if err := m.scanRange(code, vals[0], vals[1], vals[2]); err != nil {
+			return pc, err
+		}
+	case FETCH:
+		v, err := m.fetch(code)
+		if err != nil {
+			return pc, err
+		}
+		m.stack.push(v)
 
-			call := functions.LookupFunction(code.Operand1.String)
-			if call == nil {
-				return []result.Value{}
-			}
-			r := call(args)
-			var vr VMValue
-			switch r.Type {
-			case result.Integral:
-				vr = VMValue{
-					Type:     Integer,
-					Integral: r.Integral,
-				}
+	case LIMIT:
+		offset, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		limit, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		m.counters[code.Operand1.Integral] = newCounter(limit, offset)
+	case TAKE:
+		c, exists := m.counters[code.Operand2.Integral]
+		if !exists {
+			return pc, errors.New(fmt.Sprintf("Counter (%d) is not set", code.Operand2.Integral))
+		}
+		if c.full() {
+			return code.Operand1.Integral, nil
+		}
+		v := VMValue{Type: Integer}
+		if c.take() {
+			v.Integral = 1
+		}
+		m.stack.push(v)
+	case HOLD:
+		m.holding = true
+	case SORT:
+		rows, err := sortRows(m.held, code.Operand1.String)
+		if err != nil {
+			return pc, err
+		}
+		if code.Operand2.Type == Integer {
+			c, exists := m.counters[code.Operand2.Integral]
+			if !exists {
+				return pc, errors.New(fmt.Sprintf("Counter (%d) is not set", code.Operand2.Integral))
 			}
-			s.push(vr)
+			rows = c.apply(rows)
+		}
+		m.rows = append(m.rows, rows...)
+		m.holding = false
+		m.held = nil
 
-		case STORE:
-			v, err := s.pop()
+	case CREATE_INDEX:
+		idx := code.Operand1.Index
+		err := m.sess.CreateIndex(idx.DB, idx.Table, idx.Index, idx.Columns, idx.Unique)
+		if err != nil {
+			return pc, err
+		}
+	case DROP_INDEX:
+		idx := code.Operand1.Index
+		err := m.sess.DropIndex(idx.DB, idx.Index)
+		if err != nil {
+			return pc, err
+		}
+	case FOREIGN_KEY:
+		// The actions are the ReferentialAction values of the runtime.
+		vals := make([]VMValue, 3)
+		for n := len(vals) - 1; n >= 0; n-- {
+			v, err := m.stack.pop()
 			if err != nil {
-				return []result.Value{}
+				return pc, err
 			}
-			if v.Type == Integer {
-				cols = append(cols, result.Value{Type: result.Integral, Integral: v.Integral})
+			vals[n] = v
+		}
+		child, parent := code.Operand1.Index, code.Operand2.Index
+		m.foreignKeys = append(m.foreignKeys, runtime.ForeignKey{
+			Name:       child.Index,
+			Columns:    child.Columns,
+			RefTable:   parent.Table,
+			RefColumns: parent.Columns,
+			OnDelete:   runtime.ReferentialAction(vals[0].Integral),
+			OnUpdate:   runtime.ReferentialAction(vals[1].Integral),
+			Deferred:   vals[2].Integral != 0,
+		})
+	case CREATE_TABLE:
+		tbl := code.Operand1.Table
+		fks := m.foreignKeys
+		m.foreignKeys = nil
+		if err := m.sess.CreateTable(tbl.DB, tbl.Table, tbl.Columns, fks); err != nil {
+			return pc, err
+		}
+	case ANALYZE:
+		tbl := code.Operand1.Table
+		if err := m.sess.Analyze(tbl.DB, tbl.Table, m.usage); err != nil {
+			return pc, err
+		}
+
+	case PRAGMA:
+		var arg *int
+		if code.Operand2.Type == Integer {
+			v, err := m.stack.pop()
+			if err != nil {
+				return pc, err
 			}
+			arg = &v.Integral
+		}
+		rs, err := m.sess.Runtime().Pragma(code.Operand1.String, arg)
+		if err != nil {
+			return pc, err
+		}
+		for _, r := range rs {
+			m.cols = append(m.cols, result.Value{Type: result.Integral, Integral: r})
+		}
+
+	case SET:
+		v, err := m.stack.pop()
+		if err != nil {
+			return pc, err
+		}
+		if err := m.sess.Set(code.Operand1.String, v.Integral); err != nil {
+			return pc, err
+		}
 
-		case READ:
-		case FETCH:
+	case BEGIN:
+		mode := runtime.Deferred
+		if code.Operand1.String == "IMMEDIATE" {
+			mode = runtime.Immediate
+		}
+		if err := m.sess.Begin(mode); err != nil {
+			return pc, err
+		}
+	case COMMIT:
+		if err := m.sess.Commit(); err != nil {
+			return pc, err
+		}
+	case ROLLBACK:
+		if err := m.sess.Rollback(); err != nil {
+			return pc, err
+		}
+	case SAVEPOINT:
+		if err := m.sess.Savepoint(code.Operand1.String); err != nil {
+			return pc, err
+		}
+	case RELEASE:
+		if err := m.sess.Release(code.Operand1.String); err != nil {
+			return pc, err
+		}
+	case ROLLBACK_TO:
+		if err := m.sess.RollbackTo(code.Operand1.String); err != nil {
+			return pc, err
 		}
 	}
-	return cols
+	return pc + 1, nil
 }
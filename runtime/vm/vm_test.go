@@ -1,9 +1,16 @@
 package vm
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime"
 )
 
 func TestRun(t *testing.T) {
@@ -251,6 +258,35 @@ func TestRun(t *testing.T) {
 				},
 			},
 		},
+		{
+			sql: "PRAGMA cache_size = 100;",
+			vmc: []VMCode{
+				{
+					Operator: PUSH,
+					Operand1: VMValue{
+						Type:     Integer,
+						Integral: 100,
+					},
+				},
+				{
+					Operator: PRAGMA,
+					Operand1: VMValue{
+						Type:   String,
+						String: "cache_size",
+					},
+					Operand2: VMValue{
+						Type:     Integer,
+						Integral: 1,
+					},
+				},
+			},
+			expected: []result.Value{
+				{
+					Type:     result.Integral,
+					Integral: 100,
+				},
+			},
+		},
 	}
 
 	for tn, tc := range testCases {
@@ -271,3 +307,543 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestAbort(t *testing.T) {
+	codes := []VMCode{
+		{
+			Operator: BEGIN,
+			Operand1: VMValue{
+				Type:   String,
+				String: "DEFERRED",
+			},
+		},
+		{
+			Operator: PUSH,
+			Operand1: VMValue{
+				Type:     Integer,
+				Integral: 0,
+			},
+		},
+		{
+			Operator: CALL,
+			Operand1: VMValue{
+				Type:   String,
+				String: "UNKNOWN",
+			},
+		},
+		{
+			Operator: COMMIT,
+		},
+	}
+	if rslt := Run(codes); len(rslt) != 0 {
+		t.Fatalf("failed batch returns results")
+	}
+	if runtime.GetInstance().InTransaction() {
+		t.Fatalf("failed batch does not roll back the transaction")
+	}
+}
+
+func TestParam(t *testing.T) {
+	codes := []VMCode{
+		{
+			Operator: PARAM,
+			Operand1: VMValue{
+				Type:     Integer,
+				Integral: 2,
+			},
+		},
+		{
+			Operator: PARAM,
+			Operand1: VMValue{
+				Type:     Integer,
+				Integral: 1,
+			},
+		},
+		{
+			Operator: SUB,
+		},
+		{
+			Operator: STORE,
+		},
+	}
+	sess := runtime.GetInstance().Session()
+	for _, n := range []int{1, 5} {
+		rslt, err := Execute(sess, codes, VMValue{Type: Integer, Integral: n}, VMValue{Type: Integer, Integral: 10})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		if len(rslt) != 1 || rslt[0].Integral != 10-n {
+			t.Fatalf("result mismatch %v", rslt)
+		}
+	}
+	if _, err := Execute(sess, codes, VMValue{Type: Integer, Integral: 1}); err == nil {
+		t.Fatalf("unbound parameter is read")
+	}
+}
+
+func TestExecuteRows(t *testing.T) {
+	codes := []VMCode{
+		{Operator: ROW},
+		{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: 1}},
+		{Operator: STORE},
+		{Operator: PUSH, Operand1: VMValue{Type: String, String: "a"}},
+		{Operator: STORE},
+		{Operator: ROW},
+		{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: 2}},
+		{Operator: STORE},
+	}
+	rows, err := ExecuteRows(runtime.GetInstance().Session(), codes)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(rows) != 2 || len(rows[0]) != 2 || len(rows[1]) != 1 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+	if rows[0][0].Integral != 1 || rows[0][1].Type != result.String || rows[0][1].String != "a" || rows[1][0].Integral != 2 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	codes := []VMCode{
+		{Operator: PROFILE, Operand1: VMValue{Type: Integer, Integral: 4}},
+		{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: 1}},
+		{Operator: STORE},
+		{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: 2}},
+		{Operator: STORE},
+		{Operator: REPORT, Operand1: VMValue{Type: Integer, Integral: 0}, Operand2: VMValue{Type: Integer, Integral: 4}},
+		{Operator: ROW},
+		{Operator: REPORT, Operand1: VMValue{Type: Integer, Integral: 2}, Operand2: VMValue{Type: Integer, Integral: 2}},
+	}
+	rows, err := ExecuteRows(runtime.GetInstance().Session(), codes)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(rows) != 2 || len(rows[0]) != 4 || len(rows[1]) != 4 {
+		t.Fatalf("rows mismatch %v", rows)
+	}
+	if rows[0][0].Integral != 1 || rows[0][1].Integral != 1 || rows[0][2].Type != result.String || rows[0][3].Integral != 0 {
+		t.Fatalf("profile mismatch %v", rows[0])
+	}
+	if rows[1][0].Integral != 0 || rows[1][1].Integral != 0 || rows[1][2].String != "0s" {
+		t.Fatalf("profile of an empty range mismatch %v", rows[1])
+	}
+
+	if _, err := Execute(runtime.GetInstance().Session(), codes[5:]); err == nil {
+		t.Fatalf("REPORT without PROFILE succeeded")
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	sess := runtime.New().Set("../../testdata").Session()
+	set := func(name string) VMCode {
+		return VMCode{Operator: SET, Operand1: VMValue{Type: String, String: name}}
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ExecuteRowsContext(canceled, sess, []VMCode{push(1), {Operator: STORE}}); err != context.Canceled {
+		t.Fatalf("canceled context is not reported %v", err)
+	}
+	if _, err := ExecuteVectorizedContext(canceled, sess, []VMCode{read("colA"), fetch("colA"), {Operator: STORE}}); err != context.Canceled {
+		t.Fatalf("canceled context is not reported by the vectorized engine %v", err)
+	}
+	if sess.Context() != context.Background() {
+		t.Fatalf("context of the session is not restored")
+	}
+
+	// Endless loops on the register machine and on the stack machine.
+	loops := [][]VMCode{
+		{jump(JUMP, 0)},
+		{push(0), set("statement_timeout"), jump(JUMP, 0)},
+	}
+	for n, codes := range loops {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := ExecuteRowsContext(ctx, sess, codes)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("[%d] timeout is not reported %v", n, err)
+		}
+	}
+
+	if _, err := Execute(sess, []VMCode{push(20), set("statement_timeout")}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if sess.StatementTimeout() != 20*time.Millisecond {
+		t.Fatalf("statement_timeout mismatch %s", sess.StatementTimeout())
+	}
+	if _, err := Execute(sess, loops[0]); err != context.DeadlineExceeded {
+		t.Fatalf("statement timeout is not reported %v", err)
+	}
+	if _, err := Execute(sess, []VMCode{push(-1), set("statement_timeout")}); err == nil {
+		t.Fatalf("negative statement_timeout is accepted")
+	}
+	if _, err := Execute(sess, []VMCode{push(1), set("unknown")}); err == nil {
+		t.Fatalf("unknown variable is set")
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	pragma := VMCode{Operator: PRAGMA, Operand1: VMValue{Type: String, String: "parallelism"}}
+	testCases := []struct {
+		name       string
+		value      int
+		codes      []VMCode
+		vectorized bool
+	}{
+		{"max_instructions", 100, []VMCode{jump(JUMP, 0)}, false},
+		{"max_instructions", 100, []VMCode{pragma, {Operator: ROW}, jump(JUMP, 0)}, false},
+		{"max_instructions", 4, []VMCode{read("colA"), fetch("colA"), {Operator: STORE}}, true},
+		{"max_rows", 2, []VMCode{push(1), {Operator: STORE}, {Operator: ROW}, push(2), {Operator: STORE}, {Operator: ROW}, push(3), {Operator: STORE}}, false},
+		{"max_rows", 100, []VMCode{pragma, {Operator: ROW}, jump(JUMP, 0)}, false},
+		{"max_rows", 4, []VMCode{read("colA"), fetch("colA"), {Operator: STORE}}, true},
+		{"max_memory", 1000, []VMCode{pragma, {Operator: ROW}, jump(JUMP, 0)}, false},
+		{"max_memory", 1000, []VMCode{read("colA"), fetch("colA"), {Operator: STORE}}, true},
+		{"max_memory", 100, []VMCode{read("colA"), jump(NEXT, 3), jump(JUMP, 1)}, false},
+		{"max_memory", 16, []VMCode{{Operator: ANALYZE, Operand1: VMValue{Type: Table, Table: VMTable{DB: "_", Table: "tbl1"}}}}, false},
+	}
+
+	for tn, tc := range testCases {
+		sess := runtime.New().Set("../../testdata").Session()
+		if err := sess.Set(tc.name, tc.value); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		var err error
+		if tc.vectorized {
+			_, err = ExecuteVectorized(sess, tc.codes)
+		} else {
+			_, err = ExecuteRows(sess, tc.codes)
+		}
+		le, ok := err.(*runtime.ResourceLimitError)
+		if !ok || le.Resource != tc.name || le.Limit != int64(tc.value) {
+			t.Fatalf("[%d] expected %s to be exceeded but got %v", tn, tc.name, err)
+		}
+		if err.Error() != fmt.Sprintf("Resource limit exceeded: %s (%d)", tc.name, tc.value) {
+			t.Fatalf("[%d] message mismatch %s", tn, err)
+		}
+	}
+}
+
+func TestVMCodeString(t *testing.T) {
+	testCases := []struct {
+		code     VMCode
+		expected string
+	}{
+		{VMCode{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: 3}}, "PUSH 3"},
+		{VMCode{Operator: STORE, Operand1: VMValue{Type: Nothing}}, "STORE"},
+		{VMCode{Operator: SET, Operand1: VMValue{Type: String, String: "statement_timeout"}}, "SET statement_timeout"},
+		{VMCode{Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}, Operand2: VMValue{Type: Integer, Integral: 1}}, "CALL ABS, 1"},
+		{VMCode{Operator: READ, Operand1: VMValue{Type: Table, Table: VMTable{DB: "_", Table: "tbl1", Columns: []string{"colA"}}}}, "READ tbl1 [colA]"},
+		{VMCode{Operator: FETCH, Operand1: VMValue{Type: Column, Column: VMColumn{Table: "a", Column: "colB"}}}, "FETCH a.colB"},
+		{VMCode{Operator: CREATE_INDEX, Operand1: VMValue{Type: Index, Index: VMIndex{Index: "idx", Table: "tbl1", Columns: []string{"colA", "colB"}, Unique: true}}}, "CREATE_INDEX idx ON tbl1 (colA, colB) UNIQUE"},
+	}
+
+	for tn, tc := range testCases {
+		if actual := tc.code.String(); actual != tc.expected {
+			t.Fatalf("[%d] expected %q but got %q", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestJump(t *testing.T) {
+	push := func(v VMValue) VMCode {
+		return VMCode{Operator: PUSH, Operand1: v}
+	}
+	num := func(n int) VMValue {
+		return VMValue{Type: Integer, Integral: n}
+	}
+	null := VMValue{Type: Null}
+
+	testCases := []struct {
+		codes    []VMCode
+		expected []int
+	}{
+		// if 0 store 1 else store 2
+		{[]VMCode{push(num(0)), {Operator: JUMP_IF_FALSE, Operand1: num(5)}, push(num(1)), {Operator: STORE}, {Operator: JUMP, Operand1: num(7)}, push(num(2)), {Operator: STORE}}, []int{2}},
+		{[]VMCode{push(num(3)), {Operator: JUMP_IF_TRUE, Operand1: num(4)}, push(num(1)), {Operator: STORE}, push(num(2)), {Operator: STORE}}, []int{2}},
+		{[]VMCode{push(null), {Operator: JUMP_IF_TRUE, Operand1: num(4)}, push(num(1)), {Operator: STORE}}, []int{1}},
+		{[]VMCode{push(null), {Operator: JUMP_IF_FALSE, Operand1: num(4)}, push(num(1)), {Operator: STORE}}, []int{1}},
+		{[]VMCode{push(null), {Operator: JUMP_IF_NULL, Operand1: num(4)}, push(num(1)), {Operator: STORE}}, []int{}},
+		{[]VMCode{push(num(1)), {Operator: STORE}, {Operator: HALT}, push(num(2)), {Operator: STORE}}, []int{1}},
+		{[]VMCode{{Operator: PARAM, Operand1: num(1)}, {Operator: POP}, {Operator: JUMP, Operand1: num(4)}, {Operator: HALT}, push(num(5)), {Operator: STORE}}, []int{5}},
+		// 1 AND NULL is NULL, 0 AND NULL is 0
+		{[]VMCode{push(num(1)), push(null), {Operator: AND}, {Operator: JUMP_IF_NULL, Operand1: num(6)}, push(num(1)), {Operator: STORE}}, []int{}},
+		{[]VMCode{push(num(0)), push(null), {Operator: AND}, {Operator: STORE}, push(num(2)), push(num(3)), {Operator: AND}, {Operator: STORE}}, []int{0, 1}},
+		// LIMIT 1 OFFSET 1 over three rows
+		{[]VMCode{
+			push(num(1)), push(num(1)), {Operator: LIMIT, Operand1: num(0)},
+			{Operator: TAKE, Operand1: num(15), Operand2: num(0)}, {Operator: JUMP_IF_FALSE, Operand1: num(7)}, push(num(1)), {Operator: STORE},
+			{Operator: TAKE, Operand1: num(15), Operand2: num(0)}, {Operator: JUMP_IF_FALSE, Operand1: num(11)}, push(num(2)), {Operator: STORE},
+			{Operator: TAKE, Operand1: num(15), Operand2: num(0)}, {Operator: JUMP_IF_FALSE, Operand1: num(15)}, push(num(3)), {Operator: STORE},
+		}, []int{2}},
+	}
+
+	sess := runtime.GetInstance().Session()
+	for tn, tc := range testCases {
+		rslt, err := Execute(sess, tc.codes, num(1))
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if len(rslt) != len(tc.expected) {
+			t.Fatalf("[%d] result mismatch %v", tn, rslt)
+		}
+		for n := range rslt {
+			if rslt[n].Integral != tc.expected[n] {
+				t.Fatalf("[%d] result mismatch %v", tn, rslt)
+			}
+		}
+	}
+
+	rslt, err := Execute(sess, []VMCode{push(null), {Operator: STORE}})
+	if err != nil || len(rslt) != 1 || rslt[0].Type != result.Null {
+		t.Fatalf("NULL result mismatch %v %v", rslt, err)
+	}
+}
+
+func TestSort(t *testing.T) {
+	num := func(n int) VMCode {
+		return VMCode{Operator: PUSH, Operand1: VMValue{Type: Integer, Integral: n}}
+	}
+	row := func(v int, key int) []VMCode {
+		return []VMCode{num(v), {Operator: STORE}, num(key), {Operator: STORE}, {Operator: ROW}}
+	}
+	testCases := []struct {
+		order    string
+		limited  bool
+		expected string
+	}{
+		{"A", false, "[[{1 2 }] [{1 4 }] [{1 1 }] [{1 3 }]]"},
+		{"D", false, "[[{1 3 }] [{1 1 }] [{1 4 }] [{1 2 }]]"},
+		{"D", true, "[[{1 1 }] [{1 4 }]]"},
+	}
+
+	sess := runtime.GetInstance().Session()
+	for tn, tc := range testCases {
+		// LIMIT 2 OFFSET 1
+		codes := []VMCode{num(2), num(1), {Operator: LIMIT, Operand1: VMValue{Type: Integer}}, {Operator: HOLD}}
+		codes = append(codes, row(1, 3)...)
+		codes = append(codes, row(2, 1)...)
+		codes = append(codes, row(3, 4)...)
+		codes = append(codes, row(4, 2)...)
+		sort := VMCode{Operator: SORT, Operand1: VMValue{Type: String, String: tc.order}}
+		if tc.limited {
+			sort.Operand2 = VMValue{Type: Integer}
+		}
+		rows, err := ExecuteRows(sess, append(codes, sort))
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+
+	if _, err := ExecuteRows(sess, []VMCode{{Operator: HOLD}, num(1), {Operator: STORE}, {Operator: ROW}, {Operator: SORT, Operand1: VMValue{Type: String, String: "AA"}}}); err == nil {
+		t.Fatalf("rows without their keys are sorted")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	lookup := VMCode{Operator: LOOKUP, Operand1: VMValue{Type: Index, Index: VMIndex{Table: "tbl1", DB: "_", Schema: "LOCAL", Columns: []string{"colA"}}}, Operand2: VMValue{Type: Integer}}
+	loop := func(key VMCode) []VMCode {
+		// for each row of colA = key: output colB
+		return []VMCode{read("colA", "colB"), key, lookup, jump(NEXT, 7), fetch("colB"), {Operator: STORE}, jump(JUMP, 3)}
+	}
+	testCases := []struct {
+		codes    []VMCode
+		expected string
+	}{
+		{loop(push(5)), "[[{1 6 }]]"},
+		{loop(push(4)), "[]"},
+		{loop(VMCode{Operator: PUSH, Operand1: VMValue{Type: Null}}), "[]"},
+		// the cursor is read again after a lookup: all its rows
+		{append(loop(push(5)), read("colA", "colB"), jump(NEXT, 12), fetch("colA"), VMCode{Operator: STORE}, jump(JUMP, 8)), "[[{1 6 } {1 1 } {1 3 } {1 5 } {1 7 } {1 9 }]]"},
+	}
+
+	sess := runtime.New().Set("../../testdata").Session()
+	for tn, tc := range testCases {
+		rows, err := ExecuteRows(sess, tc.codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	dir := t.TempDir()
+	src, err := ioutil.ReadFile("../../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := runtime.New().Set(dir)
+	if err := r.CreateIndex("_", "tbl1", "idx_colA", []string{"colA"}, false); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	nothing := VMCode{Operator: PUSH, Operand1: VMValue{Type: Nothing}}
+	null := VMCode{Operator: PUSH, Operand1: VMValue{Type: Null}}
+	rg := VMCode{Operator: RANGE, Operand1: VMValue{Type: Index, Index: VMIndex{Index: "idx_colA", Table: "tbl1", DB: "_", Schema: "LOCAL", Columns: []string{"colA"}}}, Operand2: VMValue{Type: Integer}}
+	loop := func(low VMCode, high VMCode, order runtime.KeyOrder) []VMCode {
+		// for each row of low <= colA <= high in order: output colB
+		return []VMCode{read("colA", "colB"), low, high, push(int(order)), rg, jump(NEXT, 9), fetch("colB"), {Operator: STORE}, jump(JUMP, 5)}
+	}
+	testCases := []struct {
+		codes    []VMCode
+		expected string
+	}{
+		{loop(push(3), push(7), runtime.TableOrder), "[[{1 4 } {1 6 } {1 8 }]]"},
+		{loop(nothing, push(5), runtime.Descending), "[[{1 6 } {1 4 } {1 2 }]]"},
+		{loop(push(6), nothing, runtime.Ascending), "[[{1 8 } {1 10 }]]"},
+		{loop(nothing, nothing, runtime.Descending), "[[{1 10 } {1 8 } {1 6 } {1 4 } {1 2 }]]"},
+		{loop(null, push(5), runtime.Ascending), "[]"},
+		{loop(push(8), push(4), runtime.Ascending), "[]"},
+	}
+	for tn, tc := range testCases {
+		rows, err := ExecuteRows(r.NewSession(), tc.codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	dir := t.TempDir()
+	var sb strings.Builder
+	sb.WriteString("#colA\n")
+	for n := 0; n < 10000; n++ {
+		fmt.Fprintf(&sb, "%d\n", n)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "big.csv"), []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	tbl1, err := ioutil.ReadFile("../../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), tbl1, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	big := VMCode{Operator: READ, Operand1: VMValue{Type: Table, Table: VMTable{Table: "big", DB: "_", Schema: "LOCAL", Columns: []string{"colA"}}}}
+	inner := read("colA")
+	inner.Operand2 = VMValue{Type: Integer, Integral: 1}
+	next := jump(NEXT, 7)
+	next.Operand2 = inner.Operand2
+
+	testCases := []struct {
+		codes    []VMCode
+		expected string
+	}{
+		// the first row of big, which is not read any further
+		{[]VMCode{big, jump(NEXT, 4), fetch("colA"), {Operator: STORE}}, "[[{1 0 }]]"},
+		// a nested loop over tbl1 twice rewinds the inner cursor
+		{[]VMCode{read("colA"), jump(NEXT, 8), inner, next, push(1), {Operator: STORE}, jump(JUMP, 3), jump(JUMP, 1)}, "[[" + strings.TrimSpace(strings.Repeat("{1 1 } ", 25)) + "]]"},
+	}
+
+	r := runtime.New().Set(dir)
+	for tn, tc := range testCases {
+		sess := r.Session()
+		if err := sess.Set("max_memory", 2000); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		rows, err := ExecuteRows(sess, tc.codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if actual := fmt.Sprint(rows); actual != tc.expected {
+			t.Fatalf("[%d] expected %s but got %s", tn, tc.expected, actual)
+		}
+	}
+
+	// Rows closed before the end stop the scan and release its lock.
+	sess := r.Session()
+	rs, err := Query(context.Background(), sess, []VMCode{big, jump(NEXT, 5), fetch("colA"), {Operator: STORE}, {Operator: ROW}, jump(JUMP, 1)})
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := rs.Next(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if held := r.Locks().Held(sess.ID()); len(held) != 1 {
+		t.Fatalf("scan holds locks %v", held)
+	}
+	rs.Close()
+	if held := r.Locks().Held(sess.ID()); len(held) != 0 {
+		t.Fatalf("locks are held after close %v", held)
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	sess := largeTable(t, t.TempDir(), 60000)
+	r := sess.Runtime()
+
+	// Every row of the table, which is read in parts once there are
+	// goroutines for them, in table order.
+	codes := []VMCode{read("colA", "colB"), jump(NEXT, 8), fetch("colA"), {Operator: STORE}, fetch("colB"), {Operator: STORE}, {Operator: ROW}, jump(JUMP, 1)}
+	expected := ""
+	for _, parts := range []int{1, 4} {
+		if err := r.SetParallelism(parts); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		rows, err := ExecuteRows(sess, codes)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", parts, err)
+		}
+		if len(rows) < 60000 || rows[59999][0].Integral != 59999 || rows[59999][1].Integral != 60000 {
+			t.Fatalf("[%d] rows mismatch %d", parts, len(rows))
+		}
+		if parts == 1 {
+			expected = fmt.Sprint(rows)
+		} else if actual := fmt.Sprint(rows); actual != expected {
+			t.Fatalf("[%d] rows differ from the rows read in one part", parts)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	num := func(n int) VMValue {
+		return VMValue{Type: Integer, Integral: n}
+	}
+	testCases := []struct {
+		codes []VMCode
+		err   string
+	}{
+		{[]VMCode{{Operator: JUMP, Operand1: num(2)}}, "Invalid jump target at 0"},
+		{[]VMCode{{Operator: JUMP_IF_NULL, Operand1: num(-1)}}, "Invalid jump target at 0"},
+		{[]VMCode{{Operator: JUMP, Operand1: VMValue{Type: Label, Integral: 1}}}, "Unresolved label at 0"},
+		{[]VMCode{{Operator: LABEL, Operand1: VMValue{Type: Label, Integral: 1}}}, "Unresolved label at 0"},
+		{[]VMCode{{Operator: PROFILE, Operand1: num(2)}, {Operator: HALT}}, "Invalid profile range at 0"},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: ADD}}, "Stack underflow at 1"},
+		{[]VMCode{{Operator: STORE}}, "Stack underflow at 0"},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: JUMP_IF_TRUE, Operand1: num(3)}, {Operator: PUSH, Operand1: num(2)}, {Operator: HALT}}, "Stack depth mismatch at 3: 0 and 1"},
+		{[]VMCode{{Operator: PARAM, Operand1: num(1)}, {Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}}}, "Unknown number of arguments at 1"},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}}}, "Stack underflow at 1"},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: PUSH, Operand1: num(1)}, {Operator: CALL, Operand1: VMValue{Type: String, String: "ABS"}}, {Operator: STORE}, {Operator: JUMP, Operand1: num(0)}}, ""},
+		{[]VMCode{{Operator: READ}, {Operator: NEXT, Operand1: num(5)}, {Operator: FETCH}, {Operator: STORE}, {Operator: JUMP, Operand1: num(1)}}, ""},
+		{[]VMCode{{Operator: READ}, {Operator: NEXT, Operand1: num(6)}, {Operator: FETCH}}, "Invalid jump target at 1"},
+		{[]VMCode{{Operator: READ}, {Operator: NEXT, Operand1: num(4)}, {Operator: FETCH}, {Operator: JUMP, Operand1: num(1)}}, "Stack depth mismatch at 1: 0 and 1"},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: PUSH, Operand1: num(0)}, {Operator: LIMIT, Operand1: num(0)}, {Operator: TAKE, Operand1: num(5), Operand2: num(0)}, {Operator: POP}}, ""},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: PUSH, Operand1: num(0)}, {Operator: LIMIT, Operand1: num(0)}, {Operator: TAKE, Operand1: num(4), Operand2: num(0)}}, "Stack depth mismatch at 4: 0 and 1"},
+		{[]VMCode{{Operator: PUSH, Operand1: num(1)}, {Operator: LIMIT, Operand1: num(0)}}, "Stack underflow at 1"},
+	}
+
+	for tn, tc := range testCases {
+		err := Verify(tc.codes)
+		if tc.err == "" {
+			if err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.err {
+			t.Fatalf("[%d] expected %q but got %v", tn, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+// statementSavepoint is the savepoint a write is run under, so that the
+// rows changed by the actions of its foreign keys are undone with it.
+const statementSavepoint = "_statement"
+
+// CreateTable creates a table of the storage with the foreign keys fks.
+// The keys are checked before the table is created, so that a table is
+// never left without the keys it was declared with.
+func (s *Session) CreateTable(db string, tbl string, cols []string, fks []ForeignKey) error {
+	r := s.r
+	if err := r.checkNewTable(db, tbl); err != nil {
+		return err
+	}
+	for n := range fks {
+		fk := &fks[n]
+		fk.DB, fk.Table = db, tbl
+		if fk.Name == "" {
+			fk.Name = fmt.Sprintf("fk_%s_%d", tbl, n+1)
+		}
+		if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.RefColumns) {
+			return errors.New(fmt.Sprintf("Foreign Key (%s) column count mismatch", fk.Name))
+		}
+		if err := checkActions(*fk); err != nil {
+			return err
+		}
+		if err := checkHeader(tbl, cols, fk.Columns); err != nil {
+			return err
+		}
+		if fk.RefTable == tbl {
+			if err := checkHeader(tbl, cols, fk.RefColumns); err != nil {
+				return err
+			}
+		} else if err := r.checkColumns(db, fk.RefTable, fk.RefColumns); err != nil {
+			return err
+		}
+		for _, k := range fks[:n] {
+			if k.Name == fk.Name {
+				return errors.New(fmt.Sprintf("Foreign Key (%s) already exists", fk.Name))
+			}
+		}
+	}
+
+	return s.statement(func() error {
+		release, err := s.write(db, tbl)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if err := r.storage.CreateTable(tbl, cols); err != nil {
+			return err
+		}
+		for _, fk := range fks {
+			if err := r.addForeignKey(fk); err != nil {
+				return err
+			}
+			name := fk.Name
+			s.addUndo(func() error {
+				return r.DropForeignKey(db, tbl, name)
+			})
+		}
+		return nil
+	})
+}
+
+// checkNewTable checks that a table of the storage can be created as tbl
+// of db.
+func (r *Runtime) checkNewTable(db string, tbl string) error {
+	if db != "_" {
+		return errors.New(fmt.Sprintf("Database (%s) is read only", db))
+	}
+	if _, exists := r.tablePath(db, tbl); exists || r.stored(db, tbl) {
+		return errors.New(fmt.Sprintf("Table (%s) already exists", tbl))
+	}
+	return nil
+}
+
+// Insert adds a row to a table of the storage and returns its rowid. The
+// row has to reference existing rows through the foreign keys of the
+// table that are not deferred.
+func (s *Session) Insert(db string, tbl string, row []table.Value) (int64, error) {
+	var rowid int64
+	err := s.statement(func() error {
+		var err error
+		rowid, err = s.insert(db, tbl, row)
+		return err
+	})
+	if err == nil {
+		s.changes++
+	}
+	return rowid, err
+}
+
+// Update replaces a row of a table of the storage. The foreign keys of the
+// table are checked as for Insert, and the ON UPDATE action of the keys
+// referencing the table is run on the rows that referenced the old values.
+func (s *Session) Update(db string, tbl string, rowid int64, row []table.Value) error {
+	err := s.statement(func() error {
+		return s.update(db, tbl, rowid, row)
+	})
+	if err == nil {
+		s.changes++
+	}
+	return err
+}
+
+// Delete removes a row of a table of the storage, running the ON DELETE
+// action of the foreign keys referencing the table.
+func (s *Session) Delete(db string, tbl string, rowid int64) error {
+	err := s.statement(func() error {
+		return s.delete(db, tbl, rowid)
+	})
+	if err == nil {
+		s.changes++
+	}
+	return err
+}
+
+// statement runs fn as one statement: what it changed is undone when it
+// fails, and committed at once outside a transaction.
+func (s *Session) statement(fn func() error) error {
+	if err := s.Savepoint(statementSavepoint); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if n, _ := s.findSavepoint(statementSavepoint); n == 0 && s.tx.implicit {
+			s.Rollback()
+			return err
+		}
+		s.RollbackTo(statementSavepoint)
+		s.Release(statementSavepoint)
+		return err
+	}
+	return s.Release(statementSavepoint)
+}
+
+func (s *Session) insert(db string, tbl string, row []table.Value) (int64, error) {
+	release, err := s.write(db, tbl)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	rowid, err := s.r.storage.Insert(tbl, row)
+	if err != nil {
+		return rowid, err
+	}
+	return rowid, s.checkParents(db, tbl, nil, row)
+}
+
+func (s *Session) update(db string, tbl string, rowid int64, row []table.Value) error {
+	release, err := s.write(db, tbl)
+	if err != nil {
+		return err
+	}
+	defer release()
+	old, found, err := s.r.storage.Get(tbl, rowid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Row (%d) Not Found", rowid))
+	}
+	if err := s.r.storage.Update(tbl, rowid, row); err != nil {
+		return err
+	}
+	if err := s.checkParents(db, tbl, old, row); err != nil {
+		return err
+	}
+	return s.runActions(db, tbl, old, row)
+}
+
+func (s *Session) delete(db string, tbl string, rowid int64) error {
+	release, err := s.write(db, tbl)
+	if err != nil {
+		return err
+	}
+	defer release()
+	old, found, err := s.r.storage.Get(tbl, rowid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New(fmt.Sprintf("Row (%d) Not Found", rowid))
+	}
+	if err := s.r.storage.Delete(tbl, rowid); err != nil {
+		return err
+	}
+	return s.runActions(db, tbl, old, nil)
+}
+
+// scanTable passes the rows of a table to fn as they were last written.
+// Unlike the reads of a query, which see the snapshot of the transaction,
+// the rows of the storage include the writes of the transaction, which
+// holds the write lock of the database while it checks its foreign keys.
+// The rows of a CSV file have no rowid.
+func (s *Session) scanTable(db string, tbl string, fn func(int64, []table.Value) error) error {
+	if s.r.stored(db, tbl) {
+		return s.r.storage.Scan(tbl, func(rowid int64, row []table.Value) (bool, error) {
+			if err := fn(rowid, row); err != nil {
+				return false, err
+			}
+			return true, nil
+		})
+	}
+	return s.ScanColumnsFromLocalTable(db, tbl, nil, func(row []table.ColumnValue) error {
+		values := make([]table.Value, 0, len(row))
+		for _, cv := range row {
+			values = append(values, cv.Value)
+		}
+		return fn(-1, values)
+	})
+}
@@ -0,0 +1,267 @@
+package runtime
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/yakawa/simpleDB/runtime/storage/table"
+)
+
+func TestForeignKeyActions(t *testing.T) {
+	dir := t.TempDir()
+	r := New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	s := r.Session()
+
+	num := func(n int) table.Value {
+		return table.Value{Type: table.Integer, Integral: n}
+	}
+	null := table.Value{Type: table.Null}
+	rows := func(tbl string) string {
+		res := []string{}
+		err := r.storage.Scan(tbl, func(rowid int64, row []table.Value) (bool, error) {
+			vals := []string{}
+			for _, v := range row {
+				if v.Type == table.Null {
+					vals = append(vals, "NULL")
+				} else {
+					vals = append(vals, fmt.Sprint(v.Integral))
+				}
+			}
+			res = append(res, fmt.Sprint(vals))
+			return true, nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		return fmt.Sprint(res)
+	}
+	fk := func(ref string, onDelete ReferentialAction, onUpdate ReferentialAction) []ForeignKey {
+		return []ForeignKey{{Columns: []string{"pid"}, RefTable: ref, RefColumns: []string{"id"}, OnDelete: onDelete, OnUpdate: onUpdate}}
+	}
+
+	if err := s.CreateTable("_", "parent", []string{"id"}, nil); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	tables := []struct {
+		name string
+		fks  []ForeignKey
+	}{
+		{"cascade", fk("parent", Cascade, Cascade)},
+		{"setnull", fk("parent", SetNull, SetNull)},
+		{"restrict", fk("parent", Restrict, Restrict)},
+		{"noaction", fk("parent", NoAction, NoAction)},
+	}
+	for _, tc := range tables {
+		if err := s.CreateTable("_", tc.name, []string{"id", "pid"}, tc.fks); err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", tc.name, err)
+		}
+	}
+	if fks := r.ForeignKeys("_", "cascade"); len(fks) != 1 || fks[0].Name != "fk_cascade_1" {
+		t.Fatalf("Unexpected Foreign Keys %+v", fks)
+	}
+	if deps := r.DependentTables("_", "parent"); fmt.Sprint(deps) != "[cascade noaction restrict setnull]" {
+		t.Fatalf("Unexpected Dependent Tables %v", deps)
+	}
+	for _, bad := range [][]ForeignKey{
+		fk("missing", Cascade, Cascade),
+		fk("parent", SetDefault, NoAction),
+		fk("parent", NoAction, SetDefault),
+		{{Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"name"}}},
+		{{Columns: []string{"x"}, RefTable: "parent", RefColumns: []string{"id"}}},
+		{{Name: "fk", Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"id"}}, {Name: "fk", Columns: []string{"id"}, RefTable: "parent", RefColumns: []string{"id"}}},
+	} {
+		if err := s.CreateTable("_", "bad", []string{"id", "pid"}, bad); err == nil {
+			t.Fatalf("Table with %+v is created", bad)
+		}
+		if r.stored("_", "bad") {
+			t.Fatalf("Table with %+v is left behind", bad)
+		}
+	}
+
+	parents := map[int]int64{}
+	for _, id := range []int{1, 2, 3, 4} {
+		rowid, err := s.Insert("_", "parent", []table.Value{num(id)})
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		parents[id] = rowid
+	}
+	for _, row := range []struct {
+		tbl string
+		pid table.Value
+	}{{"cascade", num(1)}, {"cascade", num(1)}, {"cascade", num(2)}, {"setnull", num(1)}, {"setnull", null}, {"restrict", num(3)}, {"noaction", num(4)}} {
+		if _, err := s.Insert("_", row.tbl, []table.Value{num(0), row.pid}); err != nil {
+			t.Fatalf("%s: Unexpected Error: %s", row.tbl, err)
+		}
+	}
+	changes := s.Changes()
+	if _, err := s.Insert("_", "cascade", []table.Value{num(0), num(9)}); err == nil {
+		t.Fatalf("Row referencing a missing parent is inserted")
+	}
+	if err := s.Update("_", "restrict", 1, []table.Value{num(0), num(9)}); err == nil {
+		t.Fatalf("Row is updated to reference a missing parent")
+	}
+	if s.Changes() != changes || rows("cascade") != "[[0 1] [0 1] [0 2]]" || rows("restrict") != "[[0 3]]" {
+		t.Fatalf("Failed writes changed the tables: %s %s", rows("cascade"), rows("restrict"))
+	}
+
+	if err := s.Update("_", "parent", parents[1], []table.Value{num(10)}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if actual := rows("cascade"); actual != "[[0 10] [0 10] [0 2]]" {
+		t.Fatalf("ON UPDATE CASCADE: %s", actual)
+	}
+	if actual := rows("setnull"); actual != "[[0 NULL] [0 NULL]]" {
+		t.Fatalf("ON UPDATE SET NULL: %s", actual)
+	}
+	if err := s.Delete("_", "parent", parents[1]); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if actual := rows("cascade"); actual != "[[0 2]]" {
+		t.Fatalf("ON DELETE CASCADE: %s", actual)
+	}
+	if s.Changes() != changes+2 {
+		t.Fatalf("expected %d changes but got %d", changes+2, s.Changes())
+	}
+
+	for _, id := range []int{3, 4} {
+		if err := s.Delete("_", "parent", parents[id]); err == nil {
+			t.Fatalf("Referenced parent %d is deleted", id)
+		}
+		if err := s.Update("_", "parent", parents[id], []table.Value{num(id * 10)}); err == nil {
+			t.Fatalf("Referenced parent %d is updated", id)
+		}
+	}
+	if actual := rows("parent"); actual != "[[2] [3] [4]]" {
+		t.Fatalf("Failed deletes changed the parent: %s", actual)
+	}
+	if s.InTransaction() {
+		t.Fatalf("Transaction is left open")
+	}
+
+	// A failed action rolls back the whole statement, including the
+	// cascaded changes made before it.
+	if _, err := s.Insert("_", "restrict", []table.Value{num(0), num(2)}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Delete("_", "parent", parents[2]); err == nil {
+		t.Fatalf("Referenced parent 2 is deleted")
+	}
+	if actual := rows("cascade"); actual != "[[0 2]]" {
+		t.Fatalf("Cascade is not rolled back: %s", actual)
+	}
+}
+
+func TestDeferredForeignKey(t *testing.T) {
+	dir := t.TempDir()
+	r := New().Set(dir)
+	if err := r.Open(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer r.Close()
+	s := r.Session()
+
+	num := func(n int) []table.Value {
+		return []table.Value{{Type: table.Integer, Integral: n}}
+	}
+	count := func(tbl string) int {
+		n := 0
+		r.storage.Scan(tbl, func(int64, []table.Value) (bool, error) {
+			n++
+			return true, nil
+		})
+		return n
+	}
+
+	if err := s.CreateTable("_", "parent", []string{"id"}, nil); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	fks := []ForeignKey{{Name: "fk", Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"id"}, Deferred: true}}
+	if err := s.CreateTable("_", "child", []string{"pid"}, fks); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	if _, err := s.Insert("_", "child", num(1)); err == nil {
+		t.Fatalf("Deferred violation is committed outside a transaction")
+	}
+	if s.InTransaction() || count("child") != 0 {
+		t.Fatalf("Deferred violation is left behind")
+	}
+
+	if err := s.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("_", "child", num(1)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Commit(); err == nil {
+		t.Fatalf("Deferred violation is committed")
+	}
+	if s.InTransaction() {
+		t.Fatalf("Transaction is left open after a deferred violation")
+	}
+	if count("child") != 0 {
+		t.Fatalf("Deferred violation is not rolled back")
+	}
+
+	if err := s.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("_", "child", num(1)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("_", "parent", num(1)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if count("child") != 1 || count("parent") != 1 {
+		t.Fatalf("Rows are not committed")
+	}
+
+	// Removing a referenced parent is checked on commit too.
+	var rowid int64
+	r.storage.Scan("parent", func(id int64, _ []table.Value) (bool, error) {
+		rowid = id
+		return false, nil
+	})
+	if err := s.Begin(Deferred); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Delete("_", "parent", rowid); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := s.Commit(); err == nil {
+		t.Fatalf("Deferred violation is committed")
+	}
+	if count("parent") != 1 {
+		t.Fatalf("Deferred violation is not rolled back")
+	}
+
+	// Only the keys the transaction wrote are checked: a violation left by
+	// a foreign key added without a check does not fail other commits.
+	if err := s.CreateTable("_", "other", []string{"pid"}, nil); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("_", "other", num(7)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := r.AddForeignKey(ForeignKey{Name: "fk_other", Table: "other", Columns: []string{"pid"}, RefTable: "parent", RefColumns: []string{"id"}, Deferred: true}); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("_", "child", num(1)); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := s.Insert("_", "other", num(8)); err == nil {
+		t.Fatalf("Deferred violation is committed")
+	}
+	if count("child") != 2 || count("other") != 1 {
+		t.Fatalf("Rows mismatch")
+	}
+}
@@ -0,0 +1,191 @@
+// Package simpledb embeds the database engine in a Go program.
+//
+//	db, err := simpledb.Open("/path/to/tables")
+//	rows, err := db.Query(ctx, "SELECT 1 + 2")
+//	defer rows.Close()
+//	for rows.Next() {
+//		var v int
+//		rows.Scan(&v)
+//	}
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/runtime"
+)
+
+// DB is a database opened on a table directory. It is safe for concurrent
+// use; every call runs in a session of its own.
+type DB struct {
+	mu     sync.RWMutex
+	r      *runtime.Runtime
+	dir    string
+	closed bool
+}
+
+// Open opens the tables in dir. Native tables are stored in the file
+// runtime.DatabaseFile of dir, which is opened if it exists; Open does not
+// create it.
+func Open(dir string) (*DB, error) {
+	return open(dir, false)
+}
+
+// OpenStorage is Open creating the file of native tables in dir if it does
+// not exist.
+func OpenStorage(dir string) (*DB, error) {
+	return open(dir, true)
+}
+
+func open(dir string, create bool) (*DB, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.New(fmt.Sprintf("Path (%s) is not a directory", dir))
+	}
+	r := runtime.New().Set(dir)
+	fn := filepath.Join(dir, runtime.DatabaseFile)
+	if _, err := os.Stat(fn); err == nil || create {
+		if err := r.Open(fn); err != nil {
+			return nil, err
+		}
+	}
+	return &DB{r: r, dir: dir}, nil
+}
+
+func (db *DB) Runtime() *runtime.Runtime {
+	return db.r
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.r.Close()
+}
+
+// Query runs sql and returns the rows it produced. Arguments are bound to
+// the parameters of sql by position, or by name when given as sql.NamedArg.
+func (db *DB) Query(ctx context.Context, sql string, args ...interface{}) (*Rows, error) {
+	st, err := compile(db.r, sql)
+	if err != nil {
+		return nil, err
+	}
+	return (&Stmt{db: db, st: st}).Query(ctx, args...)
+}
+
+// Exec runs sql and returns the number of rows it modified.
+func (db *DB) Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+	st, err := compile(db.r, sql)
+	if err != nil {
+		return 0, err
+	}
+	return (&Stmt{db: db, st: st}).Exec(ctx, args...)
+}
+
+// Prepare compiles sql once so that it can be run many times with different
+// arguments.
+func (db *DB) Prepare(sql string) (*Stmt, error) {
+	st, err := compile(db.r, sql)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{db: db, st: st}, nil
+}
+
+// ImportCSV creates table as a native table holding the rows of the CSV
+// file fn, with the columns of its header.
+func (db *DB) ImportCSV(ctx context.Context, table string, fn string) error {
+	return db.session(func(s *runtime.Session) error {
+		s.SetContext(ctx)
+		return s.ImportCSV("_", table, fn)
+	})
+}
+
+// CreateExternalTable creates table as a native table that reads its rows
+// from the CSV file fn and cannot be written.
+func (db *DB) CreateExternalTable(ctx context.Context, table string, fn string) error {
+	return db.session(func(s *runtime.Session) error {
+		s.SetContext(ctx)
+		return s.CreateExternalTable("_", table, fn)
+	})
+}
+
+// ExportCSV writes the rows of table to the CSV file fn.
+func (db *DB) ExportCSV(ctx context.Context, table string, fn string) error {
+	return db.session(func(s *runtime.Session) error {
+		s.SetContext(ctx)
+		return s.ExportCSV("_", table, fn)
+	})
+}
+
+// session runs fn in a new session. A transaction left open by fn is rolled
+// back.
+func (db *DB) session(fn func(*runtime.Session) error) error {
+	s, close, err := db.open()
+	if err != nil {
+		return err
+	}
+	err = fn(s)
+	if cerr := close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// open starts a new session, which the returned function closes.
+func (db *DB) open() (*runtime.Session, func() error, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, nil, errors.New("Database is closed")
+	}
+	s := db.r.NewSession()
+	return s, s.Close, nil
+}
+
+// columnNames names the columns of a result after the result columns of
+// the first SELECT statement in a, so that a result without rows has them
+// as well. The n values of other statements get positional names. EXPLAIN
+// has columns of its own.
+func columnNames(a *ast.AST, n int) []string {
+	for _, sql := range a.SQL {
+		if sql.EXPLAINStatement != nil {
+			if sql.EXPLAINStatement.QueryPlan {
+				return []string{"id", "parent", "detail"}
+			}
+			if sql.EXPLAINStatement.Analyze {
+				return []string{"id", "parent", "detail", "rows", "loops", "time", "bytes"}
+			}
+			return []string{"addr", "opcode", "operands", "comment"}
+		}
+		if sql.SELECTStatement == nil || sql.SELECTStatement.Select == nil {
+			continue
+		}
+		names := []string{}
+		for n, col := range sql.SELECTStatement.Select.ResultColumns {
+			name := fmt.Sprintf("column%d", n+1)
+			if col.Expression != nil && col.Expression.Column != nil {
+				name = col.Expression.Column.Column
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("column%d", i+1)
+	}
+	return names
+}
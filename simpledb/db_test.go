@@ -0,0 +1,449 @@
+package simpledb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yakawa/simpleDB/runtime"
+)
+
+func openDB(t *testing.T) (*DB, func()) {
+	dir, err := ioutil.TempDir("", "simpledb")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	testCases := []struct {
+		input    string
+		columns  []string
+		expected [][]int
+	}{
+		{"SELECT 1 + 2", []string{"column1"}, [][]int{{3}}},
+		{"SELECT 1, 2 * 3;", []string{"column1", "column2"}, [][]int{{1, 6}}},
+		{"CREATE INDEX idx ON tbl1 (colA);", []string{}, [][]int{}},
+		{"SELECT colA, colB + 1 FROM tbl1 WHERE colA = -1", []string{"colA", "column2"}, [][]int{}},
+		{"ANALYZE; SELECT 2 = 1 + 1, 2 = 3;", []string{"column1", "column2"}, [][]int{{1, 0}}},
+	}
+
+	for tn, tc := range testCases {
+		rows, err := db.Query(context.Background(), tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		cols := rows.Columns()
+		if len(cols) != len(tc.columns) {
+			t.Fatalf("[%d] columns mismatch %v", tn, cols)
+		}
+		for n := range cols {
+			if cols[n] != tc.columns[n] {
+				t.Fatalf("[%d] columns mismatch %v", tn, cols)
+			}
+		}
+		actual := [][]int{}
+		for rows.Next() {
+			vals := make([]int, len(cols))
+			dest := []interface{}{}
+			for n := range vals {
+				dest = append(dest, &vals[n])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+			actual = append(actual, vals)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if len(actual) != len(tc.expected) {
+			t.Fatalf("[%d] rows mismatch %v", tn, actual)
+		}
+		for r := range actual {
+			for c := range actual[r] {
+				if actual[r][c] != tc.expected[r][c] {
+					t.Fatalf("[%d] rows mismatch %v", tn, actual)
+				}
+			}
+		}
+		rows.Close()
+	}
+}
+
+func TestOpen(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	fn := filepath.Join(db.dir, runtime.DatabaseFile)
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("Open created the database file %v", err)
+	}
+	if db.Runtime().Storage().Opened() {
+		t.Fatalf("Storage is opened")
+	}
+
+	storage, err := OpenStorage(db.dir)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := os.Stat(fn); err != nil {
+		t.Fatalf("OpenStorage did not create the database file %v", err)
+	}
+	storage.Close()
+	existing, err := Open(db.dir)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer existing.Close()
+	if !existing.Runtime().Storage().Opened() {
+		t.Fatalf("Existing database file is not opened")
+	}
+}
+
+func TestQueryStream(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	// The third row divides by zero, which is only found once the rows
+	// before it are read.
+	rows, err := db.Query(context.Background(), "SELECT 10 / (colA - 5) FROM tbl1")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer rows.Close()
+	actual := []int{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		actual = append(actual, v)
+	}
+	if fmt.Sprint(actual) != "[-2 -5]" {
+		t.Fatalf("rows mismatch %v", actual)
+	}
+	if err := rows.Err(); err == nil || err.Error() != "Division by zero" {
+		t.Fatalf("division by zero is not reported %v", err)
+	}
+
+	if n, err := db.Exec(context.Background(), "SELECT colA FROM tbl1"); err != nil || n != 0 {
+		t.Fatalf("affected rows mismatch %d %v", n, err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	testCases := []struct {
+		input    string
+		columns  []string
+		expected []string
+	}{
+		{"EXPLAIN SELECT 1 + 2", []string{"addr", "opcode", "operands", "comment"}, []string{"[0 PUSH 3 push a constant]", "[1 STORE  output a column]", "[2 ROW  end of a result row]"}},
+		{"EXPLAIN QUERY PLAN SELECT colA FROM tbl1", []string{"id", "parent", "detail"}, []string{"[1 0 Project [colA] (~1000 rows)]", "[2 1 Scan tbl1 COLUMNS [colA] (~1000 rows)]"}},
+	}
+
+	for tn, tc := range testCases {
+		rows, err := db.Query(context.Background(), tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if fmt.Sprint(rows.Columns()) != fmt.Sprint(tc.columns) {
+			t.Fatalf("[%d] columns mismatch %v", tn, rows.Columns())
+		}
+		actual := []string{}
+		for rows.Next() {
+			vals := make([]interface{}, len(tc.columns))
+			dest := []interface{}{}
+			for n := range vals {
+				dest = append(dest, &vals[n])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+			actual = append(actual, fmt.Sprint(vals))
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] rows mismatch %q", tn, actual)
+		}
+		rows.Close()
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	// EXPLAIN is run by the row engine in vectorized mode as well.
+	testCases := []struct {
+		input      string
+		vectorized bool
+		detail     []string
+		expected   [][]int
+	}{
+		{"EXPLAIN ANALYZE SELECT 1 + 2 FROM tbl1", false, []string{"Project [3] (~1000 rows)", "Scan tbl1 COLUMNS [] (~1000 rows)"}, [][]int{{5, 1, 33}, {5, 1, 33}}},
+		{"EXPLAIN ANALYZE SELECT colA * 2 FROM tbl1", false, []string{"Project [colA * 2] (~1000 rows)", "Scan tbl1 COLUMNS [colA] (~1000 rows)"}, [][]int{{5, 1, 33}, {5, 1, 33}}},
+		{"EXPLAIN ANALYZE SELECT colA * 2 FROM tbl1", true, []string{"Project [colA * 2] (~1000 rows)", "Scan tbl1 COLUMNS [colA] (~1000 rows)"}, [][]int{{5, 1, 33}, {5, 1, 33}}},
+		{"EXPLAIN ANALYZE SELECT 1", false, []string{"Project [1] (~1 rows)", "Values (~1 rows)"}, [][]int{{1, 1, 0}, {0, 0, 0}}},
+		{"EXPLAIN ANALYZE ANALYZE tbl1", false, []string{"ANALYZE tbl1 (~1 rows)"}, [][]int{{0, 1, 33}}},
+	}
+
+	for tn, tc := range testCases {
+		ctx := context.Background()
+		if tc.vectorized {
+			ctx = Vectorized(ctx)
+		}
+		rows, err := db.Query(ctx, tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if fmt.Sprint(rows.Columns()) != "[id parent detail rows loops time bytes]" {
+			t.Fatalf("[%d] columns mismatch %v", tn, rows.Columns())
+		}
+		n := 0
+		for ; rows.Next(); n++ {
+			var id, parent, count, loops, bytes int
+			var detail, elapsed string
+			if err := rows.Scan(&id, &parent, &detail, &count, &loops, &elapsed, &bytes); err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+			if n >= len(tc.detail) || detail != tc.detail[n] || elapsed == "" {
+				t.Fatalf("[%d] row mismatch %d %q %q", tn, n, detail, elapsed)
+			}
+			if actual := []int{count, loops, bytes}; fmt.Sprint(actual) != fmt.Sprint(tc.expected[n]) {
+				t.Fatalf("[%d] %s profile mismatch %v", tn, detail, actual)
+			}
+		}
+		if n != len(tc.detail) {
+			t.Fatalf("[%d] rows mismatch %d", tn, n)
+		}
+		rows.Close()
+	}
+}
+
+func TestQueryVectorized(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	testCases := []struct {
+		input    string
+		expected [][]int
+	}{
+		{"SELECT 1 + 2", [][]int{{3}}},
+		{"SELECT colA * colB, ABS(colA - 6) FROM tbl1", [][]int{{2, 5}, {12, 3}, {30, 1}, {56, 1}, {90, 3}}},
+		{"SELECT 1; SELECT 2", [][]int{{1}, {2}}},
+		// the row engine runs what the vectorized engine cannot
+		{"SELECT colA FROM tbl1 WHERE colA > 1 AND colB < 10", [][]int{{3}, {5}, {7}}},
+		{"SELECT colA FROM tbl1 ORDER BY colB DESC", [][]int{{9}, {7}, {5}, {3}, {1}}},
+		{"SELECT colA FROM tbl1 LIMIT 2", [][]int{{1}, {3}}},
+		{"SELECT a.colA, b.colB FROM tbl1 AS a JOIN tbl1 AS b ON a.colA = b.colA - 2", [][]int{{1, 4}, {3, 6}, {5, 8}, {7, 10}}},
+		{"SELECT colB FROM tbl1 WHERE colA = 5", [][]int{{6}}},
+		{"SELECT colB FROM tbl1 ORDER BY colA DESC", [][]int{{10}, {8}, {6}, {4}, {2}}},
+		{"SELECT colA FROM tbl1; SELECT 1", [][]int{{1}, {3}, {5}, {7}, {9}, {1}}},
+	}
+
+	ctx := Vectorized(context.Background())
+	if _, err := db.Exec(ctx, "CREATE INDEX idx ON tbl1 (colA)"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for tn, tc := range testCases {
+		rows, err := db.Query(ctx, tc.input)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		actual := [][]int{}
+		for rows.Next() {
+			row := make([]int, len(tc.expected[0]))
+			dest := []interface{}{}
+			for n := range row {
+				dest = append(dest, &row[n])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+			}
+			actual = append(actual, row)
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Fatalf("[%d] expected %v but got %v", tn, tc.expected, actual)
+		}
+		rows.Close()
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := db.Query(ctx, "SELECT UNKNOWN(1)"); err == nil {
+		t.Fatalf("unknown function is not reported")
+	}
+	if _, err := db.Exec(ctx, "DROP INDEX idx"); err == nil {
+		t.Fatalf("unknown index is not reported")
+	}
+	if _, err := db.Query(ctx, "SELECT 1 / 0"); err == nil || err.Error() != "Division by zero" {
+		t.Fatalf("division by zero is not reported %v", err)
+	}
+	if _, err := db.Query(ctx, "SELECT ? % ?", 1, 0); err == nil || err.Error() != "Division by zero" {
+		t.Fatalf("division by zero is not reported %v", err)
+	}
+	if _, err := db.Query(ctx, "SELECT 1", 1); err == nil {
+		t.Fatalf("unused argument is not reported")
+	}
+	if _, err := db.Exec(ctx, "SET unknown = 1"); err == nil {
+		t.Fatalf("unknown variable is not reported")
+	}
+	if _, err := db.Exec(ctx, "SET statement_timeout TO 1000; SELECT 1"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	expired, cancel := context.WithTimeout(ctx, -time.Second)
+	defer cancel()
+	if _, err := db.Query(Vectorized(expired), "SELECT colA FROM tbl1"); err != context.DeadlineExceeded {
+		t.Fatalf("deadline is not reported %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := db.Query(canceled, "SELECT 1"); err != context.Canceled {
+		t.Fatalf("canceled context is not reported %v", err)
+	}
+
+	rows, err := db.Query(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	var s string
+	var b bool
+	if err := rows.Scan(&s); err == nil {
+		t.Fatalf("Scan before Next succeeded")
+	}
+	rows.Next()
+	if err := rows.Scan(&b); err == nil {
+		t.Fatalf("Scan into unsupported type succeeded")
+	}
+	if err := rows.Scan(&s); err != nil || s != "1" {
+		t.Fatalf("Scan mismatch %q %v", s, err)
+	}
+
+	// A transaction does not outlive the call that began it.
+	if _, err := db.Exec(ctx, "BEGIN; CREATE INDEX idx ON tbl1 (colA);"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if len(db.Runtime().Indexes("_", "tbl1")) != 0 {
+		t.Fatalf("unfinished transaction is not rolled back")
+	}
+
+	db.Close()
+	if _, err := db.Exec(ctx, "SELECT 1"); err == nil {
+		t.Fatalf("closed database is used")
+	}
+}
+
+func TestPlanCache(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := db.Exec(ctx, "PRAGMA plan_cache_parameterize = 1"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	for n := 1; n <= 3; n++ {
+		rows, err := db.Query(ctx, fmt.Sprintf("SELECT %d * 10 + 1", n))
+		if err != nil {
+			t.Fatalf("Unexpected Error: %s", err)
+		}
+		var v int
+		if !rows.Next() || rows.Scan(&v) != nil || v != n*10+1 {
+			t.Fatalf("result mismatch %d", v)
+		}
+	}
+	if st := db.Runtime().PlanCache().Stats(); st.Hits != 2 {
+		t.Fatalf("stats mismatch %+v", st)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simpledb")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := OpenStorage(dir)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.ImportCSV(ctx, "imported", "../testdata/tbl1.csv"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := db.CreateExternalTable(ctx, "ext", "../testdata/tbl1.csv"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := db.ImportCSV(ctx, "ext", "../testdata/tbl1.csv"); err == nil {
+		t.Fatalf("Existing table is imported")
+	}
+	if err := db.ImportCSV(ctx, "missing", filepath.Join(dir, "missing.csv")); err == nil {
+		t.Fatalf("Missing file is imported")
+	}
+
+	read := func(tbl string) [][2]int {
+		rows, err := db.Query(ctx, "SELECT colA, colB FROM "+tbl)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected Error: %s", tbl, err)
+		}
+		defer rows.Close()
+		values := [][2]int{}
+		for rows.Next() {
+			var v [2]int
+			if err := rows.Scan(&v[0], &v[1]); err != nil {
+				t.Fatalf("[%s] Unexpected Error: %s", tbl, err)
+			}
+			values = append(values, v)
+		}
+		return values
+	}
+	expected := [][2]int{{1, 2}, {3, 4}, {5, 6}, {7, 8}, {9, 10}}
+	for _, tbl := range []string{"imported", "ext"} {
+		if actual := read(tbl); fmt.Sprint(actual) != fmt.Sprint(expected) {
+			t.Fatalf("[%s] rows mismatch %v", tbl, actual)
+		}
+	}
+
+	out := filepath.Join(dir, "out.csv")
+	if err := db.ExportCSV(ctx, "imported", out); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := db.CreateExternalTable(ctx, "out", out); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if actual := read("out"); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("Exported rows mismatch %v", actual)
+	}
+	if err := db.ExportCSV(ctx, "missing", out); err == nil {
+		t.Fatalf("Missing table is exported")
+	}
+}
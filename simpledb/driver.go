@@ -0,0 +1,290 @@
+package simpledb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+// DriverName is the name the driver is registered under in database/sql.
+// The data source name is the table directory, which is opened with Open:
+// its file of native tables is only used if it exists.
+const DriverName = "simpledb"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+type shared struct {
+	db   *DB
+	refs int
+}
+
+var (
+	openMu sync.Mutex
+	opened = map[string]*shared{}
+)
+
+// Driver implements driver.Driver. Connections to the same directory share
+// one DB, which is closed with the last connection.
+type Driver struct{}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	dir, err := filepath.Abs(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	openMu.Lock()
+	defer openMu.Unlock()
+	sh, exists := opened[dir]
+	if !exists {
+		db, err := Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		sh = &shared{db: db}
+		opened[dir] = sh
+	}
+	sh.refs++
+	return &conn{dir: dir, db: sh.db, s: sh.db.r.NewSession()}, nil
+}
+
+func release(dir string) error {
+	openMu.Lock()
+	defer openMu.Unlock()
+	sh, exists := opened[dir]
+	if !exists {
+		return nil
+	}
+	sh.refs--
+	if sh.refs > 0 {
+		return nil
+	}
+	delete(opened, dir)
+	return sh.db.Close()
+}
+
+type conn struct {
+	dir    string
+	db     *DB
+	s      *runtime.Session
+	closed bool
+}
+
+func (c *conn) check() error {
+	if c.closed {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	st, err := compile(c.db.r, query)
+	if err != nil {
+		return nil, err
+	}
+	return &driverStmt{c: c, st: st}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.s.Close()
+	if rerr := release(c.dir); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSerializable:
+	default:
+		return nil, errors.New(fmt.Sprintf("Isolation level (%s) is not supported", sql.IsolationLevel(opts.Isolation)))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.s.Begin(runtime.Deferred); err != nil {
+		return nil, err
+	}
+	return &tx{c: c}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	st, err := compile(c.db.r, query)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := st.query(ctx, c.s, values(args), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{rs: rs}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	st, err := compile(c.db.r, query)
+	if err != nil {
+		return nil, err
+	}
+	n, err := st.exec(ctx, c.s, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+// CheckNamedValue accepts the arguments toValue does: NULL and strings are
+// kept, and the others are converted to int64.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := toValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if v.Type == vm.Integer {
+		nv.Value = int64(v.Integral)
+	}
+	return nil
+}
+
+func values(args []driver.NamedValue) []interface{} {
+	vs := []interface{}{}
+	for _, a := range args {
+		if a.Name != "" {
+			vs = append(vs, sql.Named(a.Name, a.Value))
+			continue
+		}
+		vs = append(vs, a.Value)
+	}
+	return vs
+}
+
+type driverStmt struct {
+	c  *conn
+	st *statement
+}
+
+func (s *driverStmt) Close() error {
+	return nil
+}
+
+func (s *driverStmt) NumInput() int {
+	return s.st.NumInput()
+}
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func (s *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.c.check(); err != nil {
+		return nil, err
+	}
+	n, err := s.st.exec(ctx, s.c.s, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.c.check(); err != nil {
+		return nil, err
+	}
+	rs, err := s.st.query(ctx, s.c.s, values(args), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{rs: rs}, nil
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nvs := []driver.NamedValue{}
+	for n, a := range args {
+		nvs = append(nvs, driver.NamedValue{Ordinal: n + 1, Value: a})
+	}
+	return nvs
+}
+
+type rows struct {
+	rs *Rows
+}
+
+func (r *rows) Columns() []string {
+	return r.rs.Columns()
+}
+
+func (r *rows) Close() error {
+	return r.rs.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if !r.rs.Next() {
+		if err := r.rs.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for n := range dest {
+		var v interface{}
+		if err := assign(&v, r.rs.cur[n]); err != nil {
+			return err
+		}
+		dest[n] = v
+	}
+	return nil
+}
+
+type tx struct {
+	c *conn
+}
+
+func (t *tx) Commit() error {
+	if err := t.c.check(); err != nil {
+		return err
+	}
+	return t.c.s.Commit()
+}
+
+func (t *tx) Rollback() error {
+	if err := t.c.check(); err != nil {
+		return err
+	}
+	return t.c.s.Rollback()
+}
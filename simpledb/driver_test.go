@@ -0,0 +1,119 @@
+package simpledb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simpledb")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.ReadFile("../testdata/tbl1.csv")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tbl1.csv"), src, 0644); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	db, err := sql.Open(DriverName, dir)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer db.Close()
+
+	var v int
+	if err := db.QueryRow("SELECT 1 + 2").Scan(&v); err != nil || v != 3 {
+		t.Fatalf("result mismatch %d %v", v, err)
+	}
+	stmt, err := db.Prepare("SELECT 2 * 3")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := stmt.QueryRow().Scan(&v); err != nil || v != 6 {
+		t.Fatalf("result mismatch %d %v", v, err)
+	}
+	stmt.Close()
+	if err := db.QueryRow("SELECT $1 - $2", 10, 4).Scan(&v); err != nil || v != 6 {
+		t.Fatalf("result mismatch %d %v", v, err)
+	}
+	if err := db.QueryRow("SELECT :a * :a", sql.Named("a", 5)).Scan(&v); err != nil || v != 25 {
+		t.Fatalf("result mismatch %d %v", v, err)
+	}
+	var null interface{} = 0
+	if err := db.QueryRow("SELECT $1", nil).Scan(&null); err != nil || null != nil {
+		t.Fatalf("result mismatch %v %v", null, err)
+	}
+	var s string
+	if err := db.QueryRow("SELECT $1", "abc").Scan(&s); err != nil || s != "abc" {
+		t.Fatalf("result mismatch %s %v", s, err)
+	}
+	if err := db.QueryRow("SELECT $1 + $2", true, uint64(4)).Scan(&v); err != nil || v != 5 {
+		t.Fatalf("result mismatch %d %v", v, err)
+	}
+	rows, err := db.Query("SELECT colA, colB + 1 FROM tbl1 WHERE colA = -1")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	cols, err := rows.Columns()
+	if err != nil || fmt.Sprint(cols) != "[colA column2]" {
+		t.Fatalf("columns of an empty result mismatch %v %v", cols, err)
+	}
+	if rows.Next() {
+		t.Fatalf("empty result has a row")
+	}
+	rows.Close()
+	if _, err := db.Exec("SELECT $1", uint64(math.MaxUint64)); err == nil {
+		t.Fatalf("out of range argument is accepted")
+	}
+	if _, err := db.Exec("SELECT 1", struct{}{}); err == nil {
+		t.Fatalf("unsupported argument is accepted")
+	}
+	if _, err := db.Exec("DROP INDEX idx"); err == nil {
+		t.Fatalf("unknown index is not reported")
+	}
+
+	ctx := context.Background()
+	if _, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadUncommitted}); err == nil {
+		t.Fatalf("unsupported isolation level is accepted")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := tx.Exec("CREATE INDEX idx ON tbl1 (colA)"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+
+	// Connections to the same directory share the catalog.
+	other, err := sql.Open(DriverName, dir)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer other.Close()
+	tx, err = other.Begin()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := tx.Exec("CREATE INDEX idx ON tbl1 (colA)"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	if _, err := db.Exec("DROP INDEX idx"); err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+}
@@ -0,0 +1,171 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+// Rows iterates over the result of a query. The rows are read from the VM
+// as Next is called; the first one is read by Query, so that the errors
+// found before it are returned by Query. Rows must be closed once they are
+// read.
+type Rows struct {
+	ctx     context.Context
+	columns []string
+	src     *vm.Rows
+	rows    [][]result.Value
+	cur     []result.Value
+	err     error
+	closed  bool
+	close   func() error
+}
+
+// newRows returns the rows of src, or rows when src is nil. close is called
+// once the rows are closed.
+func newRows(ctx context.Context, a *ast.AST, src *vm.Rows, rows [][]result.Value, close func() error) (*Rows, error) {
+	rs := &Rows{
+		ctx:   ctx,
+		src:   src,
+		rows:  rows,
+		close: close,
+	}
+	if src != nil {
+		row, ok, err := rs.read()
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		if ok {
+			rs.rows = [][]result.Value{row}
+		}
+	}
+	n := 0
+	if len(rs.rows) != 0 {
+		n = len(rs.rows[0])
+	}
+	rs.columns = columnNames(a, n)
+	return rs, nil
+}
+
+// read returns the next row of the VM, if any.
+func (rs *Rows) read() ([]result.Value, bool, error) {
+	if rs.src == nil {
+		return nil, false, nil
+	}
+	row, err := rs.src.Next()
+	if err == io.EOF {
+		return nil, false, nil
+	}
+	return row, err == nil, err
+}
+
+func (rs *Rows) Columns() []string {
+	return append([]string{}, rs.columns...)
+}
+
+// Next advances to the next row. It returns false when there are no more
+// rows or an error occurred, which Err reports.
+func (rs *Rows) Next() bool {
+	if rs.closed || rs.err != nil {
+		return false
+	}
+	if err := rs.ctx.Err(); err != nil {
+		rs.err = err
+		rs.Close()
+		return false
+	}
+	if len(rs.rows) != 0 {
+		rs.cur = rs.rows[0]
+		rs.rows = rs.rows[1:]
+		return true
+	}
+	row, ok, err := rs.read()
+	if !ok {
+		rs.err = err
+		rs.Close()
+		return false
+	}
+	rs.cur = row
+	return true
+}
+
+// Scan copies the columns of the current row into dest. Supported
+// destinations are *int, *int64, *string and *interface{}; string values
+// can only be copied into the last two and NULL only into *interface{}.
+func (rs *Rows) Scan(dest ...interface{}) error {
+	if rs.closed {
+		return errors.New("Rows are closed")
+	}
+	if rs.cur == nil {
+		return errors.New("Scan called without calling Next")
+	}
+	if len(dest) != len(rs.cur) {
+		return errors.New(fmt.Sprintf("Expected %d destination arguments in Scan, not %d", len(rs.cur), len(dest)))
+	}
+	for n, v := range rs.cur {
+		if err := assign(dest[n], v); err != nil {
+			return errors.New(fmt.Sprintf("Scan error on column (%s): %s", rs.columns[n], err))
+		}
+	}
+	return nil
+}
+
+func assign(dest interface{}, v result.Value) error {
+	if d, ok := dest.(*interface{}); ok && v.Type == result.Null {
+		*d = nil
+		return nil
+	}
+	if v.Type == result.String {
+		switch d := dest.(type) {
+		case *string:
+			*d = v.String
+		case *interface{}:
+			*d = v.String
+		default:
+			return errors.New(fmt.Sprintf("Unsupported destination type (%T) for a string", dest))
+		}
+		return nil
+	}
+	if v.Type != result.Integral {
+		return errors.New(fmt.Sprintf("Unsupported value type (%d)", v.Type))
+	}
+	switch d := dest.(type) {
+	case *int:
+		*d = v.Integral
+	case *int64:
+		*d = int64(v.Integral)
+	case *string:
+		*d = fmt.Sprintf("%d", v.Integral)
+	case *interface{}:
+		*d = int64(v.Integral)
+	default:
+		return errors.New(fmt.Sprintf("Unsupported destination type (%T)", dest))
+	}
+	return nil
+}
+
+func (rs *Rows) Err() error {
+	return rs.err
+}
+
+// Close stops the query and ends its session.
+func (rs *Rows) Close() error {
+	if rs.closed {
+		return nil
+	}
+	rs.closed = true
+	rs.rows = nil
+	if rs.src != nil {
+		rs.src.Close()
+	}
+	if rs.close != nil {
+		return rs.close()
+	}
+	return nil
+}
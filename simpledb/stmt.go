@@ -0,0 +1,222 @@
+package simpledb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/yakawa/simpleDB/common/ast"
+	"github.com/yakawa/simpleDB/common/result"
+	"github.com/yakawa/simpleDB/compiler"
+	"github.com/yakawa/simpleDB/runtime"
+	"github.com/yakawa/simpleDB/runtime/vm"
+)
+
+type vectorizedKey struct{}
+
+// Vectorized returns a context that runs the queries given it with the
+// vectorized engine of vm.ExecuteVectorized, a batch of rows at a time.
+// EXPLAIN is still run by the row engine, so EXPLAIN ANALYZE profiles the
+// code of the row engine.
+func Vectorized(ctx context.Context) context.Context {
+	return context.WithValue(ctx, vectorizedKey{}, true)
+}
+
+// Stmt is a compiled statement. It is safe for concurrent use.
+type Stmt struct {
+	db *DB
+	st *statement
+}
+
+// NumInput returns the number of parameters of the statement.
+func (s *Stmt) NumInput() int {
+	return s.st.NumInput()
+}
+
+// Query runs the statement and returns its rows, which are made as they are
+// read. The session of the statement lasts until the rows are closed.
+func (s *Stmt) Query(ctx context.Context, args ...interface{}) (*Rows, error) {
+	sess, close, err := s.db.open()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.st.query(ctx, sess, args, close)
+	if err != nil {
+		close()
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (s *Stmt) Exec(ctx context.Context, args ...interface{}) (int64, error) {
+	var n int64
+	err := s.db.session(func(sess *runtime.Session) error {
+		var err error
+		n, err = s.st.exec(ctx, sess, args)
+		return err
+	})
+	return n, err
+}
+
+func (s *Stmt) Close() error {
+	return nil
+}
+
+type statement struct {
+	*compiler.Statement
+}
+
+func compile(r *runtime.Runtime, sql string) (*statement, error) {
+	st, err := compiler.CompileCached(r, sql)
+	if err != nil {
+		return nil, err
+	}
+	return &statement{st}, nil
+}
+
+// bind converts args into the values of the parameter slots. An argument
+// given as sql.NamedArg is bound to the parameter of that name, any other
+// argument to the parameter at its position. Literals lifted out by the
+// plan cache follow the parameters of the caller.
+func (st *statement) bind(args []interface{}) ([]vm.VMValue, error) {
+	params := make([]vm.VMValue, len(st.AST.Parameters))
+	for n := range params {
+		params[n].Type = vm.Nothing
+	}
+	copy(params[st.NumInput():], st.Literals)
+	for pos, arg := range args {
+		n := pos
+		if na, ok := arg.(sql.NamedArg); ok {
+			n = st.lookupParameter(na.Name)
+			if n < 0 {
+				return nil, errors.New(fmt.Sprintf("Parameter (:%s) Not Found", na.Name))
+			}
+			arg = na.Value
+		} else if n >= st.NumInput() {
+			return nil, errors.New(fmt.Sprintf("Statement has %d parameters but %d arguments were supplied", st.NumInput(), len(args)))
+		}
+		v, err := toValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		params[n] = v
+	}
+	for n, p := range params {
+		if p.Type == vm.Nothing {
+			return nil, errors.New(fmt.Sprintf("Parameter (%d) is not bound", n+1))
+		}
+	}
+	return params, nil
+}
+
+func (st *statement) lookupParameter(name string) int {
+	name = strings.TrimPrefix(name, ":")
+	for n, pn := range st.AST.Parameters[:st.NumInput()] {
+		if pn != "" && pn == name {
+			return n
+		}
+	}
+	return -1
+}
+
+// toValue converts an argument to the value of its parameter. NULL is nil,
+// a bool is 1 or 0, and unsigned integers must fit in an int64.
+func toValue(arg interface{}) (vm.VMValue, error) {
+	integer := func(n int64) (vm.VMValue, error) {
+		return vm.VMValue{Type: vm.Integer, Integral: int(n)}, nil
+	}
+	unsigned := func(n uint64) (vm.VMValue, error) {
+		if n > math.MaxInt64 {
+			return vm.VMValue{}, errors.New(fmt.Sprintf("Argument (%d) is out of range", n))
+		}
+		return integer(int64(n))
+	}
+	switch v := arg.(type) {
+	case nil:
+		return vm.VMValue{Type: vm.Null}, nil
+	case string:
+		return vm.VMValue{Type: vm.String, String: v}, nil
+	case bool:
+		if v {
+			return integer(1)
+		}
+		return integer(0)
+	case int:
+		return integer(int64(v))
+	case int8:
+		return integer(int64(v))
+	case int16:
+		return integer(int64(v))
+	case int32:
+		return integer(int64(v))
+	case int64:
+		return integer(v)
+	case uint:
+		return unsigned(uint64(v))
+	case uint8:
+		return unsigned(uint64(v))
+	case uint16:
+		return unsigned(uint64(v))
+	case uint32:
+		return unsigned(uint64(v))
+	case uint64:
+		return unsigned(v)
+	}
+	return vm.VMValue{}, errors.New(fmt.Sprintf("Unsupported argument type (%T)", arg))
+}
+
+// run starts the statement in the session s. The rows of the vectorized
+// engine are made at once, those of the row engine as they are read.
+func (st *statement) run(ctx context.Context, s *runtime.Session, args []interface{}) (*vm.Rows, [][]result.Value, error) {
+	params, err := st.bind(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if vectorized, _ := ctx.Value(vectorizedKey{}).(bool); vectorized && !explains(st.AST) {
+		rows, err := vm.ExecuteVectorizedContext(ctx, s, st.Codes, params...)
+		return nil, rows, err
+	}
+	src, err := vm.Query(ctx, s, st.Codes, params...)
+	return src, nil, err
+}
+
+func explains(a *ast.AST) bool {
+	for _, sql := range a.SQL {
+		if sql.EXPLAINStatement != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// query runs the statement in the session s. close is called once the
+// rows are closed.
+func (st *statement) query(ctx context.Context, s *runtime.Session, args []interface{}, close func() error) (*Rows, error) {
+	src, rows, err := st.run(ctx, s, args)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(ctx, st.AST, src, rows, close)
+}
+
+// exec runs the statement in the session s to its end and returns the
+// number of rows it inserted, updated or deleted.
+func (st *statement) exec(ctx context.Context, s *runtime.Session, args []interface{}) (int64, error) {
+	changes := s.Changes()
+	rs, err := st.query(ctx, s, args, nil)
+	if err != nil {
+		return 0, err
+	}
+	for rs.Next() {
+	}
+	if err := rs.Err(); err != nil {
+		return 0, err
+	}
+	return s.Changes() - changes, nil
+}
@@ -0,0 +1,63 @@
+package simpledb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	stmt, err := db.Prepare("SELECT ? * 10 + :b, :b")
+	if err != nil {
+		t.Fatalf("Unexpected Error: %s", err)
+	}
+	defer stmt.Close()
+	if stmt.NumInput() != 2 {
+		t.Fatalf("parameter count mismatch %d", stmt.NumInput())
+	}
+
+	testCases := []struct {
+		args     []interface{}
+		expected []int
+	}{
+		{[]interface{}{1, 2}, []int{12, 2}},
+		{[]interface{}{int64(3), sql.Named("b", 4)}, []int{34, 4}},
+		{[]interface{}{8, sql.Named(":b", 7)}, []int{87, 7}},
+	}
+
+	for tn, tc := range testCases {
+		rows, err := stmt.Query(ctx, tc.args...)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if !rows.Next() {
+			t.Fatalf("[%d] no rows", tn)
+		}
+		var a, b int
+		if err := rows.Scan(&a, &b); err != nil {
+			t.Fatalf("[%d] Unexpected Error: %s", tn, err)
+		}
+		if a != tc.expected[0] || b != tc.expected[1] {
+			t.Fatalf("[%d] result mismatch %d, %d", tn, a, b)
+		}
+		rows.Close()
+	}
+
+	errCases := [][]interface{}{
+		{},
+		{1},
+		{1, 2, 3},
+		{1, struct{}{}},
+		{1, uint64(1 << 63)},
+		{1, sql.Named("c", 2)},
+	}
+	for tn, args := range errCases {
+		if _, err := stmt.Exec(ctx, args...); err == nil {
+			t.Fatalf("[%d] invalid arguments are accepted", tn)
+		}
+	}
+}